@@ -17,8 +17,14 @@ const (
 	REFRESH_TOKEN      AccessRequestType = "refresh_token"
 	PASSWORD           AccessRequestType = "password"
 	CLIENT_CREDENTIALS AccessRequestType = "client_credentials"
-	ASSERTION          AccessRequestType = "assertion"
 	IMPLICIT           AccessRequestType = "__implicit"
+	DEVICE_CODE        AccessRequestType = "urn:ietf:params:oauth:grant-type:device_code"
+	TOKEN_EXCHANGE     AccessRequestType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	JWT_BEARER         AccessRequestType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+	// Deprecated: ASSERTION follows draft-ietf-oauth-v2-10 and doesn't validate
+	// the assertion, use JWT_BEARER.
+	ASSERTION AccessRequestType = "assertion"
 )
 
 // AccessRequest is a request for access tokens
@@ -38,6 +44,9 @@ type AccessRequest struct {
 	AssertionType   string
 	Assertion       string
 
+	// Verified claims of the assertion, for JWT bearer (rfc7523)
+	AssertionClaims *JWTClaims
+
 	// Set if request is authorized
 	Authorized bool
 
@@ -55,6 +64,49 @@ type AccessRequest struct {
 
 	// Optional code_verifier as described in rfc7636
 	CodeVerifier string
+
+	// Device authorization, for device code (rfc8628)
+	DeviceData *DeviceData
+
+	// Token exchange parameters (rfc8693)
+	SubjectToken       string
+	SubjectTokenType   string
+	ActorToken         string
+	ActorTokenType     string
+	Resource           []string
+	Audience           []string
+	RequestedTokenType string
+
+	// Token exchange subject and actor tokens, if they were issued by this server
+	SubjectAccessData *AccessData
+	ActorAccessData   *AccessData
+
+	// Type of the issued token, returned as issued_token_type for token exchange.
+	// Change if the AccessTokenGen doesn't issue access tokens for RequestedTokenType.
+	IssuedTokenType string
+
+	// Subject and chain of actors (current actor first) of the issued token, recorded
+	// on AccessData to audit delegation. Token exchange fills them from SubjectAccessData
+	// and ActorAccessData, using the client id of tokens without a subject. Change them
+	// to identify subjects from other token types.
+	Subject string
+	Actors  []string
+
+	// Thumbprint of the TLS client certificate the issued token is bound to (rfc8705).
+	// Set to "" to issue a token that is not bound to the certificate.
+	CertificateThumbprint string
+
+	// Verified DPoP proof of the request, if any (rfc9449)
+	DPoPProof *DPoPProof
+
+	// Token type to issue, Config.TokenType or DPoP if the request has a DPoP proof
+	TokenType string
+
+	// JWK thumbprint of the DPoP key the issued token is bound to (rfc9449)
+	JWKThumbprint string
+
+	// Whether the refresh token was already rotated, and is reused within the grace period
+	rotatedRefresh bool
 }
 
 // AccessData represents an access grant (tokens, expiration, client, etc)
@@ -88,6 +140,27 @@ type AccessData struct {
 
 	// Data to be passed to storage. Not used by the library.
 	UserData interface{}
+
+	// Subject the token was issued for, for token exchange (rfc8693)
+	Subject string
+
+	// Chain of actors acting on behalf of Subject, current actor first (rfc8693)
+	Actors []string
+
+	// Audience the token is intended for, for token exchange (rfc8693)
+	Audience []string
+
+	// `x5t#S256` thumbprint of the TLS client certificate the token is bound to (rfc8705)
+	CertificateThumbprint string
+
+	// Token type, Config.TokenType if empty
+	TokenType string
+
+	// `jkt` thumbprint of the DPoP key the token is bound to (rfc9449)
+	JWKThumbprint string
+
+	// Identifier of the grant the token was issued for, shared by the tokens refreshed from it
+	GrantId string
 }
 
 // IsExpired returns true if access expired
@@ -112,6 +185,8 @@ type AccessTokenGen interface {
 
 // HandleAccessRequest is the http.HandlerFunc for handling access token requests
 func (s *Server) HandleAccessRequest(w *Response, r *http.Request) *AccessRequest {
+	bindContext(w, r)
+
 	// Only allow GET or POST
 	if r.Method == "GET" {
 		if !s.Config.AllowGetAccessRequest {
@@ -130,23 +205,89 @@ func (s *Server) HandleAccessRequest(w *Response, r *http.Request) *AccessReques
 	}
 
 	grantType := AccessRequestType(r.FormValue("grant_type"))
-	if s.Config.AllowedAccessTypes.Exists(grantType) {
-		switch grantType {
-		case AUTHORIZATION_CODE:
-			return s.handleAuthorizationCodeRequest(w, r)
-		case REFRESH_TOKEN:
-			return s.handleRefreshTokenRequest(w, r)
-		case PASSWORD:
-			return s.handlePasswordRequest(w, r)
-		case CLIENT_CREDENTIALS:
-			return s.handleClientCredentialsRequest(w, r)
-		case ASSERTION:
-			return s.handleAssertionRequest(w, r)
-		}
+	if !s.Config.AllowedAccessTypes.Exists(grantType) {
+		s.setErrorAndLog(w, E_UNSUPPORTED_GRANT_TYPE, nil, "access_request=%s", "unknown grant type")
+		return nil
 	}
 
-	s.setErrorAndLog(w, E_UNSUPPORTED_GRANT_TYPE, nil, "access_request=%s", "unknown grant type")
-	return nil
+	// check the DPoP proof before using the grant
+	// https://www.rfc-editor.org/rfc/rfc9449#section-5
+	proof, err := s.verifyDPoPProof(r, w.Storage, "", s.tokenEndpointUrls(r)...)
+	if err != nil {
+		s.setDPoPErrorAndLog(w, err, "access_request=%s", "invalid DPoP proof")
+		return nil
+	}
+
+	var ret *AccessRequest
+	switch grantType {
+	case AUTHORIZATION_CODE:
+		ret = s.handleAuthorizationCodeRequest(w, r)
+	case REFRESH_TOKEN:
+		ret = s.handleRefreshTokenRequest(w, r)
+	case PASSWORD:
+		ret = s.handlePasswordRequest(w, r)
+	case CLIENT_CREDENTIALS:
+		ret = s.handleClientCredentialsRequest(w, r)
+	case JWT_BEARER:
+		ret = s.handleJWTBearerRequest(w, r)
+	case ASSERTION:
+		ret = s.handleAssertionRequest(w, r)
+	case DEVICE_CODE:
+		ret = s.handleDeviceCodeRequest(w, r)
+	case TOKEN_EXCHANGE:
+		ret = s.handleTokenExchangeRequest(w, r)
+	default:
+		s.setErrorAndLog(w, E_UNSUPPORTED_GRANT_TYPE, nil, "access_request=%s", "unknown grant type")
+		return nil
+	}
+
+	if ret == nil {
+		return nil
+	}
+
+	// enforce the grant types and scopes the client is restricted to
+	if !clientAllowsAccessType(ret.Client, ret.Type) {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "access_request=%s, client_id=%s", "grant type not allowed for the client", ret.Client.GetId())
+		return nil
+	}
+	if !clientAllowsScope(ret.Client, ret.Scope) {
+		s.setErrorAndLog(w, E_INVALID_SCOPE, nil, "access_request=%s, client_id=%s", "scope not allowed for the client", ret.Client.GetId())
+		return nil
+	}
+	if !clientAllowsAccessType(ret.Client, REFRESH_TOKEN) {
+		ret.GenerateRefresh = false
+	}
+	ret.Expiration = s.accessExpiration(ret.Client)
+
+	// bind the token to the TLS client certificate
+	// https://tools.ietf.org/html/rfc8705#section-3
+	if s.Config.AllowTLSClientCertificates {
+		ret.CertificateThumbprint = CertificateThumbprint(r)
+	}
+
+	// bind the token to the DPoP key, DPoP-bound refresh tokens must be used with the same key
+	ret.TokenType = s.Config.TokenType
+	if proof != nil {
+		ret.DPoPProof = proof
+		ret.TokenType = DPOP_TOKEN_TYPE
+		ret.JWKThumbprint = proof.Thumbprint
+	}
+	if ret.Type == REFRESH_TOKEN && ret.AccessData.JWKThumbprint != "" && ret.AccessData.JWKThumbprint != ret.JWKThumbprint {
+		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "refresh_token=%s", "DPoP key mismatch")
+		return nil
+	}
+	return ret
+}
+
+// tokenEndpointUrls returns the values accepted as the `htu` of DPoP proofs sent to the token endpoint
+func (s *Server) tokenEndpointUrls(r *http.Request) []string {
+	ret := []string{requestUrl(r)}
+	if s.Config.Endpoints.Token != "" {
+		if u, err := s.endpointUrl(s.Config.Endpoints.Token); err == nil {
+			ret = append(ret, u)
+		}
+	}
+	return ret
 }
 
 func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *AccessRequest {
@@ -181,7 +322,14 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 	// must be a valid authorization code
 	var err error
 	ret.AuthorizeData, err = w.Storage.LoadAuthorize(ret.Code)
-	if err != nil {
+	if errors.Is(err, ErrNotFound) {
+		// detect the reuse of redeemed codes
+		s.revokeRedeemedAuthorize(w, ret.Code, ret.Client)
+		return nil
+	} else if errors.Is(err, ErrExpired) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, err, "auth_code_request=%s", "authorization data is expired")
+		return nil
+	} else if err != nil {
 		s.setErrorAndLog(w, E_INVALID_GRANT, err, "auth_code_request=%s", "error loading authorize data")
 		return nil
 	}
@@ -193,7 +341,7 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "auth_code_request=%s", "authorization client is nil")
 		return nil
 	}
-	if ret.AuthorizeData.Client.GetRedirectUri() == "" {
+	if len(s.clientRedirectUris(ret.AuthorizeData.Client)) == 0 {
 		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "auth_code_request=%s", "client redirect uri is empty")
 		return nil
 	}
@@ -210,9 +358,9 @@ func (s *Server) handleAuthorizationCodeRequest(w *Response, r *http.Request) *A
 
 	// check redirect uri
 	if ret.RedirectUri == "" {
-		ret.RedirectUri = FirstUri(ret.Client.GetRedirectUri(), s.Config.RedirectUriSeparator)
+		ret.RedirectUri = s.firstRedirectUri(ret.Client)
 	}
-	if realRedirectUri, err := ValidateUriList(ret.Client.GetRedirectUri(), ret.RedirectUri, s.Config.RedirectUriSeparator); err != nil {
+	if realRedirectUri, err := s.validateRedirectUri(ret.Client, ret.RedirectUri); err != nil {
 		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "auth_code_request=%s", "error validating client redirect")
 		return nil
 	} else {
@@ -314,7 +462,16 @@ func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *Access
 	// must be a valid refresh code
 	var err error
 	ret.AccessData, err = w.Storage.LoadRefresh(ret.Code)
-	if err != nil {
+	if errors.Is(err, ErrNotFound) {
+		// detect the reuse of rotated refresh tokens
+		if ret.AccessData = s.loadRotatedRefresh(w, ret.Code, err); ret.AccessData == nil {
+			return nil
+		}
+		ret.rotatedRefresh = true
+	} else if errors.Is(err, ErrExpired) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, err, "refresh_token=%s", "refresh token is expired")
+		return nil
+	} else if err != nil {
 		s.setErrorAndLog(w, E_INVALID_GRANT, err, "refresh_token=%s", "error loading access data")
 		return nil
 	}
@@ -326,7 +483,7 @@ func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *Access
 		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "refresh_token=%s", "access data client is nil")
 		return nil
 	}
-	if ret.AccessData.Client.GetRedirectUri() == "" {
+	if len(s.clientRedirectUris(ret.AccessData.Client)) == 0 {
 		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "refresh_token=%s", "access data client redirect uri is empty")
 		return nil
 	}
@@ -338,6 +495,12 @@ func (s *Server) handleRefreshTokenRequest(w *Response, r *http.Request) *Access
 
 	}
 
+	// certificate-bound tokens must be refreshed with the same certificate
+	if !CheckCertificateBinding(r, ret.AccessData) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "refresh_token=%s", "client certificate mismatch")
+		return nil
+	}
+
 	// set rest of data
 	ret.RedirectUri = ret.AccessData.RedirectUri
 	ret.UserData = ret.AccessData.UserData
@@ -384,7 +547,7 @@ func (s *Server) handlePasswordRequest(w *Response, r *http.Request) *AccessRequ
 	}
 
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectUri(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = s.firstRedirectUri(ret.Client)
 
 	return ret
 }
@@ -411,7 +574,7 @@ func (s *Server) handleClientCredentialsRequest(w *Response, r *http.Request) *A
 	}
 
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectUri(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = s.firstRedirectUri(ret.Client)
 
 	return ret
 }
@@ -446,15 +609,21 @@ func (s *Server) handleAssertionRequest(w *Response, r *http.Request) *AccessReq
 	}
 
 	// set redirect uri
-	ret.RedirectUri = FirstUri(ret.Client.GetRedirectUri(), s.Config.RedirectUriSeparator)
+	ret.RedirectUri = s.firstRedirectUri(ret.Client)
 
 	return ret
 }
 
 func (s *Server) FinishAccessRequest(w *Response, r *http.Request, ar *AccessRequest) {
+	bindContext(w, r)
+	s.finishAccessRequest(w, r, ar)
+}
+
+// finishAccessRequest outputs the access token, returning its AccessData if successful
+func (s *Server) finishAccessRequest(w *Response, r *http.Request, ar *AccessRequest) *AccessData {
 	// don't process if is already an error
 	if w.IsError {
-		return
+		return nil
 	}
 	redirectUri := r.FormValue("redirect_uri")
 	// Get redirect uri from AccessRequest if it's there (e.g., refresh token request)
@@ -476,40 +645,82 @@ func (s *Server) FinishAccessRequest(w *Response, r *http.Request, ar *AccessReq
 				ExpiresIn:     ar.Expiration,
 				UserData:      ar.UserData,
 				Scope:         ar.Scope,
+				Subject:       ar.Subject,
+				Actors:        ar.Actors,
+				Audience:      ar.Audience,
+
+				CertificateThumbprint: ar.CertificateThumbprint,
+				TokenType:             ar.TokenType,
+				JWKThumbprint:         ar.JWKThumbprint,
+				GrantId:               newGrantId(),
+			}
+			if ar.AccessData != nil && ar.AccessData.GrantId != "" {
+				ret.GrantId = ar.AccessData.GrantId
 			}
 
 			// generate access token
 			ret.AccessToken, ret.RefreshToken, err = s.AccessTokenGen.GenerateAccessToken(ret, ar.GenerateRefresh)
 			if err != nil {
 				s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_access_request=%s", "error generating token")
-				return
+				return nil
 			}
 		} else {
 			ret = ar.ForceAccessData
 		}
 
+		// issue an ID token if the openid scope was granted
+		var idToken string
+		if s.IDTokenSigner != nil && ar.Type != IMPLICIT && ar.Type != TOKEN_EXCHANGE && hasScope(ret.Scope, OPENID_SCOPE) {
+			idToken, err = s.generateIDToken(&IDTokenRequest{
+				Client:        ret.Client,
+				Scope:         ret.Scope,
+				AuthorizeData: ret.AuthorizeData,
+				AccessData:    ret,
+				UserData:      ret.UserData,
+			}, ret.AccessToken, "")
+			if err != nil {
+				s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_access_request=%s", "error generating id token")
+				return nil
+			}
+		}
+
+		// redeem authorization code once
+		if ret.AuthorizeData != nil && !s.consumeAuthorize(w, ret) {
+			return nil
+		}
+
+		// exchange device code once
+		if ar.DeviceData != nil && !s.consumeDevice(w, ar.DeviceData) {
+			return nil
+		}
+
+		// rotate the previous refresh token once
+		rotate := ret.AccessData != nil && ret.AccessData.RefreshToken != "" && !s.Config.RetainTokenAfterRefresh && !ar.rotatedRefresh
+		if rotate && !s.consumeRefresh(w, ret) {
+			return nil
+		}
+
 		// save access token
 		if err = w.Storage.SaveAccess(ret); err != nil {
 			s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_access_request=%s", "error saving access token")
-			return
-		}
-
-		// remove authorization token
-		if ret.AuthorizeData != nil {
-			w.Storage.RemoveAuthorize(ret.AuthorizeData.Code)
+			return nil
 		}
 
 		// remove previous access token
 		if ret.AccessData != nil && !s.Config.RetainTokenAfterRefresh {
-			if ret.AccessData.RefreshToken != "" {
-				w.Storage.RemoveRefresh(ret.AccessData.RefreshToken)
-			}
 			w.Storage.RemoveAccess(ret.AccessData.AccessToken)
 		}
 
 		// output data
 		w.Output["access_token"] = ret.AccessToken
-		w.Output["token_type"] = s.Config.TokenType
+		w.Output["token_type"] = s.tokenType(ret)
+		if ar.Type == TOKEN_EXCHANGE {
+			// https://tools.ietf.org/html/rfc8693#section-2.2.1
+			w.Output["issued_token_type"] = ar.IssuedTokenType
+			if ar.IssuedTokenType != TOKEN_TYPE_ACCESS_TOKEN {
+				w.Output["token_type"] = "N_A"
+			}
+		}
 		w.Output["expires_in"] = ret.ExpiresIn
 		if ret.RefreshToken != "" {
 			w.Output["refresh_token"] = ret.RefreshToken
@@ -517,18 +728,39 @@ func (s *Server) FinishAccessRequest(w *Response, r *http.Request, ar *AccessReq
 		if ret.Scope != "" {
 			w.Output["scope"] = ret.Scope
 		}
-	} else {
-		s.setErrorAndLog(w, E_ACCESS_DENIED, nil, "finish_access_request=%s", "authorization failed")
+		if idToken != "" {
+			w.Output["id_token"] = idToken
+		}
+		return ret
 	}
+
+	s.setErrorAndLog(w, E_ACCESS_DENIED, nil, "finish_access_request=%s", "authorization failed")
+	return nil
 }
 
 // Helper Functions
 
 // getClient looks up and authenticates the basic auth using the given
 // storage. Sets an error on the response if auth fails or a server error occurs.
-func (s Server) getClient(auth *BasicAuth, storage Storage, w *Response) Client {
+func (s Server) getClient(auth *clientAuth, storage Storage, w *Response) Client {
+	client := s.authenticateClient(auth, storage, w)
+	if client == nil {
+		return nil
+	}
+
+	if len(s.clientRedirectUris(client)) == 0 {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "get_client=%s", "client redirect uri is empty")
+		return nil
+	}
+	return client
+}
+
+// authenticateClient looks up and authenticates the basic auth using the given
+// storage, without requiring the client to be able to take part in redirect flows.
+// Sets an error on the response if auth fails or a server error occurs.
+func (s Server) authenticateClient(auth *clientAuth, storage Storage, w *Response) Client {
 	client, err := storage.GetClient(auth.Username)
-	if err == ErrNotFound {
+	if errors.Is(err, ErrNotFound) {
 		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "get_client=%s", "not found")
 		return nil
 	}
@@ -540,14 +772,29 @@ func (s Server) getClient(auth *BasicAuth, storage Storage, w *Response) Client
 		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "get_client=%s", "client is nil")
 		return nil
 	}
-
-	if !CheckClientSecret(client, auth.Password) {
-		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "get_client=%s, client_id=%v", "client check failed", client.GetId())
+	if !clientAllowsAuthMethod(client, auth) {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "get_client=%s, client_id=%v, method=%s", "authentication method not allowed for the client", client.GetId(), auth.authMethod())
 		return nil
 	}
 
-	if client.GetRedirectUri() == "" {
-		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "get_client=%s", "client redirect uri is empty")
+	if auth.assertion != "" {
+		if err := s.verifyClientAssertion(client, auth.assertion, storage); err != nil {
+			s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, err, "get_client=%s, client_id=%v", "client assertion check failed", client.GetId())
+			return nil
+		}
+		return client
+	}
+
+	if auth.certificate != nil {
+		if !CheckClientCertificate(client, auth.certificate, auth.certificateVerified) {
+			s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "get_client=%s, client_id=%v", "client certificate check failed", client.GetId())
+			return nil
+		}
+		return client
+	}
+
+	if !CheckClientSecret(client, auth.Password) {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "get_client=%s, client_id=%v", "client check failed", client.GetId())
 		return nil
 	}
 	return client
@@ -556,6 +803,7 @@ func (s Server) getClient(auth *BasicAuth, storage Storage, w *Response) Client
 // setErrorAndLog sets the response error and internal error (if non-nil) and logs them along with the provided debug format string and arguments.
 func (s Server) setErrorAndLog(w *Response, responseError string, internalError error, debugFormat string, debugArgs ...interface{}) {
 	format := "error=%v, internal_error=%#v " + debugFormat
+	responseError = storageErrorId(responseError, internalError)
 
 	w.InternalError = internalError
 	w.SetError(responseError, "")
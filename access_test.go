@@ -301,7 +301,7 @@ func TestGetClientWithoutMatcher(t *testing.T) {
 			Password: "invalidsecret",
 		}
 		w := &Response{}
-		client := server.getClient(auth, storage, w)
+		client := server.getClient(&clientAuth{BasicAuth: auth}, storage, w)
 		if client != nil {
 			t.Errorf("Expected error, got client: %v", client)
 		}
@@ -322,7 +322,7 @@ func TestGetClientWithoutMatcher(t *testing.T) {
 			Password: "nonexistent",
 		}
 		w := &Response{}
-		client := server.getClient(auth, storage, w)
+		client := server.getClient(&clientAuth{BasicAuth: auth}, storage, w)
 		if client != nil {
 			t.Errorf("Expected error, got client: %v", client)
 		}
@@ -343,7 +343,7 @@ func TestGetClientWithoutMatcher(t *testing.T) {
 			Password: "myclientsecret",
 		}
 		w := &Response{}
-		client := server.getClient(auth, storage, w)
+		client := server.getClient(&clientAuth{BasicAuth: auth}, storage, w)
 		if client != myclient {
 			t.Errorf("Expected client, got nil with response: %v", w)
 		}
@@ -382,7 +382,7 @@ func TestGetClientSecretMatcher(t *testing.T) {
 			Password: "invalidsecret",
 		}
 		w := &Response{}
-		client := server.getClient(auth, storage, w)
+		client := server.getClient(&clientAuth{BasicAuth: auth}, storage, w)
 		if client != nil {
 			t.Errorf("Expected error, got client: %v", client)
 		}
@@ -395,7 +395,7 @@ func TestGetClientSecretMatcher(t *testing.T) {
 			Password: "myclientsecret",
 		}
 		w := &Response{}
-		client := server.getClient(auth, storage, w)
+		client := server.getClient(&clientAuth{BasicAuth: auth}, storage, w)
 		if client != myclient {
 			t.Errorf("Expected client, got nil with response: %v", w)
 		}
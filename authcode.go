@@ -0,0 +1,122 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrAuthorizeRedeemed is the error returned by AuthorizeCodeStorage.ConsumeAuthorize when
+	// the authorization code was already redeemed.
+	ErrAuthorizeRedeemed = errors.New("Authorization code already redeemed")
+)
+
+// AuthorizeCodeStorage is an optional interface Storage can implement to redeem authorization
+// codes only once, and revoke the tokens issued from a code when it is used again
+// (https://tools.ietf.org/html/rfc6749#section-4.1.2).
+type AuthorizeCodeStorage interface {
+	// ConsumeAuthorize atomically removes the authorization code and records a tombstone
+	// of its redemption. It must return ErrAuthorizeRedeemed if a tombstone of the code is
+	// already recorded, so only one of concurrent redemptions succeeds.
+	// The tombstone can be removed after it expires.
+	ConsumeAuthorize(*RedeemedAuthorizeData) error
+
+	// LoadRedeemedAuthorize looks up the tombstone of a redeemed authorization code.
+	// Returns ErrNotFound if the code was not redeemed.
+	LoadRedeemedAuthorize(code string) (*RedeemedAuthorizeData, error)
+
+	// RemoveGrant revokes or deletes all the access and refresh tokens of a grant
+	// (AccessData.GrantId).
+	RemoveGrant(grantId string) error
+}
+
+// ContextAuthorizeCodeStorage is the context-aware version of AuthorizeCodeStorage,
+// implemented by a ContextStorage to detect the reuse of authorization codes
+type ContextAuthorizeCodeStorage interface {
+	ConsumeAuthorize(ctx context.Context, data *RedeemedAuthorizeData) error
+	LoadRedeemedAuthorize(ctx context.Context, code string) (*RedeemedAuthorizeData, error)
+	RemoveGrant(ctx context.Context, grantId string) error
+}
+
+// RedeemedAuthorizeData is the tombstone of a redeemed authorization code
+type RedeemedAuthorizeData struct {
+	// Authorization code
+	Code string
+
+	// Id of the client the code was issued to
+	ClientId string
+
+	// Grant of the tokens issued from the code
+	GrantId string
+
+	// Date redeemed
+	RedeemedAt time.Time
+
+	// Date the tombstone expires, the expiration of the code
+	ExpireAt time.Time
+}
+
+// IsExpiredAt returns true if the tombstone expired at the given time
+func (d *RedeemedAuthorizeData) IsExpiredAt(t time.Time) bool {
+	return !d.ExpireAt.IsZero() && d.ExpireAt.Before(t)
+}
+
+// consumeAuthorize redeems the authorization code data was issued from.
+// If it was already redeemed, revokes the tokens of the first redemption.
+// Sets an error on the response if the code can't be redeemed.
+func (s *Server) consumeAuthorize(w *Response, data *AccessData) bool {
+	storage, ok := getAuthorizeCodeStorage(w.Storage)
+	if !ok {
+		if err := w.Storage.RemoveAuthorize(data.AuthorizeData.Code); err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_access_request=%s", "error removing authorization code")
+			return false
+		}
+		return true
+	}
+
+	err := storage.ConsumeAuthorize(&RedeemedAuthorizeData{
+		Code:       data.AuthorizeData.Code,
+		ClientId:   data.AuthorizeData.Client.GetId(),
+		GrantId:    data.GrantId,
+		RedeemedAt: s.Now(),
+		ExpireAt:   data.AuthorizeData.ExpireAt(),
+	})
+	if errors.Is(err, ErrAuthorizeRedeemed) {
+		s.revokeRedeemedAuthorize(w, data.AuthorizeData.Code, data.Client)
+		return false
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_access_request=%s", "error consuming authorization code")
+		return false
+	}
+	return true
+}
+
+// revokeRedeemedAuthorize revokes the tokens issued from an authorization code that is
+// used again by its client. Always sets an error on the response.
+func (s *Server) revokeRedeemedAuthorize(w *Response, code string, client Client) {
+	storage, ok := getAuthorizeCodeStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_INVALID_GRANT, ErrNotFound, "auth_code_request=%s", "error loading authorize data")
+		return
+	}
+	redeemed, err := storage.LoadRedeemedAuthorize(code)
+	if errors.Is(err, ErrNotFound) || (err == nil && (redeemed == nil || redeemed.IsExpiredAt(s.Now()))) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, ErrNotFound, "auth_code_request=%s", "error loading authorize data")
+		return
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "auth_code_request=%s", "error loading redeemed authorization code")
+		return
+	}
+
+	// only the client the code was issued to can revoke its tokens
+	if redeemed.ClientId == client.GetId() && redeemed.GrantId != "" {
+		if err = storage.RemoveGrant(redeemed.GrantId); err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "auth_code_request=%s, grant_id=%s", "error revoking grant", redeemed.GrantId)
+			return
+		}
+	}
+	s.setErrorAndLog(w, E_INVALID_GRANT, nil, "auth_code_request=%s, grant_id=%s", "authorization code reused, grant revoked", redeemed.GrantId)
+}
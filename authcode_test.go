@@ -0,0 +1,111 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func newAuthorizationCodeRequest(t *testing.T, clientId, secret, code string) *http.Request {
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth(clientId, secret)
+	req.Form = url.Values{
+		"grant_type": {string(AUTHORIZATION_CODE)},
+		"code":       {code},
+	}
+	req.PostForm = make(url.Values)
+	return req
+}
+
+func TestAuthorizationCodeReuse(t *testing.T) {
+	testcases := map[string]struct {
+		ClientId      string
+		Secret        string
+		ExpectRevoked bool
+	}{
+		"same client": {
+			ClientId:      "1234",
+			Secret:        "aabbccdd",
+			ExpectRevoked: true,
+		},
+		"other client": {
+			ClientId: "5678",
+			Secret:   "eeff",
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		storage := NewTestingStorage()
+		storage.SetClient("5678", &DefaultClient{Id: "5678", Secret: "eeff", RedirectUri: "http://localhost:14000/appauth"})
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+
+		resp := server.NewResponse()
+		req := newAuthorizationCodeRequest(t, "1234", "aabbccdd", "9999")
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Fatalf("%s: error in response: %s: %v", k, resp.ErrorId, resp.InternalError)
+		}
+		if storage.redeemed["9999"] == nil || storage.redeemed["9999"].GrantId != storage.access["1"].GrantId {
+			t.Fatalf("%s: redeemed code was not recorded", k)
+		}
+
+		resp = server.NewResponse()
+		req = newAuthorizationCodeRequest(t, test.ClientId, test.Secret, "9999")
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != E_INVALID_GRANT {
+			t.Errorf("%s: expected error %q, got %q", k, E_INVALID_GRANT, resp.ErrorId)
+		}
+
+		_, accessErr := storage.LoadAccess("1")
+		_, refreshErr := storage.LoadRefresh("r1")
+		if revoked := accessErr == ErrNotFound && refreshErr == ErrNotFound; revoked != test.ExpectRevoked {
+			t.Errorf("%s: expected tokens revoked %v, got access %v, refresh %v", k, test.ExpectRevoked, accessErr, refreshErr)
+		}
+	}
+}
+
+func TestAuthorizationCodeConcurrentRedemption(t *testing.T) {
+	storage := NewTestingStorage()
+	server := NewServer(NewServerConfig(), storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	// both requests load the code before either redeems it
+	var requests []*AccessRequest
+	for i := 0; i < 2; i++ {
+		resp := server.NewResponse()
+		ar := server.HandleAccessRequest(resp, newAuthorizationCodeRequest(t, "1234", "aabbccdd", "9999"))
+		if ar == nil {
+			t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+		}
+		ar.Authorized = true
+		requests = append(requests, ar)
+	}
+
+	resp := server.NewResponse()
+	server.FinishAccessRequest(resp, requests[0].HttpRequest, requests[0])
+	if resp.IsError {
+		t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+
+	resp = server.NewResponse()
+	server.FinishAccessRequest(resp, requests[1].HttpRequest, requests[1])
+	if resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected error %q, got %q", E_INVALID_GRANT, resp.ErrorId)
+	}
+	for _, token := range []string{"1", "2"} {
+		if _, err := storage.LoadAccess(token); err != ErrNotFound {
+			t.Errorf("Access token %s of the code should be revoked", token)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package osin
 
 import (
+	"errors"
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 	"time"
 )
 
@@ -14,14 +16,59 @@ const (
 	CODE  AuthorizeRequestType = "code"
 	TOKEN AuthorizeRequestType = "token"
 
+	// OpenID Connect response types, the composite ones of the hybrid flow
+	// (https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html)
+	ID_TOKEN            AuthorizeRequestType = "id_token"
+	ID_TOKEN_TOKEN      AuthorizeRequestType = "id_token token"
+	CODE_ID_TOKEN       AuthorizeRequestType = "code id_token"
+	CODE_TOKEN          AuthorizeRequestType = "code token"
+	CODE_ID_TOKEN_TOKEN AuthorizeRequestType = "code id_token token"
+
 	PKCE_PLAIN = "plain"
 	PKCE_S256  = "S256"
 )
 
 var (
 	pkceMatcher = regexp.MustCompile("^[a-zA-Z0-9~._-]{43,128}$")
+
+	// responseTypeOrder is the order of the values of composite response types
+	responseTypeOrder = []AuthorizeRequestType{CODE, ID_TOKEN, TOKEN}
 )
 
+// ParseAuthorizeRequestType parses a space separated `response_type`, whose values can be
+// in any order, to its canonical AuthorizeRequestType (e.g. "token code" is CODE_TOKEN).
+// Returns an empty type if it includes unknown or repeated values.
+func ParseAuthorizeRequestType(responseType string) AuthorizeRequestType {
+	values := strings.Fields(responseType)
+	ret := []string{}
+	for _, t := range responseTypeOrder {
+		for _, v := range values {
+			if v == string(t) {
+				ret = append(ret, v)
+			}
+		}
+	}
+	if len(ret) == 0 || len(ret) != len(values) {
+		return ""
+	}
+	for i := 1; i < len(ret); i++ {
+		if ret[i] == ret[i-1] {
+			return ""
+		}
+	}
+	return AuthorizeRequestType(strings.Join(ret, " "))
+}
+
+// Has returns true if the response type includes the single value response type v
+func (t AuthorizeRequestType) Has(v AuthorizeRequestType) bool {
+	for _, f := range strings.Fields(string(t)) {
+		if f == string(v) {
+			return true
+		}
+	}
+	return false
+}
+
 // Authorize request information
 type AuthorizeRequest struct {
 	Type        AuthorizeRequestType
@@ -47,6 +94,23 @@ type AuthorizeRequest struct {
 	CodeChallenge string
 	// Optional code_challenge_method as described in rfc7636
 	CodeChallengeMethod string
+
+	// request_uri of the pushed authorization request (rfc9126), if any
+	RequestUri string
+
+	// claims of the request object (rfc9101), if any
+	requestObject *JWTClaims
+
+	// How the response parameters are returned, defaults to QUERY for CODE and FRAGMENT otherwise
+	ResponseMode ResponseMode
+
+	// Optional OpenID Connect parameters: nonce to include in the ID token,
+	// max_age in seconds, space separated prompt and acr_values, and the JSON claims request
+	Nonce     string
+	MaxAge    string
+	Prompt    string
+	AcrValues string
+	Claims    string
 }
 
 // Authorization data
@@ -79,6 +143,13 @@ type AuthorizeData struct {
 	CodeChallenge string
 	// Optional code_challenge_method as described in rfc7636
 	CodeChallengeMethod string
+
+	// Optional OpenID Connect parameters from request
+	Nonce     string
+	MaxAge    string
+	Prompt    string
+	AcrValues string
+	Claims    string
 }
 
 // IsExpired is true if authorization expired
@@ -104,10 +175,50 @@ type AuthorizeTokenGen interface {
 // HandleAuthorizeRequest is the main http.HandlerFunc for handling
 // authorization requests
 func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *AuthorizeRequest {
+	bindContext(w, r)
 	r.ParseForm()
 
+	// use the parameters of a pushed authorization request (rfc9126)
+	params := r.Form
+	var pushed *PushedAuthorizeData
+	if requestUri := r.FormValue("request_uri"); strings.HasPrefix(requestUri, REQUEST_URI_PREFIX) {
+		if pushed = s.loadPushedAuthorize(w, r.FormValue("client_id"), requestUri); pushed == nil {
+			return nil
+		}
+		params = pushed.Params
+	}
+
+	// or the parameters of a request object (rfc9101)
+	var requestObject *JWTClaims
+	if pushed == nil && (params.Get("request") != "" || params.Get("request_uri") != "") {
+		if params, requestObject = s.verifyRequestObject(w, params); params == nil {
+			return nil
+		}
+	}
+
+	ret := s.handleAuthorizeParams(w, r, params)
+	if ret == nil {
+		return nil
+	}
+
+	if pushed != nil {
+		ret.RequestUri = pushed.RequestUri
+		ret.UserData = pushed.UserData
+	} else if s.requirePushedAuthorization(ret.Client) {
+		w.SetErrorState(E_INVALID_REQUEST, "pushed authorization request (rfc9126) required", ret.State)
+		return nil
+	} else if requestObject == nil && s.requireSignedRequestObject(ret.Client) {
+		w.SetErrorState(E_INVALID_REQUEST, "signed request object (rfc9101) required", ret.State)
+		return nil
+	}
+	ret.requestObject = requestObject
+	return ret
+}
+
+// handleAuthorizeParams validates the authorization request parameters
+func (s *Server) handleAuthorizeParams(w *Response, r *http.Request, params url.Values) *AuthorizeRequest {
 	// create the authorization request
-	unescapedUri, err := url.QueryUnescape(r.FormValue("redirect_uri"))
+	unescapedUri, err := url.QueryUnescape(params.Get("redirect_uri"))
 	if err != nil {
 		w.SetErrorState(E_INVALID_REQUEST, "", "")
 		w.InternalError = err
@@ -115,21 +226,26 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 	}
 
 	ret := &AuthorizeRequest{
-		State:       r.FormValue("state"),
-		Scope:       r.FormValue("scope"),
+		State:       params.Get("state"),
+		Scope:       params.Get("scope"),
 		RedirectUri: unescapedUri,
 		Authorized:  false,
 		HttpRequest: r,
+		Nonce:       params.Get("nonce"),
+		MaxAge:      params.Get("max_age"),
+		Prompt:      params.Get("prompt"),
+		AcrValues:   params.Get("acr_values"),
+		Claims:      params.Get("claims"),
 	}
 
 	// must have a valid client
-	ret.Client, err = w.Storage.GetClient(r.FormValue("client_id"))
-	if err == ErrNotFound {
+	ret.Client, err = w.Storage.GetClient(params.Get("client_id"))
+	if errors.Is(err, ErrNotFound) {
 		w.SetErrorState(E_UNAUTHORIZED_CLIENT, "", ret.State)
 		return nil
 	}
 	if err != nil {
-		w.SetErrorState(E_SERVER_ERROR, "", ret.State)
+		w.SetErrorState(storageErrorId(E_SERVER_ERROR, err), "", ret.State)
 		w.InternalError = err
 		return nil
 	}
@@ -137,18 +253,19 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 		w.SetErrorState(E_UNAUTHORIZED_CLIENT, "", ret.State)
 		return nil
 	}
-	if ret.Client.GetRedirectUri() == "" {
+	redirectUris := s.clientRedirectUris(ret.Client)
+	if len(redirectUris) == 0 {
 		w.SetErrorState(E_UNAUTHORIZED_CLIENT, "", ret.State)
 		return nil
 	}
 
 	// check redirect uri, if there are multiple client redirect uri's
 	// don't set the uri
-	if ret.RedirectUri == "" && FirstUri(ret.Client.GetRedirectUri(), s.Config.RedirectUriSeparator) == ret.Client.GetRedirectUri() {
-		ret.RedirectUri = FirstUri(ret.Client.GetRedirectUri(), s.Config.RedirectUriSeparator)
+	if ret.RedirectUri == "" && len(redirectUris) == 1 {
+		ret.RedirectUri = redirectUris[0]
 	}
 
-	if realRedirectUri, err := ValidateUriList(ret.Client.GetRedirectUri(), ret.RedirectUri, s.Config.RedirectUriSeparator); err != nil {
+	if realRedirectUri, err := s.validateRedirectUri(ret.Client, ret.RedirectUri); err != nil {
 		w.SetErrorState(E_INVALID_REQUEST, "", ret.State)
 		w.InternalError = err
 		return nil
@@ -157,55 +274,93 @@ func (s *Server) HandleAuthorizeRequest(w *Response, r *http.Request) *Authorize
 	}
 
 	w.SetRedirect(ret.RedirectUri)
+	w.RedirectIssuer = s.Config.Issuer
+
+	if err := validateOpenIDParams(ret); err != nil {
+		w.SetErrorState(E_INVALID_REQUEST, "", ret.State)
+		w.InternalError = err
+		return nil
+	}
+
+	requestType := ParseAuthorizeRequestType(params.Get("response_type"))
+	if !s.Config.AllowedAuthorizeTypes.Exists(requestType) {
+		w.SetErrorState(E_UNSUPPORTED_RESPONSE_TYPE, "", ret.State)
+		return nil
+	}
+	if !clientAllowsAuthorizeType(ret.Client, requestType) {
+		w.SetErrorState(E_UNAUTHORIZED_CLIENT, "", ret.State)
+		return nil
+	}
+	ret.Type = requestType
+
+	// errors are returned with the requested response mode from now on
+	if ret.ResponseMode, err = s.parseResponseMode(params.Get("response_mode"), requestType); err != nil {
+		w.SetErrorState(E_INVALID_REQUEST, "", ret.State)
+		w.InternalError = err
+		return nil
+	}
+	s.setResponseMode(w, ret)
 
-	requestType := AuthorizeRequestType(r.FormValue("response_type"))
-	if s.Config.AllowedAuthorizeTypes.Exists(requestType) {
-		switch requestType {
-		case CODE:
-			ret.Type = CODE
-			ret.Expiration = s.Config.AuthorizationExpiration
-
-			// Optional PKCE support (https://tools.ietf.org/html/rfc7636)
-			if codeChallenge := r.FormValue("code_challenge"); len(codeChallenge) == 0 {
-				if s.Config.RequirePKCEForPublicClients && CheckClientSecret(ret.Client, "") {
-					// https://tools.ietf.org/html/rfc7636#section-4.4.1
-					w.SetErrorState(E_INVALID_REQUEST, "code_challenge (rfc7636) required for public clients", ret.State)
-					return nil
-				}
-			} else {
-				codeChallengeMethod := r.FormValue("code_challenge_method")
-				// allowed values are "plain" (default) and "S256", per https://tools.ietf.org/html/rfc7636#section-4.3
-				if len(codeChallengeMethod) == 0 {
-					codeChallengeMethod = PKCE_PLAIN
-				}
-				if codeChallengeMethod != PKCE_PLAIN && codeChallengeMethod != PKCE_S256 {
-					// https://tools.ietf.org/html/rfc7636#section-4.4.1
-					w.SetErrorState(E_INVALID_REQUEST, "code_challenge_method transform algorithm not supported (rfc7636)", ret.State)
-					return nil
-				}
-
-				// https://tools.ietf.org/html/rfc7636#section-4.2
-				if matched := pkceMatcher.MatchString(codeChallenge); !matched {
-					w.SetErrorState(E_INVALID_REQUEST, "code_challenge invalid (rfc7636)", ret.State)
-					return nil
-				}
-
-				ret.CodeChallenge = codeChallenge
-				ret.CodeChallengeMethod = codeChallengeMethod
+	if requestType.Has(CODE) {
+		ret.Expiration = s.authorizationExpiration(ret.Client)
+
+		// Optional PKCE support (https://tools.ietf.org/html/rfc7636)
+		if codeChallenge := params.Get("code_challenge"); len(codeChallenge) == 0 {
+			if s.Config.RequirePKCEForPublicClients && isPublicClient(ret.Client) {
+				// https://tools.ietf.org/html/rfc7636#section-4.4.1
+				w.SetErrorState(E_INVALID_REQUEST, "code_challenge (rfc7636) required for public clients", ret.State)
+				return nil
+			}
+		} else {
+			codeChallengeMethod := params.Get("code_challenge_method")
+			// allowed values are "plain" (default) and "S256", per https://tools.ietf.org/html/rfc7636#section-4.3
+			if len(codeChallengeMethod) == 0 {
+				codeChallengeMethod = PKCE_PLAIN
+			}
+			if codeChallengeMethod != PKCE_PLAIN && codeChallengeMethod != PKCE_S256 {
+				// https://tools.ietf.org/html/rfc7636#section-4.4.1
+				w.SetErrorState(E_INVALID_REQUEST, "code_challenge_method transform algorithm not supported (rfc7636)", ret.State)
+				return nil
+			}
+
+			// https://tools.ietf.org/html/rfc7636#section-4.2
+			if matched := pkceMatcher.MatchString(codeChallenge); !matched {
+				w.SetErrorState(E_INVALID_REQUEST, "code_challenge invalid (rfc7636)", ret.State)
+				return nil
 			}
 
-		case TOKEN:
-			ret.Type = TOKEN
-			ret.Expiration = s.Config.AccessExpiration
+			ret.CodeChallenge = codeChallenge
+			ret.CodeChallengeMethod = codeChallengeMethod
 		}
-		return ret
+	} else {
+		// implicit flow, the expiration is for the ACCESS token
+		ret.Expiration = s.accessExpiration(ret.Client)
+	}
+
+	// ID tokens returned by the authorization endpoint must be bound to the request
+	// https://openid.net/specs/openid-connect-core-1_0.html#ImplicitAuthRequest
+	if requestType.Has(ID_TOKEN) {
+		if !hasScope(ret.Scope, OPENID_SCOPE) {
+			w.SetErrorState(E_INVALID_REQUEST, "id_token response type requires the openid scope", ret.State)
+			return nil
+		}
+		if ret.Nonce == "" {
+			w.SetErrorState(E_INVALID_REQUEST, "nonce required with the id_token response type", ret.State)
+			return nil
+		}
+	}
+
+	if !clientAllowsScope(ret.Client, ret.Scope) {
+		w.SetErrorState(E_INVALID_SCOPE, "", ret.State)
+		return nil
 	}
 
-	w.SetErrorState(E_UNSUPPORTED_RESPONSE_TYPE, "", ret.State)
-	return nil
+	return ret
 }
 
 func (s *Server) FinishAuthorizeRequest(w *Response, r *http.Request, ar *AuthorizeRequest) {
+	bindContext(w, r)
+
 	// don't process if is already an error
 	if w.IsError {
 		return
@@ -213,65 +368,114 @@ func (s *Server) FinishAuthorizeRequest(w *Response, r *http.Request, ar *Author
 
 	// force redirect response
 	w.SetRedirect(ar.RedirectUri)
+	w.RedirectIssuer = s.Config.Issuer
 
-	if ar.Authorized {
-		if ar.Type == TOKEN {
-			w.SetRedirectFragment(true)
-
-			// generate token directly
-			ret := &AccessRequest{
-				Type:            IMPLICIT,
-				Code:            "",
-				Client:          ar.Client,
-				RedirectUri:     ar.RedirectUri,
-				Scope:           ar.Scope,
-				GenerateRefresh: false, // per the RFC, should NOT generate a refresh token in this case
-				Authorized:      true,
-				Expiration:      ar.Expiration,
-				UserData:        ar.UserData,
-			}
+	s.setResponseMode(w, ar)
 
-			s.FinishAccessRequest(w, r, ret)
-			if ar.State != "" && w.InternalError == nil {
-				w.Output["state"] = ar.State
-			}
-		} else {
-			// generate authorization token
-			ret := &AuthorizeData{
-				Client:      ar.Client,
-				CreatedAt:   s.Now(),
-				ExpiresIn:   ar.Expiration,
-				RedirectUri: ar.RedirectUri,
-				State:       ar.State,
-				Scope:       ar.Scope,
-				UserData:    ar.UserData,
-				// Optional PKCE challenge
-				CodeChallenge:       ar.CodeChallenge,
-				CodeChallengeMethod: ar.CodeChallengeMethod,
-			}
+	if !ar.Authorized {
+		// redirect with error
+		w.SetErrorState(E_ACCESS_DENIED, "", ar.State)
+		return
+	}
 
-			// generate token code
-			code, err := s.AuthorizeTokenGen.GenerateAuthorizeToken(ret)
-			if err != nil {
-				w.SetErrorState(E_SERVER_ERROR, "", ar.State)
-				w.InternalError = err
-				return
-			}
-			ret.Code = code
+	// the request_uri of a pushed authorization request can only be used once
+	if ar.RequestUri != "" && !s.consumePushedAuthorize(w, ar) {
+		return
+	}
 
-			// save authorization token
-			if err = w.Storage.SaveAuthorize(ret); err != nil {
-				w.SetErrorState(E_SERVER_ERROR, "", ar.State)
-				w.InternalError = err
-				return
-			}
+	// and request objects can't be replayed
+	if err := saveRequestObjectJTI(w.Storage, ar.requestObject); err != nil {
+		w.SetErrorState(requestObjectErrorId(err), "", ar.State)
+		w.InternalError = err
+		return
+	}
 
-			// redirect with code
-			w.Output["code"] = ret.Code
-			w.Output["state"] = ret.State
+	ret := &AuthorizeData{
+		Client:      ar.Client,
+		CreatedAt:   s.Now(),
+		ExpiresIn:   ar.Expiration,
+		RedirectUri: ar.RedirectUri,
+		State:       ar.State,
+		Scope:       ar.Scope,
+		UserData:    ar.UserData,
+		// Optional PKCE challenge
+		CodeChallenge:       ar.CodeChallenge,
+		CodeChallengeMethod: ar.CodeChallengeMethod,
+		// Optional OpenID Connect parameters
+		Nonce:     ar.Nonce,
+		MaxAge:    ar.MaxAge,
+		Prompt:    ar.Prompt,
+		AcrValues: ar.AcrValues,
+		Claims:    ar.Claims,
+	}
+
+	if ar.Type.Has(CODE) {
+		// generate token code
+		code, err := s.AuthorizeTokenGen.GenerateAuthorizeToken(ret)
+		if err != nil {
+			w.SetErrorState(E_SERVER_ERROR, "", ar.State)
+			w.InternalError = err
+			return
 		}
-	} else {
-		// redirect with error
-		w.SetErrorState(E_ACCESS_DENIED, "", ar.State)
+		ret.Code = code
+
+		// save authorization token
+		if err = w.Storage.SaveAuthorize(ret); err != nil {
+			w.SetErrorState(storageErrorId(E_SERVER_ERROR, err), "", ar.State)
+			w.InternalError = err
+			return
+		}
+
+		// redirect with code
+		w.Output["code"] = ret.Code
+	}
+
+	var accessData *AccessData
+	if ar.Type.Has(TOKEN) {
+		// with a code, ar.Expiration is the code expiration
+		expiration := ar.Expiration
+		if ar.Type.Has(CODE) {
+			expiration = s.accessExpiration(ar.Client)
+		}
+
+		// generate token directly
+		accessData = s.finishAccessRequest(w, r, &AccessRequest{
+			Type:            IMPLICIT,
+			Code:            "",
+			Client:          ar.Client,
+			RedirectUri:     ar.RedirectUri,
+			Scope:           ar.Scope,
+			GenerateRefresh: false, // per the RFC, should NOT generate a refresh token in this case
+			Authorized:      true,
+			Expiration:      expiration,
+			UserData:        ar.UserData,
+		})
+	}
+
+	// https://openid.net/specs/openid-connect-core-1_0.html#HybridIDToken
+	if ar.Type.Has(ID_TOKEN) && !w.IsError {
+		accessToken := ""
+		if accessData != nil {
+			accessToken = accessData.AccessToken
+		}
+		idToken, err := s.generateIDToken(&IDTokenRequest{
+			Client:        ar.Client,
+			Scope:         ar.Scope,
+			AuthorizeData: ret,
+			AccessData:    accessData,
+			UserData:      ar.UserData,
+		}, accessToken, ret.Code)
+		if err != nil {
+			w.SetErrorState(E_SERVER_ERROR, "", ar.State)
+			w.InternalError = err
+			return
+		}
+		w.Output["id_token"] = idToken
+	}
+
+	if ar.Type == CODE {
+		w.Output["state"] = ret.State
+	} else if ar.State != "" && w.InternalError == nil {
+		w.Output["state"] = ar.State
 	}
 }
@@ -294,3 +294,167 @@ func TestAuthorizeCodePKCES256(t *testing.T) {
 		t.Errorf("Expected stored code_challenge S256, got %s", token.CodeChallengeMethod)
 	}
 }
+
+func TestParseAuthorizeRequestType(t *testing.T) {
+	testcases := map[string]AuthorizeRequestType{
+		"code":                CODE,
+		"token":               TOKEN,
+		"id_token":            ID_TOKEN,
+		"token id_token":      ID_TOKEN_TOKEN,
+		"id_token code":       CODE_ID_TOKEN,
+		"token  code":         CODE_TOKEN,
+		"token id_token code": CODE_ID_TOKEN_TOKEN,
+		"":                    "",
+		"code code":           "",
+		"code other":          "",
+	}
+	for responseType, expected := range testcases {
+		if rt := ParseAuthorizeRequestType(responseType); rt != expected {
+			t.Errorf("%q: expected %q, got %q", responseType, expected, rt)
+		}
+	}
+
+	allowed := AllowedAuthorizeType{CODE, "id_token code"}
+	if !allowed.Exists(CODE_ID_TOKEN) || !allowed.Exists("code  id_token") || allowed.Exists(CODE_TOKEN) {
+		t.Errorf("Composite response types should match regardless of order")
+	}
+}
+
+func TestAuthorizeIssuer(t *testing.T) {
+	testcases := map[string]struct {
+		Issuer        string
+		ResponseType  AuthorizeRequestType
+		Authorized    bool
+		ExpectedError string
+	}{
+		"code": {
+			Issuer:       "https://auth.example.com",
+			ResponseType: CODE,
+			Authorized:   true,
+		},
+		"token": {
+			Issuer:       "https://auth.example.com",
+			ResponseType: TOKEN,
+			Authorized:   true,
+		},
+		"denied": {
+			Issuer:        "https://auth.example.com",
+			ResponseType:  CODE,
+			ExpectedError: E_ACCESS_DENIED,
+		},
+		"unsupported response type": {
+			Issuer:        "https://auth.example.com",
+			ResponseType:  "other",
+			ExpectedError: E_UNSUPPORTED_RESPONSE_TYPE,
+		},
+		"no issuer": {
+			ResponseType: CODE,
+			Authorized:   true,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.Issuer = test.Issuer
+		sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{CODE, TOKEN}
+		server := NewServer(sconfig, NewTestingStorage())
+		server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{"response_type": {string(test.ResponseType)}, "client_id": {"1234"}, "state": {"a"}}
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = test.Authorized
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+
+		redirectUrl, err := resp.GetRedirectUrl()
+		if err != nil {
+			t.Errorf("%s: %v", k, err)
+			continue
+		}
+		u, err := url.Parse(redirectUrl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := u.Query()
+		if resp.RedirectInFragment {
+			params, _ = url.ParseQuery(u.Fragment)
+		}
+		if iss := params.Get("iss"); iss != test.Issuer {
+			t.Errorf("%s: expected iss %q, got %q", k, test.Issuer, iss)
+		}
+	}
+}
+
+func TestAuthorizeRedirectUris(t *testing.T) {
+	testcases := map[string]struct {
+		RedirectUri   string
+		ExpectedError string
+		ExpectedUrl   string
+	}{
+		"first": {
+			RedirectUri: "http://localhost:14000/appauth",
+			ExpectedUrl: "http://localhost:14000/appauth?code=1&state=a",
+		},
+		"second": {
+			RedirectUri: "http://localhost:14000/other,auth",
+			ExpectedUrl: "http://localhost:14000/other,auth?code=1&state=a",
+		},
+		"unregistered": {
+			RedirectUri:   "http://localhost:14000/other",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"ambiguous": {
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.RedirectUriSeparator = ","
+		storage := NewTestingStorage()
+		storage.SetClient("list", &DefaultClient{
+			Id:           "list",
+			RedirectUris: []string{"http://localhost:14000/appauth", "http://localhost:14000/other,auth"},
+		})
+		server := NewServer(sconfig, storage)
+		server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{
+			"response_type": {string(CODE)},
+			"client_id":     {"list"},
+			"redirect_uri":  {test.RedirectUri},
+			"state":         {"a"},
+		}
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			continue
+		}
+		if u, err := resp.GetRedirectUrl(); err != nil || u != test.ExpectedUrl {
+			t.Errorf("%s: expected redirect %q, got %q: %v", k, test.ExpectedUrl, u, err)
+		}
+	}
+}
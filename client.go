@@ -1,6 +1,12 @@
 package osin
 
-import "crypto/subtle"
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"strings"
+
+	"gopkg.in/square/go-jose.v1"
+)
 
 // Client information
 type Client interface {
@@ -25,12 +31,108 @@ type ClientSecretMatcher interface {
 	ClientSecretMatches(secret string) bool
 }
 
+// ClientAssertionKeys is an optional interface clients can implement which allows
+// them to authenticate with a JWT client assertion instead of sending a secret
+// (https://tools.ietf.org/html/rfc7523#section-2.2), and to send signed request objects (rfc9101)
+type ClientAssertionKeys interface {
+	// GetAssertionKeys returns the keys client assertions and request objects can be signed with:
+	// the client's registered public keys for private_key_jwt, and its secret as a symmetric key
+	// for client_secret_jwt.
+	GetAssertionKeys() (*jose.JsonWebKeySet, error)
+}
+
+// ClientRedirectUris is an optional interface clients can implement to register several
+// redirect URIs as a list. Clients not implementing it can register several URIs in
+// GetRedirectUri, joined by ServerConfig.RedirectUriSeparator.
+type ClientRedirectUris interface {
+	// GetRedirectUris returns the registered redirect URIs, the first one being the default.
+	// If empty, GetRedirectUri is used.
+	GetRedirectUris() []string
+}
+
+// ClientGrantTypes is an optional interface clients can implement to restrict the grant
+// types they can use (grant_types client metadata, rfc7591). Clients not implementing it
+// can use all the types allowed by ServerConfig.AllowedAccessTypes.
+type ClientGrantTypes interface {
+	// GetAllowedAccessTypes returns the grant types the client can use, IMPLICIT standing
+	// for the tokens issued by the authorization endpoint. Nil allows all types.
+	GetAllowedAccessTypes() AllowedAccessType
+}
+
+// ClientResponseTypes is an optional interface clients can implement to restrict the
+// response types of their authorization requests (response_types client metadata, rfc7591)
+type ClientResponseTypes interface {
+	// GetAllowedAuthorizeTypes returns the response types the client can use. Nil allows all types.
+	GetAllowedAuthorizeTypes() AllowedAuthorizeType
+}
+
+// ClientScopes is an optional interface clients can implement to restrict the scopes
+// they can request (scope client metadata, rfc7591)
+type ClientScopes interface {
+	// GetAllowedScopes returns the scopes the client can request. Nil allows any scope.
+	GetAllowedScopes() []string
+}
+
+// ClientAuthMethod is an optional interface clients can implement to only be able to
+// authenticate with their registered method (token_endpoint_auth_method client metadata, rfc7591)
+type ClientAuthMethod interface {
+	// GetTokenEndpointAuthMethod returns the authentication method of the client: none,
+	// client_secret_basic, client_secret_post, client_secret_jwt, private_key_jwt,
+	// tls_client_auth or self_signed_tls_client_auth. Empty allows any method.
+	GetTokenEndpointAuthMethod() string
+}
+
+// ClientType is an optional interface clients can implement to tell whether they are public
+// or confidential (https://tools.ietf.org/html/rfc6749#section-2.1). Clients not implementing
+// it are public if they have no secret.
+type ClientType interface {
+	// IsPublic returns true if the client can't authenticate
+	IsPublic() bool
+}
+
+// ClientTokenLifetimes is an optional interface clients can implement to override the
+// token expirations of ServerConfig
+type ClientTokenLifetimes interface {
+	// GetAccessExpiration returns the access token expiration in seconds, 0 for the default
+	GetAccessExpiration() int32
+
+	// GetAuthorizationExpiration returns the authorization code expiration in seconds, 0 for the default
+	GetAuthorizationExpiration() int32
+}
+
 // DefaultClient stores all data in struct variables
 type DefaultClient struct {
 	Id          string
 	Secret      string
 	RedirectUri string
 	UserData    interface{}
+
+	// Optional list of redirect URIs, used instead of RedirectUri if not empty
+	RedirectUris []string
+
+	// Optional redirect URI validation policy, Server.RedirectURIValidator if nil
+	RedirectURIValidator RedirectURIValidator
+
+	// Optional registered public keys, to authenticate with private_key_jwt
+	// or self_signed_tls_client_auth
+	JsonWebKeys *jose.JsonWebKeySet
+
+	// Optional registered certificate subject, to authenticate with tls_client_auth
+	TLSClientAuth *TLSClientAuth
+
+	// Optional grant types, response types and scopes the client is restricted to.
+	// Nil allows all those allowed by the server.
+	AllowedAccessTypes    AllowedAccessType
+	AllowedAuthorizeTypes AllowedAuthorizeType
+	AllowedScopes         []string
+
+	// Optional authentication method the client must use. If empty, any method
+	// is allowed and the client is public if it has no secret.
+	TokenEndpointAuthMethod string
+
+	// Optional token expirations in seconds, the server defaults if 0
+	AccessExpiration        int32
+	AuthorizationExpiration int32
 }
 
 func (d *DefaultClient) GetId() string {
@@ -42,6 +144,9 @@ func (d *DefaultClient) GetSecret() string {
 }
 
 func (d *DefaultClient) GetRedirectUri() string {
+	if d.RedirectUri == "" && len(d.RedirectUris) > 0 {
+		return d.RedirectUris[0]
+	}
 	return d.RedirectUri
 }
 
@@ -54,9 +159,206 @@ func (d *DefaultClient) ClientSecretMatches(secret string) bool {
 	return subtle.ConstantTimeCompare([]byte(d.Secret), []byte(secret)) == 1
 }
 
+// Implement the ClientAssertionKeys interface. The secret is only a key of clients
+// registered with the client_secret_jwt authentication method.
+func (d *DefaultClient) GetAssertionKeys() (*jose.JsonWebKeySet, error) {
+	ret := &jose.JsonWebKeySet{}
+	if d.JsonWebKeys != nil {
+		ret.Keys = append(ret.Keys, d.JsonWebKeys.Keys...)
+	}
+	if d.Secret != "" && d.TokenEndpointAuthMethod == "client_secret_jwt" {
+		ret.Keys = append(ret.Keys, jose.JsonWebKey{Key: []byte(d.Secret), Use: "sig"})
+	}
+	return ret, nil
+}
+
+// Implement the ClientCertificateMatcher interface. Certificates verified by the TLS server
+// are matched against TLSClientAuth, self-signed ones against the public keys in JsonWebKeys.
+func (d *DefaultClient) ClientCertificateMatches(cert *x509.Certificate, verified bool) bool {
+	if d.TLSClientAuth != nil {
+		return verified && d.TLSClientAuth.Matches(cert)
+	}
+	if d.JsonWebKeys == nil {
+		return false
+	}
+	thumbprint := keyThumbprint(cert.PublicKey)
+	if thumbprint == "" {
+		return false
+	}
+	for _, key := range d.JsonWebKeys.Keys {
+		if key.Use != "enc" && keyThumbprint(key.Key) == thumbprint {
+			return true
+		}
+	}
+	return false
+}
+
+// Implement the ClientRedirectUris interface
+func (d *DefaultClient) GetRedirectUris() []string {
+	return d.RedirectUris
+}
+
+// Implement the ClientRedirectURIValidator interface
+func (d *DefaultClient) GetRedirectURIValidator() RedirectURIValidator {
+	return d.RedirectURIValidator
+}
+
+// Implement the ClientGrantTypes interface
+func (d *DefaultClient) GetAllowedAccessTypes() AllowedAccessType {
+	return d.AllowedAccessTypes
+}
+
+// Implement the ClientResponseTypes interface
+func (d *DefaultClient) GetAllowedAuthorizeTypes() AllowedAuthorizeType {
+	return d.AllowedAuthorizeTypes
+}
+
+// Implement the ClientScopes interface
+func (d *DefaultClient) GetAllowedScopes() []string {
+	return d.AllowedScopes
+}
+
+// Implement the ClientAuthMethod interface
+func (d *DefaultClient) GetTokenEndpointAuthMethod() string {
+	return d.TokenEndpointAuthMethod
+}
+
+// Implement the ClientType interface
+func (d *DefaultClient) IsPublic() bool {
+	if d.TokenEndpointAuthMethod != "" {
+		return d.TokenEndpointAuthMethod == "none"
+	}
+	return d.Secret == ""
+}
+
+// Implement the ClientTokenLifetimes interface
+func (d *DefaultClient) GetAccessExpiration() int32 {
+	return d.AccessExpiration
+}
+
+func (d *DefaultClient) GetAuthorizationExpiration() int32 {
+	return d.AuthorizationExpiration
+}
+
 func (d *DefaultClient) CopyFrom(client Client) {
 	d.Id = client.GetId()
 	d.Secret = client.GetSecret()
 	d.RedirectUri = client.GetRedirectUri()
 	d.UserData = client.GetUserData()
+	if c, ok := client.(*DefaultClient); ok {
+		d.JsonWebKeys = c.JsonWebKeys
+		d.TLSClientAuth = c.TLSClientAuth
+		d.RedirectUris = c.RedirectUris
+		d.RedirectURIValidator = c.RedirectURIValidator
+		d.AllowedAccessTypes = c.AllowedAccessTypes
+		d.AllowedAuthorizeTypes = c.AllowedAuthorizeTypes
+		d.AllowedScopes = c.AllowedScopes
+		d.TokenEndpointAuthMethod = c.TokenEndpointAuthMethod
+		d.AccessExpiration = c.AccessExpiration
+		d.AuthorizationExpiration = c.AuthorizationExpiration
+	}
+}
+
+// clientRedirectUris returns the registered redirect URIs of the client, the first one being the default
+func (s *Server) clientRedirectUris(client Client) []string {
+	if c, ok := client.(ClientRedirectUris); ok {
+		if uris := c.GetRedirectUris(); len(uris) > 0 {
+			return uris
+		}
+	}
+	uri := client.GetRedirectUri()
+	if uri == "" {
+		return nil
+	}
+	if s.Config.RedirectUriSeparator != "" {
+		return strings.Split(uri, s.Config.RedirectUriSeparator)
+	}
+	return []string{uri}
+}
+
+// firstRedirectUri returns the default redirect URI of the client
+func (s *Server) firstRedirectUri(client Client) string {
+	if uris := s.clientRedirectUris(client); len(uris) > 0 {
+		return uris[0]
+	}
+	return ""
+}
+
+// isPublicClient returns true if the client can't authenticate (https://tools.ietf.org/html/rfc6749#section-2.1)
+func isPublicClient(client Client) bool {
+	if c, ok := client.(ClientType); ok {
+		return c.IsPublic()
+	}
+	return CheckClientSecret(client, "")
+}
+
+// clientAllowsAccessType returns true if the client can use the grant type
+func clientAllowsAccessType(client Client, t AccessRequestType) bool {
+	if c, ok := client.(ClientGrantTypes); ok {
+		if allowed := c.GetAllowedAccessTypes(); allowed != nil {
+			return allowed.Exists(t)
+		}
+	}
+	return true
+}
+
+// clientAllowsAuthorizeType returns true if the client can use the response type,
+// and the grant types it requires (https://tools.ietf.org/html/rfc7591#section-2.1)
+func clientAllowsAuthorizeType(client Client, t AuthorizeRequestType) bool {
+	if c, ok := client.(ClientResponseTypes); ok {
+		if allowed := c.GetAllowedAuthorizeTypes(); allowed != nil && !allowed.Exists(t) {
+			return false
+		}
+	}
+	if t.Has(CODE) && !clientAllowsAccessType(client, AUTHORIZATION_CODE) {
+		return false
+	}
+	if (t.Has(TOKEN) || t.Has(ID_TOKEN)) && !clientAllowsAccessType(client, IMPLICIT) {
+		return false
+	}
+	return true
+}
+
+// clientAllowsScope returns true if the client can request all the scopes of the space separated list
+func clientAllowsScope(client Client, scope string) bool {
+	c, ok := client.(ClientScopes)
+	if !ok {
+		return true
+	}
+	allowed := c.GetAllowedScopes()
+	if allowed == nil {
+		return true
+	}
+	for _, s := range strings.Fields(scope) {
+		if !containsString(allowed, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// clientAllowsAuthMethod returns true if the client can authenticate with the method of auth
+func clientAllowsAuthMethod(client Client, auth *clientAuth) bool {
+	if c, ok := client.(ClientAuthMethod); ok {
+		if method := c.GetTokenEndpointAuthMethod(); method != "" {
+			return method == auth.authMethod()
+		}
+	}
+	return true
+}
+
+// accessExpiration returns the access token expiration of the client
+func (s *Server) accessExpiration(client Client) int32 {
+	if c, ok := client.(ClientTokenLifetimes); ok && c.GetAccessExpiration() > 0 {
+		return c.GetAccessExpiration()
+	}
+	return s.Config.AccessExpiration
+}
+
+// authorizationExpiration returns the authorization code expiration of the client
+func (s *Server) authorizationExpiration(client Client) int32 {
+	if c, ok := client.(ClientTokenLifetimes); ok && c.GetAuthorizationExpiration() > 0 {
+		return c.GetAuthorizationExpiration()
+	}
+	return s.Config.AuthorizationExpiration
 }
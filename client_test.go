@@ -1,6 +1,8 @@
 package osin
 
 import (
+	"net/http"
+	"net/url"
 	"testing"
 )
 
@@ -16,3 +18,153 @@ func TestClientIntfUserData(t *testing.T) {
 		t.Error("Returned interface is not a reference")
 	}
 }
+
+func TestClientRestrictions(t *testing.T) {
+	testcases := map[string]struct {
+		Client             *DefaultClient
+		Scope              string
+		SecretInParams     bool
+		ExpectedError      string
+		ExpectedExpiration int32
+	}{
+		"unrestricted": {
+			Client:             &DefaultClient{Id: "1234", Secret: "aabbccdd"},
+			ExpectedExpiration: 3600,
+		},
+		"grant type allowed": {
+			Client:             &DefaultClient{Id: "1234", Secret: "aabbccdd", AllowedAccessTypes: AllowedAccessType{CLIENT_CREDENTIALS}, AccessExpiration: 60},
+			ExpectedExpiration: 60,
+		},
+		"grant type not allowed": {
+			Client:        &DefaultClient{Id: "1234", Secret: "aabbccdd", AllowedAccessTypes: AllowedAccessType{AUTHORIZATION_CODE}},
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"scope allowed": {
+			Client:             &DefaultClient{Id: "1234", Secret: "aabbccdd", AllowedScopes: []string{"read", "write"}},
+			Scope:              "read",
+			ExpectedExpiration: 3600,
+		},
+		"scope not allowed": {
+			Client:        &DefaultClient{Id: "1234", Secret: "aabbccdd", AllowedScopes: []string{"read"}},
+			Scope:         "read admin",
+			ExpectedError: E_INVALID_SCOPE,
+		},
+		"auth method allowed": {
+			Client:             &DefaultClient{Id: "1234", Secret: "aabbccdd", TokenEndpointAuthMethod: "client_secret_post"},
+			SecretInParams:     true,
+			ExpectedExpiration: 3600,
+		},
+		"auth method not allowed": {
+			Client:        &DefaultClient{Id: "1234", Secret: "aabbccdd", TokenEndpointAuthMethod: "client_secret_post"},
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, CLIENT_CREDENTIALS}
+		sconfig.AllowClientSecretInParams = true
+		storage := NewTestingStorage()
+		test.Client.RedirectUri = "http://localhost:14000/appauth"
+		storage.SetClient("1234", test.Client)
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{
+			"grant_type": {string(CLIENT_CREDENTIALS)},
+			"scope":      {test.Scope},
+		}
+		req.PostForm = make(url.Values)
+		if test.SecretInParams {
+			req.Form.Set("client_id", "1234")
+			req.Form.Set("client_secret", "aabbccdd")
+		} else {
+			req.SetBasicAuth("1234", "aabbccdd")
+		}
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError == "" && resp.Output["expires_in"] != test.ExpectedExpiration {
+			t.Errorf("%s: expected expiration %d, got %v", k, test.ExpectedExpiration, resp.Output["expires_in"])
+		}
+	}
+}
+
+func TestClientResponseTypes(t *testing.T) {
+	testcases := map[string]struct {
+		Client        *DefaultClient
+		ResponseType  AuthorizeRequestType
+		ExpectedError string
+	}{
+		"code allowed": {
+			Client:       &DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth", AllowedAuthorizeTypes: AllowedAuthorizeType{CODE}},
+			ResponseType: CODE,
+		},
+		"token not allowed": {
+			Client:        &DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth", AllowedAuthorizeTypes: AllowedAuthorizeType{CODE}},
+			ResponseType:  TOKEN,
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"token without implicit grant": {
+			Client:        &DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth", AllowedAccessTypes: AllowedAccessType{AUTHORIZATION_CODE}},
+			ResponseType:  TOKEN,
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"token with implicit grant": {
+			Client:       &DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth", AllowedAccessTypes: AllowedAccessType{IMPLICIT}},
+			ResponseType: TOKEN,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{CODE, TOKEN}
+		storage := NewTestingStorage()
+		storage.SetClient("1234", test.Client)
+		server := NewServer(sconfig, storage)
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{
+			"response_type": {string(test.ResponseType)},
+			"client_id":     {"1234"},
+			"state":         {"a"},
+		}
+
+		server.HandleAuthorizeRequest(resp, req)
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+		}
+	}
+}
+
+func TestClientIsPublic(t *testing.T) {
+	testcases := map[string]struct {
+		Client   Client
+		Expected bool
+	}{
+		"no secret":       {&DefaultClient{Id: "1"}, true},
+		"secret":          {&DefaultClient{Id: "1", Secret: "s"}, false},
+		"none method":     {&DefaultClient{Id: "1", Secret: "s", TokenEndpointAuthMethod: "none"}, true},
+		"private_key_jwt": {&DefaultClient{Id: "1", TokenEndpointAuthMethod: "private_key_jwt"}, false},
+	}
+	for k, test := range testcases {
+		if p := isPublicClient(test.Client); p != test.Expected {
+			t.Errorf("%s: expected public %v, got %v", k, test.Expected, p)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package osin
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// CLIENT_ASSERTION_JWT_BEARER is the client_assertion_type of JWT client assertions
+// (https://tools.ietf.org/html/rfc7523#section-2.2)
+const CLIENT_ASSERTION_JWT_BEARER = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// getClientAssertionAuth gets the client authentication from a JWT client assertion,
+// used by the private_key_jwt and client_secret_jwt authentication methods.
+// The assertion is verified when the client is authenticated.
+// Sets an error on the response if the assertion is malformed.
+func (s Server) getClientAssertionAuth(w *Response, r *http.Request) *clientAuth {
+	if t := r.FormValue("client_assertion_type"); t != CLIENT_ASSERTION_JWT_BEARER {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, fmt.Errorf("unsupported client_assertion_type %q", t), "get_client_auth=%s", "unsupported client assertion type")
+		return nil
+	}
+	assertion := r.FormValue("client_assertion")
+	if assertion == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "get_client_auth=%s", "client_assertion required")
+		return nil
+	}
+
+	// only one authentication method may be used
+	if _, hasSecret := r.Form["client_secret"]; hasSecret || r.Header.Get("Authorization") != "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "get_client_auth=%s", "multiple client authentication methods")
+		return nil
+	}
+
+	// the client is identified by the subject, client_id is optional
+	unverified, err := unverifiedJWTClaims(assertion)
+	if err != nil {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "get_client_auth=%s", "error parsing client assertion")
+		return nil
+	}
+	clientId := r.FormValue("client_id")
+	if clientId == "" {
+		clientId = unverified.Subject
+	}
+	if clientId == "" || clientId != unverified.Subject {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "get_client_auth=%s, client_id=%s", "client assertion subject does not match client_id", clientId)
+		return nil
+	}
+
+	return &clientAuth{BasicAuth: &BasicAuth{Username: clientId}, assertion: assertion}
+}
+
+// verifyClientAssertion verifies a JWT client assertion with the client's keys
+// (https://tools.ietf.org/html/rfc7523#section-3).
+// The client must implement ClientAssertionKeys.
+func (s *Server) verifyClientAssertion(client Client, assertion string, storage Storage) error {
+	assertionKeys, ok := client.(ClientAssertionKeys)
+	if !ok {
+		return errors.New("client does not support client assertions")
+	}
+	keys, err := assertionKeys.GetAssertionKeys()
+	if err != nil {
+		return err
+	}
+	if keys == nil || len(keys.Keys) == 0 {
+		return errors.New("client has no assertion keys")
+	}
+
+	audiences := s.tokenEndpointAudiences()
+	if len(audiences) == 0 {
+		return errors.New("Issuer is not configured")
+	}
+
+	claims, err := parseJWT(assertion, keys.Keys)
+	if err != nil {
+		return err
+	}
+	if claims.Issuer != client.GetId() || claims.Subject != client.GetId() {
+		return errors.New("iss and sub claims must be the client id")
+	}
+	if err = s.validateJWTClaims(claims, audiences...); err != nil {
+		return err
+	}
+	// replays can only be detected if the storage remembers the JWT IDs
+	if _, ok := getJTIStorage(storage); ok {
+		if claims.ID == "" {
+			return errors.New("jti claim required")
+		}
+		if err = saveJTI(storage, claims.Issuer, claims); err != nil {
+			return err
+		}
+	}
+	return nil
+}
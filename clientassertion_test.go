@@ -0,0 +1,225 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// newTestingSecretJWTClient returns a client authenticating with client_secret_jwt
+func newTestingSecretJWTClient() *DefaultClient {
+	return &DefaultClient{
+		Id:                      "secret-client",
+		Secret:                  "aabbccdd",
+		RedirectUri:             "http://localhost:14000/appauth",
+		TokenEndpointAuthMethod: "client_secret_jwt",
+	}
+}
+
+func TestClientAssertion(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	otherKey := newTestingKey(t, "k1")
+	secretKey := &jose.JsonWebKey{Key: []byte("aabbccdd"), Algorithm: string(jose.HS256)}
+	now := time.Now()
+
+	claims := func(clientId string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss": clientId,
+			"sub": clientId,
+			"aud": "https://auth.example.com/token",
+			"exp": now.Add(time.Minute).Unix(),
+			"jti": "assertion",
+		}
+	}
+	withoutJTI := func(clientId string) map[string]interface{} {
+		c := claims(clientId)
+		delete(c, "jti")
+		return c
+	}
+
+	testcases := map[string]struct {
+		ClientId       string
+		Assertion      func() string
+		AssertionType  string
+		BasicAuth      bool
+		MinimalStorage bool
+		ExpectedError  string
+	}{
+		"private_key_jwt": {
+			Assertion: func() string { return signTestingJWT(t, key, claims("jwt-client")) },
+		},
+		"private_key_jwt with client_id": {
+			ClientId:  "jwt-client",
+			Assertion: func() string { return signTestingJWT(t, key, claims("jwt-client")) },
+		},
+		"client_secret_jwt": {
+			Assertion: func() string { return signTestingJWT(t, secretKey, claims("secret-client")) },
+		},
+		"client_secret_jwt not registered": {
+			Assertion:     func() string { return signTestingJWT(t, secretKey, claims("1234")) },
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"unregistered key": {
+			Assertion:     func() string { return signTestingJWT(t, otherKey, claims("jwt-client")) },
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"wrong secret": {
+			Assertion: func() string {
+				return signTestingJWT(t, &jose.JsonWebKey{Key: []byte("wrong"), Algorithm: string(jose.HS256)}, claims("secret-client"))
+			},
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"client_id mismatch": {
+			ClientId:      "1234",
+			Assertion:     func() string { return signTestingJWT(t, key, claims("jwt-client")) },
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"issuer mismatch": {
+			Assertion: func() string {
+				c := claims("jwt-client")
+				c["iss"] = "other"
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"wrong audience": {
+			Assertion: func() string {
+				c := claims("jwt-client")
+				c["aud"] = "https://other.example.com/token"
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"expired": {
+			Assertion: func() string {
+				c := claims("jwt-client")
+				c["exp"] = now.Add(-time.Hour).Unix()
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"unsupported assertion type": {
+			Assertion:     func() string { return signTestingJWT(t, key, claims("jwt-client")) },
+			AssertionType: "urn:ietf:params:oauth:client-assertion-type:saml2-bearer",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"with basic auth": {
+			Assertion:     func() string { return signTestingJWT(t, key, claims("jwt-client")) },
+			BasicAuth:     true,
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"missing assertion": {
+			Assertion:     func() string { return "" },
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"missing jti": {
+			Assertion:     func() string { return signTestingJWT(t, key, withoutJTI("jwt-client")) },
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"storage without jti": {
+			Assertion:      func() string { return signTestingJWT(t, key, claims("jwt-client")) },
+			MinimalStorage: true,
+		},
+		"missing jti with storage without jti": {
+			Assertion:      func() string { return signTestingJWT(t, key, withoutJTI("jwt-client")) },
+			MinimalStorage: true,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+		sconfig.Issuer = "https://auth.example.com"
+		sconfig.Endpoints.Token = "/token"
+		storage := NewTestingStorage()
+		storage.SetClient("jwt-client", &DefaultClient{
+			Id:          "jwt-client",
+			RedirectUri: "http://localhost:14000/appauth",
+			JsonWebKeys: publicKeySet(key),
+		})
+		storage.SetClient("secret-client", newTestingSecretJWTClient())
+		server := NewServer(sconfig, storage)
+		if test.MinimalStorage {
+			server = NewServer(sconfig, &testingMinimalStorage{storage})
+		}
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "https://auth.example.com/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.BasicAuth {
+			req.SetBasicAuth("jwt-client", "")
+		}
+		assertionType := test.AssertionType
+		if assertionType == "" {
+			assertionType = CLIENT_ASSERTION_JWT_BEARER
+		}
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(CLIENT_CREDENTIALS))
+		req.Form.Set("client_assertion_type", assertionType)
+		req.Form.Set("client_assertion", test.Assertion())
+		if test.ClientId != "" {
+			req.Form.Set("client_id", test.ClientId)
+		}
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError == "" {
+			if d := resp.Output["access_token"]; d != "1" {
+				t.Errorf("%s: unexpected access token: %v", k, d)
+			}
+		}
+	}
+}
+
+func TestClientAssertionReplay(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	sconfig.Issuer = "https://auth.example.com"
+	storage := NewTestingStorage()
+	storage.SetClient("secret-client", newTestingSecretJWTClient())
+	server := NewServer(sconfig, storage)
+
+	assertion := signTestingJWT(t, &jose.JsonWebKey{Key: []byte("aabbccdd"), Algorithm: string(jose.HS256)}, map[string]interface{}{
+		"iss": "secret-client",
+		"sub": "secret-client",
+		"aud": "https://auth.example.com",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"jti": "once",
+	})
+
+	for i, expectedError := range []string{"", E_UNAUTHORIZED_CLIENT} {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "https://auth.example.com/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{
+			"grant_type":            {string(CLIENT_CREDENTIALS)},
+			"client_assertion_type": {CLIENT_ASSERTION_JWT_BEARER},
+			"client_assertion":      {assertion},
+		}
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != expectedError {
+			t.Fatalf("request %d: expected error %q, got %q", i, expectedError, resp.ErrorId)
+		}
+	}
+}
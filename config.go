@@ -3,10 +3,15 @@ package osin
 // AllowedAuthorizeType is a collection of allowed auth request types
 type AllowedAuthorizeType []AuthorizeRequestType
 
-// Exists returns true if the auth type exists in the list
+// Exists returns true if the auth type exists in the list.
+// Composite types match regardless of the order of their values.
 func (t AllowedAuthorizeType) Exists(rt AuthorizeRequestType) bool {
+	rt = ParseAuthorizeRequestType(string(rt))
+	if rt == "" {
+		return false
+	}
 	for _, k := range t {
-		if k == rt {
+		if ParseAuthorizeRequestType(string(k)) == rt {
 			return true
 		}
 	}
@@ -34,7 +39,13 @@ type ServerConfig struct {
 	// Access token expiration in seconds (default 1 hour)
 	AccessExpiration int32
 
-	// Token type to return
+	// Device and user code expiration in seconds (default 10 minutes)
+	DeviceCodeExpiration int32
+
+	// Minimum interval in seconds between device polls of the token endpoint (default 5 seconds)
+	DevicePollInterval int32
+
+	// Token type to return for bearer tokens. DPoP-bound tokens are of type DPoP.
 	TokenType string
 
 	// List of allowed authorize types (only CODE by default)
@@ -54,29 +65,76 @@ type ServerConfig struct {
 	// If true allows access request using GET, else only POST - default false
 	AllowGetAccessRequest bool
 
+	// If true allows clients to authenticate with TLS client certificates, which
+	// tokens are bound to (rfc8705) - default false. The http.Server must request
+	// client certificates.
+	AllowTLSClientCertificates bool
+
 	// Require PKCE for code flows for public OAuth clients - default false
 	RequirePKCEForPublicClients bool
 
 	// Separator to support multiple URIs in Client.GetRedirectUri().
 	// If blank (the default), don't allow multiple URIs.
+	// Clients implementing ClientRedirectUris can always have multiple URIs.
 	RedirectUriSeparator string
 
 	// RetainTokenAfter Refresh allows the server to retain the access and
 	// refresh token for re-use - default false
 	RetainTokenAfterRefresh bool
+
+	// Time in seconds a rotated refresh token can still be used, to allow concurrent
+	// refreshes (default 0). Later uses revoke all the tokens of the grant
+	// if Storage implements RefreshTokenStorage.
+	RefreshTokenGracePeriod int32
+
+	// Issuer identifier of the server, an https URL with no query or fragment
+	// (rfc8414). Required to serve the metadata document. If set, it is returned
+	// as `iss` in authorization responses for clients to detect mix-up attacks (rfc9207).
+	Issuer string
+
+	// Endpoint URLs to advertise in the metadata document
+	Endpoints ServerEndpoints
+
+	// Clock skew in seconds allowed when validating the time claims of JWTs
+	// received from other parties (default 1 minute)
+	JWTClockSkew int32
+
+	// Maximum age in seconds of DPoP proofs, in addition to the clock skew (default 1 minute)
+	DPoPProofLifetime int32
+
+	// Expiration in seconds of the request_uri of pushed authorization requests (default 1 minute)
+	PushedAuthorizationExpiration int32
+
+	// Require all clients to use pushed authorization requests (rfc9126) - default false.
+	// Clients can also require them by implementing PushedAuthorizationClient.
+	RequirePushedAuthorizationRequests bool
+
+	// Require all clients to send signed request objects (rfc9101) - default false.
+	// Clients can also require them by implementing RequestObjectClient.
+	RequireSignedRequestObject bool
+
+	// OpenID Connect ID token expiration in seconds (default 1 hour)
+	IDTokenExpiration int32
 }
 
 // NewServerConfig returns a new ServerConfig with default configuration
 func NewServerConfig() *ServerConfig {
 	return &ServerConfig{
-		AuthorizationExpiration:   250,
-		AccessExpiration:          3600,
-		TokenType:                 "Bearer",
-		AllowedAuthorizeTypes:     AllowedAuthorizeType{CODE},
-		AllowedAccessTypes:        AllowedAccessType{AUTHORIZATION_CODE},
-		ErrorStatusCode:           200,
-		AllowClientSecretInParams: false,
-		AllowGetAccessRequest:     false,
-		RetainTokenAfterRefresh:   false,
+		AuthorizationExpiration:       250,
+		AccessExpiration:              3600,
+		DeviceCodeExpiration:          600,
+		DevicePollInterval:            5,
+		TokenType:                     "Bearer",
+		AllowedAuthorizeTypes:         AllowedAuthorizeType{CODE},
+		AllowedAccessTypes:            AllowedAccessType{AUTHORIZATION_CODE},
+		ErrorStatusCode:               200,
+		AllowClientSecretInParams:     false,
+		AllowGetAccessRequest:         false,
+		AllowTLSClientCertificates:    false,
+		RetainTokenAfterRefresh:       false,
+		JWTClockSkew:                  60,
+		DPoPProofLifetime:             60,
+		PushedAuthorizationExpiration: 60,
+		IDTokenExpiration:             3600,
 	}
 }
@@ -0,0 +1,560 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// NewStorage returns a Storage using a ContextStorage, to use it with a Server.
+// Its calls get the context of the request being handled.
+func NewStorage(storage ContextStorage) Storage {
+	if adapter, ok := storage.(*storageAdapter); ok {
+		return adapter.storage
+	}
+	return &contextStorage{storage: storage, ctx: context.Background()}
+}
+
+// NewContextStorage wraps a Storage as a ContextStorage, to migrate implementations
+// gradually. The context is only checked before each call. The optional storage interfaces
+// the Storage implements are available as their context-aware versions, whose methods
+// return an error for the interfaces it doesn't implement.
+func NewContextStorage(storage Storage) ContextStorage {
+	if cs, ok := storage.(*contextStorage); ok {
+		return cs.storage
+	}
+	return &storageAdapter{storage: storage}
+}
+
+// contextStorage is a Storage calling a ContextStorage with a fixed context
+type contextStorage struct {
+	storage ContextStorage
+	ctx     context.Context
+}
+
+func (s *contextStorage) Clone() Storage {
+	return &contextStorage{storage: s.storage.Clone(), ctx: s.ctx}
+}
+
+func (s *contextStorage) Close() {
+	s.storage.Close()
+}
+
+func (s *contextStorage) GetClient(id string) (Client, error) {
+	return s.storage.GetClient(s.ctx, id)
+}
+
+func (s *contextStorage) SaveAuthorize(data *AuthorizeData) error {
+	return s.storage.SaveAuthorize(s.ctx, data)
+}
+
+func (s *contextStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	return s.storage.LoadAuthorize(s.ctx, code)
+}
+
+func (s *contextStorage) RemoveAuthorize(code string) error {
+	return s.storage.RemoveAuthorize(s.ctx, code)
+}
+
+func (s *contextStorage) SaveAccess(data *AccessData) error {
+	return s.storage.SaveAccess(s.ctx, data)
+}
+
+func (s *contextStorage) LoadAccess(token string) (*AccessData, error) {
+	return s.storage.LoadAccess(s.ctx, token)
+}
+
+func (s *contextStorage) RemoveAccess(token string) error {
+	return s.storage.RemoveAccess(s.ctx, token)
+}
+
+func (s *contextStorage) LoadRefresh(token string) (*AccessData, error) {
+	return s.storage.LoadRefresh(s.ctx, token)
+}
+
+func (s *contextStorage) RemoveRefresh(token string) error {
+	return s.storage.RemoveRefresh(s.ctx, token)
+}
+
+// storageAdapter is a ContextStorage calling a Storage
+type storageAdapter struct {
+	storage Storage
+}
+
+func (s *storageAdapter) Clone() ContextStorage {
+	return &storageAdapter{storage: s.storage.Clone()}
+}
+
+func (s *storageAdapter) Close() {
+	s.storage.Close()
+}
+
+func (s *storageAdapter) GetClient(ctx context.Context, id string) (Client, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.storage.GetClient(id)
+}
+
+func (s *storageAdapter) SaveAuthorize(ctx context.Context, data *AuthorizeData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.SaveAuthorize(data)
+}
+
+func (s *storageAdapter) LoadAuthorize(ctx context.Context, code string) (*AuthorizeData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.storage.LoadAuthorize(code)
+}
+
+func (s *storageAdapter) RemoveAuthorize(ctx context.Context, code string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.RemoveAuthorize(code)
+}
+
+func (s *storageAdapter) SaveAccess(ctx context.Context, data *AccessData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.SaveAccess(data)
+}
+
+func (s *storageAdapter) LoadAccess(ctx context.Context, token string) (*AccessData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.storage.LoadAccess(token)
+}
+
+func (s *storageAdapter) RemoveAccess(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.RemoveAccess(token)
+}
+
+func (s *storageAdapter) LoadRefresh(ctx context.Context, token string) (*AccessData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.storage.LoadRefresh(token)
+}
+
+func (s *storageAdapter) RemoveRefresh(ctx context.Context, token string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.storage.RemoveRefresh(token)
+}
+
+func (s *storageAdapter) SaveDevice(ctx context.Context, data *DeviceData) error {
+	storage, err := s.deviceStorage(ctx)
+	if err != nil {
+		return err
+	}
+	return storage.SaveDevice(data)
+}
+
+func (s *storageAdapter) LoadDevice(ctx context.Context, deviceCode string) (*DeviceData, error) {
+	storage, err := s.deviceStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.LoadDevice(deviceCode)
+}
+
+func (s *storageAdapter) LoadDeviceByUserCode(ctx context.Context, userCode string) (*DeviceData, error) {
+	storage, err := s.deviceStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.LoadDeviceByUserCode(userCode)
+}
+
+func (s *storageAdapter) UpdateDevice(ctx context.Context, data *DeviceData) error {
+	storage, err := s.deviceStorage(ctx)
+	if err != nil {
+		return err
+	}
+	return storage.UpdateDevice(data)
+}
+
+func (s *storageAdapter) ConsumeDevice(ctx context.Context, deviceCode string) (*DeviceData, error) {
+	storage, err := s.deviceStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.ConsumeDevice(deviceCode)
+}
+
+func (s *storageAdapter) SavePushedAuthorize(ctx context.Context, data *PushedAuthorizeData) error {
+	storage, err := s.pushedAuthorizeStorage(ctx)
+	if err != nil {
+		return err
+	}
+	return storage.SavePushedAuthorize(data)
+}
+
+func (s *storageAdapter) LoadPushedAuthorize(ctx context.Context, requestUri string) (*PushedAuthorizeData, error) {
+	storage, err := s.pushedAuthorizeStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.LoadPushedAuthorize(requestUri)
+}
+
+func (s *storageAdapter) ConsumePushedAuthorize(ctx context.Context, requestUri string) (*PushedAuthorizeData, error) {
+	storage, err := s.pushedAuthorizeStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.ConsumePushedAuthorize(requestUri)
+}
+
+func (s *storageAdapter) SaveJTI(ctx context.Context, jti string, expireAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	storage, ok := s.storage.(JTIStorage)
+	if !ok {
+		return errors.New("Storage does not implement JTIStorage")
+	}
+	return storage.SaveJTI(jti, expireAt)
+}
+
+func (s *storageAdapter) ConsumeRefresh(ctx context.Context, data *RotatedRefreshData) error {
+	storage, err := s.refreshTokenStorage(ctx)
+	if err != nil {
+		return err
+	}
+	return storage.ConsumeRefresh(data)
+}
+
+func (s *storageAdapter) LoadRotatedRefresh(ctx context.Context, token string) (*RotatedRefreshData, error) {
+	storage, err := s.refreshTokenStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.LoadRotatedRefresh(token)
+}
+
+func (s *storageAdapter) ConsumeAuthorize(ctx context.Context, data *RedeemedAuthorizeData) error {
+	storage, err := s.authorizeCodeStorage(ctx)
+	if err != nil {
+		return err
+	}
+	return storage.ConsumeAuthorize(data)
+}
+
+func (s *storageAdapter) LoadRedeemedAuthorize(ctx context.Context, code string) (*RedeemedAuthorizeData, error) {
+	storage, err := s.authorizeCodeStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.LoadRedeemedAuthorize(code)
+}
+
+// RemoveGrant is shared by RefreshTokenStorage and AuthorizeCodeStorage
+func (s *storageAdapter) RemoveGrant(ctx context.Context, grantId string) error {
+	if storage, err := s.refreshTokenStorage(ctx); err == nil {
+		return storage.RemoveGrant(grantId)
+	}
+	storage, err := s.authorizeCodeStorage(ctx)
+	if err != nil {
+		return err
+	}
+	return storage.RemoveGrant(grantId)
+}
+
+func (s *storageAdapter) SaveClientRegistration(ctx context.Context, data *ClientRegistration) error {
+	storage, err := s.clientStorage(ctx)
+	if err != nil {
+		return err
+	}
+	return storage.SaveClientRegistration(data)
+}
+
+func (s *storageAdapter) LoadClientRegistration(ctx context.Context, clientId string) (*ClientRegistration, error) {
+	storage, err := s.clientStorage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return storage.LoadClientRegistration(clientId)
+}
+
+func (s *storageAdapter) RemoveClientRegistration(ctx context.Context, clientId string) error {
+	storage, err := s.clientStorage(ctx)
+	if err != nil {
+		return err
+	}
+	return storage.RemoveClientRegistration(clientId)
+}
+
+// deviceStorage checks the context, and returns the DeviceStorage of the adapted storage
+func (s *storageAdapter) deviceStorage(ctx context.Context) (DeviceStorage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if storage, ok := s.storage.(DeviceStorage); ok {
+		return storage, nil
+	}
+	return nil, errors.New("Storage does not implement DeviceStorage")
+}
+
+// pushedAuthorizeStorage checks the context, and returns the PushedAuthorizeStorage of the adapted storage
+func (s *storageAdapter) pushedAuthorizeStorage(ctx context.Context) (PushedAuthorizeStorage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if storage, ok := s.storage.(PushedAuthorizeStorage); ok {
+		return storage, nil
+	}
+	return nil, errors.New("Storage does not implement PushedAuthorizeStorage")
+}
+
+// refreshTokenStorage checks the context, and returns the RefreshTokenStorage of the adapted storage
+func (s *storageAdapter) refreshTokenStorage(ctx context.Context) (RefreshTokenStorage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if storage, ok := s.storage.(RefreshTokenStorage); ok {
+		return storage, nil
+	}
+	return nil, errors.New("Storage does not implement RefreshTokenStorage")
+}
+
+// authorizeCodeStorage checks the context, and returns the AuthorizeCodeStorage of the adapted storage
+func (s *storageAdapter) authorizeCodeStorage(ctx context.Context) (AuthorizeCodeStorage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if storage, ok := s.storage.(AuthorizeCodeStorage); ok {
+		return storage, nil
+	}
+	return nil, errors.New("Storage does not implement AuthorizeCodeStorage")
+}
+
+// clientStorage checks the context, and returns the ClientStorage of the adapted storage
+func (s *storageAdapter) clientStorage(ctx context.Context) (ClientStorage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if storage, ok := s.storage.(ClientStorage); ok {
+		return storage, nil
+	}
+	return nil, errors.New("Storage does not implement ClientStorage")
+}
+
+// contextDeviceStorage is a DeviceStorage calling a ContextDeviceStorage with a fixed context
+type contextDeviceStorage struct {
+	storage ContextDeviceStorage
+	ctx     context.Context
+}
+
+func (s *contextDeviceStorage) SaveDevice(data *DeviceData) error {
+	return s.storage.SaveDevice(s.ctx, data)
+}
+
+func (s *contextDeviceStorage) LoadDevice(deviceCode string) (*DeviceData, error) {
+	return s.storage.LoadDevice(s.ctx, deviceCode)
+}
+
+func (s *contextDeviceStorage) LoadDeviceByUserCode(userCode string) (*DeviceData, error) {
+	return s.storage.LoadDeviceByUserCode(s.ctx, userCode)
+}
+
+func (s *contextDeviceStorage) UpdateDevice(data *DeviceData) error {
+	return s.storage.UpdateDevice(s.ctx, data)
+}
+
+func (s *contextDeviceStorage) ConsumeDevice(deviceCode string) (*DeviceData, error) {
+	return s.storage.ConsumeDevice(s.ctx, deviceCode)
+}
+
+// contextPushedAuthorizeStorage is a PushedAuthorizeStorage calling a
+// ContextPushedAuthorizeStorage with a fixed context
+type contextPushedAuthorizeStorage struct {
+	storage ContextPushedAuthorizeStorage
+	ctx     context.Context
+}
+
+func (s *contextPushedAuthorizeStorage) SavePushedAuthorize(data *PushedAuthorizeData) error {
+	return s.storage.SavePushedAuthorize(s.ctx, data)
+}
+
+func (s *contextPushedAuthorizeStorage) LoadPushedAuthorize(requestUri string) (*PushedAuthorizeData, error) {
+	return s.storage.LoadPushedAuthorize(s.ctx, requestUri)
+}
+
+func (s *contextPushedAuthorizeStorage) ConsumePushedAuthorize(requestUri string) (*PushedAuthorizeData, error) {
+	return s.storage.ConsumePushedAuthorize(s.ctx, requestUri)
+}
+
+// contextJTIStorage is a JTIStorage calling a ContextJTIStorage with a fixed context
+type contextJTIStorage struct {
+	storage ContextJTIStorage
+	ctx     context.Context
+}
+
+func (s *contextJTIStorage) SaveJTI(jti string, expireAt time.Time) error {
+	return s.storage.SaveJTI(s.ctx, jti, expireAt)
+}
+
+// contextRefreshTokenStorage is a RefreshTokenStorage calling a ContextRefreshTokenStorage
+// with a fixed context
+type contextRefreshTokenStorage struct {
+	storage ContextRefreshTokenStorage
+	ctx     context.Context
+}
+
+func (s *contextRefreshTokenStorage) ConsumeRefresh(data *RotatedRefreshData) error {
+	return s.storage.ConsumeRefresh(s.ctx, data)
+}
+
+func (s *contextRefreshTokenStorage) LoadRotatedRefresh(token string) (*RotatedRefreshData, error) {
+	return s.storage.LoadRotatedRefresh(s.ctx, token)
+}
+
+func (s *contextRefreshTokenStorage) RemoveGrant(grantId string) error {
+	return s.storage.RemoveGrant(s.ctx, grantId)
+}
+
+// contextAuthorizeCodeStorage is an AuthorizeCodeStorage calling a ContextAuthorizeCodeStorage
+// with a fixed context
+type contextAuthorizeCodeStorage struct {
+	storage ContextAuthorizeCodeStorage
+	ctx     context.Context
+}
+
+func (s *contextAuthorizeCodeStorage) ConsumeAuthorize(data *RedeemedAuthorizeData) error {
+	return s.storage.ConsumeAuthorize(s.ctx, data)
+}
+
+func (s *contextAuthorizeCodeStorage) LoadRedeemedAuthorize(code string) (*RedeemedAuthorizeData, error) {
+	return s.storage.LoadRedeemedAuthorize(s.ctx, code)
+}
+
+func (s *contextAuthorizeCodeStorage) RemoveGrant(grantId string) error {
+	return s.storage.RemoveGrant(s.ctx, grantId)
+}
+
+// contextClientStorage is a ClientStorage calling a ContextClientStorage with a fixed context
+type contextClientStorage struct {
+	storage ContextClientStorage
+	ctx     context.Context
+}
+
+func (s *contextClientStorage) SaveClientRegistration(data *ClientRegistration) error {
+	return s.storage.SaveClientRegistration(s.ctx, data)
+}
+
+func (s *contextClientStorage) LoadClientRegistration(clientId string) (*ClientRegistration, error) {
+	return s.storage.LoadClientRegistration(s.ctx, clientId)
+}
+
+func (s *contextClientStorage) RemoveClientRegistration(clientId string) error {
+	return s.storage.RemoveClientRegistration(s.ctx, clientId)
+}
+
+// bindContext binds the storage of the response to the context of the request,
+// if it uses a ContextStorage
+func bindContext(w *Response, r *http.Request) {
+	if cs, ok := w.Storage.(*contextStorage); ok && r != nil {
+		w.Storage = &contextStorage{storage: cs.storage, ctx: r.Context()}
+	}
+}
+
+// unwrapStorage returns the implementation of a storage, to look up the
+// optional storage interfaces it implements
+func unwrapStorage(storage Storage) interface{} {
+	if cs, ok := storage.(*contextStorage); ok {
+		if adapter, ok := cs.storage.(*storageAdapter); ok {
+			return adapter.storage
+		}
+		return cs.storage
+	}
+	return storage
+}
+
+// The optional storage interfaces are looked up with the functions below. A ContextStorage
+// implementing the context-aware version of an interface is called with the bound context.
+
+func getDeviceStorage(storage Storage) (DeviceStorage, bool) {
+	if cs, ok := unwrapStorage(storage).(ContextDeviceStorage); ok {
+		return &contextDeviceStorage{storage: cs, ctx: storageContext(storage)}, true
+	}
+	ret, ok := unwrapStorage(storage).(DeviceStorage)
+	return ret, ok
+}
+
+func getPushedAuthorizeStorage(storage Storage) (PushedAuthorizeStorage, bool) {
+	if cs, ok := unwrapStorage(storage).(ContextPushedAuthorizeStorage); ok {
+		return &contextPushedAuthorizeStorage{storage: cs, ctx: storageContext(storage)}, true
+	}
+	ret, ok := unwrapStorage(storage).(PushedAuthorizeStorage)
+	return ret, ok
+}
+
+func getJTIStorage(storage Storage) (JTIStorage, bool) {
+	if cs, ok := unwrapStorage(storage).(ContextJTIStorage); ok {
+		return &contextJTIStorage{storage: cs, ctx: storageContext(storage)}, true
+	}
+	ret, ok := unwrapStorage(storage).(JTIStorage)
+	return ret, ok
+}
+
+func getRefreshTokenStorage(storage Storage) (RefreshTokenStorage, bool) {
+	if cs, ok := unwrapStorage(storage).(ContextRefreshTokenStorage); ok {
+		return &contextRefreshTokenStorage{storage: cs, ctx: storageContext(storage)}, true
+	}
+	ret, ok := unwrapStorage(storage).(RefreshTokenStorage)
+	return ret, ok
+}
+
+func getAuthorizeCodeStorage(storage Storage) (AuthorizeCodeStorage, bool) {
+	if cs, ok := unwrapStorage(storage).(ContextAuthorizeCodeStorage); ok {
+		return &contextAuthorizeCodeStorage{storage: cs, ctx: storageContext(storage)}, true
+	}
+	ret, ok := unwrapStorage(storage).(AuthorizeCodeStorage)
+	return ret, ok
+}
+
+func getClientStorage(storage Storage) (ClientStorage, bool) {
+	if cs, ok := unwrapStorage(storage).(ContextClientStorage); ok {
+		return &contextClientStorage{storage: cs, ctx: storageContext(storage)}, true
+	}
+	ret, ok := unwrapStorage(storage).(ClientStorage)
+	return ret, ok
+}
+
+// storageContext returns the context bound to a storage
+func storageContext(storage Storage) context.Context {
+	if cs, ok := storage.(*contextStorage); ok {
+		return cs.ctx
+	}
+	return context.Background()
+}
+
+// isNotFound returns true if a storage didn't find an entity, or found it expired
+func isNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrExpired)
+}
+
+// storageErrorId returns the OAuth error of a request that failed with internalError:
+// transient storage faults and conflicts are temporarily_unavailable, otherwise responseError.
+func storageErrorId(responseError string, internalError error) string {
+	if internalError == nil {
+		return responseError
+	}
+	if errors.Is(internalError, ErrTemporarilyUnavailable) || errors.Is(internalError, context.DeadlineExceeded) ||
+		errors.Is(internalError, ErrConflict) {
+		return E_TEMPORARILY_UNAVAILABLE
+	}
+	return responseError
+}
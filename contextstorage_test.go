@@ -0,0 +1,396 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testingContextKey struct{}
+
+// testingContextStorage records the contexts of its calls, and can fail loading refresh tokens
+type testingContextStorage struct {
+	ContextStorage
+	contexts   []context.Context
+	refreshErr error
+}
+
+func (s *testingContextStorage) Clone() ContextStorage {
+	return s
+}
+
+func (s *testingContextStorage) GetClient(ctx context.Context, id string) (Client, error) {
+	s.contexts = append(s.contexts, ctx)
+	return s.ContextStorage.GetClient(ctx, id)
+}
+
+func (s *testingContextStorage) LoadRefresh(ctx context.Context, token string) (*AccessData, error) {
+	s.contexts = append(s.contexts, ctx)
+	if s.refreshErr != nil {
+		return nil, s.refreshErr
+	}
+	return s.ContextStorage.LoadRefresh(ctx, token)
+}
+
+// ConsumeRefresh, LoadRotatedRefresh and RemoveGrant implement ContextRefreshTokenStorage
+func (s *testingContextStorage) ConsumeRefresh(ctx context.Context, data *RotatedRefreshData) error {
+	s.contexts = append(s.contexts, ctx)
+	return s.ContextStorage.(ContextRefreshTokenStorage).ConsumeRefresh(ctx, data)
+}
+
+func (s *testingContextStorage) LoadRotatedRefresh(ctx context.Context, token string) (*RotatedRefreshData, error) {
+	s.contexts = append(s.contexts, ctx)
+	return s.ContextStorage.(ContextRefreshTokenStorage).LoadRotatedRefresh(ctx, token)
+}
+
+func (s *testingContextStorage) RemoveGrant(ctx context.Context, grantId string) error {
+	s.contexts = append(s.contexts, ctx)
+	return s.ContextStorage.(ContextRefreshTokenStorage).RemoveGrant(ctx, grantId)
+}
+
+func TestContextStorage(t *testing.T) {
+	testcases := map[string]struct {
+		RefreshErr    error
+		ExpectedError string
+	}{
+		"success": {},
+		"not found": {
+			RefreshErr:    fmt.Errorf("no row: %w", ErrNotFound),
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"expired": {
+			RefreshErr:    ErrExpired,
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"temporarily unavailable": {
+			RefreshErr:    fmt.Errorf("connection lost: %w", ErrTemporarilyUnavailable),
+			ExpectedError: E_TEMPORARILY_UNAVAILABLE,
+		},
+		"deadline exceeded": {
+			RefreshErr:    context.DeadlineExceeded,
+			ExpectedError: E_TEMPORARILY_UNAVAILABLE,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+		storage := &testingContextStorage{
+			ContextStorage: NewContextStorage(NewTestingStorage()),
+			refreshErr:     test.RefreshErr,
+		}
+		server := NewServer(sconfig, NewStorage(storage))
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), testingContextKey{}, k))
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{
+			"grant_type":    {string(REFRESH_TOKEN)},
+			"refresh_token": {"r9999"},
+		}
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+		}
+
+		if len(storage.contexts) == 0 {
+			t.Errorf("%s: storage was not called", k)
+		}
+		for _, ctx := range storage.contexts {
+			if ctx.Value(testingContextKey{}) != k {
+				t.Errorf("%s: storage called without the request context", k)
+			}
+		}
+		if test.ExpectedError == "" {
+			if _, err := storage.LoadRotatedRefresh(context.Background(), "r9999"); err != nil {
+				t.Errorf("%s: refresh token was not rotated through the context storage: %v", k, err)
+			}
+		}
+	}
+}
+
+func TestContextStorageAdapter(t *testing.T) {
+	v1 := NewTestingStorage()
+	storage := NewContextStorage(v1)
+	if NewStorage(storage) != v1 {
+		t.Errorf("Wrapped storage should be unwrapped")
+	}
+
+	if client, err := storage.GetClient(context.Background(), "1234"); err != nil || client.GetId() != "1234" {
+		t.Errorf("Unexpected client %v: %v", client, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := storage.GetClient(ctx, "1234"); err != context.Canceled {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+}
+
+// testingErrorStorage fails loading authorization codes, device codes and tokens, and saving access tokens
+type testingErrorStorage struct {
+	*TestingStorage
+	loadErr error
+	saveErr error
+}
+
+func (s *testingErrorStorage) Clone() Storage {
+	return s
+}
+
+func (s *testingErrorStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return s.TestingStorage.LoadAuthorize(code)
+}
+
+func (s *testingErrorStorage) LoadDevice(deviceCode string) (*DeviceData, error) {
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return s.TestingStorage.LoadDevice(deviceCode)
+}
+
+func (s *testingErrorStorage) LoadAccess(token string) (*AccessData, error) {
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return s.TestingStorage.LoadAccess(token)
+}
+
+func (s *testingErrorStorage) LoadRefresh(token string) (*AccessData, error) {
+	if s.loadErr != nil {
+		return nil, s.loadErr
+	}
+	return s.TestingStorage.LoadRefresh(token)
+}
+
+func (s *testingErrorStorage) SaveAccess(data *AccessData) error {
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	return s.TestingStorage.SaveAccess(data)
+}
+
+func TestStorageErrors(t *testing.T) {
+	expired := fmt.Errorf("token expired: %w", ErrExpired)
+	conflict := fmt.Errorf("duplicate key: %w", ErrConflict)
+
+	testcases := map[string]struct {
+		LoadErr        error
+		SaveErr        error
+		Handle         func(server *Server, resp *Response)
+		ExpectedError  string
+		ExpectedOutput ResponseData
+	}{
+		"revocation of an expired token": {
+			LoadErr: expired,
+			Handle: func(server *Server, resp *Response) {
+				req := newRevocationRequest(t, "expired", "")
+				if rr := server.HandleRevocationRequest(resp, req); rr != nil {
+					server.FinishRevocationRequest(resp, req, rr)
+				}
+			},
+			ExpectedOutput: ResponseData{},
+		},
+		"introspection of an expired token": {
+			LoadErr: expired,
+			Handle: func(server *Server, resp *Response) {
+				req := newRevocationRequest(t, "expired", "")
+				if ir := server.HandleIntrospectionRequest(resp, req); ir != nil {
+					server.FinishIntrospectionRequest(resp, req, ir)
+				}
+			},
+			ExpectedOutput: ResponseData{"active": false},
+		},
+		"userinfo with an expired token": {
+			LoadErr: expired,
+			Handle: func(server *Server, resp *Response) {
+				req, err := http.NewRequest("GET", "http://localhost:14000/userinfo", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Header.Set("Authorization", "Bearer expired")
+				if ur := server.HandleUserInfoRequest(resp, req); ur != nil {
+					server.FinishUserInfoRequest(resp, req, ur)
+				}
+			},
+			ExpectedError: E_INVALID_TOKEN,
+		},
+		"expired authorization code": {
+			LoadErr: expired,
+			Handle: func(server *Server, resp *Response) {
+				req := newAuthorizationCodeRequest(t, "1234", "aabbccdd", "9999")
+				if ar := server.HandleAccessRequest(resp, req); ar != nil {
+					ar.Authorized = true
+					server.FinishAccessRequest(resp, req, ar)
+				}
+			},
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"expired refresh token": {
+			LoadErr: expired,
+			Handle: func(server *Server, resp *Response) {
+				*resp = *refreshTestingToken(t, server, "r9999")
+			},
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"expired device code": {
+			LoadErr: expired,
+			Handle: func(server *Server, resp *Response) {
+				*resp = *pollDeviceToken(t, server, "expired")
+			},
+			ExpectedError: E_EXPIRED_TOKEN,
+		},
+		"unknown device code": {
+			Handle: func(server *Server, resp *Response) {
+				*resp = *pollDeviceToken(t, server, "unknown")
+			},
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"unavailable storage loading the device code": {
+			LoadErr: fmt.Errorf("connection refused: %w", ErrTemporarilyUnavailable),
+			Handle: func(server *Server, resp *Response) {
+				*resp = *pollDeviceToken(t, server, "device")
+			},
+			ExpectedError: E_TEMPORARILY_UNAVAILABLE,
+		},
+		"failing storage loading the device code": {
+			LoadErr: errors.New("connection reset"),
+			Handle: func(server *Server, resp *Response) {
+				*resp = *pollDeviceToken(t, server, "device")
+			},
+			ExpectedError: E_SERVER_ERROR,
+		},
+		"conflict saving the access token": {
+			SaveErr: conflict,
+			Handle: func(server *Server, resp *Response) {
+				req := newAuthorizationCodeRequest(t, "1234", "aabbccdd", "9999")
+				if ar := server.HandleAccessRequest(resp, req); ar != nil {
+					ar.Authorized = true
+					server.FinishAccessRequest(resp, req, ar)
+				}
+			},
+			ExpectedError: E_TEMPORARILY_UNAVAILABLE,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN, DEVICE_CODE}
+		storage := &testingErrorStorage{TestingStorage: NewTestingStorage(), loadErr: test.LoadErr, saveErr: test.SaveErr}
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		test.Handle(server, resp)
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedOutput != nil && !reflect.DeepEqual(resp.Output, test.ExpectedOutput) {
+			t.Errorf("%s: expected output %v, got %v", k, test.ExpectedOutput, resp.Output)
+		}
+		if test.ExpectedError == E_INVALID_GRANT && len(storage.redeemed) != 0 {
+			t.Errorf("%s: expired authorization code should not be handled as a reuse", k)
+		}
+	}
+}
+
+func TestContextStorageAdapterOptionalInterfaces(t *testing.T) {
+	storage := NewContextStorage(NewTestingStorage())
+	device := &DeviceData{DeviceCode: "d1", UserCode: "u1"}
+
+	devices, ok := storage.(ContextDeviceStorage)
+	if !ok {
+		t.Fatalf("Adapter should implement ContextDeviceStorage")
+	}
+	if err := devices.SaveDevice(context.Background(), device); err != nil {
+		t.Fatal(err)
+	}
+	if d, err := devices.LoadDeviceByUserCode(context.Background(), "u1"); err != nil || d != device {
+		t.Errorf("Unexpected device data %v: %v", d, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := devices.ConsumeDevice(ctx, "d1"); err != context.Canceled {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+
+	// the optional interfaces of the wrapped storage are still looked up by the server
+	if _, ok := getDeviceStorage(NewStorage(storage)); !ok {
+		t.Errorf("Wrapped DeviceStorage should be found")
+	}
+	minimal := NewContextStorage(&testingMinimalStorage{NewTestingStorage()})
+	if err := minimal.(ContextJTIStorage).SaveJTI(context.Background(), "jti", time.Now()); err == nil {
+		t.Errorf("Expected an error for a storage without JTIStorage")
+	}
+	if _, ok := getJTIStorage(&contextStorage{storage: minimal, ctx: context.Background()}); ok {
+		t.Errorf("Adapted storage without JTIStorage should not be found")
+	}
+}
+
+// testingMinimalStorage only implements Storage, without the optional interfaces
+type testingMinimalStorage struct {
+	storage *TestingStorage
+}
+
+func (s *testingMinimalStorage) Clone() Storage {
+	return s
+}
+
+func (s *testingMinimalStorage) Close() {
+}
+
+func (s *testingMinimalStorage) GetClient(id string) (Client, error) {
+	return s.storage.GetClient(id)
+}
+
+func (s *testingMinimalStorage) SaveAuthorize(data *AuthorizeData) error {
+	return s.storage.SaveAuthorize(data)
+}
+
+func (s *testingMinimalStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	return s.storage.LoadAuthorize(code)
+}
+
+func (s *testingMinimalStorage) RemoveAuthorize(code string) error {
+	return s.storage.RemoveAuthorize(code)
+}
+
+func (s *testingMinimalStorage) SaveAccess(data *AccessData) error {
+	return s.storage.SaveAccess(data)
+}
+
+func (s *testingMinimalStorage) LoadAccess(token string) (*AccessData, error) {
+	return s.storage.LoadAccess(token)
+}
+
+func (s *testingMinimalStorage) RemoveAccess(token string) error {
+	return s.storage.RemoveAccess(token)
+}
+
+func (s *testingMinimalStorage) LoadRefresh(token string) (*AccessData, error) {
+	return s.storage.LoadRefresh(token)
+}
+
+func (s *testingMinimalStorage) RemoveRefresh(token string) error {
+	return s.storage.RemoveRefresh(token)
+}
@@ -0,0 +1,456 @@
+package osin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DeviceStatus is the state of a device authorization
+type DeviceStatus string
+
+const (
+	DEVICE_PENDING  DeviceStatus = "pending"
+	DEVICE_APPROVED DeviceStatus = "approved"
+	DEVICE_DENIED   DeviceStatus = "denied"
+)
+
+// DeviceStorage is an optional interface Storage can implement to support
+// the device authorization grant (https://tools.ietf.org/html/rfc8628).
+// Client information MUST be loaded together with DeviceData.
+type DeviceStorage interface {
+	// SaveDevice saves a new device authorization.
+	SaveDevice(*DeviceData) error
+
+	// LoadDevice looks up DeviceData by device code, when the device polls the token endpoint.
+	// Optionally can return ErrExpired if expired.
+	LoadDevice(deviceCode string) (*DeviceData, error)
+
+	// LoadDeviceByUserCode looks up DeviceData by the user code entered on the verification page.
+	// Optionally can return ErrExpired if expired.
+	LoadDeviceByUserCode(userCode string) (*DeviceData, error)
+
+	// UpdateDevice saves changes to existing DeviceData: the status after the user approves or
+	// denies the request, and the last poll time and interval when the device polls.
+	UpdateDevice(*DeviceData) error
+
+	// ConsumeDevice atomically loads and deletes the device authorization, when it is
+	// exchanged for a token. It must return ErrNotFound if the device code was already consumed,
+	// so that a device code is exchanged at most once by concurrent requests.
+	ConsumeDevice(deviceCode string) (*DeviceData, error)
+}
+
+// ContextDeviceStorage is the context-aware version of DeviceStorage, implemented by a
+// ContextStorage to support the device authorization grant
+type ContextDeviceStorage interface {
+	SaveDevice(ctx context.Context, data *DeviceData) error
+	LoadDevice(ctx context.Context, deviceCode string) (*DeviceData, error)
+	LoadDeviceByUserCode(ctx context.Context, userCode string) (*DeviceData, error)
+	UpdateDevice(ctx context.Context, data *DeviceData) error
+	ConsumeDevice(ctx context.Context, deviceCode string) (*DeviceData, error)
+}
+
+// DeviceData represents a device authorization
+type DeviceData struct {
+	// Client information
+	Client Client
+
+	// Device verification code, used by the device to poll the token endpoint
+	DeviceCode string
+
+	// End-user verification code, entered by the user on the verification page
+	UserCode string
+
+	// Expiration of the codes in seconds
+	ExpiresIn int32
+
+	// Minimum amount of time in seconds the device should wait between polling requests
+	Interval int32
+
+	// Requested scope
+	Scope string
+
+	// Authorization state, changed by the user on the verification page
+	Status DeviceStatus
+
+	// Date created
+	CreatedAt time.Time
+
+	// Date the token endpoint was last polled. Zero if never polled.
+	LastPolledAt time.Time
+
+	// Data to be passed to storage. Not used by the library.
+	// Set by the application when the user approves the request.
+	UserData interface{}
+}
+
+// IsExpired is true if device authorization expired
+func (d *DeviceData) IsExpired() bool {
+	return d.IsExpiredAt(time.Now())
+}
+
+// IsExpiredAt is true if device authorization expires at time 't'
+func (d *DeviceData) IsExpiredAt(t time.Time) bool {
+	return d.ExpireAt().Before(t)
+}
+
+// ExpireAt returns the expiration date
+func (d *DeviceData) ExpireAt() time.Time {
+	return d.CreatedAt.Add(time.Duration(d.ExpiresIn) * time.Second)
+}
+
+// DeviceCodeGen generates device and user codes
+type DeviceCodeGen interface {
+	GenerateDeviceCode(data *DeviceData) (devicecode string, usercode string, err error)
+}
+
+// DeviceAuthorizationRequest is a request from a device to start the device flow
+type DeviceAuthorizationRequest struct {
+	Client Client
+	Scope  string
+
+	// Code expiration in seconds. Change if different from default
+	Expiration int32
+
+	// Polling interval in seconds. Change if different from default
+	Interval int32
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// DeviceVerificationRequest is a request from the user to approve or deny a device,
+// after entering the user code
+type DeviceVerificationRequest struct {
+	UserCode   string
+	DeviceData *DeviceData
+
+	// Set if request is authorized
+	Authorized bool
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleDeviceAuthorizationRequest is the http.HandlerFunc for handling device
+// authorization requests (https://tools.ietf.org/html/rfc8628#section-3.1)
+func (s *Server) HandleDeviceAuthorizationRequest(w *Response, r *http.Request) *DeviceAuthorizationRequest {
+	bindContext(w, r)
+
+	// Only allow POST
+	if r.Method != "POST" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, errors.New("Request must be POST"), "device_authorization_request=%s", "request must be POST")
+		return nil
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "device_authorization_request=%s", "parsing error")
+		return nil
+	}
+
+	if _, ok := getDeviceStorage(w.Storage); !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement DeviceStorage"), "device_authorization_request=%s", "device storage not available")
+		return nil
+	}
+
+	// get client authentication
+	auth := s.getDeviceClientAuth(w, r)
+	if auth == nil {
+		return nil
+	}
+
+	ret := &DeviceAuthorizationRequest{
+		Scope:       r.FormValue("scope"),
+		Expiration:  s.Config.DeviceCodeExpiration,
+		Interval:    s.Config.DevicePollInterval,
+		HttpRequest: r,
+	}
+
+	// must have a valid client, devices usually have no redirect uri
+	if ret.Client = s.authenticateClient(auth, w.Storage, w); ret.Client == nil {
+		return nil
+	}
+	if !clientAllowsAccessType(ret.Client, DEVICE_CODE) {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "device_authorization_request=%s, client_id=%s", "device grant not allowed for the client", ret.Client.GetId())
+		return nil
+	}
+	if !clientAllowsScope(ret.Client, ret.Scope) {
+		s.setErrorAndLog(w, E_INVALID_SCOPE, nil, "device_authorization_request=%s, client_id=%s", "scope not allowed for the client", ret.Client.GetId())
+		return nil
+	}
+
+	return ret
+}
+
+// FinishDeviceAuthorizationRequest generates and saves the device and user codes,
+// and outputs them along with the verification uri
+func (s *Server) FinishDeviceAuthorizationRequest(w *Response, r *http.Request, dr *DeviceAuthorizationRequest) {
+	bindContext(w, r)
+
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	verificationUri, err := s.endpointUrl(s.Config.Endpoints.DeviceVerification)
+	if err != nil || verificationUri == "" {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_device_authorization_request=%s", "invalid verification uri")
+		return
+	}
+
+	ret := &DeviceData{
+		Client:    dr.Client,
+		ExpiresIn: dr.Expiration,
+		Interval:  dr.Interval,
+		Scope:     dr.Scope,
+		Status:    DEVICE_PENDING,
+		CreatedAt: s.Now(),
+	}
+
+	// generate codes
+	ret.DeviceCode, ret.UserCode, err = s.DeviceCodeGen.GenerateDeviceCode(ret)
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_device_authorization_request=%s", "error generating codes")
+		return
+	}
+
+	// save device authorization
+	storage, ok := getDeviceStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement DeviceStorage"), "finish_device_authorization_request=%s", "device storage not available")
+		return
+	}
+	if err = storage.SaveDevice(ret); err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_device_authorization_request=%s", "error saving device data")
+		return
+	}
+
+	// output data
+	w.Output["device_code"] = ret.DeviceCode
+	w.Output["user_code"] = ret.UserCode
+	w.Output["verification_uri"] = verificationUri
+	w.Output["verification_uri_complete"] = verificationUri + "?" + url.Values{"user_code": {ret.UserCode}}.Encode()
+	w.Output["expires_in"] = ret.ExpiresIn
+	w.Output["interval"] = ret.Interval
+}
+
+// HandleDeviceVerificationRequest loads the device authorization for the `user_code`
+// entered by the user on the verification page
+func (s *Server) HandleDeviceVerificationRequest(w *Response, r *http.Request) *DeviceVerificationRequest {
+	bindContext(w, r)
+	r.ParseForm()
+
+	storage, ok := getDeviceStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement DeviceStorage"), "device_verification_request=%s", "device storage not available")
+		return nil
+	}
+
+	ret := &DeviceVerificationRequest{
+		UserCode:    r.FormValue("user_code"),
+		HttpRequest: r,
+	}
+
+	// "user_code" is required
+	if ret.UserCode == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "device_verification_request=%s", "user_code is required")
+		return nil
+	}
+
+	var err error
+	ret.DeviceData, err = storage.LoadDeviceByUserCode(ret.UserCode)
+	if errors.Is(err, ErrNotFound) || (err == nil && ret.DeviceData == nil) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "device_verification_request=%s", "user code not found")
+		return nil
+	}
+	if errors.Is(err, ErrExpired) {
+		s.setErrorAndLog(w, E_EXPIRED_TOKEN, err, "device_verification_request=%s", "device data is expired")
+		return nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "device_verification_request=%s", "error loading device data")
+		return nil
+	}
+	if ret.DeviceData.Client == nil {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "device_verification_request=%s", "device data client is nil")
+		return nil
+	}
+	if ret.DeviceData.IsExpiredAt(s.Now()) {
+		s.setErrorAndLog(w, E_EXPIRED_TOKEN, nil, "device_verification_request=%s", "device data is expired")
+		return nil
+	}
+	if ret.DeviceData.Status != DEVICE_PENDING {
+		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "device_verification_request=%s", "device was already approved or denied")
+		return nil
+	}
+
+	return ret
+}
+
+// FinishDeviceVerificationRequest approves or denies the device, allowing
+// its next poll of the token endpoint to complete
+func (s *Server) FinishDeviceVerificationRequest(w *Response, r *http.Request, vr *DeviceVerificationRequest) {
+	bindContext(w, r)
+
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	if vr.Authorized {
+		vr.DeviceData.Status = DEVICE_APPROVED
+		vr.DeviceData.UserData = vr.UserData
+	} else {
+		vr.DeviceData.Status = DEVICE_DENIED
+	}
+
+	storage, ok := getDeviceStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement DeviceStorage"), "finish_device_verification_request=%s", "device storage not available")
+		return
+	}
+	if err := storage.UpdateDevice(vr.DeviceData); err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_device_verification_request=%s", "error updating device data")
+		return
+	}
+
+	if !vr.Authorized {
+		s.setErrorAndLog(w, E_ACCESS_DENIED, nil, "finish_device_verification_request=%s", "authorization failed")
+	}
+}
+
+// consumeDevice deletes the device authorization before its token is saved.
+// Returns false and sets an error on the response if it was already exchanged.
+func (s *Server) consumeDevice(w *Response, data *DeviceData) bool {
+	storage, ok := getDeviceStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement DeviceStorage"), "finish_access_request=%s", "device storage not available")
+		return false
+	}
+	if _, err := storage.ConsumeDevice(data.DeviceCode); errors.Is(err, ErrNotFound) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, err, "finish_access_request=%s", "device code already exchanged")
+		return false
+	} else if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_access_request=%s", "error consuming device data")
+		return false
+	}
+	return true
+}
+
+func (s *Server) handleDeviceCodeRequest(w *Response, r *http.Request) *AccessRequest {
+	storage, ok := getDeviceStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement DeviceStorage"), "device_code_request=%s", "device storage not available")
+		return nil
+	}
+
+	// get client authentication
+	auth := s.getDeviceClientAuth(w, r)
+	if auth == nil {
+		return nil
+	}
+
+	// generate access token
+	ret := &AccessRequest{
+		Type:            DEVICE_CODE,
+		Code:            r.FormValue("device_code"),
+		GenerateRefresh: true,
+		Expiration:      s.Config.AccessExpiration,
+		HttpRequest:     r,
+	}
+
+	// "device_code" is required
+	if ret.Code == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "device_code_request=%s", "device_code is required")
+		return nil
+	}
+
+	// must have a valid client
+	if ret.Client = s.authenticateClient(auth, w.Storage, w); ret.Client == nil {
+		return nil
+	}
+
+	// must be a valid device code
+	var err error
+	ret.DeviceData, err = storage.LoadDevice(ret.Code)
+	if errors.Is(err, ErrNotFound) || (err == nil && ret.DeviceData == nil) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, err, "device_code_request=%s", "device code not found")
+		return nil
+	} else if errors.Is(err, ErrExpired) {
+		s.setErrorAndLog(w, E_EXPIRED_TOKEN, err, "device_code_request=%s", "device data is expired")
+		return nil
+	} else if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "device_code_request=%s", "error loading device data")
+		return nil
+	}
+	if ret.DeviceData.Client == nil {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "device_code_request=%s", "device data client is nil")
+		return nil
+	}
+
+	// code must be from the client
+	if ret.DeviceData.Client.GetId() != ret.Client.GetId() {
+		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "device_code_request=%s", "client code does not match")
+		return nil
+	}
+
+	now := s.Now()
+	if ret.DeviceData.IsExpiredAt(now) {
+		s.setErrorAndLog(w, E_EXPIRED_TOKEN, nil, "device_code_request=%s", "device data is expired")
+		return nil
+	}
+
+	// enforce the polling interval
+	// https://tools.ietf.org/html/rfc8628#section-3.5
+	tooFast := !ret.DeviceData.LastPolledAt.IsZero() &&
+		now.Before(ret.DeviceData.LastPolledAt.Add(time.Duration(ret.DeviceData.Interval)*time.Second))
+	if tooFast {
+		ret.DeviceData.Interval += 5
+	}
+	ret.DeviceData.LastPolledAt = now
+	if err = storage.UpdateDevice(ret.DeviceData); err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "device_code_request=%s", "error updating device data")
+		return nil
+	}
+	if tooFast {
+		s.setErrorAndLog(w, E_SLOW_DOWN, nil, "device_code_request=%s", "polling too fast")
+		return nil
+	}
+
+	switch ret.DeviceData.Status {
+	case DEVICE_APPROVED:
+	case DEVICE_DENIED:
+		s.setErrorAndLog(w, E_ACCESS_DENIED, nil, "device_code_request=%s", "device was denied")
+		return nil
+	default:
+		s.setErrorAndLog(w, E_AUTHORIZATION_PENDING, nil, "device_code_request=%s", "device is pending")
+		return nil
+	}
+
+	// set rest of data
+	ret.Scope = ret.DeviceData.Scope
+	ret.UserData = ret.DeviceData.UserData
+	ret.RedirectUri = s.firstRedirectUri(ret.Client)
+
+	return ret
+}
+
+// getDeviceClientAuth is getClientAuth which also allows public clients to
+// send only their `client_id`, as devices usually have no secret
+// (https://tools.ietf.org/html/rfc8628#section-3.1)
+func (s Server) getDeviceClientAuth(w *Response, r *http.Request) *clientAuth {
+	if r.Header.Get("Authorization") == "" && r.FormValue("client_assertion_type") == "" && (peerCertificate(r) == nil || !s.Config.AllowTLSClientCertificates) {
+		if _, hasSecret := r.Form["client_secret"]; !hasSecret {
+			if clientId := r.FormValue("client_id"); clientId != "" {
+				return &clientAuth{BasicAuth: &BasicAuth{Username: clientId}}
+			}
+		}
+	}
+	return s.getClientAuth(w, r, s.Config.AllowClientSecretInParams)
+}
@@ -0,0 +1,231 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func newDeviceTestServer() *Server {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{DEVICE_CODE}
+	sconfig.Issuer = "http://localhost:14000"
+	sconfig.Endpoints.DeviceVerification = "/device"
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.DeviceCodeGen = &TestingDeviceCodeGen{}
+	return server
+}
+
+func pollDeviceToken(t *testing.T, server *Server, deviceCode string) *Response {
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = make(url.Values)
+	req.Form.Set("grant_type", string(DEVICE_CODE))
+	req.Form.Set("device_code", deviceCode)
+	req.Form.Set("client_id", "public-client")
+	req.PostForm = make(url.Values)
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	return resp
+}
+
+func verifyDevice(t *testing.T, server *Server, userCode string, authorized bool) *Response {
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/device", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = make(url.Values)
+	req.Form.Set("user_code", userCode)
+	req.PostForm = make(url.Values)
+
+	if vr := server.HandleDeviceVerificationRequest(resp, req); vr != nil {
+		vr.Authorized = authorized
+		vr.UserData = "user-1"
+		server.FinishDeviceVerificationRequest(resp, req, vr)
+	}
+	return resp
+}
+
+func TestDeviceAuthorization(t *testing.T) {
+	server := newDeviceTestServer()
+	now := time.Now()
+	server.Now = func() time.Time { return now }
+
+	// device requests codes
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/device_authorization", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = make(url.Values)
+	req.Form.Set("client_id", "public-client")
+	req.Form.Set("scope", "read")
+	req.PostForm = make(url.Values)
+
+	if dr := server.HandleDeviceAuthorizationRequest(resp, req); dr != nil {
+		server.FinishDeviceAuthorizationRequest(resp, req, dr)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+	expected := ResponseData{
+		"device_code":               "d1",
+		"user_code":                 "u1",
+		"verification_uri":          "http://localhost:14000/device",
+		"verification_uri_complete": "http://localhost:14000/device?user_code=u1",
+		"expires_in":                int32(600),
+		"interval":                  int32(5),
+	}
+	for k, v := range expected {
+		if resp.Output[k] != v {
+			t.Errorf("Unexpected %s: %v", k, resp.Output[k])
+		}
+	}
+
+	// device polls before the user approves
+	if resp := pollDeviceToken(t, server, "d1"); resp.ErrorId != E_AUTHORIZATION_PENDING {
+		t.Fatalf("Expected error %v, got %v", E_AUTHORIZATION_PENDING, resp.ErrorId)
+	}
+
+	// device polls too fast
+	now = now.Add(time.Second)
+	if resp := pollDeviceToken(t, server, "d1"); resp.ErrorId != E_SLOW_DOWN {
+		t.Fatalf("Expected error %v, got %v", E_SLOW_DOWN, resp.ErrorId)
+	}
+	if d, _ := server.Storage.(DeviceStorage).LoadDevice("d1"); d.Interval != 10 {
+		t.Fatalf("Interval should have increased, got %d", d.Interval)
+	}
+
+	// user approves
+	if resp := verifyDevice(t, server, "u1", true); resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+
+	// device polls after the user approves
+	now = now.Add(10 * time.Second)
+	resp = pollDeviceToken(t, server, "d1")
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+	if d := resp.Output["access_token"]; d != "1" {
+		t.Fatalf("Unexpected access token: %s", d)
+	}
+	if d := resp.Output["scope"]; d != "read" {
+		t.Fatalf("Unexpected scope: %s", d)
+	}
+	if d, _ := server.Storage.LoadAccess("1"); d == nil || d.UserData != "user-1" {
+		t.Fatalf("Unexpected access data: %v", d)
+	}
+
+	// device code can only be used once
+	now = now.Add(10 * time.Second)
+	if resp := pollDeviceToken(t, server, "d1"); resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected error %v, got %v", E_INVALID_GRANT, resp.ErrorId)
+	}
+}
+
+func TestDeviceAuthorizationDenied(t *testing.T) {
+	server := newDeviceTestServer()
+	server.Storage.(DeviceStorage).SaveDevice(&DeviceData{
+		Client:     server.Storage.(*TestingStorage).clients["public-client"],
+		DeviceCode: "denied-device",
+		UserCode:   "denied-user",
+		ExpiresIn:  600,
+		Interval:   5,
+		Status:     DEVICE_PENDING,
+		CreatedAt:  time.Now(),
+	})
+
+	if resp := verifyDevice(t, server, "denied-user", false); resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Expected error %v, got %v", E_ACCESS_DENIED, resp.ErrorId)
+	}
+	if resp := pollDeviceToken(t, server, "denied-device"); resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Expected error %v, got %v", E_ACCESS_DENIED, resp.ErrorId)
+	}
+}
+
+func TestDeviceAuthorizationExpired(t *testing.T) {
+	server := newDeviceTestServer()
+	server.Storage.(DeviceStorage).SaveDevice(&DeviceData{
+		Client:     server.Storage.(*TestingStorage).clients["public-client"],
+		DeviceCode: "expired-device",
+		UserCode:   "expired-user",
+		ExpiresIn:  600,
+		Interval:   5,
+		Status:     DEVICE_APPROVED,
+		CreatedAt:  time.Now().Add(-time.Hour),
+	})
+
+	if resp := pollDeviceToken(t, server, "expired-device"); resp.ErrorId != E_EXPIRED_TOKEN {
+		t.Fatalf("Expected error %v, got %v", E_EXPIRED_TOKEN, resp.ErrorId)
+	}
+	if resp := verifyDevice(t, server, "expired-user", true); resp.ErrorId != E_EXPIRED_TOKEN {
+		t.Fatalf("Expected error %v, got %v", E_EXPIRED_TOKEN, resp.ErrorId)
+	}
+}
+
+func TestDeviceAuthorizationConcurrentExchange(t *testing.T) {
+	server := newDeviceTestServer()
+	storage := server.Storage.(*TestingStorage)
+	storage.SaveDevice(&DeviceData{
+		Client:     storage.clients["public-client"],
+		DeviceCode: "approved-device",
+		UserCode:   "approved-user",
+		ExpiresIn:  600,
+		Interval:   5,
+		Status:     DEVICE_APPROVED,
+		CreatedAt:  time.Now(),
+	})
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = url.Values{"grant_type": {string(DEVICE_CODE)}, "device_code": {"approved-device"}, "client_id": {"public-client"}}
+	req.PostForm = make(url.Values)
+
+	ar := server.HandleAccessRequest(resp, req)
+	if ar == nil {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+
+	// another request exchanges the device code in between
+	if _, err := storage.ConsumeDevice("approved-device"); err != nil {
+		t.Fatal(err)
+	}
+
+	ar.Authorized = true
+	server.FinishAccessRequest(resp, req, ar)
+	if resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected error %v, got %v", E_INVALID_GRANT, resp.ErrorId)
+	}
+	if _, err := storage.LoadAccess("1"); err != ErrNotFound {
+		t.Fatalf("Access token should not be saved, got %v", err)
+	}
+}
+
+func TestDeviceCodeGenDefault(t *testing.T) {
+	gen := &DeviceCodeGenDefault{}
+	deviceCode, userCode, err := gen.GenerateDeviceCode(&DeviceData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deviceCode == "" {
+		t.Errorf("Device code should not be empty")
+	}
+	if !regexp.MustCompile("^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$").MatchString(userCode) {
+		t.Errorf("Unexpected user code: %s", userCode)
+	}
+}
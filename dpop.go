@@ -0,0 +1,223 @@
+package osin
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// DPOP_TOKEN_TYPE is the token_type of DPoP-bound access tokens
+const DPOP_TOKEN_TYPE = "DPoP"
+
+var (
+	// ErrUseDPoPNonce is returned when a DPoP proof doesn't include a valid server nonce.
+	// Respond with a new nonce from Server.DPoPNonces in the `DPoP-Nonce` header.
+	ErrUseDPoPNonce = errors.New("DPoP proof must include a valid server nonce")
+)
+
+// dpopSigningAlgorithms are the JWS algorithms accepted for DPoP proofs, which must be asymmetric
+var dpopSigningAlgorithms = []string{
+	string(jose.RS256), string(jose.RS384), string(jose.RS512),
+	string(jose.PS256), string(jose.PS384), string(jose.PS512),
+	string(jose.ES256), string(jose.ES384), string(jose.ES512),
+}
+
+// DPoPNonces issues the server nonces clients must include in DPoP proofs
+// (https://www.rfc-editor.org/rfc/rfc9449#section-8)
+type DPoPNonces interface {
+	// NewNonce returns a nonce to send to the client in the `DPoP-Nonce` header
+	NewNonce() (string, error)
+
+	// CheckNonce returns true if the nonce was issued by NewNonce and is still valid
+	CheckNonce(nonce string) bool
+}
+
+// DPoPProof is a verified DPoP proof JWT (https://www.rfc-editor.org/rfc/rfc9449#section-4.2)
+type DPoPProof struct {
+	// Public key the proof is signed with
+	JsonWebKey *jose.JsonWebKey
+
+	// JWK thumbprint (`jkt`) of the key, which tokens are bound to
+	Thumbprint string
+
+	// Claims of the proof
+	Claims *JWTClaims
+}
+
+// CheckDPoPBinding verifies that a request using a DPoP-bound access token is sent with
+// the `DPoP` authorization scheme and a valid proof signed by the key the token is bound to
+// (https://www.rfc-editor.org/rfc/rfc9449#section-7.1). Returns nil if the token is not bound.
+// Resource servers should reject requests for which it returns an error.
+func (s *Server) CheckDPoPBinding(r *http.Request, data *AccessData) error {
+	return s.checkDPoPBinding(r, data, s.Storage)
+}
+
+func (s *Server) checkDPoPBinding(r *http.Request, data *AccessData, storage Storage) error {
+	if data.JWKThumbprint == "" {
+		return nil
+	}
+	if parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2); !strings.EqualFold(parts[0], DPOP_TOKEN_TYPE) {
+		return errors.New("DPoP-bound token must use the DPoP authorization scheme")
+	}
+	proof, err := s.verifyDPoPProof(r, storage, data.AccessToken, requestUrl(r))
+	if err != nil {
+		return err
+	}
+	if proof == nil {
+		return errors.New("DPoP proof required")
+	}
+	if proof.Thumbprint != data.JWKThumbprint {
+		return errors.New("DPoP proof is not signed by the key the token is bound to")
+	}
+	return nil
+}
+
+// verifyDPoPProof validates the `DPoP` header of the request, if any
+// (https://www.rfc-editor.org/rfc/rfc9449#section-4.3).
+// The proof `htu` must be one of the urls. If accessToken is set, the proof must include its hash in `ath`.
+// Returns a nil proof if the request has no DPoP header. The storage must implement JTIStorage.
+func (s *Server) verifyDPoPProof(r *http.Request, storage Storage, accessToken string, urls ...string) (*DPoPProof, error) {
+	headers := r.Header[http.CanonicalHeaderKey("DPoP")]
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	if len(headers) > 1 {
+		return nil, errors.New("multiple DPoP headers")
+	}
+	token := headers[0]
+
+	// the header must be of type dpop+jwt and include the public key
+	if strings.Count(token, ".") != 2 {
+		return nil, errors.New("DPoP proof is not a compact serialized JWS")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(token[:strings.Index(token, ".")])
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Type string `json:"typ"`
+	}
+	if err = json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, err
+	}
+	if header.Type != "dpop+jwt" {
+		return nil, fmt.Errorf("DPoP proof typ %q is not dpop+jwt", header.Type)
+	}
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, errors.New("DPoP proof must have exactly one signature")
+	}
+	key := jws.Signatures[0].Header.JsonWebKey
+	if key == nil || !key.IsPublic() {
+		return nil, errors.New("DPoP proof must include a public jwk")
+	}
+	if !containsString(dpopSigningAlgorithms, jws.Signatures[0].Header.Algorithm) {
+		return nil, fmt.Errorf("DPoP proof alg %q is not supported", jws.Signatures[0].Header.Algorithm)
+	}
+
+	ret := &DPoPProof{JsonWebKey: key}
+	if ret.Claims, err = parseJWT(token, []jose.JsonWebKey{*key}); err != nil {
+		return nil, err
+	}
+	if ret.Thumbprint = keyThumbprint(key.Key); ret.Thumbprint == "" {
+		return nil, errors.New("unsupported DPoP proof key")
+	}
+
+	// the proof is only valid for this request
+	if m, _ := stringClaim(ret.Claims.Claims, "htm"); m != r.Method {
+		return nil, fmt.Errorf("DPoP proof htm %q does not match the request method", m)
+	}
+	htu, _ := stringClaim(ret.Claims.Claims, "htu")
+	if i := strings.IndexAny(htu, "?#"); i >= 0 {
+		htu = htu[:i]
+	}
+	if htu == "" || !containsString(urls, htu) {
+		return nil, fmt.Errorf("DPoP proof htu %q does not match the request url", htu)
+	}
+	if accessToken != "" {
+		sum := sha256.Sum256([]byte(accessToken))
+		if ath, _ := stringClaim(ret.Claims.Claims, "ath"); ath != base64.RawURLEncoding.EncodeToString(sum[:]) {
+			return nil, errors.New("DPoP proof ath does not match the access token")
+		}
+	}
+
+	// the proof must be recent and used once
+	now := s.Now()
+	skew := time.Duration(s.Config.JWTClockSkew) * time.Second
+	lifetime := time.Duration(s.Config.DPoPProofLifetime) * time.Second
+	if ret.Claims.IssuedAt.IsZero() {
+		return nil, errors.New("DPoP proof iat is required")
+	}
+	if ret.Claims.IssuedAt.After(now.Add(skew)) || ret.Claims.IssuedAt.Add(lifetime+skew).Before(now) {
+		return nil, errors.New("DPoP proof iat is not recent")
+	}
+	if ret.Claims.ID == "" {
+		return nil, errors.New("DPoP proof jti is required")
+	}
+	if s.DPoPNonces != nil {
+		if nonce, _ := stringClaim(ret.Claims.Claims, "nonce"); nonce == "" || !s.DPoPNonces.CheckNonce(nonce) {
+			return nil, ErrUseDPoPNonce
+		}
+	}
+	jtiStorage, ok := getJTIStorage(storage)
+	if !ok {
+		return nil, errors.New("Storage does not implement JTIStorage")
+	}
+	if err = jtiStorage.SaveJTI("dpop#"+ret.Thumbprint+"#"+ret.Claims.ID, ret.Claims.IssuedAt.Add(lifetime+skew)); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// setDPoPErrorAndLog sets the error of an invalid DPoP proof, with a new nonce if one is required
+func (s Server) setDPoPErrorAndLog(w *Response, err error, debugFormat string, debugArgs ...interface{}) {
+	if err != ErrUseDPoPNonce || s.DPoPNonces == nil {
+		s.setErrorAndLog(w, E_INVALID_DPOP_PROOF, err, debugFormat, debugArgs...)
+		return
+	}
+	nonce, nonceErr := s.DPoPNonces.NewNonce()
+	if nonceErr != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, nonceErr, debugFormat, debugArgs...)
+		return
+	}
+	w.Headers.Set("DPoP-Nonce", nonce)
+	s.setErrorAndLog(w, E_USE_DPOP_NONCE, err, debugFormat, debugArgs...)
+}
+
+// tokenType returns the token_type of an access token
+func (s *Server) tokenType(data *AccessData) string {
+	if data.TokenType != "" {
+		return data.TokenType
+	}
+	return s.Config.TokenType
+}
+
+// requestUrl returns the url of the request without query, as seen by the client
+// if the server is not behind a proxy
+func requestUrl(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,353 @@
+package osin
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+type testingDPoPNonces struct{}
+
+func (testingDPoPNonces) NewNonce() (string, error)    { return "server-nonce", nil }
+func (testingDPoPNonces) CheckNonce(nonce string) bool { return nonce == "server-nonce" }
+
+func newTestingDPoPKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signTestingDPoPProof signs an ES256 DPoP proof, go-jose can't set the typ header
+func signTestingDPoPProof(t *testing.T, key *ecdsa.PrivateKey, typ string, claims map[string]interface{}) string {
+	jwk, err := (&jose.JsonWebKey{Key: &key.PublicKey}).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := json.Marshal(map[string]interface{}{"typ": typ, "alg": "ES256", "jwk": json.RawMessage(jwk)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(input))
+	r, s, err := ecdsa.Sign(rand.Reader, key, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+	return input + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func dpopThumbprint(t *testing.T, key *ecdsa.PrivateKey) string {
+	sum, err := (&jose.JsonWebKey{Key: &key.PublicKey}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(sum)
+}
+
+func newDPoPAccessRequest(t *testing.T, form url.Values, proof string) *http.Request {
+	req, err := http.NewRequest("POST", "https://auth.example.com/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TLS = &tls.ConnectionState{}
+	req.SetBasicAuth("1234", "aabbccdd")
+	if proof != "" {
+		req.Header.Set("DPoP", proof)
+	}
+	req.Form = form
+	req.PostForm = make(url.Values)
+	return req
+}
+
+func TestAccessDPoP(t *testing.T) {
+	key := newTestingDPoPKey(t)
+	now := time.Now()
+
+	claims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"jti": "proof-1",
+			"htm": "POST",
+			"htu": "https://auth.example.com/token",
+			"iat": now.Unix(),
+		}
+	}
+
+	testcases := map[string]struct {
+		Proof         func() string
+		Nonces        DPoPNonces
+		ExpectedError string
+	}{
+		"valid": {
+			Proof: func() string { return signTestingDPoPProof(t, key, "dpop+jwt", claims()) },
+		},
+		"htu with query": {
+			Proof: func() string {
+				c := claims()
+				c["htu"] = "https://auth.example.com/token?x=y"
+				return signTestingDPoPProof(t, key, "dpop+jwt", c)
+			},
+		},
+		"wrong typ": {
+			Proof:         func() string { return signTestingDPoPProof(t, key, "JWT", claims()) },
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"wrong htm": {
+			Proof: func() string {
+				c := claims()
+				c["htm"] = "GET"
+				return signTestingDPoPProof(t, key, "dpop+jwt", c)
+			},
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"wrong htu": {
+			Proof: func() string {
+				c := claims()
+				c["htu"] = "https://other.example.com/token"
+				return signTestingDPoPProof(t, key, "dpop+jwt", c)
+			},
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"old iat": {
+			Proof: func() string {
+				c := claims()
+				c["iat"] = now.Add(-time.Hour).Unix()
+				return signTestingDPoPProof(t, key, "dpop+jwt", c)
+			},
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"missing jti": {
+			Proof: func() string {
+				c := claims()
+				delete(c, "jti")
+				return signTestingDPoPProof(t, key, "dpop+jwt", c)
+			},
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"bad signature": {
+			Proof:         func() string { return signTestingDPoPProof(t, key, "dpop+jwt", claims()) + "x" },
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"nonce required": {
+			Proof:         func() string { return signTestingDPoPProof(t, key, "dpop+jwt", claims()) },
+			Nonces:        testingDPoPNonces{},
+			ExpectedError: E_USE_DPOP_NONCE,
+		},
+		"nonce": {
+			Proof: func() string {
+				c := claims()
+				c["nonce"] = "server-nonce"
+				return signTestingDPoPProof(t, key, "dpop+jwt", c)
+			},
+			Nonces: testingDPoPNonces{},
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+		server := NewServer(sconfig, NewTestingStorage())
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		server.DPoPNonces = test.Nonces
+		resp := server.NewResponse()
+
+		req := newDPoPAccessRequest(t, url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}, test.Proof())
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError == E_USE_DPOP_NONCE {
+			if d := resp.Headers.Get("DPoP-Nonce"); d != "server-nonce" {
+				t.Errorf("%s: unexpected nonce: %q", k, d)
+			}
+		}
+		if test.ExpectedError == "" {
+			if d := resp.Output["token_type"]; d != DPOP_TOKEN_TYPE {
+				t.Errorf("%s: unexpected token type: %v", k, d)
+			}
+			if ret, _ := server.Storage.LoadAccess("1"); ret == nil || ret.JWKThumbprint != dpopThumbprint(t, key) {
+				t.Errorf("%s: token is not bound to the DPoP key: %v", k, ret)
+			}
+		}
+	}
+}
+
+func TestAccessDPoPReplay(t *testing.T) {
+	key := newTestingDPoPKey(t)
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.Config.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+
+	proof := signTestingDPoPProof(t, key, "dpop+jwt", map[string]interface{}{
+		"jti": "once",
+		"htm": "POST",
+		"htu": "https://auth.example.com/token",
+		"iat": time.Now().Unix(),
+	})
+
+	for i, expectedError := range []string{"", E_INVALID_DPOP_PROOF} {
+		resp := server.NewResponse()
+		req := newDPoPAccessRequest(t, url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}}, proof)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != expectedError {
+			t.Fatalf("request %d: expected error %q, got %q", i, expectedError, resp.ErrorId)
+		}
+	}
+}
+
+func TestDPoPBoundRefresh(t *testing.T) {
+	key := newTestingDPoPKey(t)
+	otherKey := newTestingDPoPKey(t)
+
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.Storage.SaveAccess(&AccessData{
+		Client:        &DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth"},
+		AccessToken:   "bound-token",
+		RefreshToken:  "bound-refresh",
+		ExpiresIn:     3600,
+		CreatedAt:     time.Now(),
+		TokenType:     DPOP_TOKEN_TYPE,
+		JWKThumbprint: dpopThumbprint(t, key),
+	})
+
+	for i, test := range []struct {
+		Key           *ecdsa.PrivateKey
+		ExpectedError string
+	}{
+		{Key: otherKey, ExpectedError: E_INVALID_GRANT},
+		{Key: nil, ExpectedError: E_INVALID_GRANT},
+		{Key: key},
+	} {
+		proof := ""
+		if test.Key != nil {
+			proof = signTestingDPoPProof(t, test.Key, "dpop+jwt", map[string]interface{}{
+				"jti": "refresh-" + string(rune('a'+i)),
+				"htm": "POST",
+				"htu": "https://auth.example.com/token",
+				"iat": time.Now().Unix(),
+			})
+		}
+		resp := server.NewResponse()
+		req := newDPoPAccessRequest(t, url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"bound-refresh"}}, proof)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Fatalf("request %d: expected error %q, got %q: %v", i, test.ExpectedError, resp.ErrorId, resp.InternalError)
+		}
+	}
+}
+
+func TestInfoDPoP(t *testing.T) {
+	key := newTestingDPoPKey(t)
+	otherKey := newTestingDPoPKey(t)
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.Storage.SaveAccess(&AccessData{
+		Client:        &DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth"},
+		AccessToken:   "dpop-token",
+		ExpiresIn:     3600,
+		CreatedAt:     time.Now(),
+		TokenType:     DPOP_TOKEN_TYPE,
+		JWKThumbprint: dpopThumbprint(t, key),
+	})
+	sum := sha256.Sum256([]byte("dpop-token"))
+	ath := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	testcases := map[string]struct {
+		Scheme        string
+		Key           *ecdsa.PrivateKey
+		Ath           string
+		ExpectedError string
+	}{
+		"valid": {
+			Scheme: "DPoP",
+			Key:    key,
+			Ath:    ath,
+		},
+		"bearer scheme": {
+			Scheme:        "Bearer",
+			Key:           key,
+			Ath:           ath,
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"missing proof": {
+			Scheme:        "DPoP",
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"other key": {
+			Scheme:        "DPoP",
+			Key:           otherKey,
+			Ath:           ath,
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+		"wrong ath": {
+			Scheme:        "DPoP",
+			Key:           key,
+			Ath:           "wrong",
+			ExpectedError: E_INVALID_DPOP_PROOF,
+		},
+	}
+
+	for k, test := range testcases {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("GET", "https://auth.example.com/info", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.TLS = &tls.ConnectionState{}
+		req.Header.Set("Authorization", test.Scheme+" dpop-token")
+		if test.Key != nil {
+			req.Header.Set("DPoP", signTestingDPoPProof(t, test.Key, "dpop+jwt", map[string]interface{}{
+				"jti": "info-" + k,
+				"htm": "GET",
+				"htu": "https://auth.example.com/info",
+				"iat": time.Now().Unix(),
+				"ath": test.Ath,
+			}))
+		}
+
+		if ir := server.HandleInfoRequest(resp, req); ir != nil {
+			server.FinishInfoRequest(resp, req, ir)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError == "" {
+			if d := resp.Output["token_type"]; d != DPOP_TOKEN_TYPE {
+				t.Errorf("%s: unexpected token type: %v", k, d)
+			}
+		}
+	}
+}
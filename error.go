@@ -3,16 +3,31 @@ package osin
 type DefaultErrorId string
 
 const (
-	E_INVALID_REQUEST           string = "invalid_request"
-	E_UNAUTHORIZED_CLIENT              = "unauthorized_client"
-	E_ACCESS_DENIED                    = "access_denied"
-	E_UNSUPPORTED_RESPONSE_TYPE        = "unsupported_response_type"
-	E_INVALID_SCOPE                    = "invalid_scope"
-	E_SERVER_ERROR                     = "server_error"
-	E_TEMPORARILY_UNAVAILABLE          = "temporarily_unavailable"
-	E_UNSUPPORTED_GRANT_TYPE           = "unsupported_grant_type"
-	E_INVALID_GRANT                    = "invalid_grant"
-	E_INVALID_CLIENT                   = "invalid_client"
+	E_INVALID_REQUEST               string = "invalid_request"
+	E_UNAUTHORIZED_CLIENT                  = "unauthorized_client"
+	E_ACCESS_DENIED                        = "access_denied"
+	E_UNSUPPORTED_RESPONSE_TYPE            = "unsupported_response_type"
+	E_INVALID_SCOPE                        = "invalid_scope"
+	E_SERVER_ERROR                         = "server_error"
+	E_TEMPORARILY_UNAVAILABLE              = "temporarily_unavailable"
+	E_UNSUPPORTED_GRANT_TYPE               = "unsupported_grant_type"
+	E_INVALID_GRANT                        = "invalid_grant"
+	E_INVALID_CLIENT                       = "invalid_client"
+	E_AUTHORIZATION_PENDING                = "authorization_pending"
+	E_SLOW_DOWN                            = "slow_down"
+	E_EXPIRED_TOKEN                        = "expired_token"
+	E_INVALID_TARGET                       = "invalid_target"
+	E_INVALID_DPOP_PROOF                   = "invalid_dpop_proof"
+	E_USE_DPOP_NONCE                       = "use_dpop_nonce"
+	E_INVALID_REQUEST_URI                  = "invalid_request_uri"
+	E_INVALID_REQUEST_OBJECT               = "invalid_request_object"
+	E_REQUEST_URI_NOT_SUPPORTED            = "request_uri_not_supported"
+	E_INVALID_TOKEN                        = "invalid_token"
+	E_INSUFFICIENT_SCOPE                   = "insufficient_scope"
+	E_INVALID_REDIRECT_URI                 = "invalid_redirect_uri"
+	E_INVALID_CLIENT_METADATA              = "invalid_client_metadata"
+	E_INVALID_SOFTWARE_STATEMENT           = "invalid_software_statement"
+	E_UNAPPROVED_SOFTWARE_STATEMENT        = "unapproved_software_statement"
 )
 
 var (
@@ -29,6 +44,12 @@ type DefaultErrors struct {
 // http://tools.ietf.org/html/rfc6749#section-4.2.2.1
 // http://tools.ietf.org/html/rfc6749#section-5.2
 // http://tools.ietf.org/html/rfc6749#section-7.2
+// https://tools.ietf.org/html/rfc8628#section-3.5
+// https://tools.ietf.org/html/rfc8693#section-2.2.2
+// https://www.rfc-editor.org/rfc/rfc9449#section-12.2
+// https://www.rfc-editor.org/rfc/rfc9101#section-6.2
+// https://tools.ietf.org/html/rfc6750#section-3.1
+// https://tools.ietf.org/html/rfc7591#section-3.2.2
 func NewDefaultErrors() *DefaultErrors {
 	r := &DefaultErrors{errormap: make(map[string]string)}
 	r.errormap[E_INVALID_REQUEST] = "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed."
@@ -41,6 +62,21 @@ func NewDefaultErrors() *DefaultErrors {
 	r.errormap[E_UNSUPPORTED_GRANT_TYPE] = "The authorization grant type is not supported by the authorization server."
 	r.errormap[E_INVALID_GRANT] = "The provided authorization grant (e.g., authorization code, resource owner credentials) or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client."
 	r.errormap[E_INVALID_CLIENT] = "Client authentication failed (e.g., unknown client, no client authentication included, or unsupported authentication method)."
+	r.errormap[E_AUTHORIZATION_PENDING] = "The authorization request is still pending as the end user hasn't yet completed the user-interaction steps."
+	r.errormap[E_SLOW_DOWN] = "The authorization request is still pending and polling should continue, but the interval must be increased by 5 seconds for this and all subsequent requests."
+	r.errormap[E_EXPIRED_TOKEN] = "The device code has expired, and the device authorization session has concluded."
+	r.errormap[E_INVALID_TARGET] = "The requested resource or audience is invalid, unknown, or not acceptable to the authorization server."
+	r.errormap[E_INVALID_DPOP_PROOF] = "The DPoP proof is invalid."
+	r.errormap[E_USE_DPOP_NONCE] = "The DPoP proof must include the nonce provided by the authorization server."
+	r.errormap[E_INVALID_REQUEST_URI] = "The request_uri in the authorization request returns an error or contains invalid data."
+	r.errormap[E_INVALID_REQUEST_OBJECT] = "The request parameter contains an invalid request object."
+	r.errormap[E_REQUEST_URI_NOT_SUPPORTED] = "The authorization server does not support use of the request_uri parameter."
+	r.errormap[E_INVALID_TOKEN] = "The access token provided is expired, revoked, malformed, or invalid for other reasons."
+	r.errormap[E_INSUFFICIENT_SCOPE] = "The request requires higher privileges than provided by the access token."
+	r.errormap[E_INVALID_REDIRECT_URI] = "The value of one or more redirection URIs is invalid."
+	r.errormap[E_INVALID_CLIENT_METADATA] = "The value of one of the client metadata fields is invalid and the server has rejected this request."
+	r.errormap[E_INVALID_SOFTWARE_STATEMENT] = "The software statement presented is invalid."
+	r.errormap[E_UNAPPROVED_SOFTWARE_STATEMENT] = "The software statement presented is not approved for use by this authorization server."
 	return r
 }
 
@@ -12,7 +12,6 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/openshift/osin"
 	"github.com/openshift/osin/example"
@@ -22,12 +21,25 @@ import (
 
 var (
 	issuer = "http://127.0.0.1:14001"
-	server = osin.NewServer(osin.NewServerConfig(), example.NewTestStorage())
+	server = osin.NewServer(newServerConfig(), example.NewTestStorage())
 
-	jwtSigner  jose.Signer
 	publicKeys *jose.JsonWebKeySet
 )
 
+func newServerConfig() *osin.ServerConfig {
+	config := osin.NewServerConfig()
+	config.Issuer = issuer
+	// Also support the hybrid flow of legacy relying parties.
+	config.AllowedAuthorizeTypes = osin.AllowedAuthorizeType{osin.CODE, osin.CODE_ID_TOKEN, osin.CODE_ID_TOKEN_TOKEN}
+	config.Endpoints = osin.ServerEndpoints{
+		Authorization: "/authorize",
+		Token:         "/token",
+		UserInfo:      "/userinfo",
+		JwksUri:       "/publickeys",
+	}
+	return config
+}
+
 func main() {
 	// Load signing key.
 	block, _ := pem.Decode(privateKeyBytes)
@@ -39,7 +51,7 @@ func main() {
 		log.Fatalf("failed to parse key: %v", err)
 	}
 
-	// Configure jwtSigner and public keys.
+	// Configure the ID Token signer and public keys.
 	privateKey := &jose.JsonWebKey{
 		Key:       key,
 		Algorithm: "RS256",
@@ -47,10 +59,10 @@ func main() {
 		KeyID:     "1", // KeyID should use the key thumbprint.
 	}
 
-	jwtSigner, err = jose.NewSigner(jose.RS256, privateKey)
-	if err != nil {
-		log.Fatalf("failed to create jwtSigner: %v", err)
-	}
+	server.IDTokenSigner = &osin.JWTIDTokenSigner{Key: privateKey}
+	server.IDTokenClaims = userClaims{}
+	server.UserInfoClaims = userClaims{}
+
 	publicKeys = &jose.JsonWebKeySet{
 		Keys: []jose.JsonWebKey{
 			jose.JsonWebKey{Key: &key.PublicKey,
@@ -61,70 +73,83 @@ func main() {
 		},
 	}
 
-	// Register the four manditory OpenID Connect endpoints: discovery, public keys, auth, and token.
+	// Register the four manditory OpenID Connect endpoints: discovery, public keys, auth, and token,
+	// and the UserInfo endpoint.
 	http.HandleFunc("/.well-known/openid-configuration", handleDiscovery)
 	http.HandleFunc("/publickeys", handlePublicKeys)
 	http.HandleFunc("/authorize", handleAuthorization)
 	http.HandleFunc("/token", handleToken)
+	http.HandleFunc("/userinfo", handleUserInfo)
 
 	log.Fatal(http.ListenAndServe("127.0.0.1:14001", nil))
 }
 
-// The ID Token represents a JWT passed to the client as part of the token response.
+// userClaims provides the claims of the ID Tokens osin issues when the "openid"
+// scope is granted, and of the UserInfo endpoint. osin adds the iss, aud, exp, iat,
+// nonce and at_hash claims to ID Tokens, and filters the UserInfo claims by scope.
 //
 // https://openid.net/specs/openid-connect-core-1_0.html#IDToken
-type IDToken struct {
-	Issuer     string `json:"iss"`
-	UserID     string `json:"sub"`
-	ClientID   string `json:"aud"`
-	Expiration int64  `json:"exp"`
-	IssuedAt   int64  `json:"iat"`
+type userClaims struct{}
 
-	Nonce string `json:"nonce,omitempty"` // Non-manditory fields MUST be "omitempty"
+func (userClaims) GetIDTokenClaims(req *osin.IDTokenRequest) (map[string]interface{}, error) {
+	return claimsOfScope(req.Scope), nil
+}
+
+func (userClaims) GetUserInfoClaims(data *osin.AccessData) (map[string]interface{}, error) {
+	return claimsOfScope(data.Scope), nil
+}
+
+func claimsOfScope(scope string) map[string]interface{} {
+	scopes := make(map[string]bool)
+	for _, s := range strings.Fields(scope) {
+		scopes[s] = true
+	}
+
+	// These values would be tied to the end user authorizing the client,
+	// e.g. stored in the UserData of the authorization.
+	claims := map[string]interface{}{
+		"sub": "id-of-test-user",
+	}
 
 	// Custom claims supported by this server.
 	//
 	// See: https://openid.net/specs/openid-connect-core-1_0.html#StandardClaims
-
-	Email         string `json:"email,omitempty"`
-	EmailVerified *bool  `json:"email_verified,omitempty"`
-
-	Name       string `json:"name,omitempty"`
-	FamilyName string `json:"family_name,omitempty"`
-	GivenName  string `json:"given_name,omitempty"`
-	Locale     string `json:"locale,omitempty"`
+	if scopes["profile"] {
+		claims["name"] = "Jane Doe"
+		claims["given_name"] = "Jane"
+		claims["family_name"] = "Doe"
+		claims["locale"] = "us"
+	}
+	if scopes["email"] {
+		claims["email"] = "jane.doe@example.com"
+		claims["email_verified"] = true
+	}
+	return claims
 }
 
 // handleDiscovery returns the OpenID Connect discovery object, allowing clients
 // to discover OAuth2 resources.
 func handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	resp := server.NewResponse()
+	defer resp.Close()
+
+	// The document is generated from the server configuration. Add the values
+	// osin doesn't know about.
+	//
 	// For other example see: https://accounts.google.com/.well-known/openid-configuration
-	data := map[string]interface{}{
-		"issuer":                                issuer,
-		"authorization_endpoint":                issuer + "/authorize",
-		"token_endpoint":                        issuer + "/token",
-		"jwks_uri":                              issuer + "/publickeys",
-		"response_types_supported":              []string{"code"},
-		"subject_types_supported":               []string{"public"},
-		"id_token_signing_alg_values_supported": []string{"RS256"},
-		"scopes_supported":                      []string{"openid", "email", "profile"},
-		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
-		"claims_supported": []string{
-			"aud", "email", "email_verified", "exp",
+	if mr := server.HandleOpenIDConfigurationRequest(resp, r); mr != nil {
+		mr.Metadata["scopes_supported"] = []string{"openid", "email", "profile"}
+		mr.Metadata["claims_supported"] = []string{
+			"at_hash", "aud", "email", "email_verified", "exp",
 			"family_name", "given_name", "iat", "iss",
-			"locale", "name", "sub",
-		},
+			"locale", "name", "nonce", "sub",
+		}
+		server.FinishMetadataRequest(resp, r, mr)
 	}
-
-	raw, err := json.MarshalIndent(data, "", "  ")
-	if err != nil {
-		log.Printf("failed to marshal data: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	if resp.IsError && resp.InternalError != nil {
+		log.Printf("internal error: %v", resp.InternalError)
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
-	w.Write(raw)
+	osin.OutputJSON(resp, w, r)
 }
 
 // handlePublicKeys publishes the public part of this server's signing keys.
@@ -150,44 +175,9 @@ func handleAuthorization(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// The nonce and other OpenID Connect parameters are stored with the
+		// authorization, and the ID Token is issued during the code for token exchange.
 		ar.Authorized = true
-		scopes := make(map[string]bool)
-		for _, s := range strings.Fields(ar.Scope) {
-			scopes[s] = true
-		}
-
-		// If the "openid" connect scope is specified, attach an ID Token to the
-		// authorization response.
-		//
-		// The ID Token will be serialized and signed during the code for token exchange.
-		if scopes["openid"] {
-
-			// These values would be tied to the end user authorizing the client.
-			now := time.Now()
-			idToken := IDToken{
-				Issuer:     issuer,
-				UserID:     "id-of-test-user",
-				ClientID:   ar.Client.GetId(),
-				Expiration: now.Add(time.Hour).Unix(),
-				IssuedAt:   now.Unix(),
-				Nonce:      r.URL.Query().Get("nonce"),
-			}
-
-			if scopes["profile"] {
-				idToken.Name = "Jane Doe"
-				idToken.GivenName = "Jane"
-				idToken.FamilyName = "Doe"
-				idToken.Locale = "us"
-			}
-
-			if scopes["email"] {
-				t := true
-				idToken.Email = "jane.doe@example.com"
-				idToken.EmailVerified = &t
-			}
-			// NOTE: The storage must be able to encode and decode this object.
-			ar.UserData = &idToken
-		}
 		server.FinishAuthorizeRequest(resp, r, ar)
 	}
 
@@ -204,11 +194,6 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 	if ar := server.HandleAccessRequest(resp, r); ar != nil {
 		ar.Authorized = true
 		server.FinishAccessRequest(resp, r, ar)
-
-		// If an ID Token was encoded as the UserData, serialize and sign it.
-		if idToken, ok := ar.UserData.(*IDToken); ok && idToken != nil {
-			encodeIDToken(resp, idToken, jwtSigner)
-		}
 	}
 	if resp.IsError && resp.InternalError != nil {
 		fmt.Printf("ERROR: %s\n", resp.InternalError)
@@ -216,30 +201,18 @@ func handleToken(w http.ResponseWriter, r *http.Request) {
 	osin.OutputJSON(resp, w, r)
 }
 
-// encodeIDToken serializes and signs an ID Token then adds a field to the token response.
-func encodeIDToken(resp *osin.Response, idToken *IDToken, singer jose.Signer) {
-	resp.InternalError = func() error {
-		payload, err := json.Marshal(idToken)
-		if err != nil {
-			return fmt.Errorf("failed to marshal token: %v", err)
-		}
-		jws, err := jwtSigner.Sign(payload)
-		if err != nil {
-			return fmt.Errorf("failed to sign token: %v", err)
-		}
-		raw, err := jws.CompactSerialize()
-		if err != nil {
-			return fmt.Errorf("failed to serialize token: %v", err)
-		}
-		resp.Output["id_token"] = raw
-		return nil
-	}()
+// handleUserInfo returns the claims about the user who authorized the access token.
+func handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	resp := server.NewResponse()
+	defer resp.Close()
 
-	// Record errors as internal server errors.
-	if resp.InternalError != nil {
-		resp.IsError = true
-		resp.ErrorId = osin.E_SERVER_ERROR
+	if ur := server.HandleUserInfoRequest(resp, r); ur != nil {
+		server.FinishUserInfoRequest(resp, r, ur)
+	}
+	if resp.IsError && resp.InternalError != nil {
+		log.Printf("internal error: %v", resp.InternalError)
 	}
+	osin.OutputJSON(resp, w, r)
 }
 
 var (
@@ -7,6 +7,7 @@ require (
 	github.com/openshift/build-machinery-go v0.0.0-20200917070002-f171684f77ab
 	github.com/openshift/osincli v0.0.0-20160924135400-fababb0555f2
 	github.com/pborman/uuid v1.2.0
+	golang.org/x/net v0.0.0-20190108225652-1e06a53dbb7e
 	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
 	gopkg.in/square/go-jose.v1 v1.1.2
 )
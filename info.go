@@ -12,10 +12,14 @@ type InfoRequest struct {
 }
 
 // HandleInfoRequest is an http.HandlerFunc for server information
-// NOT an RFC specification.
+// NOT an RFC specification. See HandleIntrospectionRequest for rfc7662.
 func (s *Server) HandleInfoRequest(w *Response, r *http.Request) *InfoRequest {
+	bindContext(w, r)
 	r.ParseForm()
 	bearer := CheckBearerAuth(r)
+	if bearer == nil {
+		bearer = CheckDPoPAuth(r)
+	}
 	if bearer == nil {
 		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "handle_info_request=%s", "bearer is nil")
 		return nil
@@ -47,7 +51,7 @@ func (s *Server) HandleInfoRequest(w *Response, r *http.Request) *InfoRequest {
 		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "handle_info_request=%s", "access data client is nil")
 		return nil
 	}
-	if ret.AccessData.Client.GetRedirectUri() == "" {
+	if len(s.clientRedirectUris(ret.AccessData.Client)) == 0 {
 		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "handle_info_request=%s", "access data client redirect uri is empty")
 		return nil
 	}
@@ -55,12 +59,22 @@ func (s *Server) HandleInfoRequest(w *Response, r *http.Request) *InfoRequest {
 		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "handle_info_request=%s", "access data is expired")
 		return nil
 	}
+	if !CheckCertificateBinding(r, ret.AccessData) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "handle_info_request=%s", "client certificate mismatch")
+		return nil
+	}
+	if err = s.checkDPoPBinding(r, ret.AccessData, w.Storage); err != nil {
+		s.setDPoPErrorAndLog(w, err, "handle_info_request=%s", "invalid DPoP proof")
+		return nil
+	}
 
 	return ret
 }
 
 // FinishInfoRequest finalizes the request handled by HandleInfoRequest
 func (s *Server) FinishInfoRequest(w *Response, r *http.Request, ir *InfoRequest) {
+	bindContext(w, r)
+
 	// don't process if is already an error
 	if w.IsError {
 		return
@@ -69,7 +83,7 @@ func (s *Server) FinishInfoRequest(w *Response, r *http.Request, ir *InfoRequest
 	// output data
 	w.Output["client_id"] = ir.AccessData.Client.GetId()
 	w.Output["access_token"] = ir.AccessData.AccessToken
-	w.Output["token_type"] = s.Config.TokenType
+	w.Output["token_type"] = s.tokenType(ir.AccessData)
 	w.Output["expires_in"] = ir.AccessData.CreatedAt.Add(time.Duration(ir.AccessData.ExpiresIn)*time.Second).Sub(s.Now()) / time.Second
 	if ir.AccessData.RefreshToken != "" {
 		w.Output["refresh_token"] = ir.AccessData.RefreshToken
@@ -0,0 +1,148 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+)
+
+// IntrospectionRequest is a request from a protected resource for the state
+// of an access or refresh token, as described in rfc7662
+type IntrospectionRequest struct {
+	Token         string
+	TokenTypeHint TokenTypeHint
+
+	// Client making the request (usually the protected resource)
+	Client Client
+
+	// AccessData associated with Token. Nil if the token is unknown.
+	AccessData *AccessData
+
+	// Type the token was found as, access_token or refresh_token
+	TokenType TokenTypeHint
+
+	// Set if the token is active. Change to false to hide the token from the caller.
+	Active bool
+
+	// Optional subject (`sub`) of the token, usually the resource owner.
+	// Filled from AccessData.Subject, change it before calling FinishIntrospectionRequest.
+	Subject string
+
+	// Optional audience (`aud`) of the token.
+	// Filled from AccessData.Audience, change it before calling FinishIntrospectionRequest.
+	Audience []string
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleIntrospectionRequest is the http.HandlerFunc for handling token
+// introspection requests (https://tools.ietf.org/html/rfc7662)
+func (s *Server) HandleIntrospectionRequest(w *Response, r *http.Request) *IntrospectionRequest {
+	bindContext(w, r)
+
+	// Only allow POST
+	if r.Method != "POST" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, errors.New("Request must be POST"), "introspection_request=%s", "request must be POST")
+		return nil
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "introspection_request=%s", "parsing error")
+		return nil
+	}
+
+	// get client authentication
+	auth := s.getClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+
+	ret := &IntrospectionRequest{
+		Token:         r.FormValue("token"),
+		TokenTypeHint: TokenTypeHint(r.FormValue("token_type_hint")),
+		HttpRequest:   r,
+	}
+
+	// "token" is required
+	if ret.Token == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "introspection_request=%s", "token is required")
+		return nil
+	}
+
+	// must have a valid client, which doesn't need to have a redirect uri
+	if ret.Client = s.authenticateClient(auth, w.Storage, w); ret.Client == nil {
+		return nil
+	}
+
+	ret.AccessData, ret.TokenType, err = s.loadTokenByHint(w.Storage, ret.Token, ret.TokenTypeHint)
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "introspection_request=%s", "error loading token")
+		return nil
+	}
+
+	// unknown, orphaned and expired tokens are inactive
+	switch {
+	case ret.AccessData == nil:
+	case ret.AccessData.Client == nil:
+	case ret.TokenType == ACCESS_TOKEN_HINT && ret.AccessData.IsExpiredAt(s.Now()):
+	default:
+		ret.Active = true
+		ret.Subject = ret.AccessData.Subject
+		ret.Audience = ret.AccessData.Audience
+	}
+
+	return ret
+}
+
+// FinishIntrospectionRequest outputs the state of the token found by HandleIntrospectionRequest.
+// Inactive tokens only output `"active": false`, as required by
+// https://tools.ietf.org/html/rfc7662#section-2.2
+func (s *Server) FinishIntrospectionRequest(w *Response, r *http.Request, ir *IntrospectionRequest) {
+	bindContext(w, r)
+
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	w.Output["active"] = ir.Active
+	if !ir.Active {
+		return
+	}
+
+	w.Output["client_id"] = ir.AccessData.Client.GetId()
+	w.Output["iat"] = ir.AccessData.CreatedAt.Unix()
+	if ir.TokenType == ACCESS_TOKEN_HINT {
+		w.Output["token_type"] = s.tokenType(ir.AccessData)
+		w.Output["exp"] = ir.AccessData.ExpireAt().Unix()
+	}
+	if ir.AccessData.Scope != "" {
+		w.Output["scope"] = ir.AccessData.Scope
+	}
+	if ir.Subject != "" {
+		w.Output["sub"] = ir.Subject
+	}
+	if len(ir.Audience) > 0 {
+		w.Output["aud"] = ir.Audience
+	}
+	if len(ir.AccessData.Actors) > 0 {
+		w.Output["act"] = actClaim(ir.AccessData.Actors)
+	}
+	if cnf := confirmationClaim(ir.AccessData); len(cnf) > 0 {
+		w.Output["cnf"] = cnf
+	}
+}
+
+// confirmationClaim returns the `cnf` claim of sender-constrained tokens (rfc7800),
+// listing the key the token is bound to
+func confirmationClaim(data *AccessData) map[string]interface{} {
+	ret := make(map[string]interface{})
+	if data.CertificateThumbprint != "" {
+		ret["x5t#S256"] = data.CertificateThumbprint
+	}
+	if data.JWKThumbprint != "" {
+		ret["jkt"] = data.JWKThumbprint
+	}
+	return ret
+}
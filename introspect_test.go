@@ -0,0 +1,136 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestIntrospection(t *testing.T) {
+	createdAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	testStorage := NewTestingStorage()
+	// protected resources don't need a redirect uri
+	testStorage.SetClient("resource-server", &DefaultClient{Id: "resource-server", Secret: "rs-secret"})
+	testStorage.SaveAccess(&AccessData{
+		Client:       testStorage.clients["1234"],
+		AccessToken:  "active-access",
+		RefreshToken: "active-refresh",
+		Scope:        "read write",
+		ExpiresIn:    3600,
+		CreatedAt:    createdAt,
+	})
+	testStorage.SaveAccess(&AccessData{
+		Client:      testStorage.clients["1234"],
+		AccessToken: "expired-access",
+		ExpiresIn:   60,
+		CreatedAt:   time.Now().Add(-time.Hour),
+	})
+
+	testcases := map[string]struct {
+		Token     string
+		Hint      string
+		Active    bool
+		TokenType interface{}
+		Exp       interface{}
+	}{
+		"access token": {
+			Token:     "active-access",
+			Active:    true,
+			TokenType: "Bearer",
+			Exp:       createdAt.Add(time.Hour).Unix(),
+		},
+		"refresh token": {
+			Token:  "active-refresh",
+			Hint:   "refresh_token",
+			Active: true,
+		},
+		"refresh token, no hint": {
+			Token:  "active-refresh",
+			Active: true,
+		},
+		"expired access token": {
+			Token: "expired-access",
+		},
+		"unknown token": {
+			Token: "unknown",
+		},
+	}
+
+	for k, test := range testcases {
+		server := NewServer(NewServerConfig(), testStorage)
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "http://localhost:14000/introspect", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("resource-server", "rs-secret")
+		req.Form = make(url.Values)
+		req.Form.Set("token", test.Token)
+		if test.Hint != "" {
+			req.Form.Set("token_type_hint", test.Hint)
+		}
+		req.PostForm = make(url.Values)
+
+		if ir := server.HandleIntrospectionRequest(resp, req); ir != nil {
+			ir.Subject = "user-1"
+			server.FinishIntrospectionRequest(resp, req, ir)
+		}
+
+		if resp.IsError {
+			t.Errorf("%s: unexpected error: %v, %v", k, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if d := resp.Output["active"]; d != test.Active {
+			t.Errorf("%s: expected active=%v, got %v", k, test.Active, d)
+			continue
+		}
+		if !test.Active {
+			if len(resp.Output) != 1 {
+				t.Errorf("%s: inactive token should only output active, got %v", k, resp.Output)
+			}
+			continue
+		}
+		if d := resp.Output["client_id"]; d != "1234" {
+			t.Errorf("%s: unexpected client_id: %v", k, d)
+		}
+		if d := resp.Output["scope"]; d != "read write" {
+			t.Errorf("%s: unexpected scope: %v", k, d)
+		}
+		if d := resp.Output["sub"]; d != "user-1" {
+			t.Errorf("%s: unexpected sub: %v", k, d)
+		}
+		if d := resp.Output["iat"]; d != createdAt.Unix() {
+			t.Errorf("%s: unexpected iat: %v", k, d)
+		}
+		if d := resp.Output["token_type"]; d != test.TokenType {
+			t.Errorf("%s: unexpected token_type: %v", k, d)
+		}
+		if d := resp.Output["exp"]; d != test.Exp {
+			t.Errorf("%s: unexpected exp: %v", k, d)
+		}
+	}
+}
+
+func TestIntrospectionRequiresClientAuth(t *testing.T) {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("POST", "http://localhost:14000/introspect", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "wrong")
+	req.Form = make(url.Values)
+	req.Form.Set("token", "9999")
+	req.PostForm = make(url.Values)
+
+	if ir := server.HandleIntrospectionRequest(resp, req); ir != nil {
+		t.Fatalf("Should not return a request for an unauthenticated client")
+	}
+
+	if !resp.IsError || resp.ErrorId != E_UNAUTHORIZED_CLIENT {
+		t.Fatalf("Expected error %v, got %v", E_UNAUTHORIZED_CLIENT, resp.ErrorId)
+	}
+}
@@ -0,0 +1,247 @@
+package osin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// RequestObjectClient is an optional interface clients can implement to only be allowed
+// to send signed request objects (require_signed_request_object client metadata, rfc9101)
+type RequestObjectClient interface {
+	RequireSignedRequestObject() bool
+}
+
+// RequestObjectFetcher fetches the request objects referenced by the request_uri
+// of authorization requests (https://www.rfc-editor.org/rfc/rfc9101#section-5.2.3)
+type RequestObjectFetcher interface {
+	FetchRequestObject(client Client, requestUri string) (string, error)
+}
+
+// HTTPRequestObjectFetcher fetches request objects from https URLs.
+// Any URL is fetched: wrap it to only allow the request_uris registered by the client.
+type HTTPRequestObjectFetcher struct {
+	// Client to fetch request objects with, http.DefaultClient if nil
+	Client *http.Client
+}
+
+// maxRequestObjectSize limits the size of fetched request objects
+const maxRequestObjectSize = 64 * 1024
+
+// FetchRequestObject fetches the request object with a GET request
+func (f *HTTPRequestObjectFetcher) FetchRequestObject(client Client, requestUri string) (string, error) {
+	u, err := url.Parse(requestUri)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" {
+		return "", errors.New("request_uri must be an https url")
+	}
+
+	httpClient := f.Client
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Get(u.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching request_uri returned status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRequestObjectSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// requestObjectClaims are the JWT claims of request objects which are not authorization parameters
+var requestObjectClaims = []string{"iss", "aud", "exp", "nbf", "iat", "jti"}
+
+// verifyRequestObject verifies the request object of an authorization request, fetching it
+// from request_uri if needed (https://www.rfc-editor.org/rfc/rfc9101#section-6).
+// Returns the authorization parameters: only the ones of the request object are used,
+// with the client_id of the request (https://www.rfc-editor.org/rfc/rfc9101#section-5),
+// and the request object claims, whose jti is recorded by saveRequestObjectJTI.
+// Sets an error on the response if the request object is invalid.
+func (s *Server) verifyRequestObject(w *Response, params url.Values) (url.Values, *JWTClaims) {
+	request, requestUri := params.Get("request"), params.Get("request_uri")
+	if request != "" && requestUri != "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "authorize_request=%s", "request and request_uri can't be used together")
+		return nil, nil
+	}
+
+	// the request object is verified with the keys of the client sending it
+	clientId := params.Get("client_id")
+	if clientId == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "authorize_request=%s", "client_id required with a request object")
+		return nil, nil
+	}
+	client, err := w.Storage.GetClient(clientId)
+	if errors.Is(err, ErrNotFound) || (err == nil && client == nil) {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, nil, "authorize_request=%s", "client not found")
+		return nil, nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "authorize_request=%s", "error finding client")
+		return nil, nil
+	}
+
+	if requestUri != "" {
+		if s.RequestObjectFetcher == nil {
+			s.setErrorAndLog(w, E_REQUEST_URI_NOT_SUPPORTED, nil, "authorize_request=%s", "no RequestObjectFetcher")
+			return nil, nil
+		}
+		if request, err = s.RequestObjectFetcher.FetchRequestObject(client, requestUri); err != nil {
+			s.setErrorAndLog(w, E_INVALID_REQUEST_URI, err, "authorize_request=%s", "error fetching request object")
+			return nil, nil
+		}
+	}
+
+	claims, err := s.parseRequestObject(client, request)
+	if err != nil {
+		s.setErrorAndLog(w, E_INVALID_REQUEST_OBJECT, err, "authorize_request=%s, client_id=%s", "invalid request object", clientId)
+		return nil, nil
+	}
+
+	ret := url.Values{"client_id": {clientId}}
+	for k, v := range claims.Claims {
+		if containsString(requestObjectClaims, k) {
+			continue
+		}
+		if ret[k], err = requestObjectParam(v); err != nil {
+			s.setErrorAndLog(w, E_INVALID_REQUEST_OBJECT, err, "authorize_request=%s, client_id=%s", "invalid request object claim", clientId)
+			return nil, nil
+		}
+	}
+	return ret, claims
+}
+
+// saveRequestObjectJTI records the jti of a request object once its authorization request
+// is authorized, not while it is displayed, e.g. when the login page is submitted with it.
+// Replays can only be detected if the storage remembers the JWT IDs.
+func saveRequestObjectJTI(storage Storage, claims *JWTClaims) error {
+	if _, ok := getJTIStorage(storage); !ok || claims == nil || claims.ID == "" {
+		return nil
+	}
+	return saveJTI(storage, claims.Issuer, claims)
+}
+
+// requestObjectErrorId returns the OAuth error of a request object which jti could not be saved
+func requestObjectErrorId(err error) string {
+	if errors.Is(err, ErrJTIReplayed) {
+		return E_INVALID_REQUEST_OBJECT
+	}
+	return storageErrorId(E_SERVER_ERROR, err)
+}
+
+// parseRequestObject decrypts the request object if needed, verifies its
+// signature with the client keys, and validates its claims
+func (s *Server) parseRequestObject(client Client, request string) (*JWTClaims, error) {
+	// encrypted request objects are nested JWTs
+	// https://www.rfc-editor.org/rfc/rfc9101#section-6.1
+	if strings.Count(request, ".") == 4 {
+		var err error
+		if request, err = s.decryptRequestObject(request); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Config.Issuer == "" {
+		return nil, errors.New("Issuer is not configured")
+	}
+
+	assertionKeys, ok := client.(ClientAssertionKeys)
+	if !ok {
+		return nil, errors.New("client has no keys to verify request objects with")
+	}
+	keys, err := assertionKeys.GetAssertionKeys()
+	if err != nil {
+		return nil, err
+	}
+	if keys == nil || len(keys.Keys) == 0 {
+		return nil, errors.New("client has no keys to verify request objects with")
+	}
+
+	claims, err := parseJWT(request, keys.Keys)
+	if err != nil {
+		return nil, err
+	}
+	if id, err := stringClaim(claims.Claims, "client_id"); err != nil || (id != "" && id != client.GetId()) {
+		return nil, errors.New("request object client_id does not match the client")
+	}
+	if claims.Issuer != client.GetId() {
+		return nil, errors.New("request object iss must be the client id")
+	}
+	if !claims.HasAudience(s.Config.Issuer) {
+		return nil, fmt.Errorf("request object audience %v does not include the issuer", claims.Audience)
+	}
+	if !claims.ExpiresAt.IsZero() {
+		if err = s.validateJWTClaims(claims); err != nil {
+			return nil, err
+		}
+	}
+	return claims, nil
+}
+
+// decryptRequestObject decrypts an encrypted request object with one of the DecryptionKeys
+func (s *Server) decryptRequestObject(request string) (string, error) {
+	if s.DecryptionKeys == nil {
+		return "", errors.New("encrypted request objects are not supported")
+	}
+	jwe, err := jose.ParseEncrypted(request)
+	if err != nil {
+		return "", err
+	}
+	for _, key := range s.DecryptionKeys.Keys {
+		if jwe.Header.KeyID != "" && key.KeyID != jwe.Header.KeyID {
+			continue
+		}
+		if key.Use == "sig" {
+			continue
+		}
+		if plaintext, err := jwe.Decrypt(key.Key); err == nil {
+			return string(plaintext), nil
+		}
+	}
+	return "", fmt.Errorf("no key decrypts the request object (kid=%q)", jwe.Header.KeyID)
+}
+
+// requestObjectParam converts a request object claim to an authorization parameter.
+// Objects such as the `claims` parameter are JSON encoded.
+func requestObjectParam(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case json.Number:
+		return []string{v.String()}, nil
+	case bool:
+		return []string{fmt.Sprint(v)}, nil
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return []string{string(b)}, nil
+	}
+}
+
+// requireSignedRequestObject returns true if the client must send signed request objects
+func (s *Server) requireSignedRequestObject(client Client) bool {
+	if s.Config.RequireSignedRequestObject {
+		return true
+	}
+	if c, ok := client.(RequestObjectClient); ok {
+		return c.RequireSignedRequestObject()
+	}
+	return false
+}
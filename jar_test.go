@@ -0,0 +1,293 @@
+package osin
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+type testingRequestObjectFetcher map[string]string
+
+func (f testingRequestObjectFetcher) FetchRequestObject(client Client, requestUri string) (string, error) {
+	if request, ok := f[requestUri]; ok {
+		return request, nil
+	}
+	return "", errors.New("not found")
+}
+
+func encryptTestingRequestObject(t *testing.T, key *rsa.PrivateKey, request string) string {
+	encrypter, err := jose.NewEncrypter(jose.RSA_OAEP, jose.A128GCM, &key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwe, err := encrypter.Encrypt([]byte(request))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := jwe.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestAuthorizeRequestObject(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	otherKey := newTestingKey(t, "k1")
+	secretKey := &jose.JsonWebKey{Key: []byte("aabbccdd"), Algorithm: string(jose.HS256)}
+	decryptionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+
+	claims := func(clientId string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":           clientId,
+			"aud":           "https://auth.example.com",
+			"exp":           now.Add(time.Minute).Unix(),
+			"client_id":     clientId,
+			"response_type": "code",
+			"redirect_uri":  "http://localhost:14000/appauth",
+			"scope":         "read",
+			"state":         "a",
+			"claims":        map[string]interface{}{"userinfo": map[string]interface{}{"email": nil}},
+		}
+	}
+
+	testcases := map[string]struct {
+		ClientId      string
+		Request       func() string
+		RequestUri    string
+		RequireSigned bool
+		ExpectedError string
+	}{
+		"signed": {
+			ClientId: "jar-client",
+			Request:  func() string { return signTestingJWT(t, key, claims("jar-client")) },
+		},
+		"signed with secret": {
+			ClientId: "secret-client",
+			Request:  func() string { return signTestingJWT(t, secretKey, claims("secret-client")) },
+		},
+		"signed with secret of another method": {
+			ClientId:      "1234",
+			Request:       func() string { return signTestingJWT(t, secretKey, claims("1234")) },
+			ExpectedError: E_INVALID_REQUEST_OBJECT,
+		},
+		"encrypted": {
+			ClientId: "jar-client",
+			Request: func() string {
+				return encryptTestingRequestObject(t, decryptionKey, signTestingJWT(t, key, claims("jar-client")))
+			},
+		},
+		"by reference": {
+			ClientId:   "jar-client",
+			RequestUri: "https://client.example.com/request.jwt",
+		},
+		"unknown reference": {
+			ClientId:      "jar-client",
+			RequestUri:    "https://client.example.com/other.jwt",
+			ExpectedError: E_INVALID_REQUEST_URI,
+		},
+		"unregistered key": {
+			ClientId:      "jar-client",
+			Request:       func() string { return signTestingJWT(t, otherKey, claims("jar-client")) },
+			ExpectedError: E_INVALID_REQUEST_OBJECT,
+		},
+		"other client": {
+			ClientId:      "1234",
+			Request:       func() string { return signTestingJWT(t, key, claims("jar-client")) },
+			ExpectedError: E_INVALID_REQUEST_OBJECT,
+		},
+		"missing iss": {
+			ClientId: "jar-client",
+			Request: func() string {
+				c := claims("jar-client")
+				delete(c, "iss")
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_REQUEST_OBJECT,
+		},
+		"missing audience": {
+			ClientId: "jar-client",
+			Request: func() string {
+				c := claims("jar-client")
+				delete(c, "aud")
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_REQUEST_OBJECT,
+		},
+		"wrong audience": {
+			ClientId: "jar-client",
+			Request: func() string {
+				c := claims("jar-client")
+				c["aud"] = "https://other.example.com"
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_REQUEST_OBJECT,
+		},
+		"expired": {
+			ClientId: "jar-client",
+			Request: func() string {
+				c := claims("jar-client")
+				c["exp"] = now.Add(-time.Hour).Unix()
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_REQUEST_OBJECT,
+		},
+		"signed required": {
+			ClientId:      "jar-client",
+			Request:       func() string { return signTestingJWT(t, key, claims("jar-client")) },
+			RequireSigned: true,
+		},
+		"unsigned when required": {
+			ClientId:      "jar-client",
+			RequireSigned: true,
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.Issuer = "https://auth.example.com"
+		sconfig.RequireSignedRequestObject = test.RequireSigned
+		storage := NewTestingStorage()
+		storage.SetClient("jar-client", &DefaultClient{
+			Id:          "jar-client",
+			RedirectUri: "http://localhost:14000/appauth",
+			JsonWebKeys: publicKeySet(key),
+		})
+		storage.SetClient("secret-client", newTestingSecretJWTClient())
+		server := NewServer(sconfig, storage)
+		server.DecryptionKeys = &jose.JsonWebKeySet{Keys: []jose.JsonWebKey{{Key: decryptionKey}}}
+		server.RequestObjectFetcher = testingRequestObjectFetcher{
+			"https://client.example.com/request.jwt": signTestingJWT(t, key, claims("jar-client")),
+		}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{
+			"client_id":     {test.ClientId},
+			"response_type": {"code"},
+			"redirect_uri":  {"http://localhost:14000/appauth"},
+			"scope":         {"write"},
+		}
+		if test.Request != nil {
+			req.Form.Set("request", test.Request())
+		}
+		if test.RequestUri != "" {
+			req.Form.Set("request_uri", test.RequestUri)
+		}
+
+		ar := server.HandleAuthorizeRequest(resp, req)
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			continue
+		}
+		if ar == nil {
+			t.Errorf("%s: expected a request", k)
+			continue
+		}
+		if ar.Scope != "read" || ar.State != "a" {
+			t.Errorf("%s: only the request object parameters should be used: %+v", k, ar)
+		}
+	}
+}
+
+func TestRequestObjectParams(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	storage := NewTestingStorage()
+	storage.SetClient("jar-client", &DefaultClient{Id: "jar-client", JsonWebKeys: publicKeySet(key)})
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	server := NewServer(sconfig, storage)
+	resp := server.NewResponse()
+
+	params, _ := server.verifyRequestObject(resp, url.Values{
+		"client_id": {"jar-client"},
+		"nonce":     {"query"},
+		"prompt":    {"none"},
+		"request": {signTestingJWT(t, key, map[string]interface{}{
+			"iss":     "jar-client",
+			"aud":     "https://auth.example.com",
+			"nonce":   "object",
+			"max_age": 300,
+			"claims":  map[string]interface{}{"id_token": map[string]interface{}{"acr": nil}},
+		})},
+	})
+	if params == nil {
+		t.Fatalf("Unexpected error: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+	expected := url.Values{
+		"client_id": {"jar-client"},
+		"nonce":     {"object"},
+		"max_age":   {"300"},
+		"claims":    {`{"id_token":{"acr":null}}`},
+	}
+	if params.Encode() != expected.Encode() {
+		t.Errorf("expected %v, got %v", expected, params)
+	}
+}
+
+func TestRequestObjectReplay(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	storage := NewTestingStorage()
+	storage.SetClient("jar-client", &DefaultClient{Id: "jar-client", RedirectUri: "http://localhost:14000/appauth", JsonWebKeys: publicKeySet(key)})
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	server := NewServer(sconfig, storage)
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+
+	request := signTestingJWT(t, key, map[string]interface{}{
+		"iss":           "jar-client",
+		"aud":           "https://auth.example.com",
+		"exp":           time.Now().Add(time.Minute).Unix(),
+		"jti":           "request",
+		"response_type": "code",
+		"state":         "a",
+	})
+	authorize := func(method string, authorized bool) *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest(method, "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{"client_id": {"jar-client"}, "request": {request}}
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			if authorized {
+				ar.Authorized = true
+				server.FinishAuthorizeRequest(resp, req, ar)
+			}
+		}
+		return resp
+	}
+
+	// the login page is displayed, then submitted with the same request object
+	if resp := authorize("GET", false); resp.IsError {
+		t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+	if _, ok := storage.jti["jar-client#request"]; ok {
+		t.Fatal("jti was recorded before the request was authorized")
+	}
+	if resp := authorize("POST", true); resp.IsError || resp.Output["code"] != "1" {
+		t.Fatalf("Unexpected response: %s: %v: %v", resp.ErrorId, resp.InternalError, resp.Output)
+	}
+
+	// the request object can't be used to authorize again
+	if resp := authorize("POST", true); resp.ErrorId != E_INVALID_REQUEST_OBJECT || resp.Output["code"] != nil {
+		t.Errorf("expected error %q for a replayed request object, got %q", E_INVALID_REQUEST_OBJECT, resp.ErrorId)
+	}
+}
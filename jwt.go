@@ -0,0 +1,234 @@
+package osin
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+var (
+	// ErrJTIReplayed is the error returned by JTIStorage.SaveJTI when the JWT ID was already used.
+	ErrJTIReplayed = errors.New("JWT ID already used")
+)
+
+// jwtSigningAlgorithms are the JWS algorithms verified by parseJWT
+var jwtSigningAlgorithms = []string{
+	string(jose.RS256), string(jose.RS384), string(jose.RS512),
+	string(jose.PS256), string(jose.PS384), string(jose.PS512),
+	string(jose.ES256), string(jose.ES384), string(jose.ES512),
+	string(jose.HS256), string(jose.HS384), string(jose.HS512),
+}
+
+// JTIStorage is an optional interface Storage can implement to reject replayed JWTs,
+// such as assertions and proofs, by remembering their JWT IDs (`jti`)
+type JTIStorage interface {
+	// SaveJTI records a JWT ID until it expires. It must atomically return ErrJTIReplayed
+	// if the id is already recorded and not expired.
+	// The id is namespaced by the library, e.g. with the JWT issuer.
+	SaveJTI(jti string, expireAt time.Time) error
+}
+
+// ContextJTIStorage is the context-aware version of JTIStorage, implemented by a
+// ContextStorage to reject replayed JWTs
+type ContextJTIStorage interface {
+	SaveJTI(ctx context.Context, jti string, expireAt time.Time) error
+}
+
+// JWTClaims are the claims of a verified JWT (https://tools.ietf.org/html/rfc7519#section-4.1)
+type JWTClaims struct {
+	Issuer    string
+	Subject   string
+	Audience  []string
+	ExpiresAt time.Time
+	NotBefore time.Time
+	IssuedAt  time.Time
+	ID        string
+
+	// All claims of the token, including the registered ones above.
+	// Numbers are decoded as json.Number.
+	Claims map[string]interface{}
+}
+
+// HasAudience returns true if the audience of the token includes any of aud
+func (c *JWTClaims) HasAudience(aud ...string) bool {
+	for _, a := range c.Audience {
+		for _, b := range aud {
+			if a != "" && a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// validateJWTClaims checks the time claims and audience of a token.
+// `exp` is required, `nbf` and `iat` are checked when present, allowing for clock skew.
+func (s *Server) validateJWTClaims(claims *JWTClaims, audience ...string) error {
+	now := s.Now()
+	skew := time.Duration(s.Config.JWTClockSkew) * time.Second
+
+	if claims.ExpiresAt.IsZero() {
+		return errors.New("exp claim is required")
+	}
+	if now.After(claims.ExpiresAt.Add(skew)) {
+		return errors.New("token is expired")
+	}
+	if !claims.NotBefore.IsZero() && now.Add(skew).Before(claims.NotBefore) {
+		return errors.New("token is not valid yet")
+	}
+	if !claims.IssuedAt.IsZero() && now.Add(skew).Before(claims.IssuedAt) {
+		return errors.New("token was issued in the future")
+	}
+	if len(audience) > 0 && !claims.HasAudience(audience...) {
+		return fmt.Errorf("token audience %v is not one of %v", claims.Audience, audience)
+	}
+	return nil
+}
+
+// saveJTI rejects replayed JWT IDs using the storage, namespaced by issuer.
+// The storage must implement JTIStorage.
+func saveJTI(storage Storage, issuer string, claims *JWTClaims) error {
+	jtiStorage, ok := getJTIStorage(storage)
+	if !ok {
+		return errors.New("Storage does not implement JTIStorage")
+	}
+	return jtiStorage.SaveJTI(issuer+"#"+claims.ID, claims.ExpiresAt)
+}
+
+// parseJWT verifies the signature of a compact serialized JWT with one of the keys,
+// and returns its claims. Keys are filtered by the `kid` header when present.
+// Unsigned tokens (`alg: none`) are never accepted.
+func parseJWT(token string, keys []jose.JsonWebKey) (*JWTClaims, error) {
+	if strings.Count(token, ".") != 2 {
+		return nil, errors.New("token is not a compact serialized JWS")
+	}
+
+	jws, err := jose.ParseSigned(token)
+	if err != nil {
+		return nil, err
+	}
+	if len(jws.Signatures) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+	header := jws.Signatures[0].Header
+
+	var payload []byte
+	for _, key := range keys {
+		if header.KeyID != "" && key.KeyID != header.KeyID {
+			continue
+		}
+		if key.Use == "enc" {
+			continue
+		}
+		if key.Algorithm != "" && key.Algorithm != header.Algorithm {
+			continue
+		}
+		if secret, ok := key.Key.([]byte); ok && len(secret) == 0 {
+			continue
+		}
+		if payload, err = jws.Verify(publicKey(key.Key)); err == nil {
+			break
+		}
+	}
+	if payload == nil {
+		return nil, fmt.Errorf("no key verifies the token signature (kid=%q, alg=%q)", header.KeyID, header.Algorithm)
+	}
+
+	return parseJWTClaims(payload)
+}
+
+// parseJWTClaims decodes a JWT payload
+func parseJWTClaims(payload []byte) (*JWTClaims, error) {
+	ret := &JWTClaims{Claims: make(map[string]interface{})}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(&ret.Claims); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if ret.Issuer, err = stringClaim(ret.Claims, "iss"); err != nil {
+		return nil, err
+	}
+	if ret.Subject, err = stringClaim(ret.Claims, "sub"); err != nil {
+		return nil, err
+	}
+	if ret.ID, err = stringClaim(ret.Claims, "jti"); err != nil {
+		return nil, err
+	}
+	if ret.ExpiresAt, err = timeClaim(ret.Claims, "exp"); err != nil {
+		return nil, err
+	}
+	if ret.NotBefore, err = timeClaim(ret.Claims, "nbf"); err != nil {
+		return nil, err
+	}
+	if ret.IssuedAt, err = timeClaim(ret.Claims, "iat"); err != nil {
+		return nil, err
+	}
+
+	// "aud" may be a single string or an array of strings
+	switch aud := ret.Claims["aud"].(type) {
+	case nil:
+	case string:
+		ret.Audience = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			s, ok := a.(string)
+			if !ok {
+				return nil, errors.New("aud claim must contain strings")
+			}
+			ret.Audience = append(ret.Audience, s)
+		}
+	default:
+		return nil, errors.New("aud claim must be a string or an array of strings")
+	}
+
+	return ret, nil
+}
+
+func stringClaim(claims map[string]interface{}, name string) (string, error) {
+	switch v := claims[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		return "", fmt.Errorf("%s claim must be a string", name)
+	}
+}
+
+func timeClaim(claims map[string]interface{}, name string) (time.Time, error) {
+	switch v := claims[name].(type) {
+	case nil:
+		return time.Time{}, nil
+	case json.Number:
+		f, err := v.Float64()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%s claim must be a number: %v", name, err)
+		}
+		return time.Unix(int64(f), 0), nil
+	default:
+		return time.Time{}, fmt.Errorf("%s claim must be a number", name)
+	}
+}
+
+// publicKey returns the public part of private keys, which can't be used for verification
+func publicKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	default:
+		return key
+	}
+}
@@ -0,0 +1,128 @@
+package osin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+func newTestingKey(t *testing.T, kid string) *jose.JsonWebKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &jose.JsonWebKey{Key: key, KeyID: kid, Algorithm: string(jose.ES256), Use: "sig"}
+}
+
+func publicKeySet(keys ...*jose.JsonWebKey) *jose.JsonWebKeySet {
+	ret := &jose.JsonWebKeySet{}
+	for _, k := range keys {
+		ret.Keys = append(ret.Keys, jose.JsonWebKey{Key: publicKey(k.Key), KeyID: k.KeyID, Algorithm: k.Algorithm, Use: k.Use})
+	}
+	return ret
+}
+
+func signTestingJWT(t *testing.T, key *jose.JsonWebKey, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(jose.SignatureAlgorithm(key.Algorithm), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := jws.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+func TestParseJWT(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	otherKey := newTestingKey(t, "k2")
+	now := time.Now().Truncate(time.Second)
+
+	token := signTestingJWT(t, key, map[string]interface{}{
+		"iss": "issuer",
+		"sub": "subject",
+		"aud": []string{"a", "b"},
+		"exp": now.Add(time.Minute).Unix(),
+		"iat": now.Unix(),
+		"jti": "id",
+	})
+
+	claims, err := parseJWT(token, publicKeySet(otherKey, key).Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Issuer != "issuer" || claims.Subject != "subject" || claims.ID != "id" {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+	if !claims.ExpiresAt.Equal(now.Add(time.Minute)) || !claims.IssuedAt.Equal(now) {
+		t.Errorf("Unexpected time claims: %+v", claims)
+	}
+	if !claims.HasAudience("b") || claims.HasAudience("c") {
+		t.Errorf("Unexpected audience: %v", claims.Audience)
+	}
+
+	if _, err := parseJWT(token, publicKeySet(otherKey).Keys); err == nil {
+		t.Errorf("Token should not verify with another key")
+	}
+	if _, err := parseJWT(token+"x", publicKeySet(key).Keys); err == nil {
+		t.Errorf("Token with a bad signature should not verify")
+	}
+}
+
+func TestValidateJWTClaims(t *testing.T) {
+	now := time.Now()
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.Now = func() time.Time { return now }
+
+	testcases := map[string]struct {
+		Claims *JWTClaims
+		Valid  bool
+	}{
+		"valid": {
+			Claims: &JWTClaims{Audience: []string{"aud"}, ExpiresAt: now.Add(time.Minute), IssuedAt: now},
+			Valid:  true,
+		},
+		"expired within skew": {
+			Claims: &JWTClaims{Audience: []string{"aud"}, ExpiresAt: now.Add(-30 * time.Second)},
+			Valid:  true,
+		},
+		"expired": {
+			Claims: &JWTClaims{Audience: []string{"aud"}, ExpiresAt: now.Add(-time.Hour)},
+		},
+		"missing exp": {
+			Claims: &JWTClaims{Audience: []string{"aud"}},
+		},
+		"not valid yet": {
+			Claims: &JWTClaims{Audience: []string{"aud"}, ExpiresAt: now.Add(time.Hour), NotBefore: now.Add(10 * time.Minute)},
+		},
+		"issued in the future": {
+			Claims: &JWTClaims{Audience: []string{"aud"}, ExpiresAt: now.Add(time.Hour), IssuedAt: now.Add(10 * time.Minute)},
+		},
+		"wrong audience": {
+			Claims: &JWTClaims{Audience: []string{"other"}, ExpiresAt: now.Add(time.Minute)},
+		},
+	}
+
+	for k, test := range testcases {
+		err := server.validateJWTClaims(test.Claims, "aud")
+		if test.Valid && err != nil {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		} else if !test.Valid && err == nil {
+			t.Errorf("%s: expected error", k)
+		}
+	}
+}
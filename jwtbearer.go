@@ -0,0 +1,153 @@
+package osin
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// TrustedIssuers looks up the keys of the issuers whose JWTs are accepted
+// by the JWT bearer grant (rfc7523), e.g. a Kubernetes service account issuer
+type TrustedIssuers interface {
+	// GetIssuerKeys returns the keys of a trusted issuer.
+	// Returns ErrNotFound if the issuer is not trusted.
+	GetIssuerKeys(issuer string) (*jose.JsonWebKeySet, error)
+}
+
+// TrustedIssuerKeys is a TrustedIssuers with a fixed set of keys per issuer
+type TrustedIssuerKeys map[string]*jose.JsonWebKeySet
+
+// GetIssuerKeys returns the keys of a trusted issuer
+func (t TrustedIssuerKeys) GetIssuerKeys(issuer string) (*jose.JsonWebKeySet, error) {
+	if keys, ok := t[issuer]; ok {
+		return keys, nil
+	}
+	return nil, ErrNotFound
+}
+
+// handleJWTBearerRequest validates a JWT authorization grant
+// (https://tools.ietf.org/html/rfc7523#section-2.1).
+// The JWT must be signed by one of the TrustedIssuers. If the storage implements JTIStorage,
+// the JWT must have a `jti` claim and is rejected if it was already used.
+func (s *Server) handleJWTBearerRequest(w *Response, r *http.Request) *AccessRequest {
+	// get client authentication
+	auth := s.getClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+
+	// generate access token
+	ret := &AccessRequest{
+		Type:            JWT_BEARER,
+		Scope:           r.FormValue("scope"),
+		Assertion:       r.FormValue("assertion"),
+		GenerateRefresh: false,
+		Expiration:      s.Config.AccessExpiration,
+		HttpRequest:     r,
+	}
+
+	// "assertion" is required
+	if ret.Assertion == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "jwt_bearer_request=%s", "assertion required")
+		return nil
+	}
+
+	// must have a valid client, services usually have no redirect uri
+	if ret.Client = s.authenticateClient(auth, w.Storage, w); ret.Client == nil {
+		return nil
+	}
+
+	if s.TrustedIssuers == nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("TrustedIssuers is not configured"), "jwt_bearer_request=%s", "no trusted issuers")
+		return nil
+	}
+	audiences := s.tokenEndpointAudiences()
+	if len(audiences) == 0 {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Issuer is not configured"), "jwt_bearer_request=%s", "no audience to check")
+		return nil
+	}
+
+	// the issuer is only known after parsing the unverified claims
+	unverified, err := unverifiedJWTClaims(ret.Assertion)
+	if err != nil {
+		s.setErrorAndLog(w, E_INVALID_GRANT, err, "jwt_bearer_request=%s", "error parsing assertion")
+		return nil
+	}
+	if unverified.Issuer == "" || unverified.Subject == "" {
+		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "jwt_bearer_request=%s", "iss and sub claims required")
+		return nil
+	}
+	keys, err := s.TrustedIssuers.GetIssuerKeys(unverified.Issuer)
+	if errors.Is(err, ErrNotFound) || (err == nil && keys == nil) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, nil, "jwt_bearer_request=%s, iss=%s", "issuer is not trusted", unverified.Issuer)
+		return nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "jwt_bearer_request=%s", "error loading issuer keys")
+		return nil
+	}
+
+	// verify the signature, then the claims
+	// https://tools.ietf.org/html/rfc7523#section-3
+	if ret.AssertionClaims, err = parseJWT(ret.Assertion, keys.Keys); err != nil {
+		s.setErrorAndLog(w, E_INVALID_GRANT, err, "jwt_bearer_request=%s", "invalid assertion signature")
+		return nil
+	}
+	if err = s.validateJWTClaims(ret.AssertionClaims, audiences...); err != nil {
+		s.setErrorAndLog(w, E_INVALID_GRANT, err, "jwt_bearer_request=%s", "invalid assertion claims")
+		return nil
+	}
+	// replays can only be detected if the storage remembers the JWT IDs
+	if _, ok := getJTIStorage(w.Storage); ok {
+		if ret.AssertionClaims.ID == "" {
+			s.setErrorAndLog(w, E_INVALID_GRANT, nil, "jwt_bearer_request=%s", "jti claim required")
+			return nil
+		}
+		if err = saveJTI(w.Storage, ret.AssertionClaims.Issuer, ret.AssertionClaims); errors.Is(err, ErrJTIReplayed) {
+			s.setErrorAndLog(w, E_INVALID_GRANT, err, "jwt_bearer_request=%s", "assertion was replayed")
+			return nil
+		} else if err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "jwt_bearer_request=%s", "error saving assertion jti")
+			return nil
+		}
+	}
+
+	// set rest of data
+	ret.AssertionType = string(JWT_BEARER)
+	ret.Subject = ret.AssertionClaims.Subject
+	ret.RedirectUri = s.firstRedirectUri(ret.Client)
+
+	return ret
+}
+
+// tokenEndpointAudiences returns the values accepted as the audience of JWTs sent to the
+// token endpoint: the token endpoint URL and the issuer identifier
+func (s *Server) tokenEndpointAudiences() []string {
+	ret := []string{}
+	if s.Config.Issuer != "" {
+		ret = append(ret, s.Config.Issuer)
+	}
+	if s.Config.Endpoints.Token != "" {
+		if u, err := s.endpointUrl(s.Config.Endpoints.Token); err == nil {
+			ret = append(ret, u)
+		}
+	}
+	return ret
+}
+
+// unverifiedJWTClaims decodes the claims of a JWT without verifying its signature.
+// Only use it to find the keys to verify the token with.
+func unverifiedJWTClaims(token string) (*JWTClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("token is not a compact serialized JWS")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return parseJWTClaims(payload)
+}
@@ -0,0 +1,171 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAccessJWTBearer(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	untrustedKey := newTestingKey(t, "k1")
+	now := time.Now()
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss": "https://kubernetes.default.svc",
+			"sub": "system:serviceaccount:default:builder",
+			"aud": "https://auth.example.com/token",
+			"exp": now.Add(time.Minute).Unix(),
+			"iat": now.Unix(),
+			"jti": "assertion",
+		}
+	}
+
+	testcases := map[string]struct {
+		Assertion     func() string
+		ExpectedError string
+	}{
+		"valid": {
+			Assertion: func() string { return signTestingJWT(t, key, validClaims()) },
+		},
+		"issuer audience": {
+			Assertion: func() string {
+				c := validClaims()
+				c["aud"] = "https://auth.example.com"
+				return signTestingJWT(t, key, c)
+			},
+		},
+		"untrusted key": {
+			Assertion:     func() string { return signTestingJWT(t, untrustedKey, validClaims()) },
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"untrusted issuer": {
+			Assertion: func() string {
+				c := validClaims()
+				c["iss"] = "https://other.example.com"
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"missing sub": {
+			Assertion: func() string {
+				c := validClaims()
+				delete(c, "sub")
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"wrong audience": {
+			Assertion: func() string {
+				c := validClaims()
+				c["aud"] = "https://other.example.com/token"
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"expired": {
+			Assertion: func() string {
+				c := validClaims()
+				c["exp"] = now.Add(-time.Hour).Unix()
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"missing jti": {
+			Assertion: func() string {
+				c := validClaims()
+				delete(c, "jti")
+				return signTestingJWT(t, key, c)
+			},
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"not a jwt": {
+			Assertion:     func() string { return "assertion" },
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"missing": {
+			Assertion:     func() string { return "" },
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{JWT_BEARER}
+		sconfig.Issuer = "https://auth.example.com"
+		sconfig.Endpoints.Token = "/token"
+		server := NewServer(sconfig, NewTestingStorage())
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		server.TrustedIssuers = TrustedIssuerKeys{"https://kubernetes.default.svc": publicKeySet(key)}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("POST", "https://auth.example.com/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = make(url.Values)
+		req.Form.Set("grant_type", string(JWT_BEARER))
+		req.Form.Set("assertion", test.Assertion())
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			if ar.AssertionClaims == nil || ar.AssertionClaims.Subject != "system:serviceaccount:default:builder" {
+				t.Errorf("%s: unexpected assertion claims: %+v", k, ar.AssertionClaims)
+			}
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError == "" {
+			if d := resp.Output["access_token"]; d != "1" {
+				t.Errorf("%s: unexpected access token: %s", k, d)
+			}
+			if ret, _ := server.Storage.LoadAccess("1"); ret == nil || ret.Subject != "system:serviceaccount:default:builder" {
+				t.Errorf("%s: unexpected access data: %v", k, ret)
+			}
+		}
+	}
+}
+
+func TestAccessJWTBearerReplay(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{JWT_BEARER}
+	sconfig.Issuer = "https://auth.example.com"
+	server := NewServer(sconfig, NewTestingStorage())
+	server.TrustedIssuers = TrustedIssuerKeys{"issuer": publicKeySet(key)}
+
+	assertion := signTestingJWT(t, key, map[string]interface{}{
+		"iss": "issuer",
+		"sub": "subject",
+		"aud": "https://auth.example.com",
+		"exp": time.Now().Add(time.Minute).Unix(),
+		"jti": "once",
+	})
+
+	for i, expectedError := range []string{"", E_INVALID_GRANT} {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "https://auth.example.com/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(JWT_BEARER)}, "assertion": {assertion}}
+		req.PostForm = make(url.Values)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != expectedError {
+			t.Fatalf("request %d: expected error %q, got %q", i, expectedError, resp.ErrorId)
+		}
+	}
+}
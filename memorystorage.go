@@ -0,0 +1,606 @@
+package osin
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryStorageConfig is the configuration of a MemoryStorage
+type MemoryStorageConfig struct {
+	// Number of shards the entries are split into, each with its own lock (default 32)
+	Shards int
+
+	// Maximum number of authorization codes, access tokens, refresh tokens and JWT IDs stored,
+	// 0 for unlimited (default 0). Limits are enforced per shard: when a shard is full,
+	// its oldest entry is evicted. An evicted JWT ID can be replayed until the JWT expires.
+	MaxAuthorize int
+	MaxAccess    int
+	MaxRefresh   int
+	MaxJTI       int
+
+	// Time refresh tokens are kept after they are issued, 0 to keep them until
+	// they are removed (default 0)
+	RefreshExpiration time.Duration
+
+	// Time the JWT IDs of JWTs without an expiration are kept, 0 to keep them until
+	// they are evicted (default 0)
+	JTIExpiration time.Duration
+
+	// Minimum interval between the removals of the expired entries of a shard,
+	// done when the shard is used (default 1 minute)
+	CleanupInterval time.Duration
+
+	// UserId returns the id of the user an authorization or token was issued for, from
+	// its UserData, to index them by user. By default UserData is used if it is a string.
+	UserId func(userData interface{}) string
+
+	// Now returns the current time (default time.Now)
+	Now func() time.Time
+}
+
+// NewMemoryStorageConfig returns a MemoryStorageConfig with the default values
+func NewMemoryStorageConfig() *MemoryStorageConfig {
+	return &MemoryStorageConfig{
+		Shards:          32,
+		CleanupInterval: time.Minute,
+		UserId: func(userData interface{}) string {
+			userId, _ := userData.(string)
+			return userId
+		},
+		Now: time.Now,
+	}
+}
+
+// MemoryStorageStats are the metrics of a MemoryStorage
+type MemoryStorageStats struct {
+	// Number of entries removed because they expired
+	Expired uint64
+
+	// Number of entries evicted to stay within the capacity limits
+	Evicted uint64
+
+	// Number of clients, authorization codes, access tokens, refresh tokens and JWT IDs stored
+	Clients   int
+	Authorize int
+	Access    int
+	Refresh   int
+	JTI       int
+}
+
+// MemoryStorage is a Storage keeping everything in memory, safe for concurrent use.
+// Authorization codes and tokens are removed when they expire, and indexed by client and user.
+// It also implements AuthorizeCodeStorage, RefreshTokenStorage and JTIStorage.
+type MemoryStorage struct {
+	// updated atomically, first for alignment
+	expired uint64
+	evicted uint64
+
+	config MemoryStorageConfig
+
+	clientsLock sync.RWMutex
+	clients     map[string]Client
+
+	shards []*memoryShard
+}
+
+// NewMemoryStorage creates a new in-memory storage
+func NewMemoryStorage(config *MemoryStorageConfig) *MemoryStorage {
+	s := &MemoryStorage{
+		config:  *config,
+		clients: make(map[string]Client),
+	}
+	if s.config.Shards <= 0 {
+		s.config.Shards = 1
+	}
+	if s.config.UserId == nil {
+		s.config.UserId = NewMemoryStorageConfig().UserId
+	}
+	if s.config.Now == nil {
+		s.config.Now = time.Now
+	}
+	for i := 0; i < s.config.Shards; i++ {
+		s.shards = append(s.shards, &memoryShard{
+			authorize: newMemoryTable(shardLimit(s.config.MaxAuthorize, s.config.Shards)),
+			access:    newMemoryTable(shardLimit(s.config.MaxAccess, s.config.Shards)),
+			refresh:   newMemoryTable(shardLimit(s.config.MaxRefresh, s.config.Shards)),
+			redeemed:  newMemoryTable(shardLimit(s.config.MaxAuthorize, s.config.Shards)),
+			rotated:   newMemoryTable(shardLimit(s.config.MaxRefresh, s.config.Shards)),
+			jti:       newMemoryTable(shardLimit(s.config.MaxJTI, s.config.Shards)),
+		})
+	}
+	return s
+}
+
+// Clone returns the storage itself, it can be shared by all responses
+func (s *MemoryStorage) Clone() Storage {
+	return s
+}
+
+// Close does nothing, the storage can be used after responses are closed
+func (s *MemoryStorage) Close() {
+}
+
+// GetClient loads the client by id
+func (s *MemoryStorage) GetClient(id string) (Client, error) {
+	s.clientsLock.RLock()
+	defer s.clientsLock.RUnlock()
+	if c, ok := s.clients[id]; ok {
+		return c, nil
+	}
+	return nil, ErrNotFound
+}
+
+// SetClient saves a client
+func (s *MemoryStorage) SetClient(id string, client Client) error {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	s.clients[id] = client
+	return nil
+}
+
+// RemoveClient deletes a client, its authorization codes and tokens
+func (s *MemoryStorage) RemoveClient(id string) error {
+	s.clientsLock.Lock()
+	delete(s.clients, id)
+	s.clientsLock.Unlock()
+	return s.RemoveClientTokens(id)
+}
+
+// SaveAuthorize saves authorize data, until it expires
+func (s *MemoryStorage) SaveAuthorize(data *AuthorizeData) error {
+	shard, _ := s.lock(data.Code)
+	defer shard.Unlock()
+	s.put(shard.authorize, &memoryEntry{
+		key:      data.Code,
+		value:    data,
+		expireAt: data.ExpireAt(),
+		clientId: clientIdOf(data.Client),
+		userId:   s.config.UserId(data.UserData),
+	})
+	return nil
+}
+
+// LoadAuthorize looks up AuthorizeData by a code. Returns ErrNotFound if expired.
+func (s *MemoryStorage) LoadAuthorize(code string) (*AuthorizeData, error) {
+	shard, now := s.lock(code)
+	defer shard.Unlock()
+	if e := s.get(shard.authorize, code, now); e != nil {
+		return e.value.(*AuthorizeData), nil
+	}
+	return nil, ErrNotFound
+}
+
+// RemoveAuthorize deletes the authorization code
+func (s *MemoryStorage) RemoveAuthorize(code string) error {
+	shard, _ := s.lock(code)
+	defer shard.Unlock()
+	shard.authorize.remove(code)
+	return nil
+}
+
+// SaveAccess saves access data until it expires, and its refresh token until
+// MemoryStorageConfig.RefreshExpiration
+func (s *MemoryStorage) SaveAccess(data *AccessData) error {
+	entry := &memoryEntry{
+		value:    data,
+		clientId: clientIdOf(data.Client),
+		userId:   s.config.UserId(data.UserData),
+		grantId:  data.GrantId,
+	}
+
+	shard, _ := s.lock(data.AccessToken)
+	access := *entry
+	access.key = data.AccessToken
+	access.expireAt = data.ExpireAt()
+	s.put(shard.access, &access)
+	shard.Unlock()
+
+	if data.RefreshToken != "" {
+		shard, _ = s.lock(data.RefreshToken)
+		refresh := *entry
+		refresh.key = data.RefreshToken
+		refresh.expireAt = s.refreshExpireAt(data.CreatedAt)
+		s.put(shard.refresh, &refresh)
+		shard.Unlock()
+	}
+	return nil
+}
+
+// LoadAccess retrieves access data by token. Returns ErrNotFound if expired.
+func (s *MemoryStorage) LoadAccess(token string) (*AccessData, error) {
+	shard, now := s.lock(token)
+	defer shard.Unlock()
+	if e := s.get(shard.access, token, now); e != nil {
+		return e.value.(*AccessData), nil
+	}
+	return nil, ErrNotFound
+}
+
+// RemoveAccess deletes an access token
+func (s *MemoryStorage) RemoveAccess(token string) error {
+	shard, _ := s.lock(token)
+	defer shard.Unlock()
+	shard.access.remove(token)
+	return nil
+}
+
+// LoadRefresh retrieves the access data of a refresh token. Returns ErrNotFound if expired.
+func (s *MemoryStorage) LoadRefresh(token string) (*AccessData, error) {
+	shard, now := s.lock(token)
+	defer shard.Unlock()
+	if e := s.get(shard.refresh, token, now); e != nil {
+		return e.value.(*AccessData), nil
+	}
+	return nil, ErrNotFound
+}
+
+// RemoveRefresh deletes a refresh token
+func (s *MemoryStorage) RemoveRefresh(token string) error {
+	shard, _ := s.lock(token)
+	defer shard.Unlock()
+	shard.refresh.remove(token)
+	return nil
+}
+
+// ConsumeAuthorize atomically deletes the authorization code and records its redemption
+func (s *MemoryStorage) ConsumeAuthorize(data *RedeemedAuthorizeData) error {
+	shard, now := s.lock(data.Code)
+	defer shard.Unlock()
+	if s.get(shard.redeemed, data.Code, now) != nil {
+		return ErrAuthorizeRedeemed
+	}
+	shard.authorize.remove(data.Code)
+	s.put(shard.redeemed, &memoryEntry{
+		key:      data.Code,
+		value:    data,
+		expireAt: data.ExpireAt,
+		clientId: data.ClientId,
+		grantId:  data.GrantId,
+	})
+	return nil
+}
+
+// LoadRedeemedAuthorize looks up the redemption of an authorization code
+func (s *MemoryStorage) LoadRedeemedAuthorize(code string) (*RedeemedAuthorizeData, error) {
+	shard, now := s.lock(code)
+	defer shard.Unlock()
+	if e := s.get(shard.redeemed, code, now); e != nil {
+		return e.value.(*RedeemedAuthorizeData), nil
+	}
+	return nil, ErrNotFound
+}
+
+// ConsumeRefresh atomically deletes a refresh token and records it as rotated until
+// MemoryStorageConfig.RefreshExpiration
+func (s *MemoryStorage) ConsumeRefresh(data *RotatedRefreshData) error {
+	shard, now := s.lock(data.RefreshToken)
+	defer shard.Unlock()
+	if s.get(shard.refresh, data.RefreshToken, now) == nil {
+		return ErrNotFound
+	}
+	shard.refresh.remove(data.RefreshToken)
+	entry := &memoryEntry{
+		key:      data.RefreshToken,
+		value:    data,
+		grantId:  data.GrantId,
+		expireAt: s.refreshExpireAt(data.RotatedAt),
+	}
+	if data.AccessData != nil {
+		entry.clientId = clientIdOf(data.AccessData.Client)
+		entry.userId = s.config.UserId(data.AccessData.UserData)
+	}
+	s.put(shard.rotated, entry)
+	return nil
+}
+
+// LoadRotatedRefresh looks up a rotated refresh token
+func (s *MemoryStorage) LoadRotatedRefresh(token string) (*RotatedRefreshData, error) {
+	shard, now := s.lock(token)
+	defer shard.Unlock()
+	if e := s.get(shard.rotated, token, now); e != nil {
+		return e.value.(*RotatedRefreshData), nil
+	}
+	return nil, ErrNotFound
+}
+
+// RemoveGrant deletes all the access and refresh tokens of a grant
+func (s *MemoryStorage) RemoveGrant(grantId string) error {
+	s.removeIndexed(indexByGrant, grantId, func(shard *memoryShard) []*memoryTable {
+		return []*memoryTable{shard.access, shard.refresh, shard.rotated}
+	})
+	return nil
+}
+
+// SaveJTI records a JWT ID until it expires
+func (s *MemoryStorage) SaveJTI(jti string, expireAt time.Time) error {
+	shard, now := s.lock(jti)
+	defer shard.Unlock()
+	if s.get(shard.jti, jti, now) != nil {
+		return ErrJTIReplayed
+	}
+	if expireAt.IsZero() && s.config.JTIExpiration > 0 {
+		expireAt = now.Add(s.config.JTIExpiration)
+	}
+	s.put(shard.jti, &memoryEntry{key: jti, expireAt: expireAt})
+	return nil
+}
+
+// AccessByClient returns the access tokens issued to a client
+func (s *MemoryStorage) AccessByClient(clientId string) []*AccessData {
+	return s.listAccess(indexByClient, clientId)
+}
+
+// AccessByUser returns the access tokens issued for a user, identified by MemoryStorageConfig.UserId
+func (s *MemoryStorage) AccessByUser(userId string) []*AccessData {
+	return s.listAccess(indexByUser, userId)
+}
+
+// RemoveClientTokens deletes all the authorization codes, access and refresh tokens of a client
+func (s *MemoryStorage) RemoveClientTokens(clientId string) error {
+	s.removeIndexed(indexByClient, clientId, (*memoryShard).tokenTables)
+	return nil
+}
+
+// RemoveUserTokens deletes all the authorization codes, access and refresh tokens of a user
+func (s *MemoryStorage) RemoveUserTokens(userId string) error {
+	s.removeIndexed(indexByUser, userId, (*memoryShard).tokenTables)
+	return nil
+}
+
+// RemoveExpired deletes all the expired entries now, instead of waiting for
+// the cleanup of their shard
+func (s *MemoryStorage) RemoveExpired() {
+	now := s.config.Now()
+	for _, shard := range s.shards {
+		shard.Lock()
+		s.sweep(shard, now)
+		shard.Unlock()
+	}
+}
+
+// Stats returns the metrics of the storage
+func (s *MemoryStorage) Stats() MemoryStorageStats {
+	s.clientsLock.RLock()
+	stats := MemoryStorageStats{
+		Expired: atomic.LoadUint64(&s.expired),
+		Evicted: atomic.LoadUint64(&s.evicted),
+		Clients: len(s.clients),
+	}
+	s.clientsLock.RUnlock()
+	for _, shard := range s.shards {
+		shard.Lock()
+		stats.Authorize += len(shard.authorize.entries)
+		stats.Access += len(shard.access.entries)
+		stats.Refresh += len(shard.refresh.entries)
+		stats.JTI += len(shard.jti.entries)
+		shard.Unlock()
+	}
+	return stats
+}
+
+// lock locks the shard of a key, removing its expired entries if the cleanup interval elapsed
+func (s *MemoryStorage) lock(key string) (*memoryShard, time.Time) {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	shard := s.shards[h.Sum32()%uint32(len(s.shards))]
+
+	shard.Lock()
+	now := s.config.Now()
+	if !now.Before(shard.nextCleanup) {
+		s.sweep(shard, now)
+		shard.nextCleanup = now.Add(s.config.CleanupInterval)
+	}
+	return shard, now
+}
+
+// get returns the entry of a key, removing it if expired. The shard must be locked.
+func (s *MemoryStorage) get(t *memoryTable, key string, now time.Time) *memoryEntry {
+	e, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	if e.isExpiredAt(now) {
+		t.remove(key)
+		atomic.AddUint64(&s.expired, 1)
+		return nil
+	}
+	return e
+}
+
+// put saves an entry, evicting the oldest entries of a full table. The shard must be locked.
+func (s *MemoryStorage) put(t *memoryTable, e *memoryEntry) {
+	t.remove(e.key)
+	for t.limit > 0 && len(t.entries) >= t.limit {
+		t.remove(t.order.Front().Value.(*memoryEntry).key)
+		atomic.AddUint64(&s.evicted, 1)
+	}
+	t.add(e)
+}
+
+// sweep removes the expired entries of a shard. The shard must be locked.
+func (s *MemoryStorage) sweep(shard *memoryShard, now time.Time) {
+	for _, t := range shard.tables() {
+		for key, e := range t.entries {
+			if e.isExpiredAt(now) {
+				t.remove(key)
+				atomic.AddUint64(&s.expired, 1)
+			}
+		}
+	}
+}
+
+// listAccess returns the unexpired access tokens of an index
+func (s *MemoryStorage) listAccess(index memoryIndex, id string) []*AccessData {
+	var ret []*AccessData
+	now := s.config.Now()
+	for _, shard := range s.shards {
+		shard.Lock()
+		for key := range shard.access.index(index)[id] {
+			if e := s.get(shard.access, key, now); e != nil {
+				ret = append(ret, e.value.(*AccessData))
+			}
+		}
+		shard.Unlock()
+	}
+	return ret
+}
+
+// removeIndexed removes the entries of an index from the tables of all shards
+func (s *MemoryStorage) removeIndexed(index memoryIndex, id string, tables func(*memoryShard) []*memoryTable) {
+	if id == "" {
+		return
+	}
+	for _, shard := range s.shards {
+		shard.Lock()
+		for _, t := range tables(shard) {
+			for key := range t.index(index)[id] {
+				t.remove(key)
+			}
+		}
+		shard.Unlock()
+	}
+}
+
+func (s *MemoryStorage) refreshExpireAt(t time.Time) time.Time {
+	if s.config.RefreshExpiration <= 0 {
+		return time.Time{}
+	}
+	return t.Add(s.config.RefreshExpiration)
+}
+
+// shardLimit splits a capacity limit between shards
+func shardLimit(limit, shards int) int {
+	if limit <= 0 {
+		return 0
+	}
+	return (limit + shards - 1) / shards
+}
+
+func clientIdOf(client Client) string {
+	if client == nil {
+		return ""
+	}
+	return client.GetId()
+}
+
+// memoryShard is a part of a MemoryStorage, with its own lock
+type memoryShard struct {
+	sync.Mutex
+	authorize   *memoryTable
+	access      *memoryTable
+	refresh     *memoryTable
+	redeemed    *memoryTable
+	rotated     *memoryTable
+	jti         *memoryTable
+	nextCleanup time.Time
+}
+
+func (s *memoryShard) tables() []*memoryTable {
+	return []*memoryTable{s.authorize, s.access, s.refresh, s.redeemed, s.rotated, s.jti}
+}
+
+// tokenTables returns the tables of the authorization codes and tokens
+func (s *memoryShard) tokenTables() []*memoryTable {
+	return []*memoryTable{s.authorize, s.access, s.refresh, s.rotated}
+}
+
+// memoryIndex is a secondary index of a memoryTable
+type memoryIndex int
+
+const (
+	indexByClient memoryIndex = iota
+	indexByUser
+	indexByGrant
+)
+
+// memoryEntry is a value of a memoryTable, with the keys it is indexed by
+type memoryEntry struct {
+	key      string
+	value    interface{}
+	expireAt time.Time
+	clientId string
+	userId   string
+	grantId  string
+
+	// position in the insertion order
+	elem *list.Element
+}
+
+func (e *memoryEntry) isExpiredAt(t time.Time) bool {
+	return !e.expireAt.IsZero() && e.expireAt.Before(t)
+}
+
+// memoryTable is a map of entries in insertion order, indexed by client, user and grant
+type memoryTable struct {
+	limit    int
+	entries  map[string]*memoryEntry
+	order    *list.List
+	byClient map[string]map[string]struct{}
+	byUser   map[string]map[string]struct{}
+	byGrant  map[string]map[string]struct{}
+}
+
+func newMemoryTable(limit int) *memoryTable {
+	return &memoryTable{
+		limit:    limit,
+		entries:  make(map[string]*memoryEntry),
+		order:    list.New(),
+		byClient: make(map[string]map[string]struct{}),
+		byUser:   make(map[string]map[string]struct{}),
+		byGrant:  make(map[string]map[string]struct{}),
+	}
+}
+
+func (t *memoryTable) index(index memoryIndex) map[string]map[string]struct{} {
+	switch index {
+	case indexByClient:
+		return t.byClient
+	case indexByUser:
+		return t.byUser
+	}
+	return t.byGrant
+}
+
+func (t *memoryTable) add(e *memoryEntry) {
+	e.elem = t.order.PushBack(e)
+	t.entries[e.key] = e
+	addIndex(t.byClient, e.clientId, e.key)
+	addIndex(t.byUser, e.userId, e.key)
+	addIndex(t.byGrant, e.grantId, e.key)
+}
+
+func (t *memoryTable) remove(key string) {
+	e, ok := t.entries[key]
+	if !ok {
+		return
+	}
+	t.order.Remove(e.elem)
+	delete(t.entries, key)
+	removeIndex(t.byClient, e.clientId, key)
+	removeIndex(t.byUser, e.userId, key)
+	removeIndex(t.byGrant, e.grantId, key)
+}
+
+func addIndex(index map[string]map[string]struct{}, id, key string) {
+	if id == "" {
+		return
+	}
+	keys, ok := index[id]
+	if !ok {
+		keys = make(map[string]struct{})
+		index[id] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func removeIndex(index map[string]map[string]struct{}, id, key string) {
+	if keys, ok := index[id]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(index, id)
+		}
+	}
+}
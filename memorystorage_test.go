@@ -0,0 +1,259 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestingMemoryStorage(now *time.Time) *MemoryStorage {
+	config := NewMemoryStorageConfig()
+	config.Now = func() time.Time { return *now }
+	storage := NewMemoryStorage(config)
+	storage.SetClient("1234", &DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"})
+	storage.SetClient("5678", &DefaultClient{Id: "5678", Secret: "eeff", RedirectUri: "http://localhost:14000/appauth"})
+	return storage
+}
+
+func accessTokens(data []*AccessData) []string {
+	var ret []string
+	for _, d := range data {
+		ret = append(ret, d.AccessToken)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func TestMemoryStorageServer(t *testing.T) {
+	now := time.Now()
+	storage := newTestingMemoryStorage(&now)
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN}
+	server := NewServer(sconfig, storage)
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.Now = func() time.Time { return now }
+	client, _ := storage.GetClient("1234")
+
+	if err := storage.SaveAuthorize(&AuthorizeData{
+		Client:      client,
+		Code:        "code",
+		ExpiresIn:   60,
+		CreatedAt:   now,
+		RedirectUri: "http://localhost:14000/appauth",
+		UserData:    "user",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := server.NewResponse()
+	req := newAuthorizationCodeRequest(t, "1234", "aabbccdd", "code")
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+	if got := accessTokens(storage.AccessByUser("user")); len(got) != 1 || got[0] != "1" {
+		t.Fatalf("Unexpected access tokens of the user %v", got)
+	}
+
+	for _, token := range []string{"r1", "r2"} {
+		resp = server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {token}}
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Fatalf("Error refreshing %s: %s: %v", token, resp.ErrorId, resp.InternalError)
+		}
+	}
+
+	// replaying the code revokes the tokens of the grant
+	resp = server.NewResponse()
+	req = newAuthorizationCodeRequest(t, "1234", "aabbccdd", "code")
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected error %q, got %q", E_INVALID_GRANT, resp.ErrorId)
+	}
+	if stats := storage.Stats(); stats.Access != 0 || stats.Refresh != 0 {
+		t.Errorf("Tokens of the grant should be revoked: %+v", stats)
+	}
+}
+
+func TestMemoryStorageExpiry(t *testing.T) {
+	now := time.Now()
+	storage := newTestingMemoryStorage(&now)
+	storage.config.RefreshExpiration = time.Hour
+	client, _ := storage.GetClient("1234")
+
+	storage.SaveAuthorize(&AuthorizeData{Client: client, Code: "code", ExpiresIn: 60, CreatedAt: now})
+	storage.SaveAccess(&AccessData{Client: client, AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 600, CreatedAt: now})
+	storage.SaveJTI("jti", now.Add(time.Minute))
+
+	testcases := []struct {
+		Elapsed         time.Duration
+		ExpectAuthorize bool
+		ExpectAccess    bool
+		ExpectRefresh   bool
+	}{
+		{Elapsed: 0, ExpectAuthorize: true, ExpectAccess: true, ExpectRefresh: true},
+		{Elapsed: 2 * time.Minute, ExpectAccess: true, ExpectRefresh: true},
+		{Elapsed: 20 * time.Minute, ExpectRefresh: true},
+		{Elapsed: 2 * time.Hour},
+	}
+
+	for _, test := range testcases {
+		now = time.Now().Add(test.Elapsed)
+		_, err := storage.LoadAuthorize("code")
+		if (err == nil) != test.ExpectAuthorize {
+			t.Errorf("%s: unexpected authorize error %v", test.Elapsed, err)
+		}
+		_, err = storage.LoadAccess("access")
+		if (err == nil) != test.ExpectAccess {
+			t.Errorf("%s: unexpected access error %v", test.Elapsed, err)
+		}
+		_, err = storage.LoadRefresh("refresh")
+		if (err == nil) != test.ExpectRefresh {
+			t.Errorf("%s: unexpected refresh error %v", test.Elapsed, err)
+		}
+	}
+
+	// the JWT ID is removed by the cleanup of its shard
+	storage.RemoveExpired()
+	if stats := storage.Stats(); stats.Expired != 4 || stats.Authorize != 0 || stats.Access != 0 || stats.Refresh != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if err := storage.SaveJTI("jti", now.Add(time.Minute)); err != nil {
+		t.Errorf("Expired JWT ID should be saved again: %v", err)
+	}
+	if err := storage.SaveJTI("jti", now.Add(time.Minute)); err != ErrJTIReplayed {
+		t.Errorf("Expected error %v, got %v", ErrJTIReplayed, err)
+	}
+}
+
+func TestMemoryStorageIndexes(t *testing.T) {
+	now := time.Now()
+	storage := newTestingMemoryStorage(&now)
+	client1, _ := storage.GetClient("1234")
+	client2, _ := storage.GetClient("5678")
+
+	save := func(token string, client Client, user string) {
+		storage.SaveAccess(&AccessData{Client: client, AccessToken: token, RefreshToken: "r" + token, ExpiresIn: 3600, CreatedAt: now, UserData: user})
+	}
+	save("a", client1, "alice")
+	save("b", client1, "bob")
+	save("c", client2, "alice")
+
+	if got := accessTokens(storage.AccessByClient("1234")); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("Unexpected access tokens of the client %v", got)
+	}
+	if got := accessTokens(storage.AccessByUser("alice")); len(got) != 2 || got[0] != "a" || got[1] != "c" {
+		t.Errorf("Unexpected access tokens of the user %v", got)
+	}
+
+	storage.RemoveUserTokens("alice")
+	if got := accessTokens(storage.AccessByClient("1234")); len(got) != 1 || got[0] != "b" {
+		t.Errorf("Unexpected access tokens of the client %v", got)
+	}
+	if _, err := storage.LoadRefresh("rc"); err != ErrNotFound {
+		t.Errorf("Refresh token of the user should be removed")
+	}
+
+	storage.RemoveClient("1234")
+	if stats := storage.Stats(); stats.Clients != 1 || stats.Access != 0 || stats.Refresh != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestMemoryStorageCapacity(t *testing.T) {
+	now := time.Now()
+	config := NewMemoryStorageConfig()
+	config.Shards = 1
+	config.MaxAccess = 2
+	config.Now = func() time.Time { return now }
+	storage := NewMemoryStorage(config)
+
+	for _, token := range []string{"1", "2", "3"} {
+		storage.SaveAccess(&AccessData{AccessToken: token, ExpiresIn: 3600, CreatedAt: now})
+	}
+	if _, err := storage.LoadAccess("1"); err != ErrNotFound {
+		t.Errorf("Oldest access token should be evicted")
+	}
+	if _, err := storage.LoadAccess("3"); err != nil {
+		t.Errorf("Newest access token should be kept: %v", err)
+	}
+	if stats := storage.Stats(); stats.Evicted != 1 || stats.Access != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestMemoryStorageJTICapacity(t *testing.T) {
+	now := time.Now()
+	config := NewMemoryStorageConfig()
+	config.Shards = 1
+	config.MaxJTI = 2
+	config.JTIExpiration = time.Minute
+	config.Now = func() time.Time { return now }
+	storage := NewMemoryStorage(config)
+
+	for _, jti := range []string{"1", "2", "3"} {
+		if err := storage.SaveJTI(jti, time.Time{}); err != nil {
+			t.Fatalf("Error saving jti %s: %v", jti, err)
+		}
+	}
+	if err := storage.SaveJTI("3", time.Time{}); err != ErrJTIReplayed {
+		t.Errorf("Newest jti should be kept, got %v", err)
+	}
+	if stats := storage.Stats(); stats.Evicted != 1 || stats.JTI != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if err := storage.SaveJTI("3", time.Time{}); err != nil {
+		t.Errorf("Jti without expiration should expire after JTIExpiration, got %v", err)
+	}
+}
+
+func TestMemoryStorageConcurrency(t *testing.T) {
+	storage := NewMemoryStorage(NewMemoryStorageConfig())
+	storage.SetClient("1234", &DefaultClient{Id: "1234"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client, _ := storage.GetClient("1234")
+			for j := 0; j < 100; j++ {
+				token := strconv.Itoa(i) + "-" + strconv.Itoa(j)
+				storage.SaveAccess(&AccessData{Client: client, AccessToken: token, RefreshToken: "r" + token, ExpiresIn: 3600, CreatedAt: time.Now()})
+				if _, err := storage.LoadRefresh("r" + token); err != nil {
+					t.Errorf("Error loading %s: %v", token, err)
+				}
+				storage.AccessByClient("1234")
+				storage.RemoveAccess(token)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if stats := storage.Stats(); stats.Access != 0 || stats.Refresh != 800 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,262 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// MetadataType is the kind of metadata document being served
+type MetadataType string
+
+const (
+	// OAUTH_METADATA is served at /.well-known/oauth-authorization-server (rfc8414)
+	OAUTH_METADATA MetadataType = "oauth-authorization-server"
+	// OPENID_METADATA is served at /.well-known/openid-configuration (OpenID Connect Discovery 1.0)
+	OPENID_METADATA MetadataType = "openid-configuration"
+)
+
+// ServerEndpoints contains the URLs the application serves each endpoint at,
+// to be advertised in the metadata document. Endpoints left blank are not advertised.
+// Relative URLs are resolved against ServerConfig.Issuer.
+type ServerEndpoints struct {
+	// Endpoint calling HandleAuthorizeRequest
+	Authorization string
+
+	// Endpoint calling HandleAccessRequest
+	Token string
+
+	// Endpoint calling HandleRevocationRequest
+	Revocation string
+
+	// Endpoint calling HandleIntrospectionRequest
+	Introspection string
+
+	// Endpoint calling HandleUserInfoRequest (OpenID Connect)
+	UserInfo string
+
+	// URL of the JSON Web Key Set document with the server signing keys
+	JwksUri string
+
+	// Endpoint calling HandleDeviceAuthorizationRequest
+	DeviceAuthorization string
+
+	// Endpoint calling HandlePushedAuthorizationRequest
+	PushedAuthorization string
+
+	// Endpoint calling HandleClientRegistrationRequest. The configuration endpoint of
+	// each client, calling HandleClientConfigurationRequest, is served at this URL
+	// followed by "/" and the client id.
+	Registration string
+
+	// Page where users enter the user code, calling HandleDeviceVerificationRequest.
+	// Returned as `verification_uri` to devices.
+	DeviceVerification string
+}
+
+// MetadataRequest is a request for the authorization server metadata document
+type MetadataRequest struct {
+	Type MetadataType
+
+	// Metadata generated from the server configuration. Add or change values
+	// (e.g. scopes_supported) before calling FinishMetadataRequest.
+	Metadata ResponseData
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleMetadataRequest is the http.HandlerFunc for serving the authorization
+// server metadata at /.well-known/oauth-authorization-server (https://tools.ietf.org/html/rfc8414)
+func (s *Server) HandleMetadataRequest(w *Response, r *http.Request) *MetadataRequest {
+	return s.handleMetadataRequest(w, r, OAUTH_METADATA)
+}
+
+// HandleOpenIDConfigurationRequest is the http.HandlerFunc for serving the OpenID Connect
+// provider metadata at /.well-known/openid-configuration
+// (https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata)
+func (s *Server) HandleOpenIDConfigurationRequest(w *Response, r *http.Request) *MetadataRequest {
+	return s.handleMetadataRequest(w, r, OPENID_METADATA)
+}
+
+func (s *Server) handleMetadataRequest(w *Response, r *http.Request, t MetadataType) *MetadataRequest {
+	// Only allow GET
+	if r.Method != "GET" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, errors.New("Request must be GET"), "metadata_request=%s", "request must be GET")
+		return nil
+	}
+
+	if s.Config.Issuer == "" {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Issuer is not configured"), "metadata_request=%s", "issuer is required")
+		return nil
+	}
+
+	metadata, err := s.serverMetadata(t)
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "metadata_request=%s", "error building metadata")
+		return nil
+	}
+
+	return &MetadataRequest{
+		Type:        t,
+		Metadata:    metadata,
+		HttpRequest: r,
+	}
+}
+
+// FinishMetadataRequest outputs the metadata document
+func (s *Server) FinishMetadataRequest(w *Response, r *http.Request, mr *MetadataRequest) {
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	for k, v := range mr.Metadata {
+		w.Output[k] = v
+	}
+}
+
+// serverMetadata builds the metadata document from the server configuration,
+// only advertising the features the Storage implements the optional interfaces of
+func (s *Server) serverMetadata(t MetadataType) (ResponseData, error) {
+	ret := ResponseData{
+		"issuer": s.Config.Issuer,
+	}
+
+	endpoints := []struct {
+		name string
+		uri  string
+	}{
+		{"authorization_endpoint", s.Config.Endpoints.Authorization},
+		{"token_endpoint", s.Config.Endpoints.Token},
+		{"revocation_endpoint", s.Config.Endpoints.Revocation},
+		{"introspection_endpoint", s.Config.Endpoints.Introspection},
+		{"userinfo_endpoint", s.Config.Endpoints.UserInfo},
+		{"jwks_uri", s.Config.Endpoints.JwksUri},
+		{"device_authorization_endpoint", s.Config.Endpoints.DeviceAuthorization},
+		{"pushed_authorization_request_endpoint", s.Config.Endpoints.PushedAuthorization},
+		{"registration_endpoint", s.Config.Endpoints.Registration},
+	}
+	for _, e := range endpoints {
+		if e.uri == "" {
+			continue
+		}
+		u, err := s.endpointUrl(e.uri)
+		if err != nil {
+			return nil, err
+		}
+		ret[e.name] = u
+	}
+
+	responseTypes := []string{}
+	responseModes := []string{}
+	codeChallengeMethods := []string{}
+	grantTypes := []string{}
+	for _, rt := range s.Config.AllowedAuthorizeTypes {
+		responseTypes = append(responseTypes, string(rt))
+		if rt.Has(CODE) {
+			codeChallengeMethods = []string{PKCE_PLAIN, PKCE_S256}
+		}
+		if ParseAuthorizeRequestType(string(rt)) == CODE {
+			responseModes = append(responseModes, "query")
+		} else if !containsString(responseModes, "fragment") {
+			responseModes = append(responseModes, "fragment")
+			grantTypes = append(grantTypes, "implicit")
+		}
+	}
+	for _, gt := range s.Config.AllowedAccessTypes {
+		if gt == IMPLICIT {
+			continue
+		}
+		grantTypes = append(grantTypes, string(gt))
+	}
+	responseModes = append(responseModes, string(FORM_POST))
+	if s.IDTokenSigner != nil {
+		// https://openid.net/specs/oauth-v2-jarm.html#section-4
+		jwtModes := []string{}
+		for _, m := range responseModes {
+			jwtModes = append(jwtModes, m+".jwt")
+		}
+		responseModes = append(append(responseModes, jwtModes...), string(JWT))
+		ret["authorization_signing_alg_values_supported"] = []string{s.IDTokenSigner.SigningAlgorithm()}
+	}
+	ret["response_types_supported"] = responseTypes
+	ret["authorization_response_iss_parameter_supported"] = true
+	ret["response_modes_supported"] = responseModes
+	ret["grant_types_supported"] = grantTypes
+	if len(codeChallengeMethods) > 0 {
+		ret["code_challenge_methods_supported"] = codeChallengeMethods
+	}
+
+	// JWTs are only accepted with the keys and storage to verify them
+	_, hasJTIStorage := getJTIStorage(s.Storage)
+	authMethods := []string{"client_secret_basic"}
+	if s.Config.AllowClientSecretInParams {
+		authMethods = append(authMethods, "client_secret_post")
+	}
+	if hasJTIStorage {
+		// client assertion replays are detected with the jti
+		authMethods = append(authMethods, "private_key_jwt", "client_secret_jwt")
+	}
+	if s.Config.AllowTLSClientCertificates {
+		authMethods = append(authMethods, "tls_client_auth", "self_signed_tls_client_auth")
+	}
+	endpointAuth := []string{"token_endpoint"}
+	if s.Config.Endpoints.Revocation != "" {
+		endpointAuth = append(endpointAuth, "revocation_endpoint")
+	}
+	if s.Config.Endpoints.Introspection != "" {
+		endpointAuth = append(endpointAuth, "introspection_endpoint")
+	}
+	for _, e := range endpointAuth {
+		ret[e+"_auth_methods_supported"] = authMethods
+		if hasJTIStorage {
+			ret[e+"_auth_signing_alg_values_supported"] = jwtSigningAlgorithms
+		}
+	}
+
+	if s.Config.AllowTLSClientCertificates {
+		ret["tls_client_certificate_bound_access_tokens"] = true
+	}
+	if hasJTIStorage {
+		// DPoP proofs are used once
+		ret["dpop_signing_alg_values_supported"] = dpopSigningAlgorithms
+	}
+	if s.Config.RequirePushedAuthorizationRequests {
+		ret["require_pushed_authorization_requests"] = true
+	}
+	if s.Config.Endpoints.Authorization != "" || s.Config.Endpoints.PushedAuthorization != "" {
+		ret["request_parameter_supported"] = true
+		ret["request_uri_parameter_supported"] = s.RequestObjectFetcher != nil
+		ret["request_object_signing_alg_values_supported"] = jwtSigningAlgorithms
+		if s.Config.RequireSignedRequestObject {
+			ret["require_signed_request_object"] = true
+		}
+	}
+
+	if t == OPENID_METADATA {
+		// required by https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata,
+		// applications signing ID tokens without an IDTokenSigner must add id_token_signing_alg_values_supported
+		ret["subject_types_supported"] = []string{"public"}
+		if s.IDTokenSigner != nil {
+			ret["id_token_signing_alg_values_supported"] = []string{s.IDTokenSigner.SigningAlgorithm()}
+			ret["userinfo_signing_alg_values_supported"] = []string{s.IDTokenSigner.SigningAlgorithm()}
+		}
+		ret["claims_parameter_supported"] = true
+	}
+
+	return ret, nil
+}
+
+// endpointUrl resolves an endpoint URL against the issuer
+func (s *Server) endpointUrl(uri string) (string, error) {
+	issuer, err := url.Parse(s.Config.Issuer)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", err
+	}
+	return issuer.ResolveReference(u).String(), nil
+}
@@ -0,0 +1,157 @@
+package osin
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestMetadata(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	sconfig.Endpoints = ServerEndpoints{
+		Authorization: "/authorize",
+		Token:         "https://auth.example.com/token",
+		Revocation:    "/revoke",
+	}
+	sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{CODE, TOKEN}
+	sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN}
+	sconfig.AllowClientSecretInParams = true
+	sconfig.AllowTLSClientCertificates = true
+	server := NewServer(sconfig, NewTestingStorage())
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "https://auth.example.com/.well-known/oauth-authorization-server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mr := server.HandleMetadataRequest(resp, req); mr != nil {
+		mr.Metadata["scopes_supported"] = []string{"read", "write"}
+		server.FinishMetadataRequest(resp, req, mr)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+
+	expected := ResponseData{
+		"issuer":                                                "https://auth.example.com",
+		"authorization_endpoint":                                "https://auth.example.com/authorize",
+		"token_endpoint":                                        "https://auth.example.com/token",
+		"revocation_endpoint":                                   "https://auth.example.com/revoke",
+		"response_types_supported":                              []string{"code", "token"},
+		"response_modes_supported":                              []string{"query", "fragment", "form_post"},
+		"grant_types_supported":                                 []string{"implicit", "authorization_code", "refresh_token"},
+		"code_challenge_methods_supported":                      []string{"plain", "S256"},
+		"authorization_response_iss_parameter_supported":        true,
+		"token_endpoint_auth_methods_supported":                 []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "client_secret_jwt", "tls_client_auth", "self_signed_tls_client_auth"},
+		"revocation_endpoint_auth_methods_supported":            []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "client_secret_jwt", "tls_client_auth", "self_signed_tls_client_auth"},
+		"token_endpoint_auth_signing_alg_values_supported":      jwtSigningAlgorithms,
+		"revocation_endpoint_auth_signing_alg_values_supported": jwtSigningAlgorithms,
+		"tls_client_certificate_bound_access_tokens":            true,
+		"dpop_signing_alg_values_supported":                     dpopSigningAlgorithms,
+		"request_parameter_supported":                           true,
+		"request_uri_parameter_supported":                       false,
+		"request_object_signing_alg_values_supported":           jwtSigningAlgorithms,
+		"scopes_supported":                                      []string{"read", "write"},
+	}
+	if !reflect.DeepEqual(resp.Output, expected) {
+		t.Fatalf("expected\n\t%v, got\n\t%v", expected, resp.Output)
+	}
+}
+
+func TestOpenIDConfiguration(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	sconfig.Endpoints.JwksUri = "/keys"
+	server := NewServer(sconfig, NewTestingStorage())
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "https://auth.example.com/.well-known/openid-configuration", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mr := server.HandleOpenIDConfigurationRequest(resp, req); mr != nil {
+		server.FinishMetadataRequest(resp, req, mr)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+	if d := resp.Output["jwks_uri"]; d != "https://auth.example.com/keys" {
+		t.Errorf("Unexpected jwks_uri: %v", d)
+	}
+	if d := resp.Output["subject_types_supported"]; !reflect.DeepEqual(d, []string{"public"}) {
+		t.Errorf("Unexpected subject_types_supported: %v", d)
+	}
+	if d := resp.Output["grant_types_supported"]; !reflect.DeepEqual(d, []string{"authorization_code"}) {
+		t.Errorf("Unexpected grant_types_supported: %v", d)
+	}
+	if d, ok := resp.Output["id_token_signing_alg_values_supported"]; ok {
+		t.Errorf("Unexpected id_token_signing_alg_values_supported without a signer: %v", d)
+	}
+
+	server.IDTokenSigner = &JWTIDTokenSigner{Key: newTestingKey(t, "k1")}
+	resp = server.NewResponse()
+	if mr := server.HandleOpenIDConfigurationRequest(resp, req); mr != nil {
+		server.FinishMetadataRequest(resp, req, mr)
+	}
+	if d := resp.Output["id_token_signing_alg_values_supported"]; !reflect.DeepEqual(d, []string{"ES256"}) {
+		t.Errorf("Unexpected id_token_signing_alg_values_supported: %v", d)
+	}
+}
+
+func TestMetadataMinimalStorage(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	sconfig.Endpoints.Token = "/token"
+	sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{}
+	sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+	server := NewServer(sconfig, &testingMinimalStorage{NewTestingStorage()})
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "https://auth.example.com/.well-known/oauth-authorization-server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mr := server.HandleMetadataRequest(resp, req); mr != nil {
+		server.FinishMetadataRequest(resp, req, mr)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+
+	expected := ResponseData{
+		"issuer":                   "https://auth.example.com",
+		"token_endpoint":           "https://auth.example.com/token",
+		"response_types_supported": []string{},
+		"response_modes_supported": []string{"form_post"},
+		"grant_types_supported":    []string{"client_credentials"},
+		"authorization_response_iss_parameter_supported": true,
+		"token_endpoint_auth_methods_supported":          []string{"client_secret_basic"},
+	}
+	if !reflect.DeepEqual(resp.Output, expected) {
+		t.Fatalf("expected\n\t%v, got\n\t%v", expected, resp.Output)
+	}
+}
+
+func TestMetadataRequiresIssuer(t *testing.T) {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "https://auth.example.com/.well-known/oauth-authorization-server", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mr := server.HandleMetadataRequest(resp, req); mr != nil {
+		t.Fatalf("Should not return a request without an issuer")
+	}
+	if !resp.IsError || resp.ErrorId != E_SERVER_ERROR {
+		t.Fatalf("Expected error %v, got %v", E_SERVER_ERROR, resp.ErrorId)
+	}
+}
@@ -0,0 +1,115 @@
+package osin
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"net/http"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// ClientCertificateMatcher is an optional interface clients can implement
+// which allows them to authenticate with a TLS client certificate
+// (https://tools.ietf.org/html/rfc8705#section-2)
+type ClientCertificateMatcher interface {
+	// ClientCertificateMatches returns true if the certificate authenticates the client.
+	// verified is true if the TLS server verified the certificate chain, which
+	// tls_client_auth requires, self_signed_tls_client_auth doesn't.
+	ClientCertificateMatches(cert *x509.Certificate, verified bool) bool
+}
+
+// TLSClientAuth is the registered certificate subject of a client using tls_client_auth
+// (https://tools.ietf.org/html/rfc8705#section-2.1.2). Only one value should be set.
+type TLSClientAuth struct {
+	SubjectDN string
+	SANDNS    string
+	SANURI    string
+	SANIP     string
+	SANEmail  string
+}
+
+// Matches returns true if the certificate has the registered subject
+func (t *TLSClientAuth) Matches(cert *x509.Certificate) bool {
+	switch {
+	case t.SubjectDN != "":
+		return cert.Subject.String() == t.SubjectDN
+	case t.SANDNS != "":
+		for _, name := range cert.DNSNames {
+			if name == t.SANDNS {
+				return true
+			}
+		}
+	case t.SANURI != "":
+		for _, uri := range cert.URIs {
+			if uri.String() == t.SANURI {
+				return true
+			}
+		}
+	case t.SANIP != "":
+		ip := net.ParseIP(t.SANIP)
+		for _, addr := range cert.IPAddresses {
+			if ip != nil && addr.Equal(ip) {
+				return true
+			}
+		}
+	case t.SANEmail != "":
+		for _, email := range cert.EmailAddresses {
+			if email == t.SANEmail {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CheckClientCertificate determines whether the TLS client certificate authenticates the client.
+// Clients must implement ClientCertificateMatcher.
+func CheckClientCertificate(client Client, cert *x509.Certificate, verified bool) bool {
+	matcher, ok := client.(ClientCertificateMatcher)
+	if !ok {
+		return false
+	}
+	return matcher.ClientCertificateMatches(cert, verified)
+}
+
+// CertificateThumbprint returns the `x5t#S256` thumbprint of the TLS client certificate
+// of the request, or "" if the client didn't send one (https://tools.ietf.org/html/rfc8705#section-3.1)
+func CertificateThumbprint(r *http.Request) string {
+	cert := peerCertificate(r)
+	if cert == nil {
+		return ""
+	}
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// CheckCertificateBinding returns true if the access token is not bound to a certificate,
+// or if the request is sent with the certificate it is bound to.
+// Resource servers should reject certificate-bound tokens for which it returns false.
+func CheckCertificateBinding(r *http.Request, data *AccessData) bool {
+	if data.CertificateThumbprint == "" {
+		return true
+	}
+	return data.CertificateThumbprint == CertificateThumbprint(r)
+}
+
+// peerCertificate returns the TLS client certificate of the request, or nil
+func peerCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return r.TLS.PeerCertificates[0]
+}
+
+// keyThumbprint returns the JWK thumbprint (rfc7638) of a public key, or "" if the key is not supported
+func keyThumbprint(key interface{}) string {
+	jwk := &jose.JsonWebKey{Key: publicKey(key)}
+	sum, err := jwk.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(sum)
+}
@@ -0,0 +1,211 @@
+package osin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+func newTestingCertificate(t *testing.T, commonName string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName + ".mesh.local"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newTLSRequest(t *testing.T, form url.Values, cert *x509.Certificate, verified bool) *http.Request {
+	req, err := http.NewRequest("POST", "https://auth.example.com/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = form
+	req.PostForm = make(url.Values)
+	req.TLS = &tls.ConnectionState{}
+	if cert != nil {
+		req.TLS.PeerCertificates = []*x509.Certificate{cert}
+		if verified {
+			req.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+	}
+	return req
+}
+
+func TestClientCertificateAuth(t *testing.T) {
+	cert, key := newTestingCertificate(t, "orders")
+	otherCert, _ := newTestingCertificate(t, "orders")
+
+	testcases := map[string]struct {
+		Client        *DefaultClient
+		Cert          *x509.Certificate
+		Verified      bool
+		NotAllowed    bool
+		ExpectedError string
+	}{
+		"tls_client_auth subject": {
+			Client:   &DefaultClient{TLSClientAuth: &TLSClientAuth{SubjectDN: "CN=orders"}},
+			Cert:     cert,
+			Verified: true,
+		},
+		"tls_client_auth dns": {
+			Client:   &DefaultClient{TLSClientAuth: &TLSClientAuth{SANDNS: "orders.mesh.local"}},
+			Cert:     cert,
+			Verified: true,
+		},
+		"tls_client_auth unverified": {
+			Client:        &DefaultClient{TLSClientAuth: &TLSClientAuth{SubjectDN: "CN=orders"}},
+			Cert:          cert,
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"tls_client_auth wrong subject": {
+			Client:        &DefaultClient{TLSClientAuth: &TLSClientAuth{SubjectDN: "CN=billing"}},
+			Cert:          cert,
+			Verified:      true,
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"self_signed_tls_client_auth": {
+			Client: &DefaultClient{JsonWebKeys: &jose.JsonWebKeySet{Keys: []jose.JsonWebKey{{Key: &key.PublicKey}}}},
+			Cert:   cert,
+		},
+		"self_signed_tls_client_auth unregistered": {
+			Client:        &DefaultClient{JsonWebKeys: &jose.JsonWebKeySet{Keys: []jose.JsonWebKey{{Key: &key.PublicKey}}}},
+			Cert:          otherCert,
+			ExpectedError: E_UNAUTHORIZED_CLIENT,
+		},
+		"certificates not allowed": {
+			Client:        &DefaultClient{TLSClientAuth: &TLSClientAuth{SubjectDN: "CN=orders"}},
+			Cert:          cert,
+			Verified:      true,
+			NotAllowed:    true,
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"no certificate": {
+			Client:        &DefaultClient{TLSClientAuth: &TLSClientAuth{SubjectDN: "CN=orders"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, test := range testcases {
+		test.Client.Id = "mesh-client"
+		test.Client.RedirectUri = "http://localhost:14000/appauth"
+
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{CLIENT_CREDENTIALS}
+		sconfig.AllowTLSClientCertificates = !test.NotAllowed
+		storage := NewTestingStorage()
+		storage.SetClient("mesh-client", test.Client)
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		resp := server.NewResponse()
+
+		req := newTLSRequest(t, url.Values{"grant_type": {string(CLIENT_CREDENTIALS)}, "client_id": {"mesh-client"}}, test.Cert, test.Verified)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError == "" {
+			ret, err := server.Storage.LoadAccess("1")
+			if err != nil {
+				t.Fatalf("%s: %v", k, err)
+			}
+			if ret.CertificateThumbprint == "" || ret.CertificateThumbprint != CertificateThumbprint(req) {
+				t.Errorf("%s: token is not bound to the certificate: %q", k, ret.CertificateThumbprint)
+			}
+		}
+	}
+}
+
+func TestCertificateBoundRefresh(t *testing.T) {
+	cert, _ := newTestingCertificate(t, "orders")
+	otherCert, _ := newTestingCertificate(t, "orders")
+
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	bound := &AccessData{
+		Client:                &DefaultClient{Id: "1234", RedirectUri: "http://localhost:14000/appauth"},
+		AccessToken:           "bound-token",
+		RefreshToken:          "bound-refresh",
+		ExpiresIn:             3600,
+		CreatedAt:             time.Now(),
+		CertificateThumbprint: CertificateThumbprint(newTLSRequest(t, nil, cert, false)),
+	}
+	server.Storage.SaveAccess(bound)
+
+	for i, test := range []struct {
+		Cert          *x509.Certificate
+		ExpectedError string
+	}{
+		{Cert: otherCert, ExpectedError: E_INVALID_GRANT},
+		{Cert: nil, ExpectedError: E_INVALID_GRANT},
+		{Cert: cert},
+	} {
+		resp := server.NewResponse()
+		req := newTLSRequest(t, url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"bound-refresh"}}, test.Cert, false)
+		req.SetBasicAuth("1234", "aabbccdd")
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Fatalf("request %d: expected error %q, got %q", i, test.ExpectedError, resp.ErrorId)
+		}
+	}
+}
+
+func TestCheckCertificateBinding(t *testing.T) {
+	cert, _ := newTestingCertificate(t, "orders")
+	otherCert, _ := newTestingCertificate(t, "orders")
+	data := &AccessData{CertificateThumbprint: CertificateThumbprint(newTLSRequest(t, nil, cert, false))}
+
+	if !CheckCertificateBinding(newTLSRequest(t, nil, cert, false), data) {
+		t.Errorf("Token should be usable with its certificate")
+	}
+	if CheckCertificateBinding(newTLSRequest(t, nil, otherCert, false), data) {
+		t.Errorf("Token should not be usable with another certificate")
+	}
+	if CheckCertificateBinding(newTLSRequest(t, nil, nil, false), data) {
+		t.Errorf("Token should not be usable without a certificate")
+	}
+	if !CheckCertificateBinding(newTLSRequest(t, nil, nil, false), &AccessData{}) {
+		t.Errorf("Unbound token should be usable without a certificate")
+	}
+	if d := confirmationClaim(data); d["x5t#S256"] != data.CertificateThumbprint {
+		t.Errorf("Unexpected cnf claim: %v", d)
+	}
+}
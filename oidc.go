@@ -0,0 +1,192 @@
+package osin
+
+import (
+	"crypto"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/square/go-jose.v1"
+)
+
+// OPENID_SCOPE is the scope requesting an ID token (OpenID Connect Core 1.0)
+const OPENID_SCOPE = "openid"
+
+// prompt values (https://openid.net/specs/openid-connect-core-1_0.html#AuthRequest)
+var promptValues = []string{"none", "login", "consent", "select_account"}
+
+// IDTokenSigner signs the ID tokens issued to OpenID Connect clients
+type IDTokenSigner interface {
+	// SigningAlgorithm returns the JWS alg of the ID tokens, which also selects
+	// the hash function of at_hash and c_hash
+	SigningAlgorithm() string
+
+	// SignIDToken returns the claims as a compact serialized JWS
+	SignIDToken(claims map[string]interface{}) (string, error)
+}
+
+// IDTokenClaimsProvider supplies the claims about the end-user of ID tokens
+type IDTokenClaimsProvider interface {
+	// GetIDTokenClaims returns the end-user claims of the ID token, which must include `sub`,
+	// and `auth_time` if max_age was requested. The claims set by the library
+	// (iss, aud, exp, iat, nonce, at_hash, c_hash) are overwritten.
+	GetIDTokenClaims(req *IDTokenRequest) (map[string]interface{}, error)
+}
+
+// IDTokenRequest describes an ID token being issued
+type IDTokenRequest struct {
+	Client Client
+
+	// Granted scope, including openid
+	Scope string
+
+	// Authorization the token is issued for, nil if not issued from an authorization (e.g. on refresh)
+	AuthorizeData *AuthorizeData
+
+	// Access token issued with the ID token, nil if none
+	AccessData *AccessData
+
+	// Data of the authorization. Not used by the library.
+	UserData interface{}
+}
+
+// JWTIDTokenSigner signs ID tokens with a private key
+type JWTIDTokenSigner struct {
+	// Private key, with its algorithm set. The key id is included in the token header.
+	Key *jose.JsonWebKey
+}
+
+// SigningAlgorithm returns the algorithm of the key
+func (s *JWTIDTokenSigner) SigningAlgorithm() string {
+	return s.Key.Algorithm
+}
+
+// SignIDToken signs the claims with the key
+func (s *JWTIDTokenSigner) SignIDToken(claims map[string]interface{}) (string, error) {
+	signer, err := jose.NewSigner(jose.SignatureAlgorithm(s.Key.Algorithm), s.Key)
+	if err != nil {
+		return "", err
+	}
+	signer.SetEmbedJwk(false)
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	jws, err := signer.Sign(payload)
+	if err != nil {
+		return "", err
+	}
+	return jws.CompactSerialize()
+}
+
+// generateIDToken generates a signed ID token
+// (https://openid.net/specs/openid-connect-core-1_0.html#IDToken).
+// at_hash and c_hash are included for the non empty accessToken and code.
+func (s *Server) generateIDToken(req *IDTokenRequest, accessToken, code string) (string, error) {
+	if s.IDTokenSigner == nil {
+		return "", errors.New("no IDTokenSigner")
+	}
+	if s.IDTokenClaims == nil {
+		return "", errors.New("no IDTokenClaims provider")
+	}
+	if s.Config.Issuer == "" {
+		return "", errors.New("issuer required to issue ID tokens")
+	}
+
+	claims, err := s.IDTokenClaims.GetIDTokenClaims(req)
+	if err != nil {
+		return "", err
+	}
+	if claims == nil {
+		claims = make(map[string]interface{})
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		return "", errors.New("ID token sub claim required")
+	}
+
+	now := s.Now()
+	claims["iss"] = s.Config.Issuer
+	claims["aud"] = req.Client.GetId()
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Duration(s.Config.IDTokenExpiration) * time.Second).Unix()
+	delete(claims, "nonce")
+	if req.AuthorizeData != nil {
+		if req.AuthorizeData.Nonce != "" {
+			claims["nonce"] = req.AuthorizeData.Nonce
+		}
+		// https://openid.net/specs/openid-connect-core-1_0.html#IDToken
+		if _, ok := claims["auth_time"]; !ok && req.AuthorizeData.MaxAge != "" {
+			return "", errors.New("ID token auth_time claim required with max_age")
+		}
+	}
+
+	alg := s.IDTokenSigner.SigningAlgorithm()
+	if accessToken != "" {
+		if claims["at_hash"], err = tokenHash(alg, accessToken); err != nil {
+			return "", err
+		}
+	}
+	if code != "" {
+		if claims["c_hash"], err = tokenHash(alg, code); err != nil {
+			return "", err
+		}
+	}
+
+	return s.IDTokenSigner.SignIDToken(claims)
+}
+
+// tokenHash returns the at_hash or c_hash of a token: the left half of its hash
+// with the hash function of the ID token alg, base64url encoded
+// (https://openid.net/specs/openid-connect-core-1_0.html#CodeIDToken)
+func tokenHash(alg, token string) (string, error) {
+	var h crypto.Hash
+	switch {
+	case strings.HasSuffix(alg, "256"):
+		h = crypto.SHA256
+	case strings.HasSuffix(alg, "384"):
+		h = crypto.SHA384
+	case strings.HasSuffix(alg, "512"):
+		h = crypto.SHA512
+	default:
+		return "", fmt.Errorf("no hash function for ID token alg %q", alg)
+	}
+	hash := h.New()
+	hash.Write([]byte(token))
+	sum := hash.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
+
+// hasScope returns true if the space separated scope list includes scope
+func hasScope(scopes, scope string) bool {
+	return containsString(strings.Fields(scopes), scope)
+}
+
+// validateOpenIDParams validates the OpenID Connect parameters of an authorization request
+func validateOpenIDParams(ar *AuthorizeRequest) error {
+	if ar.MaxAge != "" {
+		if _, err := strconv.ParseUint(ar.MaxAge, 10, 31); err != nil {
+			return fmt.Errorf("invalid max_age %q", ar.MaxAge)
+		}
+	}
+	prompt := strings.Fields(ar.Prompt)
+	for _, p := range prompt {
+		if !containsString(promptValues, p) {
+			return fmt.Errorf("invalid prompt %q", p)
+		}
+	}
+	if containsString(prompt, "none") && len(prompt) > 1 {
+		return errors.New("prompt none can't be used with other values")
+	}
+	if ar.Claims != "" {
+		if _, err := parseClaimsRequest(ar.Claims); err != nil {
+			return fmt.Errorf("invalid claims: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,311 @@
+package osin
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+type testingIDTokenClaims struct{}
+
+func (testingIDTokenClaims) GetIDTokenClaims(req *IDTokenRequest) (map[string]interface{}, error) {
+	ret := map[string]interface{}{"sub": "user", "nonce": "ignored"}
+	if req.AuthorizeData != nil && req.AuthorizeData.MaxAge != "" {
+		ret["auth_time"] = 1
+	}
+	return ret, nil
+}
+
+func TestAuthorizeOpenIDParams(t *testing.T) {
+	testcases := map[string]struct {
+		Form          url.Values
+		ExpectedError string
+	}{
+		"valid": {
+			Form: url.Values{"nonce": {"n"}, "max_age": {"300"}, "prompt": {"login consent"}, "acr_values": {"urn:mace:incommon:iap:silver"}},
+		},
+		"prompt none": {
+			Form: url.Values{"prompt": {"none"}},
+		},
+		"invalid max_age": {
+			Form:          url.Values{"max_age": {"-1"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"invalid prompt": {
+			Form:          url.Values{"prompt": {"always"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"prompt none with others": {
+			Form:          url.Values{"prompt": {"none login"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, test := range testcases {
+		storage := NewTestingStorage()
+		server := NewServer(NewServerConfig(), storage)
+		server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = test.Form
+		req.Form.Set("response_type", string(CODE))
+		req.Form.Set("client_id", "1234")
+		req.Form.Set("scope", "openid")
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			continue
+		}
+
+		data := storage.authorize["1"]
+		if data == nil {
+			t.Errorf("%s: authorization not saved", k)
+			continue
+		}
+		if data.Nonce != test.Form.Get("nonce") || data.MaxAge != test.Form.Get("max_age") ||
+			data.Prompt != test.Form.Get("prompt") || data.AcrValues != test.Form.Get("acr_values") {
+			t.Errorf("%s: unexpected authorization data: %+v", k, data)
+		}
+	}
+}
+
+func TestIDToken(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN}
+	storage := NewTestingStorage()
+	storage.SaveAuthorize(&AuthorizeData{
+		Client:      storage.clients["1234"],
+		Code:        "oidc",
+		ExpiresIn:   3600,
+		CreatedAt:   time.Now(),
+		RedirectUri: "http://localhost:14000/appauth",
+		Scope:       "openid profile",
+		Nonce:       "n-0S6_WzA2Mj",
+		MaxAge:      "300",
+	})
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.IDTokenSigner = &JWTIDTokenSigner{Key: key}
+	server.IDTokenClaims = testingIDTokenClaims{}
+
+	token := func(form url.Values) *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = form
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+		}
+		return resp
+	}
+	verify := func(resp *Response) *JWTClaims {
+		idToken, _ := resp.Output["id_token"].(string)
+		if idToken == "" {
+			t.Fatalf("Expected an id_token: %v", resp.Output)
+		}
+		claims, err := parseJWT(idToken, publicKeySet(key).Keys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if claims.Issuer != "https://auth.example.com" || claims.Subject != "user" || !claims.HasAudience("1234") {
+			t.Errorf("Unexpected claims: %+v", claims)
+		}
+		if claims.ExpiresAt.Sub(claims.IssuedAt).Seconds() != 3600 {
+			t.Errorf("Unexpected expiration: %v", claims.ExpiresAt)
+		}
+		sum := sha256.Sum256([]byte(resp.Output["access_token"].(string)))
+		if atHash, _ := stringClaim(claims.Claims, "at_hash"); atHash != base64.RawURLEncoding.EncodeToString(sum[:16]) {
+			t.Errorf("Unexpected at_hash: %q", atHash)
+		}
+		return claims
+	}
+
+	resp := token(url.Values{"grant_type": {string(AUTHORIZATION_CODE)}, "code": {"oidc"}})
+	claims := verify(resp)
+	if nonce, _ := stringClaim(claims.Claims, "nonce"); nonce != "n-0S6_WzA2Mj" {
+		t.Errorf("Unexpected nonce: %q", nonce)
+	}
+	if _, ok := claims.Claims["auth_time"]; !ok {
+		t.Errorf("Expected auth_time: %v", claims.Claims)
+	}
+
+	// refreshed ID tokens are not bound to the authorization request
+	resp = token(url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {resp.Output["refresh_token"].(string)}})
+	claims = verify(resp)
+	if _, ok := claims.Claims["nonce"]; ok {
+		t.Errorf("Unexpected nonce: %v", claims.Claims)
+	}
+
+	// no ID token without the openid scope
+	resp = token(url.Values{"grant_type": {string(AUTHORIZATION_CODE)}, "code": {"9999"}})
+	if _, ok := resp.Output["id_token"]; ok {
+		t.Errorf("Unexpected id_token: %v", resp.Output)
+	}
+}
+
+func TestTokenHash(t *testing.T) {
+	// https://openid.net/specs/openid-connect-core-1_0.html#code-id_tokenExample
+	if h, err := tokenHash("RS256", "Qcb0Orv1zh30vL1MPRsbm-diHiMwcLyZvn1arpZv-Jxf_11jnpEX3Tgfvk"); err != nil || h != "LDktKdoQak3Pk0cnXxCltA" {
+		t.Errorf("Unexpected c_hash %q: %v", h, err)
+	}
+	if _, err := tokenHash("none", "x"); err == nil {
+		t.Errorf("Expected an error for alg none")
+	}
+}
+
+func TestAuthorizeHybrid(t *testing.T) {
+	key := newTestingKey(t, "k1")
+
+	testcases := map[string]struct {
+		ResponseType  string
+		Scope         string
+		Nonce         string
+		ExpectedError string
+		ExpectedOut   []string
+	}{
+		"id_token": {
+			ResponseType: "id_token",
+			ExpectedOut:  []string{"id_token", "state"},
+		},
+		"id_token token": {
+			ResponseType: "token id_token",
+			ExpectedOut:  []string{"id_token", "access_token", "token_type", "expires_in", "scope", "state"},
+		},
+		"code id_token": {
+			ResponseType: "code id_token",
+			ExpectedOut:  []string{"code", "id_token", "state"},
+		},
+		"code token": {
+			ResponseType: "code token",
+			ExpectedOut:  []string{"code", "access_token", "token_type", "expires_in", "scope", "state"},
+		},
+		"code id_token token": {
+			ResponseType: "code id_token token",
+			ExpectedOut:  []string{"code", "id_token", "access_token", "token_type", "expires_in", "scope", "state"},
+		},
+		"missing nonce": {
+			ResponseType:  "code id_token",
+			Nonce:         "-",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"missing openid scope": {
+			ResponseType:  "id_token",
+			Scope:         "read",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"not allowed": {
+			ResponseType:  "token code id_token code",
+			ExpectedError: E_UNSUPPORTED_RESPONSE_TYPE,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.Issuer = "https://auth.example.com"
+		sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{ID_TOKEN, ID_TOKEN_TOKEN, CODE_ID_TOKEN, CODE_TOKEN, CODE_ID_TOKEN_TOKEN}
+		storage := NewTestingStorage()
+		server := NewServer(sconfig, storage)
+		server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		server.IDTokenSigner = &JWTIDTokenSigner{Key: key}
+		server.IDTokenClaims = testingIDTokenClaims{}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scope, nonce := test.Scope, test.Nonce
+		if scope == "" {
+			scope = "openid"
+		}
+		if nonce == "" {
+			nonce = "n-0S6_WzA2Mj"
+		}
+		req.Form = url.Values{
+			"response_type": {test.ResponseType},
+			"client_id":     {"1234"},
+			"scope":         {scope},
+			"state":         {"a"},
+		}
+		if nonce != "-" {
+			req.Form.Set("nonce", nonce)
+		}
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			continue
+		}
+
+		if resp.Type != REDIRECT || !resp.RedirectInFragment {
+			t.Errorf("%s: response should be a redirect with fragment", k)
+		}
+		if len(resp.Output) != len(test.ExpectedOut) {
+			t.Errorf("%s: expected %v, got %v", k, test.ExpectedOut, resp.Output)
+		}
+		for _, name := range test.ExpectedOut {
+			if _, ok := resp.Output[name]; !ok {
+				t.Errorf("%s: expected %s in %v", k, name, resp.Output)
+			}
+		}
+
+		// the code is saved with the nonce to issue the ID token of the token response
+		if code, ok := resp.Output["code"].(string); ok && storage.authorize[code].Nonce != nonce {
+			t.Errorf("%s: nonce not saved with the code", k)
+		}
+
+		idToken, ok := resp.Output["id_token"].(string)
+		if !ok {
+			continue
+		}
+		claims, err := parseJWT(idToken, publicKeySet(key).Keys)
+		if err != nil {
+			t.Errorf("%s: %v", k, err)
+			continue
+		}
+		if n, _ := stringClaim(claims.Claims, "nonce"); n != nonce {
+			t.Errorf("%s: unexpected nonce %q", k, n)
+		}
+		for claim, output := range map[string]string{"c_hash": "code", "at_hash": "access_token"} {
+			value, _ := resp.Output[output].(string)
+			expected := ""
+			if value != "" {
+				expected, _ = tokenHash("ES256", value)
+			}
+			if h, _ := stringClaim(claims.Claims, claim); h != expected {
+				t.Errorf("%s: expected %s %q, got %q", k, claim, expected, h)
+			}
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package osin
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// REQUEST_URI_PREFIX is the prefix of the request_uri of pushed authorization requests
+const REQUEST_URI_PREFIX = "urn:ietf:params:oauth:request_uri:"
+
+// PushedAuthorizeStorage is an optional interface Storage must implement to support
+// pushed authorization requests (https://www.rfc-editor.org/rfc/rfc9126)
+type PushedAuthorizeStorage interface {
+	// SavePushedAuthorize saves the parameters of a pushed authorization request
+	SavePushedAuthorize(*PushedAuthorizeData) error
+
+	// LoadPushedAuthorize looks up PushedAuthorizeData by request_uri, without deleting it.
+	// Client information MUST be loaded together.
+	// Returns ErrNotFound if the request_uri was consumed, optionally if expired.
+	LoadPushedAuthorize(requestUri string) (*PushedAuthorizeData, error)
+
+	// ConsumePushedAuthorize atomically loads and deletes PushedAuthorizeData by request_uri,
+	// so that it is used at most once by concurrent authorization requests.
+	// Client information MUST be loaded together.
+	// Returns ErrNotFound if the request_uri was already consumed.
+	ConsumePushedAuthorize(requestUri string) (*PushedAuthorizeData, error)
+}
+
+// ContextPushedAuthorizeStorage is the context-aware version of PushedAuthorizeStorage,
+// implemented by a ContextStorage to support pushed authorization requests
+type ContextPushedAuthorizeStorage interface {
+	SavePushedAuthorize(ctx context.Context, data *PushedAuthorizeData) error
+	LoadPushedAuthorize(ctx context.Context, requestUri string) (*PushedAuthorizeData, error)
+	ConsumePushedAuthorize(ctx context.Context, requestUri string) (*PushedAuthorizeData, error)
+}
+
+// PushedAuthorizationClient is an optional interface clients can implement to
+// only be allowed to make pushed authorization requests
+// (require_pushed_authorization_requests client metadata, rfc9126)
+type PushedAuthorizationClient interface {
+	RequirePushedAuthorizationRequests() bool
+}
+
+// PushedAuthorizeData is a stored pushed authorization request
+type PushedAuthorizeData struct {
+	// Client information
+	Client Client
+
+	// request_uri referencing the request
+	RequestUri string
+
+	// Authorization request parameters
+	Params url.Values
+
+	// Expiration in seconds
+	ExpiresIn int32
+
+	// Date created
+	CreatedAt time.Time
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+}
+
+// IsExpiredAt returns true if the request expires at time 't'
+func (d *PushedAuthorizeData) IsExpiredAt(t time.Time) bool {
+	return d.ExpireAt().Before(t)
+}
+
+// ExpireAt returns the expiration date
+func (d *PushedAuthorizeData) ExpireAt() time.Time {
+	return d.CreatedAt.Add(time.Duration(d.ExpiresIn) * time.Second)
+}
+
+// PushedAuthorizationRequest is a request to push the parameters of an authorization request
+type PushedAuthorizationRequest struct {
+	Client Client
+
+	// The validated authorization request
+	AuthorizeRequest *AuthorizeRequest
+
+	// Authorization request parameters to store
+	Params url.Values
+
+	// Expiration of the request_uri in seconds. Change if different from default.
+	Expiration int32
+
+	// Set if request is authorized
+	Authorized bool
+
+	// Data to be passed to storage, and to the AuthorizeRequest using the request_uri.
+	// Not used by the library.
+	UserData interface{}
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+
+	// claims of the request object (rfc9101), if any
+	requestObject *JWTClaims
+}
+
+// HandlePushedAuthorizationRequest is the http.HandlerFunc for the pushed authorization
+// request endpoint (https://www.rfc-editor.org/rfc/rfc9126#section-2).
+// The client is authenticated as on the token endpoint, and the parameters are
+// validated as by HandleAuthorizeRequest.
+func (s *Server) HandlePushedAuthorizationRequest(w *Response, r *http.Request) *PushedAuthorizationRequest {
+	bindContext(w, r)
+
+	// Only allow POST
+	if r.Method != "POST" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, errors.New("Request must be POST"), "pushed_authorization_request=%s", "request must be POST")
+		return nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "pushed_authorization_request=%s", "parsing error")
+		return nil
+	}
+
+	// get client authentication
+	auth := s.getClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+
+	ret := &PushedAuthorizationRequest{
+		Params:      make(url.Values),
+		Expiration:  s.Config.PushedAuthorizationExpiration,
+		HttpRequest: r,
+	}
+
+	// must have a valid client
+	if ret.Client = s.getClient(auth, w.Storage, w); ret.Client == nil {
+		return nil
+	}
+
+	// request_uri can't be pushed
+	// https://www.rfc-editor.org/rfc/rfc9126#section-2.1
+	if r.FormValue("request_uri") != "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "pushed_authorization_request=%s", "request_uri not allowed")
+		return nil
+	}
+	if clientId := r.FormValue("client_id"); clientId != "" && clientId != ret.Client.GetId() {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "pushed_authorization_request=%s", "client_id does not match the authenticated client")
+		return nil
+	}
+
+	// store the authorization parameters, without the client credentials
+	for k, v := range r.Form {
+		switch k {
+		case "client_secret", "client_assertion", "client_assertion_type":
+		default:
+			ret.Params[k] = v
+		}
+	}
+	ret.Params.Set("client_id", ret.Client.GetId())
+
+	// store the parameters of the request object, it can't be passed by reference
+	// https://www.rfc-editor.org/rfc/rfc9126#section-3
+	if ret.Params.Get("request") != "" {
+		if ret.Params, ret.requestObject = s.verifyRequestObject(w, ret.Params); ret.Params == nil {
+			return nil
+		}
+	} else if s.requireSignedRequestObject(ret.Client) {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "pushed_authorization_request=%s", "signed request object required")
+		return nil
+	}
+
+	// validate the parameters as the authorization endpoint, errors are not redirected
+	ret.AuthorizeRequest = s.handleAuthorizeParams(w, r, ret.Params)
+	w.Type = DATA
+	w.URL = ""
+	if ret.AuthorizeRequest == nil {
+		return nil
+	}
+
+	return ret
+}
+
+// FinishPushedAuthorizationRequest stores the authorization request parameters and
+// outputs the request_uri to use them with
+func (s *Server) FinishPushedAuthorizationRequest(w *Response, r *http.Request, pr *PushedAuthorizationRequest) {
+	bindContext(w, r)
+
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	if !pr.Authorized {
+		s.setErrorAndLog(w, E_ACCESS_DENIED, nil, "pushed_authorization_request=%s", "authorization failed")
+		return
+	}
+
+	storage, ok := getPushedAuthorizeStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement PushedAuthorizeStorage"), "pushed_authorization_request=%s", "pushed authorization requests not supported")
+		return
+	}
+
+	// the request object is only used through the request_uri
+	if err := saveRequestObjectJTI(w.Storage, pr.requestObject); err != nil {
+		s.setErrorAndLog(w, requestObjectErrorId(err), err, "pushed_authorization_request=%s", "error saving request object jti")
+		return
+	}
+
+	ret := &PushedAuthorizeData{
+		Client:     pr.Client,
+		RequestUri: REQUEST_URI_PREFIX + base64.RawURLEncoding.EncodeToString([]byte(uuid.NewRandom())),
+		Params:     pr.Params,
+		ExpiresIn:  pr.Expiration,
+		CreatedAt:  s.Now(),
+		UserData:   pr.UserData,
+	}
+	if err := storage.SavePushedAuthorize(ret); err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "pushed_authorization_request=%s", "error saving request")
+		return
+	}
+
+	// https://www.rfc-editor.org/rfc/rfc9126#section-2.2
+	w.StatusCode = http.StatusCreated
+	w.Output["request_uri"] = ret.RequestUri
+	w.Output["expires_in"] = ret.ExpiresIn
+}
+
+// loadPushedAuthorize loads the pushed authorization request referenced by the
+// request_uri of an authorization request, which must be of the same client.
+// The request_uri is only consumed by FinishAuthorizeRequest, so that the authorization
+// request can be handled again, e.g. when the login page is submitted.
+// Sets an error on the response if the request_uri is invalid.
+func (s *Server) loadPushedAuthorize(w *Response, clientId, requestUri string) *PushedAuthorizeData {
+	storage, ok := getPushedAuthorizeStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement PushedAuthorizeStorage"), "authorize_request=%s", "pushed authorization requests not supported")
+		return nil
+	}
+	if clientId == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "authorize_request=%s", "client_id required with request_uri")
+		return nil
+	}
+
+	ret, err := storage.LoadPushedAuthorize(requestUri)
+	if errors.Is(err, ErrNotFound) || (err == nil && ret == nil) {
+		s.setErrorAndLog(w, E_INVALID_REQUEST_URI, nil, "authorize_request=%s", "request_uri not found")
+		return nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "authorize_request=%s", "error loading pushed authorization request")
+		return nil
+	}
+	if ret.IsExpiredAt(s.Now()) {
+		s.setErrorAndLog(w, E_INVALID_REQUEST_URI, nil, "authorize_request=%s", "request_uri expired")
+		return nil
+	}
+	if !s.pushedByClient(ret, clientId) {
+		s.setErrorAndLog(w, E_INVALID_REQUEST_URI, nil, "authorize_request=%s, client_id=%s", "request_uri was pushed by another client", clientId)
+		return nil
+	}
+	return ret
+}
+
+// consumePushedAuthorize consumes the pushed authorization request of an authorized
+// request, so that its request_uri is used at most once.
+// Sets an error on the response if it was already consumed.
+func (s *Server) consumePushedAuthorize(w *Response, ar *AuthorizeRequest) bool {
+	storage, ok := getPushedAuthorizeStorage(w.Storage)
+	if !ok {
+		w.SetErrorState(E_SERVER_ERROR, "", ar.State)
+		w.InternalError = errors.New("Storage does not implement PushedAuthorizeStorage")
+		return false
+	}
+
+	ret, err := storage.ConsumePushedAuthorize(ar.RequestUri)
+	if errors.Is(err, ErrNotFound) || (err == nil && ret == nil) {
+		w.SetErrorState(E_INVALID_REQUEST_URI, "", ar.State)
+		w.InternalError = errors.New("request_uri was already used")
+		return false
+	}
+	if err != nil {
+		w.SetErrorState(storageErrorId(E_SERVER_ERROR, err), "", ar.State)
+		w.InternalError = err
+		return false
+	}
+	if !s.pushedByClient(ret, ar.Client.GetId()) {
+		w.SetErrorState(E_INVALID_REQUEST_URI, "", ar.State)
+		w.InternalError = errors.New("request_uri was pushed by another client")
+		return false
+	}
+	return true
+}
+
+// pushedByClient returns true if the pushed authorization request was pushed by the client
+func (s *Server) pushedByClient(d *PushedAuthorizeData, clientId string) bool {
+	return d.Client != nil && d.Client.GetId() == clientId && d.Params.Get("client_id") == clientId
+}
+
+// requirePushedAuthorization returns true if the client must use pushed authorization requests
+func (s *Server) requirePushedAuthorization(client Client) bool {
+	if s.Config.RequirePushedAuthorizationRequests {
+		return true
+	}
+	if c, ok := client.(PushedAuthorizationClient); ok {
+		return c.RequirePushedAuthorizationRequests()
+	}
+	return false
+}
@@ -0,0 +1,271 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newPushedAuthorizationRequest(t *testing.T, form url.Values) *http.Request {
+	req, err := http.NewRequest("POST", "http://localhost:14000/par", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = form
+	req.PostForm = make(url.Values)
+	return req
+}
+
+func TestPushedAuthorization(t *testing.T) {
+	sconfig := NewServerConfig()
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+
+	// push the authorization request
+	resp := server.NewResponse()
+	req := newPushedAuthorizationRequest(t, url.Values{
+		"response_type": {string(CODE)},
+		"redirect_uri":  {"http://localhost:14000/appauth"},
+		"scope":         {"read"},
+		"state":         {"a"},
+	})
+	if pr := server.HandlePushedAuthorizationRequest(resp, req); pr != nil {
+		if pr.AuthorizeRequest == nil || pr.AuthorizeRequest.Scope != "read" {
+			t.Fatalf("Unexpected authorize request: %+v", pr.AuthorizeRequest)
+		}
+		pr.Authorized = true
+		server.FinishPushedAuthorizationRequest(resp, req, pr)
+	}
+	if resp.IsError {
+		t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+	if resp.Type != DATA || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Unexpected response: type %v, status %d", resp.Type, resp.StatusCode)
+	}
+	requestUri, _ := resp.Output["request_uri"].(string)
+	if requestUri == "" || resp.Output["expires_in"] != int32(60) {
+		t.Fatalf("Unexpected output: %v", resp.Output)
+	}
+
+	// use it, parameters in the url are ignored
+	authorize := func(method string, authorized bool) *Response {
+		resp := server.NewResponse()
+		req, err := http.NewRequest(method, "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{"client_id": {"1234"}, "request_uri": {requestUri}, "scope": {"write"}}
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			if ar.Scope != "read" || ar.State != "a" || ar.RequestUri != requestUri {
+				t.Fatalf("Unexpected authorize request: %+v", ar)
+			}
+			if authorized {
+				ar.Authorized = true
+				server.FinishAuthorizeRequest(resp, req, ar)
+			}
+		}
+		return resp
+	}
+
+	// the login page is displayed, then submitted with the same request_uri
+	if resp = authorize("GET", false); resp.IsError {
+		t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+	resp = authorize("POST", true)
+	if resp.IsError {
+		t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+	if d := resp.Output["code"]; d != "1" {
+		t.Fatalf("Unexpected authorization code: %v", d)
+	}
+
+	// the request_uri can only be used once
+	if resp = authorize("POST", true); resp.ErrorId != E_INVALID_REQUEST_URI {
+		t.Fatalf("Expected error %q, got %q", E_INVALID_REQUEST_URI, resp.ErrorId)
+	}
+}
+
+func TestPushedAuthorizationInvalid(t *testing.T) {
+	testcases := map[string]struct {
+		Form          url.Values
+		ExpectedError string
+	}{
+		"invalid redirect uri": {
+			Form:          url.Values{"response_type": {string(CODE)}, "redirect_uri": {"http://evil.example.com"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"unsupported response type": {
+			Form:          url.Values{"response_type": {string(TOKEN)}},
+			ExpectedError: E_UNSUPPORTED_RESPONSE_TYPE,
+		},
+		"request_uri": {
+			Form:          url.Values{"response_type": {string(CODE)}, "request_uri": {REQUEST_URI_PREFIX + "x"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"other client_id": {
+			Form:          url.Values{"response_type": {string(CODE)}, "client_id": {"public-client"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, test := range testcases {
+		server := NewServer(NewServerConfig(), NewTestingStorage())
+		resp := server.NewResponse()
+		req := newPushedAuthorizationRequest(t, test.Form)
+
+		if pr := server.HandlePushedAuthorizationRequest(resp, req); pr != nil {
+			t.Errorf("%s: should not return a request", k)
+			continue
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q", k, test.ExpectedError, resp.ErrorId)
+		}
+		if resp.Type != DATA {
+			t.Errorf("%s: errors should not be redirected", k)
+		}
+	}
+}
+
+func TestAuthorizeRequestUri(t *testing.T) {
+	now := time.Now()
+	newStorage := func() *TestingStorage {
+		storage := NewTestingStorage()
+		storage.SavePushedAuthorize(&PushedAuthorizeData{
+			Client:     storage.clients["1234"],
+			RequestUri: REQUEST_URI_PREFIX + "valid",
+			Params:     url.Values{"client_id": {"1234"}, "response_type": {string(CODE)}},
+			ExpiresIn:  60,
+			CreatedAt:  now,
+		})
+		storage.SavePushedAuthorize(&PushedAuthorizeData{
+			Client:     storage.clients["1234"],
+			RequestUri: REQUEST_URI_PREFIX + "expired",
+			Params:     url.Values{"client_id": {"1234"}, "response_type": {string(CODE)}},
+			ExpiresIn:  60,
+			CreatedAt:  now.Add(-time.Hour),
+		})
+		return storage
+	}
+
+	testcases := map[string]struct {
+		Form          url.Values
+		Require       bool
+		ExpectedError string
+	}{
+		"valid": {
+			Form: url.Values{"client_id": {"1234"}, "request_uri": {REQUEST_URI_PREFIX + "valid"}},
+		},
+		"valid when required": {
+			Form:    url.Values{"client_id": {"1234"}, "request_uri": {REQUEST_URI_PREFIX + "valid"}},
+			Require: true,
+		},
+		"other client": {
+			Form:          url.Values{"client_id": {"public-client"}, "request_uri": {REQUEST_URI_PREFIX + "valid"}},
+			ExpectedError: E_INVALID_REQUEST_URI,
+		},
+		"missing client_id": {
+			Form:          url.Values{"request_uri": {REQUEST_URI_PREFIX + "valid"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"expired": {
+			Form:          url.Values{"client_id": {"1234"}, "request_uri": {REQUEST_URI_PREFIX + "expired"}},
+			ExpectedError: E_INVALID_REQUEST_URI,
+		},
+		"unknown": {
+			Form:          url.Values{"client_id": {"1234"}, "request_uri": {REQUEST_URI_PREFIX + "unknown"}},
+			ExpectedError: E_INVALID_REQUEST_URI,
+		},
+		"not pushed when required": {
+			Form:          url.Values{"client_id": {"1234"}, "response_type": {string(CODE)}},
+			Require:       true,
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.RequirePushedAuthorizationRequests = test.Require
+		storage := newStorage()
+		server := NewServer(sconfig, storage)
+		server.Now = func() time.Time { return now }
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = test.Form
+
+		ar := server.HandleAuthorizeRequest(resp, req)
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError == "" && ar == nil {
+			t.Errorf("%s: expected a request", k)
+		}
+
+		// the request_uri is only consumed when the authorization finishes
+		if len(storage.pushed) != 2 {
+			t.Errorf("%s: request_uri was consumed", k)
+		}
+	}
+}
+
+func TestFinishAuthorizeRequestUri(t *testing.T) {
+	storage := NewTestingStorage()
+	storage.SavePushedAuthorize(&PushedAuthorizeData{
+		Client:     storage.clients["1234"],
+		RequestUri: REQUEST_URI_PREFIX + "valid",
+		Params:     url.Values{"client_id": {"1234"}, "response_type": {string(CODE)}},
+		ExpiresIn:  60,
+		CreatedAt:  time.Now(),
+	})
+	server := NewServer(NewServerConfig(), storage)
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+
+	handle := func() (*Response, *http.Request, *AuthorizeRequest) {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{"client_id": {"1234"}, "request_uri": {REQUEST_URI_PREFIX + "valid"}}
+		ar := server.HandleAuthorizeRequest(resp, req)
+		if ar == nil {
+			t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+		}
+		return resp, req, ar
+	}
+
+	// concurrent submissions load the same request_uri, only one is authorized
+	resp1, req1, ar1 := handle()
+	resp2, req2, ar2 := handle()
+
+	// denying the request doesn't consume it
+	server.FinishAuthorizeRequest(resp1, req1, ar1)
+	if resp1.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Expected error %q, got %q", E_ACCESS_DENIED, resp1.ErrorId)
+	}
+	if _, ok := storage.pushed[REQUEST_URI_PREFIX+"valid"]; !ok {
+		t.Fatal("request_uri was consumed by a denied request")
+	}
+
+	resp1, req1, ar1 = handle()
+	ar1.Authorized = true
+	server.FinishAuthorizeRequest(resp1, req1, ar1)
+	if resp1.IsError {
+		t.Fatalf("Error in response: %s: %v", resp1.ErrorId, resp1.InternalError)
+	}
+
+	ar2.Authorized = true
+	server.FinishAuthorizeRequest(resp2, req2, ar2)
+	if resp2.ErrorId != E_INVALID_REQUEST_URI {
+		t.Fatalf("Expected error %q, got %q", E_INVALID_REQUEST_URI, resp2.ErrorId)
+	}
+	if resp2.Type != REDIRECT || resp2.Output["code"] != nil {
+		t.Fatalf("Unexpected response: type %v, output %v", resp2.Type, resp2.Output)
+	}
+}
@@ -0,0 +1,123 @@
+package osin
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+// RefreshTokenStorage is an optional interface Storage can implement to detect the reuse of
+// rotated refresh tokens, and then revoke all the tokens of their grant
+// (https://tools.ietf.org/html/draft-ietf-oauth-security-topics#section-4.14.2).
+// Refresh tokens are rotated unless ServerConfig.RetainTokenAfterRefresh is set.
+type RefreshTokenStorage interface {
+	// ConsumeRefresh atomically deletes a refresh token replaced by a refresh, and records it
+	// as rotated. The record must be kept as long as the tokens of its grant.
+	// It must return ErrNotFound if the refresh token was already consumed or removed,
+	// so that a refresh token is rotated at most once by concurrent requests.
+	ConsumeRefresh(*RotatedRefreshData) error
+
+	// LoadRotatedRefresh looks up a rotated refresh token.
+	// Returns ErrNotFound if the token was never rotated.
+	LoadRotatedRefresh(token string) (*RotatedRefreshData, error)
+
+	// RemoveGrant revokes or deletes all the access and refresh tokens of a grant
+	// (AccessData.GrantId), and its rotated refresh tokens.
+	RemoveGrant(grantId string) error
+}
+
+// ContextRefreshTokenStorage is the context-aware version of RefreshTokenStorage, implemented
+// by a ContextStorage to detect the reuse of rotated refresh tokens
+type ContextRefreshTokenStorage interface {
+	ConsumeRefresh(ctx context.Context, data *RotatedRefreshData) error
+	LoadRotatedRefresh(ctx context.Context, token string) (*RotatedRefreshData, error)
+	RemoveGrant(ctx context.Context, grantId string) error
+}
+
+// RotatedRefreshData is a refresh token replaced by a newer token of the same grant
+type RotatedRefreshData struct {
+	// The rotated refresh token
+	RefreshToken string
+
+	// Grant the token belongs to
+	GrantId string
+
+	// Access data the token was issued with, refreshed again by concurrent
+	// requests within ServerConfig.RefreshTokenGracePeriod
+	AccessData *AccessData
+
+	// Date rotated
+	RotatedAt time.Time
+}
+
+// loadRotatedRefresh looks up a refresh token that was rotated out. Within the grace period,
+// the access data it was issued with is returned to refresh it again. Otherwise the token
+// was replayed, and all the tokens of its grant are revoked.
+// Sets an error on the response if the token can't be used.
+func (s *Server) loadRotatedRefresh(w *Response, token string, loadErr error) *AccessData {
+	storage, ok := getRefreshTokenStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_INVALID_GRANT, loadErr, "refresh_token=%s", "error loading access data")
+		return nil
+	}
+	rotated, err := storage.LoadRotatedRefresh(token)
+	if errors.Is(err, ErrNotFound) || (err == nil && rotated == nil) {
+		s.setErrorAndLog(w, E_INVALID_GRANT, loadErr, "refresh_token=%s", "error loading access data")
+		return nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "refresh_token=%s", "error loading rotated refresh token")
+		return nil
+	}
+
+	grace := time.Duration(s.Config.RefreshTokenGracePeriod) * time.Second
+	if rotated.AccessData != nil && s.Now().Sub(rotated.RotatedAt) < grace {
+		return rotated.AccessData
+	}
+
+	// the token may have been stolen, revoke the whole grant
+	if err = storage.RemoveGrant(rotated.GrantId); err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "refresh_token=%s, grant_id=%s", "error revoking grant", rotated.GrantId)
+		return nil
+	}
+	s.setErrorAndLog(w, E_INVALID_GRANT, nil, "refresh_token=%s, grant_id=%s", "rotated refresh token reused, grant revoked", rotated.GrantId)
+	return nil
+}
+
+// consumeRefresh rotates out the refresh token replaced by data, before the new tokens are
+// saved. A refresh token consumed by a concurrent request is handled as a reuse.
+// Returns false and sets an error on the response if the token can't be used.
+func (s *Server) consumeRefresh(w *Response, data *AccessData) bool {
+	token := data.AccessData.RefreshToken
+	storage, ok := getRefreshTokenStorage(w.Storage)
+	if !ok {
+		if err := w.Storage.RemoveRefresh(token); err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_access_request=%s", "error removing refresh token")
+			return false
+		}
+		return true
+	}
+
+	err := storage.ConsumeRefresh(&RotatedRefreshData{
+		RefreshToken: token,
+		GrantId:      data.GrantId,
+		AccessData:   data.AccessData,
+		RotatedAt:    s.Now(),
+	})
+	if errors.Is(err, ErrNotFound) {
+		return s.loadRotatedRefresh(w, token, err) != nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_access_request=%s", "error consuming refresh token")
+		return false
+	}
+	return true
+}
+
+// newGrantId generates the identifier of a new grant
+func newGrantId() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(uuid.NewRandom()))
+}
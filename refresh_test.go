@@ -0,0 +1,139 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func refreshTestingToken(t *testing.T, server *Server, token string) *Response {
+	resp := server.NewResponse()
+	req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{
+		"grant_type":    {string(REFRESH_TOKEN)},
+		"refresh_token": {token},
+	}
+	req.PostForm = make(url.Values)
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	return resp
+}
+
+func TestRefreshTokenReuse(t *testing.T) {
+	testcases := map[string]struct {
+		GracePeriod   int32
+		Elapsed       time.Duration
+		ExpectedError string
+	}{
+		"reuse revokes grant": {
+			ExpectedError: E_INVALID_GRANT,
+		},
+		"reuse within grace period": {
+			GracePeriod: 10,
+			Elapsed:     5 * time.Second,
+		},
+		"reuse after grace period": {
+			GracePeriod:   10,
+			Elapsed:       10 * time.Second,
+			ExpectedError: E_INVALID_GRANT,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+		sconfig.RefreshTokenGracePeriod = test.GracePeriod
+		storage := NewTestingStorage()
+		server := NewServer(sconfig, storage)
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		now := time.Now()
+		server.Now = func() time.Time { return now }
+
+		// r9999 -> r1 -> r2
+		for _, token := range []string{"r9999", "r1"} {
+			if resp := refreshTestingToken(t, server, token); resp.IsError {
+				t.Fatalf("%s: error refreshing %s: %s: %v", k, token, resp.ErrorId, resp.InternalError)
+			}
+		}
+		grantId := storage.access["2"].GrantId
+		if grantId == "" || storage.access["1"] != nil || storage.rotated["r1"] == nil || storage.rotated["r1"].GrantId != grantId {
+			t.Fatalf("%s: refresh token was not rotated in grant %q", k, grantId)
+		}
+
+		now = now.Add(test.Elapsed)
+		resp := refreshTestingToken(t, server, "r1")
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			if _, err := storage.LoadAccess("2"); err != ErrNotFound {
+				t.Errorf("%s: access token of the grant was not revoked", k)
+			}
+			if _, err := storage.LoadRefresh("r2"); err != ErrNotFound {
+				t.Errorf("%s: refresh token of the grant was not revoked", k)
+			}
+			continue
+		}
+		if resp.Output["refresh_token"] != "r3" || storage.access["3"].GrantId != grantId {
+			t.Errorf("%s: unexpected output %v", k, resp.Output)
+		}
+	}
+}
+
+func TestRefreshTokenConcurrentRotation(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{REFRESH_TOKEN}
+	storage := NewTestingStorage()
+	server := NewServer(sconfig, storage)
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	if resp := refreshTestingToken(t, server, "r9999"); resp.IsError {
+		t.Fatalf("Error refreshing r9999: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+
+	// both requests load r1 before either rotates it
+	var requests []*AccessRequest
+	var responses []*Response
+	for i := 0; i < 2; i++ {
+		resp := server.NewResponse()
+		req, err := http.NewRequest("POST", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"r1"}}
+		req.PostForm = make(url.Values)
+
+		ar := server.HandleAccessRequest(resp, req)
+		if ar == nil {
+			t.Fatalf("request %d: error loading refresh token: %s: %v", i, resp.ErrorId, resp.InternalError)
+		}
+		ar.Authorized = true
+		requests = append(requests, ar)
+		responses = append(responses, resp)
+	}
+
+	server.FinishAccessRequest(responses[0], requests[0].HttpRequest, requests[0])
+	if responses[0].IsError {
+		t.Fatalf("Should not be an error: %s: %v", responses[0].ErrorId, responses[0].InternalError)
+	}
+	server.FinishAccessRequest(responses[1], requests[1].HttpRequest, requests[1])
+	if responses[1].ErrorId != E_INVALID_GRANT {
+		t.Fatalf("Expected error %q, got %q", E_INVALID_GRANT, responses[1].ErrorId)
+	}
+	if _, err := storage.LoadAccess("2"); err != ErrNotFound {
+		t.Errorf("Access token of the grant was not revoked")
+	}
+	if _, err := storage.LoadAccess("3"); err != ErrNotFound {
+		t.Errorf("Access token of the concurrent request was saved")
+	}
+}
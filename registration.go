@@ -0,0 +1,642 @@
+package osin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pborman/uuid"
+	"gopkg.in/square/go-jose.v1"
+)
+
+// ClientRegistrationType is the operation of a client registration request
+type ClientRegistrationType string
+
+const (
+	// https://tools.ietf.org/html/rfc7591#section-3
+	REGISTER_CLIENT ClientRegistrationType = "register"
+
+	// https://tools.ietf.org/html/rfc7592#section-2
+	READ_CLIENT   ClientRegistrationType = "read"
+	UPDATE_CLIENT ClientRegistrationType = "update"
+	DELETE_CLIENT ClientRegistrationType = "delete"
+)
+
+// maxRegistrationBody is the maximum size of client registration request bodies
+const maxRegistrationBody = 1 << 20
+
+// registrationReadOnlyFields are the response fields clients can't send
+// in registration requests (https://tools.ietf.org/html/rfc7592#section-2.2)
+var registrationReadOnlyFields = []string{
+	"registration_access_token", "registration_client_uri", "client_id_issued_at", "client_secret_expires_at",
+}
+
+// ClientMetadata is the metadata of a dynamically registered client
+// (https://tools.ietf.org/html/rfc7591#section-2)
+type ClientMetadata struct {
+	RedirectUris            []string            `json:"redirect_uris,omitempty"`
+	TokenEndpointAuthMethod string              `json:"token_endpoint_auth_method,omitempty"`
+	GrantTypes              []string            `json:"grant_types,omitempty"`
+	ResponseTypes           []string            `json:"response_types,omitempty"`
+	ClientName              string              `json:"client_name,omitempty"`
+	ClientUri               string              `json:"client_uri,omitempty"`
+	LogoUri                 string              `json:"logo_uri,omitempty"`
+	Scope                   string              `json:"scope,omitempty"`
+	Contacts                []string            `json:"contacts,omitempty"`
+	TosUri                  string              `json:"tos_uri,omitempty"`
+	PolicyUri               string              `json:"policy_uri,omitempty"`
+	JwksUri                 string              `json:"jwks_uri,omitempty"`
+	Jwks                    *jose.JsonWebKeySet `json:"jwks,omitempty"`
+	SoftwareId              string              `json:"software_id,omitempty"`
+	SoftwareVersion         string              `json:"software_version,omitempty"`
+
+	// Registered certificate subject of tls_client_auth clients (rfc8705)
+	TLSClientAuthSubjectDN string `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSClientAuthSANDNS    string `json:"tls_client_auth_san_dns,omitempty"`
+	TLSClientAuthSANURI    string `json:"tls_client_auth_san_uri,omitempty"`
+	TLSClientAuthSANIP     string `json:"tls_client_auth_san_ip,omitempty"`
+	TLSClientAuthSANEmail  string `json:"tls_client_auth_san_email,omitempty"`
+}
+
+// tlsClientAuth returns the registered certificate subject, nil if none
+func (m *ClientMetadata) tlsClientAuth() *TLSClientAuth {
+	ret := &TLSClientAuth{
+		SubjectDN: m.TLSClientAuthSubjectDN,
+		SANDNS:    m.TLSClientAuthSANDNS,
+		SANURI:    m.TLSClientAuthSANURI,
+		SANIP:     m.TLSClientAuthSANIP,
+		SANEmail:  m.TLSClientAuthSANEmail,
+	}
+	if *ret == (TLSClientAuth{}) {
+		return nil
+	}
+	return ret
+}
+
+// ClientStorage is an optional interface Storage must implement to support
+// dynamic client registration (rfc7591) and management (rfc7592)
+type ClientStorage interface {
+	// SaveClientRegistration creates or updates a client registration.
+	// GetClient must return the registered client afterwards.
+	SaveClientRegistration(*ClientRegistration) error
+
+	// LoadClientRegistration looks up a ClientRegistration by client id.
+	// Client information MUST be loaded together.
+	LoadClientRegistration(clientId string) (*ClientRegistration, error)
+
+	// RemoveClientRegistration deletes a registered client
+	RemoveClientRegistration(clientId string) error
+}
+
+// ContextClientStorage is the context-aware version of ClientStorage, implemented by a
+// ContextStorage to support dynamic client registration and management
+type ContextClientStorage interface {
+	SaveClientRegistration(ctx context.Context, data *ClientRegistration) error
+	LoadClientRegistration(ctx context.Context, clientId string) (*ClientRegistration, error)
+	RemoveClientRegistration(ctx context.Context, clientId string) error
+}
+
+// ClientRegistration is a dynamically registered client
+type ClientRegistration struct {
+	// Client information
+	Client Client
+
+	// Registered metadata
+	Metadata *ClientMetadata
+
+	// Software statement the client was registered with, if any
+	SoftwareStatement string
+
+	// Token authorizing the client to read, update and delete its registration
+	RegistrationAccessToken string
+
+	// Date created
+	CreatedAt time.Time
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+}
+
+// ClientRegistrationRequest is a request to register a client, or to read,
+// update or delete a registration
+type ClientRegistrationRequest struct {
+	Type ClientRegistrationType
+
+	// Validated metadata to register, nil when reading or deleting
+	Metadata *ClientMetadata
+
+	// Current registration, nil when registering
+	Registration *ClientRegistration
+
+	// Initial access token sent as a bearer token with REGISTER_CLIENT requests,
+	// to be checked by the application if registration is restricted
+	InitialAccessToken string
+
+	// Software statement sent with the metadata, and its verified claims,
+	// which override the metadata sent in the clear
+	SoftwareStatement       string
+	SoftwareStatementClaims *JWTClaims
+
+	// Set if request is authorized.
+	// Management requests are authenticated by the registration access token.
+	Authorized bool
+
+	// Data to be passed to storage. Not used by the library.
+	UserData interface{}
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleClientRegistrationRequest is the http.HandlerFunc for the client registration
+// endpoint (https://tools.ietf.org/html/rfc7591#section-3)
+func (s *Server) HandleClientRegistrationRequest(w *Response, r *http.Request) *ClientRegistrationRequest {
+	bindContext(w, r)
+
+	// Only allow POST
+	if r.Method != "POST" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, errors.New("Request must be POST"), "client_registration_request=%s", "request must be POST")
+		return nil
+	}
+
+	token, err := accessTokenAuth(r)
+	if err != nil {
+		s.setBearerErrorAndLog(w, E_INVALID_TOKEN, err, "client_registration_request=%s", "invalid initial access token authentication")
+		return nil
+	}
+
+	ret := &ClientRegistrationRequest{
+		Type:               REGISTER_CLIENT,
+		InitialAccessToken: token,
+		HttpRequest:        r,
+	}
+	if !s.parseClientMetadata(w, r, ret, "") {
+		return nil
+	}
+	return ret
+}
+
+// HandleClientConfigurationRequest is the http.HandlerFunc for the client configuration
+// endpoint (https://tools.ietf.org/html/rfc7592#section-2), served at the
+// registration_client_uri: the registration endpoint followed by the client id.
+// The client is authenticated by its registration access token.
+func (s *Server) HandleClientConfigurationRequest(w *Response, r *http.Request) *ClientRegistrationRequest {
+	bindContext(w, r)
+	ret := &ClientRegistrationRequest{
+		HttpRequest: r,
+	}
+	switch r.Method {
+	case "GET":
+		ret.Type = READ_CLIENT
+	case "PUT":
+		ret.Type = UPDATE_CLIENT
+	case "DELETE":
+		ret.Type = DELETE_CLIENT
+	default:
+		s.setErrorAndLog(w, E_INVALID_REQUEST, fmt.Errorf("Unsupported method %s", r.Method), "client_configuration_request=%s", "request must be GET, PUT or DELETE")
+		return nil
+	}
+
+	storage, ok := getClientStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement ClientStorage"), "client_configuration_request=%s", "client registration not supported")
+		return nil
+	}
+
+	token, err := accessTokenAuth(r)
+	if err != nil || token == "" {
+		s.setBearerErrorAndLog(w, E_INVALID_TOKEN, err, "client_configuration_request=%s", "registration access token required")
+		return nil
+	}
+
+	// unknown clients are reported as invalid tokens
+	// https://tools.ietf.org/html/rfc7592#section-2.1
+	clientId := path.Base(r.URL.Path)
+	ret.Registration, err = storage.LoadClientRegistration(clientId)
+	if errors.Is(err, ErrNotFound) || (err == nil && (ret.Registration == nil || ret.Registration.Client == nil)) {
+		s.setBearerErrorAndLog(w, E_INVALID_TOKEN, nil, "client_configuration_request=%s, client_id=%s", "client not found", clientId)
+		return nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "client_configuration_request=%s", "error loading client registration")
+		return nil
+	}
+	if ret.Registration.RegistrationAccessToken == "" || subtle.ConstantTimeCompare([]byte(ret.Registration.RegistrationAccessToken), []byte(token)) != 1 {
+		s.setBearerErrorAndLog(w, E_INVALID_TOKEN, nil, "client_configuration_request=%s, client_id=%s", "registration access token mismatch", clientId)
+		return nil
+	}
+
+	if ret.Type == UPDATE_CLIENT {
+		if !s.parseClientMetadata(w, r, ret, clientId) {
+			return nil
+		}
+	}
+	return ret
+}
+
+// FinishClientRegistrationRequest saves or deletes the client registration,
+// and outputs the registered client information
+func (s *Server) FinishClientRegistrationRequest(w *Response, r *http.Request, cr *ClientRegistrationRequest) {
+	bindContext(w, r)
+
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	if !cr.Authorized {
+		s.setErrorAndLog(w, E_ACCESS_DENIED, nil, "client_registration_request=%s", "authorization failed")
+		return
+	}
+
+	storage, ok := getClientStorage(w.Storage)
+	if !ok {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("Storage does not implement ClientStorage"), "client_registration_request=%s", "client registration not supported")
+		return
+	}
+
+	var reg *ClientRegistration
+	switch cr.Type {
+	case REGISTER_CLIENT:
+		reg = &ClientRegistration{
+			Client:                  s.registeredClient(generateRegistrationToken(), "", cr.Metadata),
+			Metadata:                cr.Metadata,
+			SoftwareStatement:       cr.SoftwareStatement,
+			RegistrationAccessToken: generateRegistrationToken(),
+			CreatedAt:               s.Now(),
+			UserData:                cr.UserData,
+		}
+	case UPDATE_CLIENT:
+		reg = &ClientRegistration{}
+		*reg = *cr.Registration
+		reg.Client = s.registeredClient(reg.Client.GetId(), reg.Client.GetSecret(), cr.Metadata)
+		reg.Metadata = cr.Metadata
+		reg.SoftwareStatement = cr.SoftwareStatement
+		if cr.UserData != nil {
+			reg.UserData = cr.UserData
+		}
+	case READ_CLIENT:
+		reg = cr.Registration
+	case DELETE_CLIENT:
+		if err := storage.RemoveClientRegistration(cr.Registration.Client.GetId()); err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "client_registration_request=%s", "error removing client registration")
+			return
+		}
+		// https://tools.ietf.org/html/rfc7592#section-2.3
+		w.StatusCode = http.StatusNoContent
+		w.RawOutput = []byte{}
+		return
+	default:
+		s.setErrorAndLog(w, E_INVALID_REQUEST, fmt.Errorf("unknown registration request type %q", cr.Type), "client_registration_request=%s", "unknown request type")
+		return
+	}
+
+	if cr.Type != READ_CLIENT {
+		if err := storage.SaveClientRegistration(reg); err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "client_registration_request=%s", "error saving client registration")
+			return
+		}
+	}
+
+	output, err := s.clientInformation(reg)
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "client_registration_request=%s", "error building client information")
+		return
+	}
+	// https://tools.ietf.org/html/rfc7591#section-3.2.1
+	if cr.Type == REGISTER_CLIENT {
+		w.StatusCode = http.StatusCreated
+	}
+	for k, v := range output {
+		w.Output[k] = v
+	}
+}
+
+// parseClientMetadata decodes and validates the JSON client metadata of a registration
+// request, overridden by the claims of its software statement.
+// Updates of the client clientId can't change the client credentials.
+// Returns false and sets an error on the response if the metadata is invalid.
+func (s *Server) parseClientMetadata(w *Response, r *http.Request, cr *ClientRegistrationRequest, clientId string) bool {
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/json" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, fmt.Errorf("unsupported Content-Type %q", ct), "client_registration_request=%s", "request must be JSON")
+		return false
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxRegistrationBody+1))
+	if err != nil || len(body) > maxRegistrationBody {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "client_registration_request=%s", "error reading request body")
+		return false
+	}
+	fields := map[string]interface{}{}
+	if err = json.Unmarshal(body, &fields); err != nil {
+		s.setErrorAndLog(w, E_INVALID_CLIENT_METADATA, err, "client_registration_request=%s", "invalid JSON metadata")
+		return false
+	}
+
+	// the client credentials are sent back unchanged on updates
+	// https://tools.ietf.org/html/rfc7592#section-2.2
+	if clientId != "" {
+		if id, _ := fields["client_id"].(string); id != clientId {
+			s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "client_registration_request=%s, client_id=%s", "client_id does not match", clientId)
+			return false
+		}
+		for _, f := range registrationReadOnlyFields {
+			if _, ok := fields[f]; ok {
+				s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "client_registration_request=%s, field=%s", "read-only field sent", f)
+				return false
+			}
+		}
+		if secret, ok := fields["client_secret"]; ok {
+			if str, _ := secret.(string); !CheckClientSecret(cr.Registration.Client, str) {
+				s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "client_registration_request=%s, client_id=%s", "client_secret does not match", clientId)
+				return false
+			}
+		}
+	}
+	delete(fields, "client_id")
+	delete(fields, "client_secret")
+
+	// https://tools.ietf.org/html/rfc7591#section-2.3
+	if statement, ok := fields["software_statement"]; ok {
+		cr.SoftwareStatement, _ = statement.(string)
+		if cr.SoftwareStatementClaims = s.verifySoftwareStatement(w, cr.SoftwareStatement); cr.SoftwareStatementClaims == nil {
+			return false
+		}
+		for k, v := range cr.SoftwareStatementClaims.Claims {
+			switch k {
+			case "iss", "sub", "aud", "exp", "nbf", "iat", "jti":
+			default:
+				fields[k] = v
+			}
+		}
+		delete(fields, "software_statement")
+	}
+
+	cr.Metadata = &ClientMetadata{}
+	if body, err = json.Marshal(fields); err == nil {
+		err = json.Unmarshal(body, cr.Metadata)
+	}
+	if err == nil {
+		err = s.validateClientMetadata(cr.Metadata)
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_INVALID_CLIENT_METADATA, err, "client_registration_request=%s", "invalid client metadata")
+		return false
+	}
+	if err = s.validateRegisteredRedirectUris(cr.Metadata); err != nil {
+		s.setErrorAndLog(w, E_INVALID_REDIRECT_URI, err, "client_registration_request=%s", "invalid redirect_uris")
+		return false
+	}
+	return true
+}
+
+// verifySoftwareStatement verifies a software statement signed by one of the
+// SoftwareStatementIssuers, and returns its claims.
+// Sets an error on the response if the statement is invalid or not trusted.
+func (s *Server) verifySoftwareStatement(w *Response, statement string) *JWTClaims {
+	unverified, err := unverifiedJWTClaims(statement)
+	if err != nil || unverified.Issuer == "" {
+		s.setErrorAndLog(w, E_INVALID_SOFTWARE_STATEMENT, err, "client_registration_request=%s", "error parsing software statement")
+		return nil
+	}
+	if s.SoftwareStatementIssuers == nil {
+		s.setErrorAndLog(w, E_UNAPPROVED_SOFTWARE_STATEMENT, nil, "client_registration_request=%s", "software statements not accepted")
+		return nil
+	}
+	keys, err := s.SoftwareStatementIssuers.GetIssuerKeys(unverified.Issuer)
+	if errors.Is(err, ErrNotFound) || (err == nil && keys == nil) {
+		s.setErrorAndLog(w, E_UNAPPROVED_SOFTWARE_STATEMENT, nil, "client_registration_request=%s, iss=%s", "software statement issuer is not trusted", unverified.Issuer)
+		return nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "client_registration_request=%s", "error loading issuer keys")
+		return nil
+	}
+
+	claims, err := parseJWT(statement, keys.Keys)
+	if err != nil {
+		s.setErrorAndLog(w, E_INVALID_SOFTWARE_STATEMENT, err, "client_registration_request=%s", "invalid software statement signature")
+		return nil
+	}
+	// statements are long lived, exp is optional
+	if !claims.ExpiresAt.IsZero() {
+		if err = s.validateJWTClaims(claims); err != nil {
+			s.setErrorAndLog(w, E_INVALID_SOFTWARE_STATEMENT, err, "client_registration_request=%s", "invalid software statement claims")
+			return nil
+		}
+	}
+	return claims
+}
+
+// validateClientMetadata sets the default values of the client metadata, and checks
+// they are consistent and supported by the server configuration
+// (https://tools.ietf.org/html/rfc7591#section-2)
+func (s *Server) validateClientMetadata(m *ClientMetadata) error {
+	if m.TokenEndpointAuthMethod == "" {
+		m.TokenEndpointAuthMethod = "client_secret_basic"
+	}
+	if len(m.GrantTypes) == 0 {
+		m.GrantTypes = []string{string(AUTHORIZATION_CODE)}
+	}
+	if len(m.ResponseTypes) == 0 && containsString(m.GrantTypes, string(AUTHORIZATION_CODE)) {
+		m.ResponseTypes = []string{string(CODE)}
+	}
+
+	for _, gt := range m.GrantTypes {
+		if gt == "implicit" {
+			if !s.implicitAllowed() {
+				return errors.New("grant type implicit is not supported")
+			}
+		} else if !s.Config.AllowedAccessTypes.Exists(AccessRequestType(gt)) {
+			return fmt.Errorf("grant type %q is not supported", gt)
+		}
+	}
+	for _, rt := range m.ResponseTypes {
+		t := ParseAuthorizeRequestType(rt)
+		if t == "" || !s.Config.AllowedAuthorizeTypes.Exists(t) {
+			return fmt.Errorf("response type %q is not supported", rt)
+		}
+		// https://tools.ietf.org/html/rfc7591#section-2.1
+		if t.Has(CODE) && !containsString(m.GrantTypes, string(AUTHORIZATION_CODE)) {
+			return fmt.Errorf("response type %q requires the authorization_code grant type", rt)
+		}
+		if (t.Has(TOKEN) || t.Has(ID_TOKEN)) && !containsString(m.GrantTypes, "implicit") {
+			return fmt.Errorf("response type %q requires the implicit grant type", rt)
+		}
+	}
+
+	if m.Jwks != nil && m.JwksUri != "" {
+		return errors.New("jwks and jwks_uri can't be used together")
+	}
+	if m.Jwks != nil {
+		for _, key := range m.Jwks.Keys {
+			if !key.Valid() || !key.IsPublic() {
+				return fmt.Errorf("jwks key %q is not a valid public key", key.KeyID)
+			}
+		}
+	}
+	if m.JwksUri != "" {
+		if u, err := url.Parse(m.JwksUri); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("jwks_uri %q must be an https URL", m.JwksUri)
+		}
+	}
+
+	switch m.TokenEndpointAuthMethod {
+	case "none", "client_secret_basic", "client_secret_jwt":
+	case "client_secret_post":
+		if !s.Config.AllowClientSecretInParams {
+			return errors.New("token endpoint auth method client_secret_post is not supported")
+		}
+	case "private_key_jwt", "self_signed_tls_client_auth":
+		// client keys are not fetched from jwks_uri
+		if m.Jwks == nil || len(m.Jwks.Keys) == 0 {
+			return fmt.Errorf("jwks required with token endpoint auth method %s", m.TokenEndpointAuthMethod)
+		}
+	case "tls_client_auth":
+		// https://tools.ietf.org/html/rfc8705#section-2.1.2
+		n := 0
+		for _, v := range []string{m.TLSClientAuthSubjectDN, m.TLSClientAuthSANDNS, m.TLSClientAuthSANURI, m.TLSClientAuthSANIP, m.TLSClientAuthSANEmail} {
+			if v != "" {
+				n++
+			}
+		}
+		if n != 1 {
+			return errors.New("exactly one certificate subject is required with token endpoint auth method tls_client_auth")
+		}
+	default:
+		return fmt.Errorf("token endpoint auth method %q is not supported", m.TokenEndpointAuthMethod)
+	}
+
+	for _, uri := range []string{m.ClientUri, m.LogoUri, m.TosUri, m.PolicyUri} {
+		if uri == "" {
+			continue
+		}
+		if u, err := url.Parse(uri); err != nil || !u.IsAbs() {
+			return fmt.Errorf("%q is not an absolute URL", uri)
+		}
+	}
+
+	// https://tools.ietf.org/html/rfc6749#section-3.3
+	for _, c := range m.Scope {
+		if c != ' ' && (c < 0x21 || c == 0x22 || c == 0x5c || c > 0x7e) {
+			return fmt.Errorf("invalid scope %q", m.Scope)
+		}
+	}
+	return nil
+}
+
+// validateRegisteredRedirectUris checks the redirect_uris of the client metadata,
+// required by the grant types using redirects (https://tools.ietf.org/html/rfc7591#section-2)
+func (s *Server) validateRegisteredRedirectUris(m *ClientMetadata) error {
+	if len(m.RedirectUris) == 0 {
+		if containsString(m.GrantTypes, string(AUTHORIZATION_CODE)) || containsString(m.GrantTypes, "implicit") {
+			return errors.New("redirect_uris required")
+		}
+		return nil
+	}
+	for _, uri := range m.RedirectUris {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			return fmt.Errorf("redirect uri %q must be absolute, without fragment", uri)
+		}
+	}
+	return nil
+}
+
+// implicitAllowed returns true if response types issuing tokens from the
+// authorization endpoint are allowed
+func (s *Server) implicitAllowed() bool {
+	for _, rt := range s.Config.AllowedAuthorizeTypes {
+		if rt.Has(TOKEN) || rt.Has(ID_TOKEN) {
+			return true
+		}
+	}
+	return false
+}
+
+// registeredClient builds the client registered with the metadata, restricted to
+// its grant types, response types, scopes and authentication method. A secret is generated if the auth method uses one and the client has none yet.
+func (s *Server) registeredClient(id, secret string, m *ClientMetadata) Client {
+	switch m.TokenEndpointAuthMethod {
+	case "client_secret_basic", "client_secret_post", "client_secret_jwt":
+		if secret == "" {
+			secret = generateRegistrationToken()
+		}
+	default:
+		secret = ""
+	}
+	ret := &DefaultClient{
+		Id:                      id,
+		Secret:                  secret,
+		RedirectUris:            m.RedirectUris,
+		JsonWebKeys:             m.Jwks,
+		TLSClientAuth:           m.tlsClientAuth(),
+		AllowedAccessTypes:      AllowedAccessType{},
+		AllowedAuthorizeTypes:   AllowedAuthorizeType{},
+		TokenEndpointAuthMethod: m.TokenEndpointAuthMethod,
+	}
+	for _, gt := range m.GrantTypes {
+		if gt == "implicit" {
+			ret.AllowedAccessTypes = append(ret.AllowedAccessTypes, IMPLICIT)
+		} else {
+			ret.AllowedAccessTypes = append(ret.AllowedAccessTypes, AccessRequestType(gt))
+		}
+	}
+	for _, rt := range m.ResponseTypes {
+		ret.AllowedAuthorizeTypes = append(ret.AllowedAuthorizeTypes, AuthorizeRequestType(rt))
+	}
+	if m.Scope != "" {
+		ret.AllowedScopes = strings.Fields(m.Scope)
+	}
+	return ret
+}
+
+// clientInformation returns the registered metadata and credentials of a client
+// (https://tools.ietf.org/html/rfc7591#section-3.2.1, https://tools.ietf.org/html/rfc7592#section-3)
+func (s *Server) clientInformation(reg *ClientRegistration) (ResponseData, error) {
+	ret := ResponseData{}
+	if reg.Metadata != nil {
+		b, err := json.Marshal(reg.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(b, &ret); err != nil {
+			return nil, err
+		}
+	}
+
+	clientId := reg.Client.GetId()
+	ret["client_id"] = clientId
+	ret["client_id_issued_at"] = reg.CreatedAt.Unix()
+	if secret := reg.Client.GetSecret(); secret != "" {
+		ret["client_secret"] = secret
+		ret["client_secret_expires_at"] = 0
+	}
+	if reg.SoftwareStatement != "" {
+		ret["software_statement"] = reg.SoftwareStatement
+	}
+	ret["registration_access_token"] = reg.RegistrationAccessToken
+	if s.Config.Endpoints.Registration != "" {
+		u, err := s.endpointUrl(s.Config.Endpoints.Registration)
+		if err != nil {
+			return nil, err
+		}
+		ret["registration_client_uri"] = strings.TrimSuffix(u, "/") + "/" + url.PathEscape(clientId)
+	}
+	return ret, nil
+}
+
+// generateRegistrationToken generates a random client id, secret or registration access token
+func generateRegistrationToken() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(uuid.NewRandom()))
+}
@@ -0,0 +1,301 @@
+package osin
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func newRegistrationRequest(t *testing.T, method, url string, body interface{}) *http.Request {
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestClientRegistration(t *testing.T) {
+	trusted := newTestingKey(t, "trusted")
+	untrusted := newTestingKey(t, "untrusted")
+	statement := signTestingJWT(t, trusted, map[string]interface{}{
+		"iss":         "https://software.example.com",
+		"software_id": "app",
+		"client_name": "Signed App",
+	})
+
+	testcases := map[string]struct {
+		Metadata      map[string]interface{}
+		ContentType   string
+		Unauthorized  bool
+		ExpectedError string
+		ExpectSecret  bool
+		ExpectedName  string
+	}{
+		"confidential client": {
+			Metadata:     map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb"}, "client_name": "App"},
+			ExpectSecret: true,
+			ExpectedName: "App",
+		},
+		"public client": {
+			Metadata: map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb"}, "token_endpoint_auth_method": "none"},
+		},
+		"service client": {
+			Metadata:     map[string]interface{}{"grant_types": []string{"client_credentials"}},
+			ExpectSecret: true,
+		},
+		"software statement": {
+			Metadata:     map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb"}, "client_name": "Other", "software_statement": statement},
+			ExpectSecret: true,
+			ExpectedName: "Signed App",
+		},
+		"untrusted software statement": {
+			Metadata: map[string]interface{}{
+				"redirect_uris":      []string{"https://app.example.com/cb"},
+				"software_statement": signTestingJWT(t, untrusted, map[string]interface{}{"iss": "https://other.example.com"}),
+			},
+			ExpectedError: E_UNAPPROVED_SOFTWARE_STATEMENT,
+		},
+		"invalid software statement": {
+			Metadata: map[string]interface{}{
+				"redirect_uris":      []string{"https://app.example.com/cb"},
+				"software_statement": signTestingJWT(t, untrusted, map[string]interface{}{"iss": "https://software.example.com"}),
+			},
+			ExpectedError: E_INVALID_SOFTWARE_STATEMENT,
+		},
+		"missing redirect uri": {
+			Metadata:      map[string]interface{}{"client_name": "App"},
+			ExpectedError: E_INVALID_REDIRECT_URI,
+		},
+		"relative redirect uri": {
+			Metadata:      map[string]interface{}{"redirect_uris": []string{"/cb"}},
+			ExpectedError: E_INVALID_REDIRECT_URI,
+		},
+		"multiple redirect uris": {
+			Metadata:     map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb", "https://app.example.com/cb2"}},
+			ExpectSecret: true,
+		},
+		"unsupported grant type": {
+			Metadata:      map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb"}, "grant_types": []string{"password"}},
+			ExpectedError: E_INVALID_CLIENT_METADATA,
+		},
+		"unsupported response type": {
+			Metadata:      map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb"}, "response_types": []string{"token"}},
+			ExpectedError: E_INVALID_CLIENT_METADATA,
+		},
+		"private_key_jwt without keys": {
+			Metadata:      map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb"}, "token_endpoint_auth_method": "private_key_jwt"},
+			ExpectedError: E_INVALID_CLIENT_METADATA,
+		},
+		"jwks and jwks_uri": {
+			Metadata: map[string]interface{}{
+				"redirect_uris": []string{"https://app.example.com/cb"},
+				"jwks":          publicKeySet(trusted),
+				"jwks_uri":      "https://app.example.com/jwks",
+			},
+			ExpectedError: E_INVALID_CLIENT_METADATA,
+		},
+		"invalid type": {
+			Metadata:      map[string]interface{}{"redirect_uris": "https://app.example.com/cb"},
+			ExpectedError: E_INVALID_CLIENT_METADATA,
+		},
+		"form encoded": {
+			Metadata:      map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb"}},
+			ContentType:   "application/x-www-form-urlencoded",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"not authorized": {
+			Metadata:      map[string]interface{}{"redirect_uris": []string{"https://app.example.com/cb"}},
+			Unauthorized:  true,
+			ExpectedError: E_ACCESS_DENIED,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.Issuer = "https://auth.example.com"
+		sconfig.Endpoints.Registration = "/register"
+		sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, CLIENT_CREDENTIALS}
+		storage := NewTestingStorage()
+		server := NewServer(sconfig, storage)
+		server.SoftwareStatementIssuers = TrustedIssuerKeys{"https://software.example.com": publicKeySet(trusted)}
+		resp := server.NewResponse()
+
+		req := newRegistrationRequest(t, "POST", "https://auth.example.com/register", test.Metadata)
+		req.Header.Set("Authorization", "Bearer initial")
+		if test.ContentType != "" {
+			req.Header.Set("Content-Type", test.ContentType)
+		}
+
+		if cr := server.HandleClientRegistrationRequest(resp, req); cr != nil {
+			if cr.InitialAccessToken != "initial" {
+				t.Errorf("%s: unexpected initial access token %q", k, cr.InitialAccessToken)
+			}
+			cr.Authorized = !test.Unauthorized
+			server.FinishClientRegistrationRequest(resp, req, cr)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			continue
+		}
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("%s: unexpected status %d", k, resp.StatusCode)
+		}
+		clientId, _ := resp.Output["client_id"].(string)
+		secret, _ := resp.Output["client_secret"].(string)
+		if clientId == "" || resp.Output["registration_access_token"] == "" || (secret != "") != test.ExpectSecret {
+			t.Errorf("%s: unexpected output %v", k, resp.Output)
+			continue
+		}
+		if uri := resp.Output["registration_client_uri"]; uri != "https://auth.example.com/register/"+clientId {
+			t.Errorf("%s: unexpected registration_client_uri %v", k, uri)
+		}
+		if name, _ := resp.Output["client_name"].(string); name != test.ExpectedName {
+			t.Errorf("%s: expected client_name %q, got %q", k, test.ExpectedName, name)
+		}
+
+		client, err := storage.GetClient(clientId)
+		if err != nil {
+			t.Errorf("%s: registered client not found: %v", k, err)
+			continue
+		}
+		if client.GetSecret() != secret {
+			t.Errorf("%s: unexpected client secret %q", k, client.GetSecret())
+		}
+	}
+}
+
+func TestClientConfiguration(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	sconfig.Endpoints.Registration = "/register"
+	storage := NewTestingStorage()
+	server := NewServer(sconfig, storage)
+
+	resp := server.NewResponse()
+	req := newRegistrationRequest(t, "POST", "https://auth.example.com/register", map[string]interface{}{
+		"redirect_uris": []string{"https://app.example.com/cb"},
+		"client_name":   "App",
+	})
+	cr := server.HandleClientRegistrationRequest(resp, req)
+	if cr == nil {
+		t.Fatalf("Error in registration: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+	cr.Authorized = true
+	server.FinishClientRegistrationRequest(resp, req, cr)
+	if resp.IsError {
+		t.Fatalf("Error in registration: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+	clientId := resp.Output["client_id"].(string)
+	secret := resp.Output["client_secret"].(string)
+	token := resp.Output["registration_access_token"].(string)
+	uri := resp.Output["registration_client_uri"].(string)
+
+	testcases := []struct {
+		Name           string
+		Method         string
+		Token          string
+		Metadata       map[string]interface{}
+		ExpectedError  string
+		ExpectedStatus int
+		ExpectedName   string
+	}{
+		{
+			Name:          "wrong token",
+			Method:        "GET",
+			Token:         "wrong",
+			ExpectedError: E_INVALID_TOKEN,
+		},
+		{
+			Name:           "read",
+			Method:         "GET",
+			Token:          token,
+			ExpectedStatus: http.StatusOK,
+			ExpectedName:   "App",
+		},
+		{
+			Name:          "update another client",
+			Method:        "PUT",
+			Token:         token,
+			Metadata:      map[string]interface{}{"client_id": "1234", "redirect_uris": []string{"https://app.example.com/cb"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		{
+			Name:          "update read-only field",
+			Method:        "PUT",
+			Token:         token,
+			Metadata:      map[string]interface{}{"client_id": clientId, "registration_access_token": token, "redirect_uris": []string{"https://app.example.com/cb"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		{
+			Name:          "update wrong secret",
+			Method:        "PUT",
+			Token:         token,
+			Metadata:      map[string]interface{}{"client_id": clientId, "client_secret": "wrong", "redirect_uris": []string{"https://app.example.com/cb"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		{
+			Name:           "update",
+			Method:         "PUT",
+			Token:          token,
+			Metadata:       map[string]interface{}{"client_id": clientId, "client_secret": secret, "redirect_uris": []string{"https://app.example.com/cb2"}, "client_name": "New App"},
+			ExpectedStatus: http.StatusOK,
+			ExpectedName:   "New App",
+		},
+		{
+			Name:           "delete",
+			Method:         "DELETE",
+			Token:          token,
+			ExpectedStatus: http.StatusNoContent,
+		},
+		{
+			Name:          "read deleted",
+			Method:        "GET",
+			Token:         token,
+			ExpectedError: E_INVALID_TOKEN,
+		},
+	}
+
+	for _, test := range testcases {
+		resp := server.NewResponse()
+		req := newRegistrationRequest(t, test.Method, uri, test.Metadata)
+		req.Header.Set("Authorization", "Bearer "+test.Token)
+
+		if cr := server.HandleClientConfigurationRequest(resp, req); cr != nil {
+			cr.Authorized = true
+			server.FinishClientRegistrationRequest(resp, req, cr)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", test.Name, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			continue
+		}
+		if resp.StatusCode != test.ExpectedStatus {
+			t.Errorf("%s: expected status %d, got %d", test.Name, test.ExpectedStatus, resp.StatusCode)
+		}
+		if test.ExpectedStatus == http.StatusNoContent {
+			continue
+		}
+		if resp.Output["client_id"] != clientId || resp.Output["client_secret"] != secret || resp.Output["client_name"] != test.ExpectedName {
+			t.Errorf("%s: unexpected output %v", test.Name, resp.Output)
+		}
+	}
+
+	if _, err := storage.GetClient(clientId); err != ErrNotFound {
+		t.Errorf("Deleted client should not be found: %v", err)
+	}
+}
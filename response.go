@@ -26,11 +26,19 @@ type Response struct {
 	ErrorStatusCode    int
 	URL                string
 	Output             ResponseData
+	RawOutput          []byte // Raw body to output instead of Output, of the Content-Type set in Headers
 	Headers            http.Header
 	IsError            bool
 	ErrorId            string
 	InternalError      error
 	RedirectInFragment bool
+	RedirectInFormPost bool
+
+	// Issuer identifier returned as `iss` with the redirect parameters (rfc9207)
+	RedirectIssuer string
+
+	// Optional encoding of the redirect parameters, such as a signed JWT (JARM)
+	redirectEncoder func(ResponseData) (ResponseData, error)
 
 	// Storage to use in this response - required
 	Storage Storage
@@ -84,6 +92,7 @@ func (r *Response) SetErrorUri(id string, description string, uri string, state
 		r.StatusText = ""
 	}
 	r.Output = make(ResponseData) // clear output
+	r.RawOutput = nil
 	r.Output["error"] = id
 	r.Output["error_description"] = description
 	if uri != "" {
@@ -106,6 +115,30 @@ func (r *Response) SetRedirectFragment(f bool) {
 	r.RedirectInFragment = f
 }
 
+// SetRedirectFormPost sets redirect values to be posted by an auto-submitting
+// HTML form (form_post response mode) written by OutputFormPost
+func (r *Response) SetRedirectFormPost(f bool) {
+	r.RedirectInFormPost = f
+}
+
+// GetRedirectOutput returns the parameters to pass to the redirect url, with the
+// issuer if set, encoded as required by the response mode
+func (r *Response) GetRedirectOutput() (ResponseData, error) {
+	output := r.Output
+	if r.RedirectIssuer != "" {
+		// https://www.rfc-editor.org/rfc/rfc9207#section-2
+		output = make(ResponseData)
+		for k, v := range r.Output {
+			output[k] = v
+		}
+		output["iss"] = r.RedirectIssuer
+	}
+	if r.redirectEncoder == nil {
+		return output, nil
+	}
+	return r.redirectEncoder(output)
+}
+
 // GetRedirectUrl returns the redirect url with all query string parameters
 func (r *Response) GetRedirectUrl() (string, error) {
 	if r.Type != REDIRECT {
@@ -117,6 +150,11 @@ func (r *Response) GetRedirectUrl() (string, error) {
 		return "", err
 	}
 
+	output, err := r.GetRedirectOutput()
+	if err != nil {
+		return "", err
+	}
+
 	var q url.Values
 	if r.RedirectInFragment {
 		// start with empty set for fragment
@@ -127,7 +165,7 @@ func (r *Response) GetRedirectUrl() (string, error) {
 	}
 
 	// add parameters
-	for n, v := range r.Output {
+	for n, v := range output {
 		q.Set(n, fmt.Sprint(v))
 	}
 
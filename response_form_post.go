@@ -0,0 +1,54 @@
+package osin
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// formPostTemplate auto-submits the response parameters to the redirect uri
+// (https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html#FormPostResponseMode)
+var formPostTemplate = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Submit This Form</title></head>
+<body onload="javascript:document.forms[0].submit()">
+<form method="post" action="{{.URL}}">
+{{range $name, $value := .Params}}<input type="hidden" name="{{$name}}" value="{{$value}}"/>
+{{end}}<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// OutputFormPost writes a redirect Response as an HTML form posting the
+// parameters to the redirect url (form_post response mode).
+// OutputJSON calls it for responses with RedirectInFormPost set.
+func OutputFormPost(rs *Response, w http.ResponseWriter, r *http.Request) error {
+	if rs.Type != REDIRECT {
+		return errors.New("Not a redirect response")
+	}
+
+	output, err := rs.GetRedirectOutput()
+	if err != nil {
+		return err
+	}
+	params := make(map[string]string)
+	for n, v := range output {
+		params[n] = fmt.Sprint(v)
+	}
+
+	// Add headers
+	for i, k := range rs.Headers {
+		for _, v := range k {
+			w.Header().Add(i, v)
+		}
+	}
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+
+	return formPostTemplate.Execute(w, struct {
+		URL    string
+		Params map[string]string
+	}{rs.URL, params})
+}
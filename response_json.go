@@ -7,6 +7,11 @@ import (
 
 // OutputJSON encodes the Response to JSON and writes to the http.ResponseWriter
 func OutputJSON(rs *Response, w http.ResponseWriter, r *http.Request) error {
+	// form_post redirects are written as HTML
+	if rs.Type == REDIRECT && rs.RedirectInFormPost {
+		return OutputFormPost(rs, w, r)
+	}
+
 	// Add headers
 	for i, k := range rs.Headers {
 		for _, v := range k {
@@ -29,6 +34,11 @@ func OutputJSON(rs *Response, w http.ResponseWriter, r *http.Request) error {
 		}
 		w.WriteHeader(rs.StatusCode)
 
+		if rs.RawOutput != nil {
+			_, err := w.Write(rs.RawOutput)
+			return err
+		}
+
 		encoder := json.NewEncoder(w)
 		err := encoder.Encode(rs.Output)
 		if err != nil {
@@ -0,0 +1,100 @@
+package osin
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ResponseMode is the type for the OAuth param `response_mode`, how the
+// authorization response parameters are returned to the client
+type ResponseMode string
+
+const (
+	// https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseModes
+	QUERY    ResponseMode = "query"
+	FRAGMENT ResponseMode = "fragment"
+
+	// https://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html
+	FORM_POST ResponseMode = "form_post"
+
+	// JWT Secured Authorization Response Mode (JARM), the parameters are returned
+	// in a `response` JWT signed by Server.IDTokenSigner.
+	// JWT is resolved to QUERY_JWT or FRAGMENT_JWT by the response type.
+	QUERY_JWT     ResponseMode = "query.jwt"
+	FRAGMENT_JWT  ResponseMode = "fragment.jwt"
+	FORM_POST_JWT ResponseMode = "form_post.jwt"
+	JWT           ResponseMode = "jwt"
+)
+
+// IsJWT returns true if the response parameters are returned in a signed JWT (JARM)
+func (m ResponseMode) IsJWT() bool {
+	return m == QUERY_JWT || m == FRAGMENT_JWT || m == FORM_POST_JWT || m == JWT
+}
+
+// parseResponseMode validates the response_mode of the authorization request,
+// resolving the default one of the response type
+func (s *Server) parseResponseMode(responseMode string, responseType AuthorizeRequestType) (ResponseMode, error) {
+	mode := ResponseMode(responseMode)
+	switch mode {
+	case "":
+		// https://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseTypesAndModes
+		mode = FRAGMENT
+		if responseType == CODE {
+			mode = QUERY
+		}
+	case JWT:
+		// https://openid.net/specs/oauth-v2-jarm.html#section-2.3.4
+		mode = FRAGMENT_JWT
+		if responseType == CODE {
+			mode = QUERY_JWT
+		}
+	case QUERY, FRAGMENT, FORM_POST, QUERY_JWT, FRAGMENT_JWT, FORM_POST_JWT:
+	default:
+		return "", fmt.Errorf("unsupported response_mode %q", responseMode)
+	}
+
+	// tokens must not be returned in the query
+	if (mode == QUERY || mode == QUERY_JWT) && responseType != CODE {
+		return "", fmt.Errorf("response_mode %q can't be used with response_type %q", mode, responseType)
+	}
+	if mode.IsJWT() && (s.IDTokenSigner == nil || s.Config.Issuer == "") {
+		return "", errors.New("JWT response modes require an IDTokenSigner and issuer")
+	}
+	return mode, nil
+}
+
+// setResponseMode sets how the authorization response parameters are returned
+func (s *Server) setResponseMode(w *Response, ar *AuthorizeRequest) {
+	mode := ar.ResponseMode
+	if mode == "" {
+		mode = FRAGMENT
+		if ar.Type == CODE {
+			mode = QUERY
+		}
+	}
+	w.SetRedirectFragment(mode == FRAGMENT || mode == FRAGMENT_JWT)
+	w.SetRedirectFormPost(mode == FORM_POST || mode == FORM_POST_JWT)
+	w.redirectEncoder = nil
+	if !mode.IsJWT() {
+		return
+	}
+
+	// https://openid.net/specs/oauth-v2-jarm.html#section-2.1
+	clientId := ar.Client.GetId()
+	w.redirectEncoder = func(output ResponseData) (ResponseData, error) {
+		now := s.Now()
+		claims := map[string]interface{}{}
+		for k, v := range output {
+			claims[k] = v
+		}
+		claims["iss"] = s.Config.Issuer
+		claims["aud"] = clientId
+		claims["exp"] = now.Add(time.Duration(s.Config.AuthorizationExpiration) * time.Second).Unix()
+		response, err := s.IDTokenSigner.SignIDToken(claims)
+		if err != nil {
+			return nil, err
+		}
+		return ResponseData{"response": response}, nil
+	}
+}
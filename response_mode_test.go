@@ -0,0 +1,240 @@
+package osin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestResponseMode(t *testing.T) {
+	key := newTestingKey(t, "k1")
+
+	testcases := map[string]struct {
+		ResponseType     AuthorizeRequestType
+		ResponseMode     string
+		NoSigner         bool
+		ExpectedError    string
+		ExpectedMode     ResponseMode
+		ExpectedFragment bool
+		ExpectedFormPost bool
+	}{
+		"code default": {
+			ResponseType: CODE,
+			ExpectedMode: QUERY,
+		},
+		"token default": {
+			ResponseType:     TOKEN,
+			ExpectedMode:     FRAGMENT,
+			ExpectedFragment: true,
+		},
+		"code fragment": {
+			ResponseType:     CODE,
+			ResponseMode:     "fragment",
+			ExpectedMode:     FRAGMENT,
+			ExpectedFragment: true,
+		},
+		"code form_post": {
+			ResponseType:     CODE,
+			ResponseMode:     "form_post",
+			ExpectedMode:     FORM_POST,
+			ExpectedFormPost: true,
+		},
+		"code jwt": {
+			ResponseType: CODE,
+			ResponseMode: "jwt",
+			ExpectedMode: QUERY_JWT,
+		},
+		"token jwt": {
+			ResponseType:     TOKEN,
+			ResponseMode:     "jwt",
+			ExpectedMode:     FRAGMENT_JWT,
+			ExpectedFragment: true,
+		},
+		"code form_post.jwt": {
+			ResponseType:     CODE,
+			ResponseMode:     "form_post.jwt",
+			ExpectedMode:     FORM_POST_JWT,
+			ExpectedFormPost: true,
+		},
+		"token in query": {
+			ResponseType:  TOKEN,
+			ResponseMode:  "query",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"token in query.jwt": {
+			ResponseType:  TOKEN,
+			ResponseMode:  "query.jwt",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"unknown": {
+			ResponseType:  CODE,
+			ResponseMode:  "web_message",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"jwt without signer": {
+			ResponseType:  CODE,
+			ResponseMode:  "jwt",
+			NoSigner:      true,
+			ExpectedError: E_INVALID_REQUEST,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.Issuer = "https://auth.example.com"
+		sconfig.AllowedAuthorizeTypes = AllowedAuthorizeType{CODE, TOKEN}
+		server := NewServer(sconfig, NewTestingStorage())
+		server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+		server.AccessTokenGen = &TestingAccessTokenGen{}
+		if !test.NoSigner {
+			server.IDTokenSigner = &JWTIDTokenSigner{Key: key}
+		}
+		resp := server.NewResponse()
+
+		req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Form = url.Values{
+			"response_type": {string(test.ResponseType)},
+			"response_mode": {test.ResponseMode},
+			"client_id":     {"1234"},
+			"state":         {"a"},
+		}
+
+		if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+			if ar.ResponseMode != test.ExpectedMode {
+				t.Errorf("%s: expected mode %q, got %q", k, test.ExpectedMode, ar.ResponseMode)
+			}
+			ar.Authorized = true
+			server.FinishAuthorizeRequest(resp, req, ar)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			continue
+		}
+		if resp.RedirectInFragment != test.ExpectedFragment || resp.RedirectInFormPost != test.ExpectedFormPost {
+			t.Errorf("%s: unexpected fragment %v, form post %v", k, resp.RedirectInFragment, resp.RedirectInFormPost)
+		}
+
+		output, err := resp.GetRedirectOutput()
+		if err != nil {
+			t.Errorf("%s: %v", k, err)
+			continue
+		}
+		if !test.ExpectedMode.IsJWT() {
+			if output["state"] != "a" {
+				t.Errorf("%s: unexpected output %v", k, output)
+			}
+			continue
+		}
+
+		// https://openid.net/specs/oauth-v2-jarm.html#section-2.1
+		response, _ := output["response"].(string)
+		if len(output) != 1 || response == "" {
+			t.Errorf("%s: expected a response JWT, got %v", k, output)
+			continue
+		}
+		claims, err := parseJWT(response, publicKeySet(key).Keys)
+		if err != nil {
+			t.Errorf("%s: %v", k, err)
+			continue
+		}
+		if claims.Issuer != "https://auth.example.com" || !claims.HasAudience("1234") || claims.ExpiresAt.IsZero() {
+			t.Errorf("%s: unexpected claims %+v", k, claims)
+		}
+		if state, _ := stringClaim(claims.Claims, "state"); state != "a" {
+			t.Errorf("%s: unexpected state %q", k, state)
+		}
+	}
+}
+
+func TestResponseModeError(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	server := NewServer(sconfig, NewTestingStorage())
+	server.IDTokenSigner = &JWTIDTokenSigner{Key: key}
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = url.Values{
+		"response_type": {string(CODE)},
+		"response_mode": {"jwt"},
+		"client_id":     {"1234"},
+		"state":         {"a"},
+	}
+
+	// errors are returned in the response JWT too
+	if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+		server.FinishAuthorizeRequest(resp, req, ar)
+	}
+	if resp.ErrorId != E_ACCESS_DENIED {
+		t.Fatalf("Expected error %q, got %q", E_ACCESS_DENIED, resp.ErrorId)
+	}
+	u, err := resp.GetRedirectUrl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Query().Get("error") != "" {
+		t.Fatalf("Error should not be returned in the clear: %s", u)
+	}
+	claims, err := parseJWT(parsed.Query().Get("response"), publicKeySet(key).Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := stringClaim(claims.Claims, "error"); e != E_ACCESS_DENIED {
+		t.Errorf("Unexpected error claim %q", e)
+	}
+}
+
+func TestOutputFormPost(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+
+	r := NewResponse(NewTestingStorage())
+	r.SetRedirect("http://localhost:14000/cb?a=1")
+	r.SetRedirectFormPost(true)
+	r.Output["code"] = "1234"
+	r.Output["state"] = `"><script>`
+
+	if err = OutputJSON(r, w, req); err != nil {
+		t.Fatalf("Error outputting form post: %s", err)
+	}
+
+	if w.Code != 200 {
+		t.Fatalf("Invalid response code for output: %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Fatalf("Unexpected Content-Type %q", ct)
+	}
+	if w.Header().Get("Location") != "" {
+		t.Fatalf("Form post should not redirect")
+	}
+	body := w.Body.String()
+	for _, s := range []string{
+		`action="http://localhost:14000/cb?a=1"`,
+		`<input type="hidden" name="code" value="1234"/>`,
+		`<input type="hidden" name="state" value="&#34;&gt;&lt;script&gt;"/>`,
+	} {
+		if !strings.Contains(body, s) {
+			t.Errorf("Expected %s in the form:\n%s", s, body)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+)
+
+// TokenTypeHint is the type for OAuth param `token_type_hint`
+type TokenTypeHint string
+
+const (
+	ACCESS_TOKEN_HINT  TokenTypeHint = "access_token"
+	REFRESH_TOKEN_HINT TokenTypeHint = "refresh_token"
+)
+
+// RevokeRequest is a request to revoke an access or refresh token
+// as described in rfc7009
+type RevokeRequest struct {
+	Token         string
+	TokenTypeHint TokenTypeHint
+	Client        Client
+
+	// AccessData associated with Token. Nil if the token is unknown,
+	// in which case nothing is revoked but the request still succeeds.
+	AccessData *AccessData
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// HandleRevocationRequest is the http.HandlerFunc for handling token
+// revocation requests (https://tools.ietf.org/html/rfc7009)
+func (s *Server) HandleRevocationRequest(w *Response, r *http.Request) *RevokeRequest {
+	bindContext(w, r)
+
+	// Only allow POST
+	if r.Method != "POST" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, errors.New("Request must be POST"), "revocation_request=%s", "request must be POST")
+		return nil
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "revocation_request=%s", "parsing error")
+		return nil
+	}
+
+	// get client authentication
+	auth := s.getClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+
+	ret := &RevokeRequest{
+		Token:         r.FormValue("token"),
+		TokenTypeHint: TokenTypeHint(r.FormValue("token_type_hint")),
+		HttpRequest:   r,
+	}
+
+	// "token" is required
+	if ret.Token == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "revocation_request=%s", "token is required")
+		return nil
+	}
+
+	// must have a valid client
+	if ret.Client = s.getClient(auth, w.Storage, w); ret.Client == nil {
+		return nil
+	}
+
+	// look up the token, trying the hinted type first
+	// https://tools.ietf.org/html/rfc7009#section-2.1
+	ret.AccessData, _, err = s.loadTokenByHint(w.Storage, ret.Token, ret.TokenTypeHint)
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "revocation_request=%s", "error loading token")
+		return nil
+	}
+
+	// unknown tokens are not an error
+	// https://tools.ietf.org/html/rfc7009#section-2.2
+	if ret.AccessData == nil {
+		return ret
+	}
+
+	// token must be from the client
+	if ret.AccessData.Client == nil || ret.AccessData.Client.GetId() != ret.Client.GetId() {
+		s.setErrorAndLog(w, E_UNAUTHORIZED_CLIENT, errors.New("Token was issued to another client"), "revocation_request=%s", "client mismatch")
+		return nil
+	}
+
+	return ret
+}
+
+// FinishRevocationRequest revokes the token found by HandleRevocationRequest.
+// Both the access token and its paired refresh token are removed.
+func (s *Server) FinishRevocationRequest(w *Response, r *http.Request, rr *RevokeRequest) {
+	bindContext(w, r)
+
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	if rr.AccessData == nil {
+		return
+	}
+
+	if rr.AccessData.RefreshToken != "" {
+		if err := w.Storage.RemoveRefresh(rr.AccessData.RefreshToken); err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_revocation_request=%s", "error removing refresh token")
+			return
+		}
+	}
+	if rr.AccessData.AccessToken != "" {
+		if err := w.Storage.RemoveAccess(rr.AccessData.AccessToken); err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_revocation_request=%s", "error removing access token")
+			return
+		}
+	}
+}
+
+// loadTokenByHint looks up a token as an access token and as a refresh token,
+// in the order suggested by hint. Returns the AccessData and the type the token
+// was found as, or nil AccessData if the token is not found or expired.
+func (s *Server) loadTokenByHint(storage Storage, token string, hint TokenTypeHint) (*AccessData, TokenTypeHint, error) {
+	types := []TokenTypeHint{ACCESS_TOKEN_HINT, REFRESH_TOKEN_HINT}
+	if hint == REFRESH_TOKEN_HINT {
+		types = []TokenTypeHint{REFRESH_TOKEN_HINT, ACCESS_TOKEN_HINT}
+	}
+
+	for _, t := range types {
+		var ret *AccessData
+		var err error
+		if t == REFRESH_TOKEN_HINT {
+			ret, err = storage.LoadRefresh(token)
+		} else {
+			ret, err = storage.LoadAccess(token)
+		}
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if ret != nil {
+			return ret, t, nil
+		}
+	}
+	return nil, "", nil
+}
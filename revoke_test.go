@@ -0,0 +1,129 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newRevocationTestServer() *Server {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.Storage.SaveAccess(&AccessData{
+		Client:       &DefaultClient{Id: "1234"},
+		AccessToken:  "revoke-access",
+		RefreshToken: "revoke-refresh",
+		ExpiresIn:    3600,
+		CreatedAt:    time.Now(),
+	})
+	return server
+}
+
+func newRevocationRequest(t *testing.T, token, hint string) *http.Request {
+	req, err := http.NewRequest("POST", "http://localhost:14000/revoke", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+
+	req.Form = make(url.Values)
+	req.Form.Set("token", token)
+	if hint != "" {
+		req.Form.Set("token_type_hint", hint)
+	}
+	req.PostForm = make(url.Values)
+	return req
+}
+
+func TestRevocation(t *testing.T) {
+	testcases := map[string]struct {
+		Token string
+		Hint  string
+	}{
+		"access token":               {Token: "revoke-access"},
+		"access token, hinted":       {Token: "revoke-access", Hint: "access_token"},
+		"refresh token":              {Token: "revoke-refresh"},
+		"refresh token, hinted":      {Token: "revoke-refresh", Hint: "refresh_token"},
+		"refresh token, wrong hint":  {Token: "revoke-refresh", Hint: "access_token"},
+		"access token, unknown hint": {Token: "revoke-access", Hint: "unknown"},
+	}
+
+	for k, test := range testcases {
+		server := newRevocationTestServer()
+		resp := server.NewResponse()
+		req := newRevocationRequest(t, test.Token, test.Hint)
+
+		if rr := server.HandleRevocationRequest(resp, req); rr != nil {
+			server.FinishRevocationRequest(resp, req, rr)
+		}
+
+		if resp.IsError {
+			t.Errorf("%s: unexpected error: %v, %v", k, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if resp.StatusCode != 200 {
+			t.Errorf("%s: unexpected status code: %d", k, resp.StatusCode)
+		}
+		if _, err := server.Storage.LoadAccess("revoke-access"); err != ErrNotFound {
+			t.Errorf("%s: access token was not removed", k)
+		}
+		if _, err := server.Storage.LoadRefresh("revoke-refresh"); err != ErrNotFound {
+			t.Errorf("%s: refresh token was not removed", k)
+		}
+	}
+}
+
+func TestRevocationUnknownToken(t *testing.T) {
+	server := newRevocationTestServer()
+	resp := server.NewResponse()
+	req := newRevocationRequest(t, "unknown-token", "")
+
+	if rr := server.HandleRevocationRequest(resp, req); rr != nil {
+		server.FinishRevocationRequest(resp, req, rr)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v", resp.ErrorId)
+	}
+	if resp.StatusCode != 200 {
+		t.Fatalf("Unexpected status code: %d", resp.StatusCode)
+	}
+}
+
+func TestRevocationOtherClient(t *testing.T) {
+	server := newRevocationTestServer()
+	server.Storage.SaveAccess(&AccessData{
+		Client:      &DefaultClient{Id: "other"},
+		AccessToken: "other-access",
+		ExpiresIn:   3600,
+		CreatedAt:   time.Now(),
+	})
+	resp := server.NewResponse()
+	req := newRevocationRequest(t, "other-access", "")
+
+	if rr := server.HandleRevocationRequest(resp, req); rr != nil {
+		server.FinishRevocationRequest(resp, req, rr)
+	}
+
+	if !resp.IsError || resp.ErrorId != E_UNAUTHORIZED_CLIENT {
+		t.Fatalf("Expected error %v, got %v", E_UNAUTHORIZED_CLIENT, resp.ErrorId)
+	}
+	if _, err := server.Storage.LoadAccess("other-access"); err != nil {
+		t.Fatalf("token incorrectly deleted: %s", err)
+	}
+}
+
+func TestRevocationRequiresClientAuth(t *testing.T) {
+	server := newRevocationTestServer()
+	resp := server.NewResponse()
+	req := newRevocationRequest(t, "revoke-access", "")
+	req.Header.Del("Authorization")
+
+	if rr := server.HandleRevocationRequest(resp, req); rr != nil {
+		t.Fatalf("Should not return a request without client authentication")
+	}
+
+	if !resp.IsError || resp.ErrorId != E_INVALID_REQUEST {
+		t.Fatalf("Expected error %v, got %v", E_INVALID_REQUEST, resp.ErrorId)
+	}
+}
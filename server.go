@@ -2,6 +2,8 @@ package osin
 
 import (
 	"time"
+
+	"gopkg.in/square/go-jose.v1"
 )
 
 // Server is an OAuth2 implementation
@@ -10,8 +12,36 @@ type Server struct {
 	Storage           Storage
 	AuthorizeTokenGen AuthorizeTokenGen
 	AccessTokenGen    AccessTokenGen
+	DeviceCodeGen     DeviceCodeGen
 	Now               func() time.Time
 	Logger            Logger
+
+	// Issuers of the JWTs accepted by the JWT bearer grant (rfc7523)
+	TrustedIssuers TrustedIssuers
+
+	// Optional server nonces DPoP proofs must include (rfc9449)
+	DPoPNonces DPoPNonces
+
+	// Optional fetcher of request objects passed by reference (rfc9101)
+	RequestObjectFetcher RequestObjectFetcher
+
+	// Optional private keys to decrypt encrypted request objects with (rfc9101)
+	DecryptionKeys *jose.JsonWebKeySet
+
+	// Optional signer and end-user claims of OpenID Connect ID tokens, issued
+	// when the openid scope is granted. Both are required to issue ID tokens.
+	IDTokenSigner IDTokenSigner
+	IDTokenClaims IDTokenClaimsProvider
+
+	// Optional end-user claims served by HandleUserInfoRequest (OpenID Connect)
+	UserInfoClaims UserInfoClaimsProvider
+
+	// Optional policy validating the redirect URIs of requests, PrefixRedirectURIValidator
+	// by default. Clients can select their own by implementing ClientRedirectURIValidator.
+	RedirectURIValidator RedirectURIValidator
+
+	// Optional issuers of the software statements accepted by dynamic client registration (rfc7591)
+	SoftwareStatementIssuers TrustedIssuers
 }
 
 // NewServer creates a new server instance
@@ -21,6 +51,7 @@ func NewServer(config *ServerConfig, storage Storage) *Server {
 		Storage:           storage,
 		AuthorizeTokenGen: &AuthorizeTokenGenDefault{},
 		AccessTokenGen:    &AccessTokenGenDefault{},
+		DeviceCodeGen:     &DeviceCodeGenDefault{},
 		Now:               time.Now,
 		Logger:            &LoggerDefault{},
 	}
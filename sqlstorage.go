@@ -0,0 +1,1115 @@
+package osin
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// SQLDialect adapts the statements of a SQLStorage to a database
+type SQLDialect interface {
+	// Placeholder returns the placeholder of the n-th argument of a statement, starting at 1
+	Placeholder(n int) string
+
+	// Upsert returns a statement inserting a row in a table, or updating its columns if a
+	// row with the same keys exists. The arguments are the keys followed by the columns.
+	Upsert(table string, keys, columns []string) string
+}
+
+// SQLiteDialect is the SQLDialect of SQLite
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (d SQLiteDialect) Upsert(table string, keys, columns []string) string {
+	return sqlInsert(d, table, keys, columns) + sqlOnConflict(keys, columns, "excluded")
+}
+
+// PostgresDialect is the SQLDialect of PostgreSQL
+type PostgresDialect struct{}
+
+func (PostgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (d PostgresDialect) Upsert(table string, keys, columns []string) string {
+	return sqlInsert(d, table, keys, columns) + sqlOnConflict(keys, columns, "EXCLUDED")
+}
+
+// MySQLDialect is the SQLDialect of MySQL and MariaDB
+type MySQLDialect struct{}
+
+func (MySQLDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (d MySQLDialect) Upsert(table string, keys, columns []string) string {
+	var set []string
+	for _, c := range columns {
+		set = append(set, c+" = VALUES("+c+")")
+	}
+	return sqlInsert(d, table, keys, columns) + " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+// UserDataSerializer converts the UserData of clients, authorizations and tokens
+// to and from the text stored by a SQLStorage
+type UserDataSerializer interface {
+	SerializeUserData(data interface{}) (string, error)
+	DeserializeUserData(data string) (interface{}, error)
+}
+
+// JSONUserDataSerializer stores UserData as JSON
+type JSONUserDataSerializer struct {
+	// New returns a pointer to the value UserData is decoded into,
+	// by default generic JSON values (map[string]interface{}, ...)
+	New func() interface{}
+}
+
+func (s JSONUserDataSerializer) SerializeUserData(data interface{}) (string, error) {
+	b, err := json.Marshal(data)
+	return string(b), err
+}
+
+func (s JSONUserDataSerializer) DeserializeUserData(data string) (interface{}, error) {
+	if s.New == nil {
+		var v interface{}
+		err := json.Unmarshal([]byte(data), &v)
+		return v, err
+	}
+	v := s.New()
+	err := json.Unmarshal([]byte(data), v)
+	return v, err
+}
+
+// SQLStorageConfig is the configuration of a SQLStorage
+type SQLStorageConfig struct {
+	// Dialect of the database (default SQLiteDialect)
+	Dialect SQLDialect
+
+	// Prefix of the table names (default "osin_")
+	TablePrefix string
+
+	// Serialization of UserData (default JSONUserDataSerializer)
+	UserData UserDataSerializer
+
+	// TranslateError converts the errors of the driver to storage errors, such as ErrConflict
+	// or ErrTemporarilyUnavailable. By default, connection errors are ErrTemporarilyUnavailable.
+	TranslateError func(err error) error
+
+	// Now returns the current time (default time.Now)
+	Now func() time.Time
+}
+
+// NewSQLStorageConfig returns a SQLStorageConfig with the default values
+func NewSQLStorageConfig() *SQLStorageConfig {
+	return &SQLStorageConfig{
+		Dialect:        SQLiteDialect{},
+		TablePrefix:    "osin_",
+		UserData:       JSONUserDataSerializer{},
+		TranslateError: translateSQLError,
+		Now:            time.Now,
+	}
+}
+
+// sqlQuerier is a database or a transaction
+type sqlQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// SQLStorage is a ContextStorage using a database/sql database, use NewStorage to use it
+// with a Server. Clients are loaded as DefaultClient. Migrate creates or updates its schema.
+// It implements the context-aware versions of all the optional storage interfaces.
+type SQLStorage struct {
+	db     *sql.DB
+	config SQLStorageConfig
+}
+
+// NewSQLStorage creates a new storage using db
+func NewSQLStorage(db *sql.DB, config *SQLStorageConfig) *SQLStorage {
+	s := &SQLStorage{db: db, config: *config}
+	defaults := NewSQLStorageConfig()
+	if s.config.Dialect == nil {
+		s.config.Dialect = defaults.Dialect
+	}
+	if s.config.UserData == nil {
+		s.config.UserData = defaults.UserData
+	}
+	if s.config.TranslateError == nil {
+		s.config.TranslateError = defaults.TranslateError
+	}
+	if s.config.Now == nil {
+		s.config.Now = defaults.Now
+	}
+	return s
+}
+
+// sqlMigration is a version of the schema of a SQLStorage.
+// Released migrations must never change, add new ones instead.
+type sqlMigration struct {
+	version    int
+	statements func(prefix string) []string
+}
+
+var sqlMigrations = []sqlMigration{
+	{
+		version: 1,
+		statements: func(p string) []string {
+			return []string{
+				`CREATE TABLE ` + p + `client (
+	id VARCHAR(255) NOT NULL PRIMARY KEY,
+	secret VARCHAR(255) NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	redirect_uris TEXT NOT NULL,
+	user_data TEXT,
+	json_web_keys TEXT NOT NULL,
+	tls_client_auth TEXT NOT NULL,
+	allowed_access_types TEXT NOT NULL,
+	allowed_authorize_types TEXT NOT NULL,
+	allowed_scopes TEXT NOT NULL,
+	token_endpoint_auth_method VARCHAR(255) NOT NULL,
+	access_expiration INTEGER NOT NULL,
+	authorization_expiration INTEGER NOT NULL
+)`,
+				`CREATE TABLE ` + p + `authorize (
+	code VARCHAR(255) NOT NULL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL,
+	expires_in INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	state TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT,
+	code_challenge VARCHAR(255) NOT NULL,
+	code_challenge_method VARCHAR(255) NOT NULL,
+	nonce TEXT NOT NULL,
+	max_age VARCHAR(255) NOT NULL,
+	prompt VARCHAR(255) NOT NULL,
+	acr_values TEXT NOT NULL,
+	claims TEXT NOT NULL
+)`,
+				`CREATE TABLE ` + p + `access (
+	access_token VARCHAR(255) NOT NULL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL,
+	refresh_token VARCHAR(255) NOT NULL,
+	expires_in INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT,
+	subject VARCHAR(255) NOT NULL,
+	actors TEXT NOT NULL,
+	audience TEXT NOT NULL,
+	certificate_thumbprint VARCHAR(255) NOT NULL,
+	token_type VARCHAR(255) NOT NULL,
+	jwk_thumbprint VARCHAR(255) NOT NULL,
+	grant_id VARCHAR(255) NOT NULL
+)`,
+				`CREATE TABLE ` + p + `refresh (
+	token VARCHAR(255) NOT NULL PRIMARY KEY,
+	access_token VARCHAR(255) NOT NULL
+)`,
+				`CREATE TABLE ` + p + `redeemed (
+	code VARCHAR(255) NOT NULL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL,
+	grant_id VARCHAR(255) NOT NULL,
+	redeemed_at BIGINT NOT NULL,
+	expire_at BIGINT NOT NULL
+)`,
+				`CREATE TABLE ` + p + `rotated (
+	token VARCHAR(255) NOT NULL PRIMARY KEY,
+	rotated_at BIGINT NOT NULL,
+	access_token VARCHAR(255) NOT NULL,
+	client_id VARCHAR(255) NOT NULL,
+	refresh_token VARCHAR(255) NOT NULL,
+	expires_in INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT,
+	subject VARCHAR(255) NOT NULL,
+	actors TEXT NOT NULL,
+	audience TEXT NOT NULL,
+	certificate_thumbprint VARCHAR(255) NOT NULL,
+	token_type VARCHAR(255) NOT NULL,
+	jwk_thumbprint VARCHAR(255) NOT NULL,
+	grant_id VARCHAR(255) NOT NULL
+)`,
+				`CREATE TABLE ` + p + `jti (
+	jti VARCHAR(255) NOT NULL PRIMARY KEY,
+	expire_at BIGINT NOT NULL
+)`,
+				`CREATE TABLE ` + p + `device (
+	device_code VARCHAR(255) NOT NULL PRIMARY KEY,
+	user_code VARCHAR(255) NOT NULL,
+	client_id VARCHAR(255) NOT NULL,
+	expires_in INTEGER NOT NULL,
+	poll_interval INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	status VARCHAR(255) NOT NULL,
+	created_at BIGINT NOT NULL,
+	last_polled_at BIGINT NOT NULL,
+	user_data TEXT
+)`,
+				`CREATE TABLE ` + p + `pushed (
+	request_uri VARCHAR(255) NOT NULL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL,
+	params TEXT NOT NULL,
+	expires_in INTEGER NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT
+)`,
+				`CREATE TABLE ` + p + `registration (
+	client_id VARCHAR(255) NOT NULL PRIMARY KEY,
+	metadata TEXT NOT NULL,
+	software_statement TEXT NOT NULL,
+	registration_access_token VARCHAR(255) NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT
+)`,
+				`CREATE INDEX ` + p + `access_client_id ON ` + p + `access (client_id)`,
+				`CREATE INDEX ` + p + `access_grant_id ON ` + p + `access (grant_id)`,
+				`CREATE INDEX ` + p + `refresh_access_token ON ` + p + `refresh (access_token)`,
+				`CREATE INDEX ` + p + `rotated_grant_id ON ` + p + `rotated (grant_id)`,
+				`CREATE INDEX ` + p + `jti_expire_at ON ` + p + `jti (expire_at)`,
+				`CREATE UNIQUE INDEX ` + p + `device_user_code ON ` + p + `device (user_code)`,
+			}
+		},
+	},
+}
+
+var (
+	sqlClientColumns = []string{"id", "secret", "redirect_uri", "redirect_uris", "user_data", "json_web_keys", "tls_client_auth",
+		"allowed_access_types", "allowed_authorize_types", "allowed_scopes", "token_endpoint_auth_method", "access_expiration", "authorization_expiration"}
+
+	sqlAuthorizeColumns = []string{"code", "client_id", "expires_in", "scope", "redirect_uri", "state", "created_at", "user_data",
+		"code_challenge", "code_challenge_method", "nonce", "max_age", "prompt", "acr_values", "claims"}
+
+	sqlAccessColumns = []string{"access_token", "client_id", "refresh_token", "expires_in", "scope", "redirect_uri", "created_at", "user_data",
+		"subject", "actors", "audience", "certificate_thumbprint", "token_type", "jwk_thumbprint", "grant_id"}
+
+	sqlRedeemedColumns = []string{"code", "client_id", "grant_id", "redeemed_at", "expire_at"}
+
+	sqlDeviceColumns = []string{"device_code", "user_code", "client_id", "expires_in", "poll_interval", "scope", "status", "created_at",
+		"last_polled_at", "user_data"}
+
+	sqlPushedColumns = []string{"request_uri", "client_id", "params", "expires_in", "created_at", "user_data"}
+
+	sqlRegistrationColumns = []string{"client_id", "metadata", "software_statement", "registration_access_token", "created_at", "user_data"}
+)
+
+// Migrate creates the tables of the storage, or applies the migrations of its schema
+// not applied yet
+func (s *SQLStorage) Migrate(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+s.table("migration")+` (
+	version INTEGER NOT NULL PRIMARY KEY,
+	applied_at BIGINT NOT NULL
+)`); err != nil {
+		return s.error(err)
+	}
+
+	version, err := s.SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	for _, m := range sqlMigrations {
+		if m.version <= version {
+			continue
+		}
+		if err = s.transaction(ctx, func(tx *sql.Tx) error {
+			for _, statement := range m.statements(s.config.TablePrefix) {
+				if _, err := tx.ExecContext(ctx, statement); err != nil {
+					return fmt.Errorf("migration %d: %w", m.version, err)
+				}
+			}
+			_, err := tx.ExecContext(ctx, sqlInsert(s.config.Dialect, s.table("migration"), []string{"version", "applied_at"}, nil),
+				m.version, sqlTime(s.config.Now()))
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SchemaVersion returns the version of the last migration applied, 0 if none
+func (s *SQLStorage) SchemaVersion(ctx context.Context) (int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT version FROM `+s.table("migration"))
+	if err != nil {
+		return 0, s.error(err)
+	}
+	defer rows.Close()
+
+	version := 0
+	for rows.Next() {
+		var v int
+		if err = rows.Scan(&v); err != nil {
+			return 0, s.error(err)
+		}
+		if v > version {
+			version = v
+		}
+	}
+	return version, s.error(rows.Err())
+}
+
+// Clone returns the storage itself, sql.DB is safe for concurrent use
+func (s *SQLStorage) Clone() ContextStorage {
+	return s
+}
+
+// Close does nothing, the database is closed by its owner
+func (s *SQLStorage) Close() {
+}
+
+// GetClient loads the client by id
+func (s *SQLStorage) GetClient(ctx context.Context, id string) (Client, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+strings.Join(sqlClientColumns, ", ")+` FROM `+s.table("client")+
+		` WHERE id = `+s.config.Dialect.Placeholder(1), id)
+	client, err := s.scanClient(row.Scan, nil)
+	if err != nil {
+		return nil, s.notFound(err)
+	}
+	return client, nil
+}
+
+// SetClient creates or updates a client. Clients are loaded as DefaultClient: the settings of
+// other implementations can't be stored, only the fields of the Client interface and
+// ClientRedirectUris, and an error is returned if they implement other client interfaces.
+// The RedirectURIValidator of a DefaultClient can't be stored either.
+func (s *SQLStorage) SetClient(ctx context.Context, client Client) error {
+	values, err := s.clientValues(client)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, s.config.Dialect.Upsert(s.table("client"), sqlClientColumns[:1], sqlClientColumns[1:]), values...)
+	return s.error(err)
+}
+
+// clientValues returns the values of the client columns of a client
+func (s *SQLStorage) clientValues(client Client) ([]interface{}, error) {
+	c, ok := client.(*DefaultClient)
+	if !ok {
+		switch client.(type) {
+		case ClientAssertionKeys, ClientCertificateMatcher, ClientRedirectURIValidator, ClientGrantTypes, ClientResponseTypes,
+			ClientScopes, ClientAuthMethod, ClientTokenLifetimes, ClientType:
+			return nil, fmt.Errorf("SQLStorage can't store the settings of clients of type %T, use DefaultClient", client)
+		}
+		c = &DefaultClient{}
+		c.CopyFrom(client)
+		if uris, ok := client.(ClientRedirectUris); ok {
+			c.RedirectUris = uris.GetRedirectUris()
+		}
+	}
+	if c.RedirectURIValidator != nil {
+		return nil, errors.New("SQLStorage can't store the RedirectURIValidator of clients")
+	}
+
+	// nil lists allow everything, unlike empty ones
+	var encoded []string
+	for _, v := range []interface{}{nonNilStrings(c.RedirectUris), c.JsonWebKeys, c.TLSClientAuth, c.AllowedAccessTypes, c.AllowedAuthorizeTypes, c.AllowedScopes} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, string(b))
+	}
+	userData, err := s.serialize(c.UserData)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{c.Id, c.Secret, c.RedirectUri, encoded[0], userData, encoded[1], encoded[2],
+		encoded[3], encoded[4], encoded[5], c.TokenEndpointAuthMethod, c.AccessExpiration, c.AuthorizationExpiration}, nil
+}
+
+// RemoveClient deletes a client
+func (s *SQLStorage) RemoveClient(ctx context.Context, id string) error {
+	return s.remove(ctx, "client", "id", id)
+}
+
+// SaveAuthorize saves authorize data
+func (s *SQLStorage) SaveAuthorize(ctx context.Context, data *AuthorizeData) error {
+	userData, err := s.serialize(data.UserData)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, sqlInsert(s.config.Dialect, s.table("authorize"), sqlAuthorizeColumns, nil),
+		data.Code, data.Client.GetId(), data.ExpiresIn, data.Scope, data.RedirectUri, data.State, data.CreatedAt.UnixNano(), userData,
+		data.CodeChallenge, data.CodeChallengeMethod, data.Nonce, data.MaxAge, data.Prompt, data.AcrValues, data.Claims)
+	return s.error(err)
+}
+
+// LoadAuthorize looks up AuthorizeData by a code, with its client
+func (s *SQLStorage) LoadAuthorize(ctx context.Context, code string) (*AuthorizeData, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sqlColumns("a", sqlAuthorizeColumns)+`, `+sqlColumns("c", sqlClientColumns)+
+		` FROM `+s.table("authorize")+` a JOIN `+s.table("client")+` c ON c.id = a.client_id`+
+		` WHERE a.code = `+s.config.Dialect.Placeholder(1), code)
+
+	var data AuthorizeData
+	var clientId string
+	var createdAt int64
+	var userData sql.NullString
+	client, err := s.scanClient(row.Scan, []interface{}{&data.Code, &clientId, &data.ExpiresIn, &data.Scope, &data.RedirectUri, &data.State,
+		&createdAt, &userData, &data.CodeChallenge, &data.CodeChallengeMethod, &data.Nonce, &data.MaxAge, &data.Prompt, &data.AcrValues, &data.Claims})
+	if err != nil {
+		return nil, s.notFound(err)
+	}
+	data.Client = client
+	data.CreatedAt = time.Unix(0, createdAt)
+	if data.UserData, err = s.deserialize(userData); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// RemoveAuthorize deletes the authorization code
+func (s *SQLStorage) RemoveAuthorize(ctx context.Context, code string) error {
+	return s.remove(ctx, "authorize", "code", code)
+}
+
+// SaveAccess saves access data, and its refresh token
+func (s *SQLStorage) SaveAccess(ctx context.Context, data *AccessData) error {
+	values, err := s.accessValues(data)
+	if err != nil {
+		return err
+	}
+
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, sqlInsert(s.config.Dialect, s.table("access"), sqlAccessColumns, nil), values...); err != nil {
+			return err
+		}
+		if data.RefreshToken == "" {
+			return nil
+		}
+		_, err := tx.ExecContext(ctx, sqlInsert(s.config.Dialect, s.table("refresh"), []string{"token", "access_token"}, nil),
+			data.RefreshToken, data.AccessToken)
+		return err
+	})
+}
+
+// accessValues returns the values of the access columns of access data
+func (s *SQLStorage) accessValues(data *AccessData) ([]interface{}, error) {
+	userData, err := s.serialize(data.UserData)
+	if err != nil {
+		return nil, err
+	}
+	actors, err := json.Marshal(nonNilStrings(data.Actors))
+	if err != nil {
+		return nil, err
+	}
+	audience, err := json.Marshal(nonNilStrings(data.Audience))
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{data.AccessToken, data.Client.GetId(), data.RefreshToken, data.ExpiresIn, data.Scope, data.RedirectUri,
+		data.CreatedAt.UnixNano(), userData, data.Subject, string(actors), string(audience), data.CertificateThumbprint, data.TokenType,
+		data.JWKThumbprint, data.GrantId}, nil
+}
+
+// LoadAccess retrieves access data by token, with its client
+func (s *SQLStorage) LoadAccess(ctx context.Context, token string) (*AccessData, error) {
+	return s.loadAccess(ctx, s.table("access")+` a JOIN `+s.table("client")+` c ON c.id = a.client_id`,
+		`a.access_token`, token, nil)
+}
+
+// RemoveAccess deletes an access token, and the refresh token issued with it
+func (s *SQLStorage) RemoveAccess(ctx context.Context, token string) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := s.removeTx(ctx, tx, "refresh", "access_token", token); err != nil {
+			return err
+		}
+		_, err := s.removeTx(ctx, tx, "access", "access_token", token)
+		return err
+	})
+}
+
+// LoadRefresh retrieves the access data of a refresh token, with its client
+func (s *SQLStorage) LoadRefresh(ctx context.Context, token string) (*AccessData, error) {
+	return s.loadAccess(ctx, s.table("refresh")+` r JOIN `+s.table("access")+` a ON a.access_token = r.access_token JOIN `+
+		s.table("client")+` c ON c.id = a.client_id`, `r.token`, token, nil)
+}
+
+// RemoveRefresh deletes a refresh token
+func (s *SQLStorage) RemoveRefresh(ctx context.Context, token string) error {
+	return s.remove(ctx, "refresh", "token", token)
+}
+
+// ConsumeAuthorize atomically deletes the authorization code and records its redemption
+func (s *SQLStorage) ConsumeAuthorize(ctx context.Context, data *RedeemedAuthorizeData) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		// only one of concurrent redemptions deletes the code
+		if removed, err := s.removeTx(ctx, tx, "authorize", "code", data.Code); err != nil {
+			return err
+		} else if removed == 0 {
+			return ErrAuthorizeRedeemed
+		}
+		_, err := tx.ExecContext(ctx, s.config.Dialect.Upsert(s.table("redeemed"), sqlRedeemedColumns[:1], sqlRedeemedColumns[1:]),
+			data.Code, data.ClientId, data.GrantId, sqlTime(data.RedeemedAt), sqlTime(data.ExpireAt))
+		return err
+	})
+}
+
+// LoadRedeemedAuthorize looks up the redemption of an authorization code
+func (s *SQLStorage) LoadRedeemedAuthorize(ctx context.Context, code string) (*RedeemedAuthorizeData, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+strings.Join(sqlRedeemedColumns, ", ")+` FROM `+s.table("redeemed")+
+		` WHERE code = `+s.config.Dialect.Placeholder(1), code)
+
+	var data RedeemedAuthorizeData
+	var redeemedAt, expireAt int64
+	if err := row.Scan(&data.Code, &data.ClientId, &data.GrantId, &redeemedAt, &expireAt); err != nil {
+		return nil, s.notFound(err)
+	}
+	data.RedeemedAt = sqlParseTime(redeemedAt)
+	data.ExpireAt = sqlParseTime(expireAt)
+	return &data, nil
+}
+
+// ConsumeRefresh atomically deletes a refresh token and records it as rotated,
+// with the access data it was issued with
+func (s *SQLStorage) ConsumeRefresh(ctx context.Context, data *RotatedRefreshData) error {
+	if data.AccessData == nil {
+		return errors.New("rotated refresh token has no access data")
+	}
+	values, err := s.accessValues(data.AccessData)
+	if err != nil {
+		return err
+	}
+	values[len(values)-1] = data.GrantId // grant_id, the last access column
+
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		// only one of concurrent refreshes deletes the token
+		if removed, err := s.removeTx(ctx, tx, "refresh", "token", data.RefreshToken); err != nil {
+			return err
+		} else if removed == 0 {
+			return ErrNotFound
+		}
+		columns := append([]string{"token", "rotated_at"}, sqlAccessColumns...)
+		_, err := tx.ExecContext(ctx, s.config.Dialect.Upsert(s.table("rotated"), columns[:1], columns[1:]),
+			append([]interface{}{data.RefreshToken, sqlTime(data.RotatedAt)}, values...)...)
+		return err
+	})
+}
+
+// LoadRotatedRefresh looks up a rotated refresh token, with the access data it was issued with
+func (s *SQLStorage) LoadRotatedRefresh(ctx context.Context, token string) (*RotatedRefreshData, error) {
+	var rotatedAt int64
+	access, err := s.loadAccess(ctx, s.table("rotated")+` a JOIN `+s.table("client")+` c ON c.id = a.client_id`,
+		`a.token`, token, []string{"rotated_at"}, &rotatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &RotatedRefreshData{
+		RefreshToken: token,
+		GrantId:      access.GrantId,
+		AccessData:   access,
+		RotatedAt:    sqlParseTime(rotatedAt),
+	}, nil
+}
+
+// RemoveGrant deletes the access and refresh tokens of a grant, and its rotated refresh tokens
+func (s *SQLStorage) RemoveGrant(ctx context.Context, grantId string) error {
+	// tokens issued without a grant are not revoked together
+	if grantId == "" {
+		return nil
+	}
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT access_token FROM `+s.table("access")+
+			` WHERE grant_id = `+s.config.Dialect.Placeholder(1), grantId)
+		if err != nil {
+			return err
+		}
+		var tokens []string
+		for rows.Next() {
+			var token string
+			if err = rows.Scan(&token); err != nil {
+				rows.Close()
+				return err
+			}
+			tokens = append(tokens, token)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for _, token := range tokens {
+			if _, err = s.removeTx(ctx, tx, "refresh", "access_token", token); err != nil {
+				return err
+			}
+		}
+		if _, err = s.removeTx(ctx, tx, "access", "grant_id", grantId); err != nil {
+			return err
+		}
+		_, err = s.removeTx(ctx, tx, "rotated", "grant_id", grantId)
+		return err
+	})
+}
+
+// SaveJTI records a JWT ID until it expires, forever if expireAt is zero.
+// An expired JWT ID can be recorded again, use RemoveExpired to delete them.
+func (s *SQLStorage) SaveJTI(ctx context.Context, jti string, expireAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, sqlInsert(s.config.Dialect, s.table("jti"), []string{"jti", "expire_at"}, nil), jti, sqlTime(expireAt))
+	if err == nil {
+		return nil
+	}
+
+	// the insert of a recorded id violates the primary key, only one of
+	// concurrent requests replaces it once expired
+	result, uerr := s.db.ExecContext(ctx, `UPDATE `+s.table("jti")+` SET expire_at = `+s.config.Dialect.Placeholder(1)+
+		` WHERE jti = `+s.config.Dialect.Placeholder(2)+` AND expire_at > `+s.config.Dialect.Placeholder(3)+
+		` AND expire_at < `+s.config.Dialect.Placeholder(4), sqlTime(expireAt), jti, 0, sqlTime(s.config.Now()))
+	if uerr != nil {
+		return s.error(uerr)
+	}
+	if updated, uerr := result.RowsAffected(); uerr != nil {
+		return s.error(uerr)
+	} else if updated == 1 {
+		return nil
+	}
+
+	var recorded int64
+	row := s.db.QueryRowContext(ctx, `SELECT expire_at FROM `+s.table("jti")+` WHERE jti = `+s.config.Dialect.Placeholder(1), jti)
+	if row.Scan(&recorded) == nil {
+		return ErrJTIReplayed
+	}
+	return s.error(err)
+}
+
+// RemoveExpired deletes the JWT IDs and the redeemed authorization codes which expired.
+// They are not deleted when they are used, call it periodically.
+func (s *SQLStorage) RemoveExpired(ctx context.Context) error {
+	now := sqlTime(s.config.Now())
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		for _, table := range []string{"jti", "redeemed"} {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM `+s.table(table)+` WHERE expire_at > `+s.config.Dialect.Placeholder(1)+
+				` AND expire_at < `+s.config.Dialect.Placeholder(2), 0, now); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveDevice saves a new device authorization
+func (s *SQLStorage) SaveDevice(ctx context.Context, data *DeviceData) error {
+	values, err := s.deviceValues(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, sqlInsert(s.config.Dialect, s.table("device"), sqlDeviceColumns, nil), values...)
+	return s.error(err)
+}
+
+// LoadDevice looks up a device authorization by device code, with its client
+func (s *SQLStorage) LoadDevice(ctx context.Context, deviceCode string) (*DeviceData, error) {
+	return s.loadDevice(ctx, s.db, "device_code", deviceCode)
+}
+
+// LoadDeviceByUserCode looks up a device authorization by user code, with its client
+func (s *SQLStorage) LoadDeviceByUserCode(ctx context.Context, userCode string) (*DeviceData, error) {
+	return s.loadDevice(ctx, s.db, "user_code", userCode)
+}
+
+// UpdateDevice saves changes to a device authorization, unless it was consumed
+func (s *SQLStorage) UpdateDevice(ctx context.Context, data *DeviceData) error {
+	values, err := s.deviceValues(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, sqlUpdate(s.config.Dialect, s.table("device"), sqlDeviceColumns[:1], sqlDeviceColumns[1:]),
+		append(values[1:], values[0])...)
+	return s.error(err)
+}
+
+// ConsumeDevice atomically loads and deletes a device authorization
+func (s *SQLStorage) ConsumeDevice(ctx context.Context, deviceCode string) (*DeviceData, error) {
+	var ret *DeviceData
+	err := s.transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		if ret, err = s.loadDevice(ctx, tx, "device_code", deviceCode); err != nil {
+			return err
+		}
+		// only one of concurrent exchanges deletes the device code
+		if removed, err := s.removeTx(ctx, tx, "device", "device_code", deviceCode); err != nil {
+			return err
+		} else if removed == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// deviceValues returns the values of the device columns of a device authorization
+func (s *SQLStorage) deviceValues(data *DeviceData) ([]interface{}, error) {
+	userData, err := s.serialize(data.UserData)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{data.DeviceCode, data.UserCode, data.Client.GetId(), data.ExpiresIn, data.Interval, data.Scope, string(data.Status),
+		sqlTime(data.CreatedAt), sqlTime(data.LastPolledAt), userData}, nil
+}
+
+func (s *SQLStorage) loadDevice(ctx context.Context, q sqlQuerier, key, value string) (*DeviceData, error) {
+	row := q.QueryRowContext(ctx, `SELECT `+sqlColumns("d", sqlDeviceColumns)+`, `+sqlColumns("c", sqlClientColumns)+
+		` FROM `+s.table("device")+` d JOIN `+s.table("client")+` c ON c.id = d.client_id`+
+		` WHERE d.`+key+` = `+s.config.Dialect.Placeholder(1), value)
+
+	var data DeviceData
+	var clientId, status string
+	var createdAt, lastPolledAt int64
+	var userData sql.NullString
+	client, err := s.scanClient(row.Scan, []interface{}{&data.DeviceCode, &data.UserCode, &clientId, &data.ExpiresIn, &data.Interval, &data.Scope,
+		&status, &createdAt, &lastPolledAt, &userData})
+	if err != nil {
+		return nil, s.notFound(err)
+	}
+	data.Client = client
+	data.Status = DeviceStatus(status)
+	data.CreatedAt = sqlParseTime(createdAt)
+	data.LastPolledAt = sqlParseTime(lastPolledAt)
+	if data.UserData, err = s.deserialize(userData); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// SavePushedAuthorize saves a pushed authorization request
+func (s *SQLStorage) SavePushedAuthorize(ctx context.Context, data *PushedAuthorizeData) error {
+	userData, err := s.serialize(data.UserData)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, sqlInsert(s.config.Dialect, s.table("pushed"), sqlPushedColumns, nil),
+		data.RequestUri, data.Client.GetId(), data.Params.Encode(), data.ExpiresIn, sqlTime(data.CreatedAt), userData)
+	return s.error(err)
+}
+
+// LoadPushedAuthorize looks up a pushed authorization request, with its client
+func (s *SQLStorage) LoadPushedAuthorize(ctx context.Context, requestUri string) (*PushedAuthorizeData, error) {
+	return s.loadPushedAuthorize(ctx, s.db, requestUri)
+}
+
+// ConsumePushedAuthorize atomically loads and deletes a pushed authorization request, with its client
+func (s *SQLStorage) ConsumePushedAuthorize(ctx context.Context, requestUri string) (*PushedAuthorizeData, error) {
+	var ret *PushedAuthorizeData
+	err := s.transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		if ret, err = s.loadPushedAuthorize(ctx, tx, requestUri); err != nil {
+			return err
+		}
+		// only one of concurrent authorization requests deletes the request_uri
+		if removed, err := s.removeTx(ctx, tx, "pushed", "request_uri", requestUri); err != nil {
+			return err
+		} else if removed == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func (s *SQLStorage) loadPushedAuthorize(ctx context.Context, q sqlQuerier, requestUri string) (*PushedAuthorizeData, error) {
+	row := q.QueryRowContext(ctx, `SELECT `+sqlColumns("p", sqlPushedColumns)+`, `+sqlColumns("c", sqlClientColumns)+
+		` FROM `+s.table("pushed")+` p JOIN `+s.table("client")+` c ON c.id = p.client_id`+
+		` WHERE p.request_uri = `+s.config.Dialect.Placeholder(1), requestUri)
+
+	var data PushedAuthorizeData
+	var clientId, params string
+	var createdAt int64
+	var userData sql.NullString
+	client, err := s.scanClient(row.Scan, []interface{}{&data.RequestUri, &clientId, &params, &data.ExpiresIn, &createdAt, &userData})
+	if err != nil {
+		return nil, s.notFound(err)
+	}
+	data.Client = client
+	data.CreatedAt = sqlParseTime(createdAt)
+	if data.Params, err = url.ParseQuery(params); err != nil {
+		return nil, err
+	}
+	if data.UserData, err = s.deserialize(userData); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// SaveClientRegistration creates or updates a registered client and its registration
+func (s *SQLStorage) SaveClientRegistration(ctx context.Context, data *ClientRegistration) error {
+	client, err := s.clientValues(data.Client)
+	if err != nil {
+		return err
+	}
+	metadata, err := json.Marshal(data.Metadata)
+	if err != nil {
+		return err
+	}
+	userData, err := s.serialize(data.UserData)
+	if err != nil {
+		return err
+	}
+
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, s.config.Dialect.Upsert(s.table("client"), sqlClientColumns[:1], sqlClientColumns[1:]), client...); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, s.config.Dialect.Upsert(s.table("registration"), sqlRegistrationColumns[:1], sqlRegistrationColumns[1:]),
+			data.Client.GetId(), string(metadata), data.SoftwareStatement, data.RegistrationAccessToken, sqlTime(data.CreatedAt), userData)
+		return err
+	})
+}
+
+// LoadClientRegistration looks up the registration of a client, with the client
+func (s *SQLStorage) LoadClientRegistration(ctx context.Context, clientId string) (*ClientRegistration, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sqlColumns("r", sqlRegistrationColumns)+`, `+sqlColumns("c", sqlClientColumns)+
+		` FROM `+s.table("registration")+` r JOIN `+s.table("client")+` c ON c.id = r.client_id`+
+		` WHERE r.client_id = `+s.config.Dialect.Placeholder(1), clientId)
+
+	var data ClientRegistration
+	var metadata string
+	var createdAt int64
+	var userData sql.NullString
+	client, err := s.scanClient(row.Scan, []interface{}{&clientId, &metadata, &data.SoftwareStatement, &data.RegistrationAccessToken,
+		&createdAt, &userData})
+	if err != nil {
+		return nil, s.notFound(err)
+	}
+	data.Client = client
+	data.CreatedAt = sqlParseTime(createdAt)
+	if err = json.Unmarshal([]byte(metadata), &data.Metadata); err != nil {
+		return nil, err
+	}
+	if data.UserData, err = s.deserialize(userData); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// RemoveClientRegistration deletes a registered client and its registration
+func (s *SQLStorage) RemoveClientRegistration(ctx context.Context, clientId string) error {
+	return s.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := s.removeTx(ctx, tx, "registration", "client_id", clientId); err != nil {
+			return err
+		}
+		_, err := s.removeTx(ctx, tx, "client", "id", clientId)
+		return err
+	})
+}
+
+// loadAccess loads the access data of a join of the access and client tables,
+// scanning the extra columns of the access table into dest first
+func (s *SQLStorage) loadAccess(ctx context.Context, from, key, token string, extra []string, dest ...interface{}) (*AccessData, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+sqlColumns("a", append(append([]string{}, extra...), sqlAccessColumns...))+`, `+
+		sqlColumns("c", sqlClientColumns)+` FROM `+from+` WHERE `+key+` = `+s.config.Dialect.Placeholder(1), token)
+
+	var data AccessData
+	var clientId, actors, audience string
+	var createdAt int64
+	var userData sql.NullString
+	client, err := s.scanClient(row.Scan, append(dest, &data.AccessToken, &clientId, &data.RefreshToken, &data.ExpiresIn, &data.Scope,
+		&data.RedirectUri, &createdAt, &userData, &data.Subject, &actors, &audience, &data.CertificateThumbprint, &data.TokenType,
+		&data.JWKThumbprint, &data.GrantId))
+	if err != nil {
+		return nil, s.notFound(err)
+	}
+	data.Client = client
+	data.CreatedAt = time.Unix(0, createdAt)
+	if err = json.Unmarshal([]byte(actors), &data.Actors); err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal([]byte(audience), &data.Audience); err != nil {
+		return nil, err
+	}
+	if len(data.Actors) == 0 {
+		data.Actors = nil
+	}
+	if len(data.Audience) == 0 {
+		data.Audience = nil
+	}
+	if data.UserData, err = s.deserialize(userData); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// scanClient scans a row of dest followed by the client columns
+func (s *SQLStorage) scanClient(scan func(dest ...interface{}) error, dest []interface{}) (*DefaultClient, error) {
+	var client DefaultClient
+	var redirectUris, jsonWebKeys, tlsClientAuth, accessTypes, authorizeTypes, scopes string
+	var userData sql.NullString
+	if err := scan(append(dest, &client.Id, &client.Secret, &client.RedirectUri, &redirectUris, &userData, &jsonWebKeys, &tlsClientAuth,
+		&accessTypes, &authorizeTypes, &scopes, &client.TokenEndpointAuthMethod, &client.AccessExpiration, &client.AuthorizationExpiration)...); err != nil {
+		return nil, err
+	}
+	for _, v := range []struct {
+		encoded string
+		dest    interface{}
+	}{
+		{redirectUris, &client.RedirectUris},
+		{jsonWebKeys, &client.JsonWebKeys},
+		{tlsClientAuth, &client.TLSClientAuth},
+		{accessTypes, &client.AllowedAccessTypes},
+		{authorizeTypes, &client.AllowedAuthorizeTypes},
+		{scopes, &client.AllowedScopes},
+	} {
+		if err := json.Unmarshal([]byte(v.encoded), v.dest); err != nil {
+			return nil, err
+		}
+	}
+	if len(client.RedirectUris) == 0 {
+		client.RedirectUris = nil
+	}
+	var err error
+	if client.UserData, err = s.deserialize(userData); err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (s *SQLStorage) remove(ctx context.Context, table, key, value string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM `+s.table(table)+` WHERE `+key+` = `+s.config.Dialect.Placeholder(1), value)
+	return s.error(err)
+}
+
+// removeTx deletes the rows of a table in a transaction, and returns the number of rows deleted
+func (s *SQLStorage) removeTx(ctx context.Context, tx *sql.Tx, table, key, value string) (int64, error) {
+	result, err := tx.ExecContext(ctx, `DELETE FROM `+s.table(table)+` WHERE `+key+` = `+s.config.Dialect.Placeholder(1), value)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// transaction runs f in a transaction, committed if f succeeds
+func (s *SQLStorage) transaction(ctx context.Context, f func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return s.error(err)
+	}
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrAuthorizeRedeemed) {
+			return err
+		}
+		return s.error(err)
+	}
+	return s.error(tx.Commit())
+}
+
+func (s *SQLStorage) table(name string) string {
+	return s.config.TablePrefix + name
+}
+
+func (s *SQLStorage) serialize(data interface{}) (sql.NullString, error) {
+	if data == nil {
+		return sql.NullString{}, nil
+	}
+	v, err := s.config.UserData.SerializeUserData(data)
+	return sql.NullString{String: v, Valid: true}, err
+}
+
+func (s *SQLStorage) deserialize(data sql.NullString) (interface{}, error) {
+	if !data.Valid {
+		return nil, nil
+	}
+	return s.config.UserData.DeserializeUserData(data.String)
+}
+
+// notFound returns ErrNotFound if no row was found, the storage error otherwise
+func (s *SQLStorage) notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return s.error(err)
+}
+
+func (s *SQLStorage) error(err error) error {
+	if err == nil {
+		return nil
+	}
+	return s.config.TranslateError(err)
+}
+
+// translateSQLError is the default SQLStorageConfig.TranslateError
+func translateSQLError(err error) error {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) {
+		return fmt.Errorf("%w: %v", ErrTemporarilyUnavailable, err)
+	}
+	return err
+}
+
+// sqlInsert returns the statement inserting the keys and columns in a table
+func sqlInsert(d SQLDialect, table string, keys, columns []string) string {
+	all := append(append([]string{}, keys...), columns...)
+	var placeholders []string
+	for i := range all {
+		placeholders = append(placeholders, d.Placeholder(i+1))
+	}
+	return `INSERT INTO ` + table + ` (` + strings.Join(all, ", ") + `) VALUES (` + strings.Join(placeholders, ", ") + `)`
+}
+
+// sqlUpdate returns the statement updating the columns of the row of a table with the keys.
+// The arguments are the columns followed by the keys.
+func sqlUpdate(d SQLDialect, table string, keys, columns []string) string {
+	var set, where []string
+	for i, c := range columns {
+		set = append(set, c+" = "+d.Placeholder(i+1))
+	}
+	for i, k := range keys {
+		where = append(where, k+" = "+d.Placeholder(len(columns)+i+1))
+	}
+	return `UPDATE ` + table + ` SET ` + strings.Join(set, ", ") + ` WHERE ` + strings.Join(where, " AND ")
+}
+
+// sqlOnConflict returns the ON CONFLICT clause updating columns with the values of the excluded row
+func sqlOnConflict(keys, columns []string, excluded string) string {
+	var set []string
+	for _, c := range columns {
+		set = append(set, c+" = "+excluded+"."+c)
+	}
+	return ` ON CONFLICT (` + strings.Join(keys, ", ") + `) DO UPDATE SET ` + strings.Join(set, ", ")
+}
+
+// sqlColumns returns the columns qualified by a table alias
+func sqlColumns(alias string, columns []string) string {
+	qualified := make([]string, len(columns))
+	for i, c := range columns {
+		qualified[i] = alias + "." + c
+	}
+	return strings.Join(qualified, ", ")
+}
+
+// sqlTime returns the stored value of a date, 0 if it is zero
+func sqlTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// sqlParseTime returns the date of a stored value
+func sqlParseTime(t int64) time.Time {
+	if t == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, t)
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
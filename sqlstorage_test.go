@@ -0,0 +1,982 @@
+package osin
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQLDriver is a database/sql driver keeping tables in memory. It only understands
+// the statements of SQLStorage: CREATE TABLE, INSERT (upserts replace rows), UPDATE, DELETE
+// and SELECT with equality joins, filtered by comparisons joined with AND.
+// Only primary keys are unique.
+type fakeSQLDriver struct{}
+
+var (
+	fakeSQLLock      sync.Mutex
+	fakeSQLDatabases = map[string]*fakeSQLDatabase{}
+)
+
+func init() {
+	sql.Register("osin_fake", fakeSQLDriver{})
+}
+
+type fakeSQLDatabase struct {
+	sync.Mutex
+	tables map[string]*fakeSQLTable
+
+	// error returned by all statements, if set
+	fail error
+
+	// statements prepared, in order
+	statements []string
+}
+
+type fakeSQLTable struct {
+	columns []string
+	key     string
+	rows    []map[string]driver.Value
+}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	fakeSQLLock.Lock()
+	defer fakeSQLLock.Unlock()
+	db, ok := fakeSQLDatabases[name]
+	if !ok {
+		db = &fakeSQLDatabase{tables: map[string]*fakeSQLTable{}}
+		fakeSQLDatabases[name] = db
+	}
+	return &fakeSQLConn{db: db}, nil
+}
+
+type fakeSQLConn struct {
+	db *fakeSQLDatabase
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	c.db.Lock()
+	defer c.db.Unlock()
+	c.db.statements = append(c.db.statements, query)
+	return &fakeSQLStmt{db: c.db, tokens: fakeSQLTokens(query)}, nil
+}
+
+func (c *fakeSQLConn) Close() error {
+	return nil
+}
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *fakeSQLConn) Commit() error {
+	return nil
+}
+
+func (c *fakeSQLConn) Rollback() error {
+	return nil
+}
+
+func fakeSQLTokens(query string) []string {
+	return strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ", ",", " , ").Replace(query))
+}
+
+type fakeSQLStmt struct {
+	db     *fakeSQLDatabase
+	tokens []string
+	args   []driver.Value
+
+	// index of the next ? placeholder
+	nextArg int
+}
+
+func (s *fakeSQLStmt) Close() error {
+	return nil
+}
+
+func (s *fakeSQLStmt) NumInput() int {
+	return -1
+}
+
+// next returns the next token, upper cased
+func (s *fakeSQLStmt) next() string {
+	if len(s.tokens) == 0 {
+		return ""
+	}
+	t := s.tokens[0]
+	s.tokens = s.tokens[1:]
+	return strings.ToUpper(t)
+}
+
+// list returns the names of a list until one of the end tokens
+func (s *fakeSQLStmt) list(end ...string) []string {
+	var ret []string
+	for len(s.tokens) > 0 {
+		t := s.tokens[0]
+		for _, e := range end {
+			if strings.EqualFold(t, e) {
+				return ret
+			}
+		}
+		s.tokens = s.tokens[1:]
+		if t != "," {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// arg returns the argument of a placeholder, ? or $n
+func (s *fakeSQLStmt) arg(placeholder string) driver.Value {
+	i := s.nextArg
+	if strings.HasPrefix(placeholder, "$") {
+		i, _ = strconv.Atoi(placeholder[1:])
+		i--
+	} else {
+		s.nextArg++
+	}
+	return s.args[i]
+}
+
+// where returns the filter of a WHERE clause, comparisons joined with AND
+func (s *fakeSQLStmt) where() func(row map[string]driver.Value) bool {
+	type condition struct {
+		column, op string
+		value      driver.Value
+	}
+	var conditions []condition
+	for len(s.tokens) >= 3 {
+		column, op, placeholder := strings.ToLower(s.next()), s.next(), s.next()
+		conditions = append(conditions, condition{column, op, s.arg(placeholder)})
+		if s.next() != "AND" {
+			break
+		}
+	}
+	return func(row map[string]driver.Value) bool {
+		for _, c := range conditions {
+			v := row[c.column]
+			a, _ := v.(int64)
+			b, _ := c.value.(int64)
+			switch {
+			case c.op == "=" && v != c.value:
+				return false
+			case c.op == "<" && a >= b:
+				return false
+			case c.op == ">" && a <= b:
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+	if s.db.fail != nil {
+		return nil, s.db.fail
+	}
+	s.args = args
+
+	if strings.EqualFold(s.tokens[0], "UPDATE") {
+		s.next()
+		return s.update()
+	}
+	switch s.next() + " " + s.next() {
+	case "CREATE TABLE":
+		return s.createTable()
+	case "CREATE INDEX", "CREATE UNIQUE":
+		return driver.ResultNoRows, nil
+	case "INSERT INTO":
+		return s.insert()
+	case "DELETE FROM":
+		return s.delete()
+	}
+	return nil, errors.New("unsupported statement")
+}
+
+func (s *fakeSQLStmt) createTable() (driver.Result, error) {
+	name := s.next()
+	ifNotExists := name == "IF"
+	if ifNotExists {
+		s.next()
+		s.next()
+		name = s.next()
+	}
+	name = strings.ToLower(name)
+	if _, ok := s.db.tables[name]; ok {
+		if ifNotExists {
+			return driver.ResultNoRows, nil
+		}
+		return nil, fmt.Errorf("table %s already exists", name)
+	}
+
+	table := &fakeSQLTable{}
+	s.next() // (
+	for len(s.tokens) > 1 {
+		definition := s.list(",")
+		if len(s.tokens) > 0 {
+			s.tokens = s.tokens[1:]
+		} else {
+			// strip the closing parenthesis of the table
+			definition = definition[:len(definition)-1]
+		}
+		table.columns = append(table.columns, definition[0])
+		if strings.Contains(strings.ToUpper(strings.Join(definition, " ")), "PRIMARY KEY") {
+			table.key = definition[0]
+		}
+	}
+	s.db.tables[name] = table
+	return driver.ResultNoRows, nil
+}
+
+func (s *fakeSQLStmt) insert() (driver.Result, error) {
+	table, ok := s.db.tables[strings.ToLower(s.next())]
+	if !ok {
+		return nil, errors.New("no such table")
+	}
+	s.next() // (
+	columns := s.list(")")
+	s.next() // )
+	s.next() // VALUES
+	s.next() // (
+	placeholders := s.list(")")
+	s.next() // )
+	upsert := len(s.tokens) > 0
+
+	row := map[string]driver.Value{}
+	for i, c := range columns {
+		if !containsString(table.columns, c) {
+			return nil, fmt.Errorf("no such column %s", c)
+		}
+		row[c] = s.arg(placeholders[i])
+	}
+	for i, r := range table.rows {
+		if r[table.key] == row[table.key] {
+			if !upsert {
+				return nil, errors.New("UNIQUE constraint failed")
+			}
+			table.rows[i] = row
+			return driver.RowsAffected(1), nil
+		}
+	}
+	table.rows = append(table.rows, row)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeSQLStmt) delete() (driver.Result, error) {
+	table, ok := s.db.tables[strings.ToLower(s.next())]
+	if !ok {
+		return nil, errors.New("no such table")
+	}
+	s.next() // WHERE
+	matches := s.where()
+
+	var rows []map[string]driver.Value
+	for _, r := range table.rows {
+		if !matches(r) {
+			rows = append(rows, r)
+		}
+	}
+	deleted := len(table.rows) - len(rows)
+	table.rows = rows
+	return driver.RowsAffected(deleted), nil
+}
+
+func (s *fakeSQLStmt) update() (driver.Result, error) {
+	table, ok := s.db.tables[strings.ToLower(s.next())]
+	if !ok {
+		return nil, errors.New("no such table")
+	}
+	s.next() // SET
+	values := map[string]driver.Value{}
+	for {
+		column, _, placeholder := strings.ToLower(s.next()), s.next(), s.next()
+		if !containsString(table.columns, column) {
+			return nil, fmt.Errorf("no such column %s", column)
+		}
+		values[column] = s.arg(placeholder)
+		if s.next() != "," {
+			break // WHERE
+		}
+	}
+	matches := s.where()
+
+	updated := 0
+	for _, r := range table.rows {
+		if matches(r) {
+			for c, v := range values {
+				r[c] = v
+			}
+			updated++
+		}
+	}
+	return driver.RowsAffected(updated), nil
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.db.Lock()
+	defer s.db.Unlock()
+	if s.db.fail != nil {
+		return nil, s.db.fail
+	}
+	s.args = args
+
+	if s.next() != "SELECT" {
+		return nil, errors.New("unsupported query")
+	}
+	columns := s.list("FROM")
+	s.next() // FROM
+
+	// rows of the joined tables, by qualified and unqualified column names
+	var rows []map[string]driver.Value
+	for first := true; len(s.tokens) > 0 && (first || strings.EqualFold(s.tokens[0], "JOIN")); first = false {
+		if !first {
+			s.next() // JOIN
+		}
+		table, ok := s.db.tables[strings.ToLower(s.next())]
+		if !ok {
+			return nil, errors.New("no such table")
+		}
+		alias := ""
+		if len(s.tokens) > 0 && !containsString([]string{"JOIN", "WHERE", "ON"}, strings.ToUpper(s.tokens[0])) {
+			alias = s.next()
+		}
+		var left, right string
+		if !first {
+			s.next() // ON
+			left, _, right = strings.ToLower(s.next()), s.next(), strings.ToLower(s.next())
+		}
+
+		var joined []map[string]driver.Value
+		for _, r := range table.rows {
+			qualified := map[string]driver.Value{}
+			for c, v := range r {
+				qualified[strings.ToLower(alias+"."+c)] = v
+				qualified[c] = v
+			}
+			if first {
+				joined = append(joined, qualified)
+				continue
+			}
+			for _, row := range rows {
+				merged := map[string]driver.Value{}
+				for c, v := range row {
+					merged[c] = v
+				}
+				for c, v := range qualified {
+					merged[c] = v
+				}
+				if merged[left] == merged[right] {
+					joined = append(joined, merged)
+				}
+			}
+		}
+		rows = joined
+	}
+
+	if s.next() == "WHERE" {
+		matches := s.where()
+		var filtered []map[string]driver.Value
+		for _, r := range rows {
+			if matches(r) {
+				filtered = append(filtered, r)
+			}
+		}
+		rows = filtered
+	}
+
+	ret := &fakeSQLRows{columns: columns}
+	for _, r := range rows {
+		var values []driver.Value
+		for _, c := range columns {
+			v, ok := r[strings.ToLower(c)]
+			if !ok {
+				return nil, fmt.Errorf("no such column %s", c)
+			}
+			values = append(values, v)
+		}
+		ret.rows = append(ret.rows, values)
+	}
+	return ret, nil
+}
+
+type fakeSQLRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeSQLRows) Close() error {
+	return nil
+}
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+type testingSQLUserData struct {
+	Name string
+}
+
+func newTestingSQLStorage(t *testing.T, name string, dialect SQLDialect) (*SQLStorage, *fakeSQLDatabase) {
+	// start from an empty database when tests are repeated
+	fakeSQLLock.Lock()
+	delete(fakeSQLDatabases, t.Name()+"/"+name)
+	fakeSQLLock.Unlock()
+
+	db, err := sql.Open("osin_fake", t.Name()+"/"+name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := NewSQLStorageConfig()
+	config.Dialect = dialect
+	config.UserData = JSONUserDataSerializer{New: func() interface{} { return &testingSQLUserData{} }}
+	storage := NewSQLStorage(db, config)
+	if err = storage.Migrate(context.Background()); err != nil {
+		t.Fatalf("Error migrating: %v", err)
+	}
+
+	fakeSQLLock.Lock()
+	defer fakeSQLLock.Unlock()
+	return storage, fakeSQLDatabases[t.Name()+"/"+name]
+}
+
+func TestSQLStorageMigrate(t *testing.T) {
+	storage, fake := newTestingSQLStorage(t, "db", SQLiteDialect{})
+	ctx := context.Background()
+
+	// migrations already applied are skipped
+	if err := storage.Migrate(ctx); err != nil {
+		t.Fatalf("Error migrating again: %v", err)
+	}
+	version, err := storage.SchemaVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != sqlMigrations[len(sqlMigrations)-1].version {
+		t.Errorf("Unexpected schema version %d", version)
+	}
+	for _, table := range []string{"osin_migration", "osin_client", "osin_authorize", "osin_access", "osin_refresh",
+		"osin_redeemed", "osin_rotated", "osin_jti", "osin_device", "osin_pushed", "osin_registration"} {
+		if _, ok := fake.tables[table]; !ok {
+			t.Errorf("Table %s was not created", table)
+		}
+	}
+	if rows := len(fake.tables["osin_migration"].rows); rows != len(sqlMigrations) {
+		t.Errorf("Unexpected number of migrations %d", rows)
+	}
+}
+
+func TestSQLStorage(t *testing.T) {
+	dialects := map[string]SQLDialect{
+		"sqlite":   SQLiteDialect{},
+		"postgres": PostgresDialect{},
+		"mysql":    MySQLDialect{},
+	}
+
+	for k, dialect := range dialects {
+		storage, _ := newTestingSQLStorage(t, k, dialect)
+		ctx := context.Background()
+		now := time.Unix(0, time.Now().UnixNano())
+
+		client := &DefaultClient{Id: "1234", Secret: "old", RedirectUri: "http://localhost:14000/appauth"}
+		if err := storage.SetClient(ctx, client); err != nil {
+			t.Fatalf("%s: error saving client: %v", k, err)
+		}
+		client.Secret = "aabbccdd"
+		client.UserData = &testingSQLUserData{Name: "app"}
+		client.RedirectUris = []string{"http://localhost:14000/appauth", "http://localhost:14000/other"}
+		client.JsonWebKeys = publicKeySet(newTestingKey(t, "k1"))
+		client.JsonWebKeys.Keys[0].Certificates = []*x509.Certificate{} // as decoded by go-jose
+		client.TLSClientAuth = &TLSClientAuth{SubjectDN: "CN=orders"}
+		client.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN}
+		client.AllowedAuthorizeTypes = AllowedAuthorizeType{CODE}
+		client.AllowedScopes = []string{}
+		client.TokenEndpointAuthMethod = "private_key_jwt"
+		client.AccessExpiration = 60
+		client.AuthorizationExpiration = 30
+		if err := storage.SetClient(ctx, client); err != nil {
+			t.Fatalf("%s: error updating client: %v", k, err)
+		}
+		if loaded, err := storage.GetClient(ctx, "1234"); err != nil || !reflect.DeepEqual(loaded, client) {
+			t.Errorf("%s: unexpected client %+v: %v", k, loaded, err)
+		}
+
+		authorize := &AuthorizeData{
+			Client:        client,
+			Code:          "code",
+			ExpiresIn:     60,
+			Scope:         "openid",
+			RedirectUri:   "http://localhost:14000/appauth",
+			State:         "a",
+			CreatedAt:     now,
+			UserData:      &testingSQLUserData{Name: "user"},
+			CodeChallenge: "challenge",
+			Nonce:         "nonce",
+		}
+		if err := storage.SaveAuthorize(ctx, authorize); err != nil {
+			t.Fatalf("%s: error saving authorize data: %v", k, err)
+		}
+		if loaded, err := storage.LoadAuthorize(ctx, "code"); err != nil || !reflect.DeepEqual(loaded, authorize) {
+			t.Errorf("%s: unexpected authorize data %+v: %v", k, loaded, err)
+		}
+
+		access := &AccessData{
+			Client:       client,
+			AccessToken:  "access",
+			RefreshToken: "refresh",
+			ExpiresIn:    3600,
+			Scope:        "openid",
+			CreatedAt:    now,
+			UserData:     &testingSQLUserData{Name: "user"},
+			Audience:     []string{"https://api.example.com"},
+			GrantId:      "grant",
+		}
+		if err := storage.SaveAccess(ctx, access); err != nil {
+			t.Fatalf("%s: error saving access data: %v", k, err)
+		}
+		if err := storage.SaveAccess(ctx, access); err == nil {
+			t.Errorf("%s: duplicate access token should not be saved", k)
+		}
+		if loaded, err := storage.LoadAccess(ctx, "access"); err != nil || !reflect.DeepEqual(loaded, access) {
+			t.Errorf("%s: unexpected access data %+v: %v", k, loaded, err)
+		}
+		if loaded, err := storage.LoadRefresh(ctx, "refresh"); err != nil || !reflect.DeepEqual(loaded, access) {
+			t.Errorf("%s: unexpected refresh data %+v: %v", k, loaded, err)
+		}
+
+		storage.RemoveAuthorize(ctx, "code")
+		storage.RemoveRefresh(ctx, "refresh")
+		storage.RemoveAccess(ctx, "access")
+		if _, err := storage.LoadAuthorize(ctx, "code"); err != ErrNotFound {
+			t.Errorf("%s: expected error %v, got %v", k, ErrNotFound, err)
+		}
+		if _, err := storage.LoadAccess(ctx, "access"); err != ErrNotFound {
+			t.Errorf("%s: expected error %v, got %v", k, ErrNotFound, err)
+		}
+		if _, err := storage.LoadRefresh(ctx, "refresh"); err != ErrNotFound {
+			t.Errorf("%s: expected error %v, got %v", k, ErrNotFound, err)
+		}
+	}
+}
+
+func TestSQLStorageOptionalInterfaces(t *testing.T) {
+	dialects := map[string]SQLDialect{
+		"sqlite":   SQLiteDialect{},
+		"postgres": PostgresDialect{},
+		"mysql":    MySQLDialect{},
+	}
+
+	for k, dialect := range dialects {
+		sqlStorage, _ := newTestingSQLStorage(t, k, dialect)
+		ctx := context.Background()
+		now := time.Unix(0, time.Now().UnixNano())
+		sqlStorage.config.Now = func() time.Time { return now }
+		client := &DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"}
+		sqlStorage.SetClient(ctx, client)
+
+		storage := NewStorage(sqlStorage)
+		if _, ok := getAuthorizeCodeStorage(storage); !ok {
+			t.Errorf("%s: AuthorizeCodeStorage not implemented", k)
+		}
+		if _, ok := getRefreshTokenStorage(storage); !ok {
+			t.Errorf("%s: RefreshTokenStorage not implemented", k)
+		}
+		if _, ok := getJTIStorage(storage); !ok {
+			t.Errorf("%s: JTIStorage not implemented", k)
+		}
+		if _, ok := getDeviceStorage(storage); !ok {
+			t.Errorf("%s: DeviceStorage not implemented", k)
+		}
+		if _, ok := getPushedAuthorizeStorage(storage); !ok {
+			t.Errorf("%s: PushedAuthorizeStorage not implemented", k)
+		}
+		if _, ok := getClientStorage(storage); !ok {
+			t.Errorf("%s: ClientStorage not implemented", k)
+		}
+
+		// authorization codes are redeemed once
+		sqlStorage.SaveAuthorize(ctx, &AuthorizeData{Client: client, Code: "code", ExpiresIn: 60, CreatedAt: now})
+		redeemed := &RedeemedAuthorizeData{Code: "code", ClientId: "1234", GrantId: "grant", RedeemedAt: now, ExpireAt: now.Add(time.Minute)}
+		if err := sqlStorage.ConsumeAuthorize(ctx, redeemed); err != nil {
+			t.Errorf("%s: error consuming authorization code: %v", k, err)
+		}
+		if err := sqlStorage.ConsumeAuthorize(ctx, redeemed); err != ErrAuthorizeRedeemed {
+			t.Errorf("%s: expected error %v, got %v", k, ErrAuthorizeRedeemed, err)
+		}
+		if _, err := sqlStorage.LoadAuthorize(ctx, "code"); err != ErrNotFound {
+			t.Errorf("%s: authorization code was not deleted: %v", k, err)
+		}
+		if loaded, err := sqlStorage.LoadRedeemedAuthorize(ctx, "code"); err != nil || !reflect.DeepEqual(loaded, redeemed) {
+			t.Errorf("%s: unexpected redeemed authorization code %+v: %v", k, loaded, err)
+		}
+
+		// refresh tokens are rotated once, and revoked with their grant
+		access := &AccessData{Client: client, AccessToken: "access", RefreshToken: "refresh", ExpiresIn: 3600, CreatedAt: now, GrantId: "grant"}
+		sqlStorage.SaveAccess(ctx, access)
+		rotated := &RotatedRefreshData{RefreshToken: "refresh", GrantId: "grant", AccessData: access, RotatedAt: now}
+		if err := sqlStorage.ConsumeRefresh(ctx, rotated); err != nil {
+			t.Errorf("%s: error consuming refresh token: %v", k, err)
+		}
+		if err := sqlStorage.ConsumeRefresh(ctx, rotated); err != ErrNotFound {
+			t.Errorf("%s: expected error %v, got %v", k, ErrNotFound, err)
+		}
+		if loaded, err := sqlStorage.LoadRotatedRefresh(ctx, "refresh"); err != nil || !reflect.DeepEqual(loaded, rotated) {
+			t.Errorf("%s: unexpected rotated refresh token %+v: %v", k, loaded, err)
+		}
+		sqlStorage.SaveAccess(ctx, &AccessData{Client: client, AccessToken: "access2", RefreshToken: "refresh2", CreatedAt: now, GrantId: "grant"})
+		sqlStorage.SaveAccess(ctx, &AccessData{Client: client, AccessToken: "other", RefreshToken: "other", CreatedAt: now, GrantId: "other"})
+		if err := sqlStorage.RemoveGrant(ctx, "grant"); err != nil {
+			t.Errorf("%s: error removing grant: %v", k, err)
+		}
+		for _, token := range []string{"access", "access2"} {
+			if _, err := sqlStorage.LoadAccess(ctx, token); err != ErrNotFound {
+				t.Errorf("%s: access token %s of the grant was not removed: %v", k, token, err)
+			}
+		}
+		if _, err := sqlStorage.LoadRefresh(ctx, "refresh2"); err != ErrNotFound {
+			t.Errorf("%s: refresh token of the grant was not removed: %v", k, err)
+		}
+		if _, err := sqlStorage.LoadRotatedRefresh(ctx, "refresh"); err != ErrNotFound {
+			t.Errorf("%s: rotated refresh token of the grant was not removed: %v", k, err)
+		}
+		if _, err := sqlStorage.LoadRefresh(ctx, "other"); err != nil {
+			t.Errorf("%s: refresh token of another grant was removed: %v", k, err)
+		}
+
+		// removing an access token removes its refresh token
+		sqlStorage.RemoveAccess(ctx, "other")
+		if _, err := sqlStorage.LoadRefresh(ctx, "other"); err != ErrNotFound {
+			t.Errorf("%s: refresh token of a removed access token was not removed: %v", k, err)
+		}
+
+		// JWT IDs are used once until they expire
+		if err := sqlStorage.SaveJTI(ctx, "jti", now.Add(time.Minute)); err != nil {
+			t.Errorf("%s: error saving jti: %v", k, err)
+		}
+		if err := sqlStorage.SaveJTI(ctx, "jti", now.Add(time.Minute)); err != ErrJTIReplayed {
+			t.Errorf("%s: expected error %v, got %v", k, ErrJTIReplayed, err)
+		}
+		sqlStorage.SaveJTI(ctx, "expired", now.Add(-time.Minute))
+		if err := sqlStorage.SaveJTI(ctx, "expired", now.Add(time.Minute)); err != nil {
+			t.Errorf("%s: error saving expired jti again: %v", k, err)
+		}
+		if err := sqlStorage.SaveJTI(ctx, "expired", now.Add(time.Minute)); err != ErrJTIReplayed {
+			t.Errorf("%s: expected error %v for a jti saved again, got %v", k, ErrJTIReplayed, err)
+		}
+		sqlStorage.SaveJTI(ctx, "forever", time.Time{})
+
+		// expired JWT IDs and redeemed codes are only deleted by RemoveExpired
+		now = now.Add(2 * time.Minute)
+		if err := sqlStorage.RemoveExpired(ctx); err != nil {
+			t.Errorf("%s: error removing expired entries: %v", k, err)
+		}
+		if _, err := sqlStorage.LoadRedeemedAuthorize(ctx, "code"); err != ErrNotFound {
+			t.Errorf("%s: expired redeemed authorization code was not removed: %v", k, err)
+		}
+		if err := sqlStorage.SaveJTI(ctx, "jti", now.Add(time.Minute)); err != nil {
+			t.Errorf("%s: error saving removed jti again: %v", k, err)
+		}
+		if err := sqlStorage.SaveJTI(ctx, "forever", now.Add(time.Minute)); err != ErrJTIReplayed {
+			t.Errorf("%s: expected error %v for a jti without expiration, got %v", k, ErrJTIReplayed, err)
+		}
+
+		// device codes are exchanged once
+		device := &DeviceData{Client: client, DeviceCode: "device", UserCode: "user", ExpiresIn: 600, Interval: 5, Scope: "read",
+			Status: DEVICE_PENDING, CreatedAt: now}
+		if err := sqlStorage.SaveDevice(ctx, device); err != nil {
+			t.Errorf("%s: error saving device: %v", k, err)
+		}
+		device.Status = DEVICE_APPROVED
+		device.LastPolledAt = now
+		device.UserData = &testingSQLUserData{Name: "user"}
+		if err := sqlStorage.UpdateDevice(ctx, device); err != nil {
+			t.Errorf("%s: error updating device: %v", k, err)
+		}
+		if loaded, err := sqlStorage.LoadDeviceByUserCode(ctx, "user"); err != nil || !reflect.DeepEqual(loaded, device) {
+			t.Errorf("%s: unexpected device %+v: %v", k, loaded, err)
+		}
+		if loaded, err := sqlStorage.ConsumeDevice(ctx, "device"); err != nil || !reflect.DeepEqual(loaded, device) {
+			t.Errorf("%s: unexpected consumed device %+v: %v", k, loaded, err)
+		}
+		if _, err := sqlStorage.ConsumeDevice(ctx, "device"); err != ErrNotFound {
+			t.Errorf("%s: expected error %v, got %v", k, ErrNotFound, err)
+		}
+
+		// pushed authorization requests are used once
+		pushed := &PushedAuthorizeData{Client: client, RequestUri: REQUEST_URI_PREFIX + "1", Params: url.Values{"state": {"a"}},
+			ExpiresIn: 60, CreatedAt: now}
+		if err := sqlStorage.SavePushedAuthorize(ctx, pushed); err != nil {
+			t.Errorf("%s: error saving pushed authorization request: %v", k, err)
+		}
+		if loaded, err := sqlStorage.LoadPushedAuthorize(ctx, pushed.RequestUri); err != nil || !reflect.DeepEqual(loaded, pushed) {
+			t.Errorf("%s: unexpected loaded pushed authorization request %+v: %v", k, loaded, err)
+		}
+		if loaded, err := sqlStorage.ConsumePushedAuthorize(ctx, pushed.RequestUri); err != nil || !reflect.DeepEqual(loaded, pushed) {
+			t.Errorf("%s: unexpected pushed authorization request %+v: %v", k, loaded, err)
+		}
+		if _, err := sqlStorage.ConsumePushedAuthorize(ctx, pushed.RequestUri); err != ErrNotFound {
+			t.Errorf("%s: expected error %v, got %v", k, ErrNotFound, err)
+		}
+
+		// registered clients
+		registration := &ClientRegistration{
+			Client:                  &DefaultClient{Id: "registered", RedirectUri: "https://client.example.com/cb", TokenEndpointAuthMethod: "none"},
+			Metadata:                &ClientMetadata{RedirectUris: []string{"https://client.example.com/cb"}, TokenEndpointAuthMethod: "none"},
+			RegistrationAccessToken: "registration-token",
+			CreatedAt:               now,
+		}
+		if err := sqlStorage.SaveClientRegistration(ctx, registration); err != nil {
+			t.Errorf("%s: error saving client registration: %v", k, err)
+		}
+		if loaded, err := sqlStorage.LoadClientRegistration(ctx, "registered"); err != nil || !reflect.DeepEqual(loaded, registration) {
+			t.Errorf("%s: unexpected client registration %+v: %v", k, loaded, err)
+		}
+		if loaded, err := sqlStorage.GetClient(ctx, "registered"); err != nil || !reflect.DeepEqual(loaded, registration.Client) {
+			t.Errorf("%s: unexpected registered client %+v: %v", k, loaded, err)
+		}
+		sqlStorage.RemoveClientRegistration(ctx, "registered")
+		if _, err := sqlStorage.LoadClientRegistration(ctx, "registered"); err != ErrNotFound {
+			t.Errorf("%s: expected error %v, got %v", k, ErrNotFound, err)
+		}
+		if _, err := sqlStorage.GetClient(ctx, "registered"); err != ErrNotFound {
+			t.Errorf("%s: expected error %v, got %v", k, ErrNotFound, err)
+		}
+	}
+}
+
+type testingSQLScopedClient struct {
+	DefaultClient
+}
+
+func (c *testingSQLScopedClient) GetAllowedScopes() []string {
+	return []string{"read"}
+}
+
+func TestSQLStorageUnsupportedClient(t *testing.T) {
+	storage, _ := newTestingSQLStorage(t, "db", SQLiteDialect{})
+	ctx := context.Background()
+
+	if err := storage.SetClient(ctx, &testingSQLScopedClient{DefaultClient{Id: "scoped"}}); err == nil {
+		t.Errorf("Clients with settings other than DefaultClient should not be stored")
+	}
+	validator := &DefaultClient{Id: "validator", RedirectURIValidator: ExactRedirectURIValidator{}}
+	if err := storage.SetClient(ctx, validator); err == nil {
+		t.Errorf("Clients with a RedirectURIValidator should not be stored")
+	}
+	if _, err := storage.GetClient(ctx, "scoped"); err != ErrNotFound {
+		t.Errorf("expected error %v, got %v", ErrNotFound, err)
+	}
+}
+
+func TestSQLStorageServer(t *testing.T) {
+	storage, _ := newTestingSQLStorage(t, "db", PostgresDialect{})
+	storage.SetClient(context.Background(), &DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"})
+
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{AUTHORIZATION_CODE, REFRESH_TOKEN}
+	server := NewServer(sconfig, NewStorage(storage))
+	server.AuthorizeTokenGen = &TestingAuthorizeTokenGen{}
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = url.Values{"response_type": {string(CODE)}, "client_id": {"1234"}, "state": {"a"}}
+	if ar := server.HandleAuthorizeRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		ar.UserData = &testingSQLUserData{Name: "user"}
+		server.FinishAuthorizeRequest(resp, req, ar)
+	}
+	if resp.IsError {
+		t.Fatalf("Error in authorization: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+
+	for _, form := range []url.Values{
+		{"grant_type": {string(AUTHORIZATION_CODE)}, "code": {"1"}},
+		{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"r1"}},
+	} {
+		resp = server.NewResponse()
+		req, err = http.NewRequest("POST", "http://localhost:14000/token", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.SetBasicAuth("1234", "aabbccdd")
+		req.Form = form
+		req.PostForm = make(url.Values)
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			if user, _ := ar.UserData.(*testingSQLUserData); user == nil || user.Name != "user" {
+				t.Errorf("%s: unexpected user data %#v", form.Get("grant_type"), ar.UserData)
+			}
+			ar.Authorized = true
+			server.FinishAccessRequest(resp, req, ar)
+		}
+		if resp.IsError {
+			t.Fatalf("%s: error in response: %s: %v", form.Get("grant_type"), resp.ErrorId, resp.InternalError)
+		}
+	}
+	if resp.Output["access_token"] != "2" || resp.Output["refresh_token"] != "r2" {
+		t.Errorf("Unexpected output %v", resp.Output)
+	}
+
+	// reusing the rotated refresh token revokes the grant
+	resp = server.NewResponse()
+	req, err = http.NewRequest("POST", "http://localhost:14000/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = url.Values{"grant_type": {string(REFRESH_TOKEN)}, "refresh_token": {"r1"}}
+	req.PostForm = make(url.Values)
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+	if resp.ErrorId != E_INVALID_GRANT {
+		t.Errorf("Expected error %q, got %q: %v", E_INVALID_GRANT, resp.ErrorId, resp.InternalError)
+	}
+	if _, err = storage.LoadAccess(context.Background(), "2"); err != ErrNotFound {
+		t.Errorf("Access token of the grant was not revoked: %v", err)
+	}
+}
+
+func TestSQLStorageTemporarilyUnavailable(t *testing.T) {
+	storage, fake := newTestingSQLStorage(t, "db", SQLiteDialect{})
+	fake.fail = driver.ErrBadConn
+
+	if _, err := storage.GetClient(context.Background(), "1234"); !errors.Is(err, ErrTemporarilyUnavailable) {
+		t.Fatalf("Expected error %v, got %v", ErrTemporarilyUnavailable, err)
+	}
+
+	server := NewServer(NewServerConfig(), NewStorage(storage))
+	resp := server.NewResponse()
+	req, err := http.NewRequest("GET", "http://localhost:14000/appauth", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Form = url.Values{"response_type": {string(CODE)}, "client_id": {"1234"}, "state": {"a"}}
+	server.HandleAuthorizeRequest(resp, req)
+	if resp.ErrorId != E_TEMPORARILY_UNAVAILABLE {
+		t.Errorf("Expected error %q, got %q", E_TEMPORARILY_UNAVAILABLE, resp.ErrorId)
+	}
+}
+
+var updateSQLStatements = flag.Bool("update-sql", false, "update the statements of testdata/sqlstorage_*.sql")
+
+// TestSQLStatements checks the statements of each dialect against the files
+// of testdata, which can be run against the databases
+func TestSQLStatements(t *testing.T) {
+	dialects := map[string]SQLDialect{
+		"sqlite":   SQLiteDialect{},
+		"postgres": PostgresDialect{},
+		"mysql":    MySQLDialect{},
+	}
+
+	for k, dialect := range dialects {
+		storage, fake := newTestingSQLStorage(t, k, dialect)
+		ctx := context.Background()
+		now := time.Now()
+		client := &DefaultClient{Id: "1234", Secret: "aabbccdd", RedirectUri: "http://localhost:14000/appauth"}
+		access := &AccessData{Client: client, AccessToken: "access", RefreshToken: "refresh", CreatedAt: now, GrantId: "grant"}
+		device := &DeviceData{Client: client, DeviceCode: "device", UserCode: "user", CreatedAt: now}
+
+		storage.SetClient(ctx, client)
+		storage.GetClient(ctx, "1234")
+		storage.SaveAuthorize(ctx, &AuthorizeData{Client: client, Code: "code", CreatedAt: now})
+		storage.LoadAuthorize(ctx, "code")
+		storage.RemoveAuthorize(ctx, "code")
+		storage.SaveAccess(ctx, access)
+		storage.LoadAccess(ctx, "access")
+		storage.LoadRefresh(ctx, "refresh")
+		storage.RemoveRefresh(ctx, "refresh")
+		storage.RemoveAccess(ctx, "access")
+		storage.ConsumeAuthorize(ctx, &RedeemedAuthorizeData{Code: "code"})
+		storage.LoadRedeemedAuthorize(ctx, "code")
+		storage.SaveAccess(ctx, access)
+		storage.ConsumeRefresh(ctx, &RotatedRefreshData{RefreshToken: "refresh", GrantId: "grant", AccessData: access, RotatedAt: now})
+		storage.LoadRotatedRefresh(ctx, "refresh")
+		storage.RemoveGrant(ctx, "grant")
+		storage.SaveJTI(ctx, "jti", now)
+		storage.SaveJTI(ctx, "jti", now.Add(time.Minute))
+		storage.RemoveExpired(ctx)
+		storage.SaveDevice(ctx, device)
+		storage.LoadDevice(ctx, "device")
+		storage.LoadDeviceByUserCode(ctx, "user")
+		storage.UpdateDevice(ctx, device)
+		storage.ConsumeDevice(ctx, "device")
+		storage.SavePushedAuthorize(ctx, &PushedAuthorizeData{Client: client, RequestUri: "request", CreatedAt: now})
+		storage.LoadPushedAuthorize(ctx, "request")
+		storage.ConsumePushedAuthorize(ctx, "request")
+		storage.SaveClientRegistration(ctx, &ClientRegistration{Client: &DefaultClient{Id: "registered"}, CreatedAt: now})
+		storage.LoadClientRegistration(ctx, "registered")
+		storage.RemoveClientRegistration(ctx, "registered")
+		storage.RemoveClient(ctx, "1234")
+
+		statements := strings.Join(fake.statements, ";\n\n") + ";\n"
+		file := filepath.Join("testdata", "sqlstorage_"+k+".sql")
+		if *updateSQLStatements {
+			if err := ioutil.WriteFile(file, []byte(statements), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		expected, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statements != string(expected) {
+			t.Errorf("%s: statements do not match %s, run the tests with -update-sql to update it:\n%s", k, file, statements)
+		}
+	}
+}
+
+func TestSQLDialects(t *testing.T) {
+	testcases := map[string]struct {
+		Dialect  SQLDialect
+		Expected string
+	}{
+		"sqlite": {
+			Dialect:  SQLiteDialect{},
+			Expected: "INSERT INTO t (id, a, b) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET a = excluded.a, b = excluded.b",
+		},
+		"postgres": {
+			Dialect:  PostgresDialect{},
+			Expected: "INSERT INTO t (id, a, b) VALUES ($1, $2, $3) ON CONFLICT (id) DO UPDATE SET a = EXCLUDED.a, b = EXCLUDED.b",
+		},
+		"mysql": {
+			Dialect:  MySQLDialect{},
+			Expected: "INSERT INTO t (id, a, b) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE a = VALUES(a), b = VALUES(b)",
+		},
+	}
+
+	for k, test := range testcases {
+		if upsert := test.Dialect.Upsert("t", []string{"id"}, []string{"a", "b"}); upsert != test.Expected {
+			t.Errorf("%s: expected %q, got %q", k, test.Expected, upsert)
+		}
+	}
+}
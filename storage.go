@@ -1,15 +1,32 @@
 package osin
 
 import (
+	"context"
 	"errors"
 )
 
+// Errors returned by storages. They can be wrapped, e.g. with fmt.Errorf("...: %w", ErrNotFound),
+// and are matched with errors.Is.
 var (
 	// ErrNotFound is the error returned by Storage Get<...> and Load<...> functions in case
 	// no entity is found in the storage. E.g. Storage.GetClient() returns ErrNotFound when
 	// client is not found. All other returned errors must be treated as storage-specific errors,
 	// like "connection lost", "connection refused", etc.
 	ErrNotFound = errors.New("Entity not found")
+
+	// ErrConflict is the error returned by Storage Save<...> functions when the entity
+	// already exists. Codes and tokens are generated, so a conflict is a collision that
+	// a retry resolves: the request fails with temporarily_unavailable.
+	ErrConflict = errors.New("Entity already exists")
+
+	// ErrExpired is the error Storage Load<...> functions can return when the entity
+	// exists but expired. It is handled like ErrNotFound.
+	ErrExpired = errors.New("Entity expired")
+
+	// ErrTemporarilyUnavailable is the error returned by Storage functions in case of transient
+	// faults, like a lost connection or an overloaded database, that may succeed when retried.
+	// The request fails with temporarily_unavailable instead of server_error.
+	ErrTemporarilyUnavailable = errors.New("Storage temporarily unavailable")
 )
 
 // Storage interface
@@ -57,3 +74,53 @@ type Storage interface {
 	// RemoveRefresh revokes or deletes refresh AccessData.
 	RemoveRefresh(token string) error
 }
+
+// ContextStorage is the context-aware version of Storage. The context is the one of the
+// request being handled, so cancellation, deadlines and tracing reach the storage.
+// Use NewStorage to use a ContextStorage with a Server, and NewContextStorage to
+// wrap an existing Storage.
+//
+// A ContextStorage can implement the context-aware versions of the optional storage
+// interfaces (ContextDeviceStorage, ContextJTIStorage, ...), or the optional interfaces
+// themselves (DeviceStorage, JTIStorage, ...) which don't get the context.
+type ContextStorage interface {
+	// Clone the storage if needed, see Storage.Clone.
+	Clone() ContextStorage
+
+	// Close the resources the storage potentially holds (using Clone for example)
+	Close()
+
+	// GetClient loads the client by id (client_id)
+	GetClient(ctx context.Context, id string) (Client, error)
+
+	// SaveAuthorize saves authorize data.
+	SaveAuthorize(ctx context.Context, data *AuthorizeData) error
+
+	// LoadAuthorize looks up AuthorizeData by a code.
+	// Client information MUST be loaded together.
+	// Optionally can return ErrExpired if expired.
+	LoadAuthorize(ctx context.Context, code string) (*AuthorizeData, error)
+
+	// RemoveAuthorize revokes or deletes the authorization code.
+	RemoveAuthorize(ctx context.Context, code string) error
+
+	// SaveAccess writes AccessData.
+	// If RefreshToken is not blank, it must save in a way that can be loaded using LoadRefresh.
+	SaveAccess(ctx context.Context, data *AccessData) error
+
+	// LoadAccess retrieves access data by token. Client information MUST be loaded together.
+	// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+	// Optionally can return ErrExpired if expired.
+	LoadAccess(ctx context.Context, token string) (*AccessData, error)
+
+	// RemoveAccess revokes or deletes an AccessData.
+	RemoveAccess(ctx context.Context, token string) error
+
+	// LoadRefresh retrieves refresh AccessData. Client information MUST be loaded together.
+	// AuthorizeData and AccessData DON'T NEED to be loaded if not easily available.
+	// Optionally can return ErrExpired if expired.
+	LoadRefresh(ctx context.Context, token string) (*AccessData, error)
+
+	// RemoveRefresh revokes or deletes refresh AccessData.
+	RemoveRefresh(ctx context.Context, token string) error
+}
@@ -10,6 +10,12 @@ type TestingStorage struct {
 	authorize map[string]*AuthorizeData
 	access    map[string]*AccessData
 	refresh   map[string]string
+	device    map[string]*DeviceData
+	jti       map[string]time.Time
+	pushed    map[string]*PushedAuthorizeData
+	registry  map[string]*ClientRegistration
+	rotated   map[string]*RotatedRefreshData
+	redeemed  map[string]*RedeemedAuthorizeData
 }
 
 func NewTestingStorage() *TestingStorage {
@@ -18,6 +24,12 @@ func NewTestingStorage() *TestingStorage {
 		authorize: make(map[string]*AuthorizeData),
 		access:    make(map[string]*AccessData),
 		refresh:   make(map[string]string),
+		device:    make(map[string]*DeviceData),
+		jti:       make(map[string]time.Time),
+		pushed:    make(map[string]*PushedAuthorizeData),
+		registry:  make(map[string]*ClientRegistration),
+		rotated:   make(map[string]*RotatedRefreshData),
+		redeemed:  make(map[string]*RedeemedAuthorizeData),
 	}
 
 	r.clients["1234"] = &DefaultClient{
@@ -43,6 +55,7 @@ func NewTestingStorage() *TestingStorage {
 		Client:        r.clients["1234"],
 		AuthorizeData: r.authorize["9999"],
 		AccessToken:   "9999",
+		RefreshToken:  "r9999",
 		ExpiresIn:     3600,
 		CreatedAt:     time.Now(),
 	}
@@ -130,6 +143,136 @@ func (s *TestingStorage) RemoveRefresh(code string) error {
 	return nil
 }
 
+func (s *TestingStorage) SaveDevice(data *DeviceData) error {
+	s.device[data.DeviceCode] = data
+	return nil
+}
+
+func (s *TestingStorage) LoadDevice(code string) (*DeviceData, error) {
+	if d, ok := s.device[code]; ok {
+		return d, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *TestingStorage) LoadDeviceByUserCode(code string) (*DeviceData, error) {
+	for _, d := range s.device {
+		if d.UserCode == code {
+			return d, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *TestingStorage) UpdateDevice(data *DeviceData) error {
+	s.device[data.DeviceCode] = data
+	return nil
+}
+
+func (s *TestingStorage) ConsumeDevice(code string) (*DeviceData, error) {
+	d, ok := s.device[code]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.device, code)
+	return d, nil
+}
+
+func (s *TestingStorage) SaveJTI(jti string, expireAt time.Time) error {
+	if e, ok := s.jti[jti]; ok && e.After(time.Now()) {
+		return ErrJTIReplayed
+	}
+	s.jti[jti] = expireAt
+	return nil
+}
+
+func (s *TestingStorage) SavePushedAuthorize(data *PushedAuthorizeData) error {
+	s.pushed[data.RequestUri] = data
+	return nil
+}
+
+func (s *TestingStorage) LoadPushedAuthorize(requestUri string) (*PushedAuthorizeData, error) {
+	if d, ok := s.pushed[requestUri]; ok {
+		return d, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *TestingStorage) ConsumePushedAuthorize(requestUri string) (*PushedAuthorizeData, error) {
+	d, ok := s.pushed[requestUri]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	delete(s.pushed, requestUri)
+	return d, nil
+}
+
+func (s *TestingStorage) SaveClientRegistration(data *ClientRegistration) error {
+	s.registry[data.Client.GetId()] = data
+	s.clients[data.Client.GetId()] = data.Client
+	return nil
+}
+
+func (s *TestingStorage) LoadClientRegistration(clientId string) (*ClientRegistration, error) {
+	if d, ok := s.registry[clientId]; ok {
+		return d, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *TestingStorage) RemoveClientRegistration(clientId string) error {
+	delete(s.registry, clientId)
+	delete(s.clients, clientId)
+	return nil
+}
+
+func (s *TestingStorage) ConsumeRefresh(data *RotatedRefreshData) error {
+	if _, ok := s.refresh[data.RefreshToken]; !ok {
+		return ErrNotFound
+	}
+	delete(s.refresh, data.RefreshToken)
+	s.rotated[data.RefreshToken] = data
+	return nil
+}
+
+func (s *TestingStorage) LoadRotatedRefresh(token string) (*RotatedRefreshData, error) {
+	if d, ok := s.rotated[token]; ok {
+		return d, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *TestingStorage) ConsumeAuthorize(data *RedeemedAuthorizeData) error {
+	if _, ok := s.redeemed[data.Code]; ok {
+		return ErrAuthorizeRedeemed
+	}
+	s.redeemed[data.Code] = data
+	delete(s.authorize, data.Code)
+	return nil
+}
+
+func (s *TestingStorage) LoadRedeemedAuthorize(code string) (*RedeemedAuthorizeData, error) {
+	if d, ok := s.redeemed[code]; ok {
+		return d, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *TestingStorage) RemoveGrant(grantId string) error {
+	for token, d := range s.access {
+		if d.GrantId == grantId {
+			delete(s.access, token)
+			delete(s.refresh, d.RefreshToken)
+		}
+	}
+	for token, d := range s.rotated {
+		if d.GrantId == grantId {
+			delete(s.rotated, token)
+		}
+	}
+	return nil
+}
+
 // Predictable testing token generation
 
 type TestingAuthorizeTokenGen struct {
@@ -156,3 +299,12 @@ func (a *TestingAccessTokenGen) GenerateAccessToken(data *AccessData, generatere
 	}
 	return
 }
+
+type TestingDeviceCodeGen struct {
+	counter int64
+}
+
+func (a *TestingDeviceCodeGen) GenerateDeviceCode(data *DeviceData) (devicecode string, usercode string, err error) {
+	a.counter++
+	return "d" + strconv.FormatInt(a.counter, 10), "u" + strconv.FormatInt(a.counter, 10), nil
+}
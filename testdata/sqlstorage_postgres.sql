@@ -0,0 +1,229 @@
+CREATE TABLE IF NOT EXISTS osin_migration (
+	version INTEGER NOT NULL PRIMARY KEY,
+	applied_at BIGINT NOT NULL
+);
+
+SELECT version FROM osin_migration;
+
+CREATE TABLE osin_client (
+	id VARCHAR(255) NOT NULL PRIMARY KEY,
+	secret VARCHAR(255) NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	redirect_uris TEXT NOT NULL,
+	user_data TEXT,
+	json_web_keys TEXT NOT NULL,
+	tls_client_auth TEXT NOT NULL,
+	allowed_access_types TEXT NOT NULL,
+	allowed_authorize_types TEXT NOT NULL,
+	allowed_scopes TEXT NOT NULL,
+	token_endpoint_auth_method VARCHAR(255) NOT NULL,
+	access_expiration INTEGER NOT NULL,
+	authorization_expiration INTEGER NOT NULL
+);
+
+CREATE TABLE osin_authorize (
+	code VARCHAR(255) NOT NULL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL,
+	expires_in INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	state TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT,
+	code_challenge VARCHAR(255) NOT NULL,
+	code_challenge_method VARCHAR(255) NOT NULL,
+	nonce TEXT NOT NULL,
+	max_age VARCHAR(255) NOT NULL,
+	prompt VARCHAR(255) NOT NULL,
+	acr_values TEXT NOT NULL,
+	claims TEXT NOT NULL
+);
+
+CREATE TABLE osin_access (
+	access_token VARCHAR(255) NOT NULL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL,
+	refresh_token VARCHAR(255) NOT NULL,
+	expires_in INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT,
+	subject VARCHAR(255) NOT NULL,
+	actors TEXT NOT NULL,
+	audience TEXT NOT NULL,
+	certificate_thumbprint VARCHAR(255) NOT NULL,
+	token_type VARCHAR(255) NOT NULL,
+	jwk_thumbprint VARCHAR(255) NOT NULL,
+	grant_id VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE osin_refresh (
+	token VARCHAR(255) NOT NULL PRIMARY KEY,
+	access_token VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE osin_redeemed (
+	code VARCHAR(255) NOT NULL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL,
+	grant_id VARCHAR(255) NOT NULL,
+	redeemed_at BIGINT NOT NULL,
+	expire_at BIGINT NOT NULL
+);
+
+CREATE TABLE osin_rotated (
+	token VARCHAR(255) NOT NULL PRIMARY KEY,
+	rotated_at BIGINT NOT NULL,
+	access_token VARCHAR(255) NOT NULL,
+	client_id VARCHAR(255) NOT NULL,
+	refresh_token VARCHAR(255) NOT NULL,
+	expires_in INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	redirect_uri TEXT NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT,
+	subject VARCHAR(255) NOT NULL,
+	actors TEXT NOT NULL,
+	audience TEXT NOT NULL,
+	certificate_thumbprint VARCHAR(255) NOT NULL,
+	token_type VARCHAR(255) NOT NULL,
+	jwk_thumbprint VARCHAR(255) NOT NULL,
+	grant_id VARCHAR(255) NOT NULL
+);
+
+CREATE TABLE osin_jti (
+	jti VARCHAR(255) NOT NULL PRIMARY KEY,
+	expire_at BIGINT NOT NULL
+);
+
+CREATE TABLE osin_device (
+	device_code VARCHAR(255) NOT NULL PRIMARY KEY,
+	user_code VARCHAR(255) NOT NULL,
+	client_id VARCHAR(255) NOT NULL,
+	expires_in INTEGER NOT NULL,
+	poll_interval INTEGER NOT NULL,
+	scope TEXT NOT NULL,
+	status VARCHAR(255) NOT NULL,
+	created_at BIGINT NOT NULL,
+	last_polled_at BIGINT NOT NULL,
+	user_data TEXT
+);
+
+CREATE TABLE osin_pushed (
+	request_uri VARCHAR(255) NOT NULL PRIMARY KEY,
+	client_id VARCHAR(255) NOT NULL,
+	params TEXT NOT NULL,
+	expires_in INTEGER NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT
+);
+
+CREATE TABLE osin_registration (
+	client_id VARCHAR(255) NOT NULL PRIMARY KEY,
+	metadata TEXT NOT NULL,
+	software_statement TEXT NOT NULL,
+	registration_access_token VARCHAR(255) NOT NULL,
+	created_at BIGINT NOT NULL,
+	user_data TEXT
+);
+
+CREATE INDEX osin_access_client_id ON osin_access (client_id);
+
+CREATE INDEX osin_access_grant_id ON osin_access (grant_id);
+
+CREATE INDEX osin_refresh_access_token ON osin_refresh (access_token);
+
+CREATE INDEX osin_rotated_grant_id ON osin_rotated (grant_id);
+
+CREATE INDEX osin_jti_expire_at ON osin_jti (expire_at);
+
+CREATE UNIQUE INDEX osin_device_user_code ON osin_device (user_code);
+
+INSERT INTO osin_migration (version, applied_at) VALUES ($1, $2);
+
+INSERT INTO osin_client (id, secret, redirect_uri, redirect_uris, user_data, json_web_keys, tls_client_auth, allowed_access_types, allowed_authorize_types, allowed_scopes, token_endpoint_auth_method, access_expiration, authorization_expiration) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) ON CONFLICT (id) DO UPDATE SET secret = EXCLUDED.secret, redirect_uri = EXCLUDED.redirect_uri, redirect_uris = EXCLUDED.redirect_uris, user_data = EXCLUDED.user_data, json_web_keys = EXCLUDED.json_web_keys, tls_client_auth = EXCLUDED.tls_client_auth, allowed_access_types = EXCLUDED.allowed_access_types, allowed_authorize_types = EXCLUDED.allowed_authorize_types, allowed_scopes = EXCLUDED.allowed_scopes, token_endpoint_auth_method = EXCLUDED.token_endpoint_auth_method, access_expiration = EXCLUDED.access_expiration, authorization_expiration = EXCLUDED.authorization_expiration;
+
+SELECT id, secret, redirect_uri, redirect_uris, user_data, json_web_keys, tls_client_auth, allowed_access_types, allowed_authorize_types, allowed_scopes, token_endpoint_auth_method, access_expiration, authorization_expiration FROM osin_client WHERE id = $1;
+
+INSERT INTO osin_authorize (code, client_id, expires_in, scope, redirect_uri, state, created_at, user_data, code_challenge, code_challenge_method, nonce, max_age, prompt, acr_values, claims) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15);
+
+SELECT a.code, a.client_id, a.expires_in, a.scope, a.redirect_uri, a.state, a.created_at, a.user_data, a.code_challenge, a.code_challenge_method, a.nonce, a.max_age, a.prompt, a.acr_values, a.claims, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_authorize a JOIN osin_client c ON c.id = a.client_id WHERE a.code = $1;
+
+DELETE FROM osin_authorize WHERE code = $1;
+
+INSERT INTO osin_access (access_token, client_id, refresh_token, expires_in, scope, redirect_uri, created_at, user_data, subject, actors, audience, certificate_thumbprint, token_type, jwk_thumbprint, grant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15);
+
+INSERT INTO osin_refresh (token, access_token) VALUES ($1, $2);
+
+SELECT a.access_token, a.client_id, a.refresh_token, a.expires_in, a.scope, a.redirect_uri, a.created_at, a.user_data, a.subject, a.actors, a.audience, a.certificate_thumbprint, a.token_type, a.jwk_thumbprint, a.grant_id, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_access a JOIN osin_client c ON c.id = a.client_id WHERE a.access_token = $1;
+
+SELECT a.access_token, a.client_id, a.refresh_token, a.expires_in, a.scope, a.redirect_uri, a.created_at, a.user_data, a.subject, a.actors, a.audience, a.certificate_thumbprint, a.token_type, a.jwk_thumbprint, a.grant_id, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_refresh r JOIN osin_access a ON a.access_token = r.access_token JOIN osin_client c ON c.id = a.client_id WHERE r.token = $1;
+
+DELETE FROM osin_refresh WHERE token = $1;
+
+DELETE FROM osin_refresh WHERE access_token = $1;
+
+DELETE FROM osin_access WHERE access_token = $1;
+
+DELETE FROM osin_authorize WHERE code = $1;
+
+SELECT code, client_id, grant_id, redeemed_at, expire_at FROM osin_redeemed WHERE code = $1;
+
+INSERT INTO osin_access (access_token, client_id, refresh_token, expires_in, scope, redirect_uri, created_at, user_data, subject, actors, audience, certificate_thumbprint, token_type, jwk_thumbprint, grant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15);
+
+INSERT INTO osin_refresh (token, access_token) VALUES ($1, $2);
+
+DELETE FROM osin_refresh WHERE token = $1;
+
+INSERT INTO osin_rotated (token, rotated_at, access_token, client_id, refresh_token, expires_in, scope, redirect_uri, created_at, user_data, subject, actors, audience, certificate_thumbprint, token_type, jwk_thumbprint, grant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) ON CONFLICT (token) DO UPDATE SET rotated_at = EXCLUDED.rotated_at, access_token = EXCLUDED.access_token, client_id = EXCLUDED.client_id, refresh_token = EXCLUDED.refresh_token, expires_in = EXCLUDED.expires_in, scope = EXCLUDED.scope, redirect_uri = EXCLUDED.redirect_uri, created_at = EXCLUDED.created_at, user_data = EXCLUDED.user_data, subject = EXCLUDED.subject, actors = EXCLUDED.actors, audience = EXCLUDED.audience, certificate_thumbprint = EXCLUDED.certificate_thumbprint, token_type = EXCLUDED.token_type, jwk_thumbprint = EXCLUDED.jwk_thumbprint, grant_id = EXCLUDED.grant_id;
+
+SELECT a.rotated_at, a.access_token, a.client_id, a.refresh_token, a.expires_in, a.scope, a.redirect_uri, a.created_at, a.user_data, a.subject, a.actors, a.audience, a.certificate_thumbprint, a.token_type, a.jwk_thumbprint, a.grant_id, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_rotated a JOIN osin_client c ON c.id = a.client_id WHERE a.token = $1;
+
+SELECT access_token FROM osin_access WHERE grant_id = $1;
+
+DELETE FROM osin_refresh WHERE access_token = $1;
+
+DELETE FROM osin_access WHERE grant_id = $1;
+
+DELETE FROM osin_rotated WHERE grant_id = $1;
+
+INSERT INTO osin_jti (jti, expire_at) VALUES ($1, $2);
+
+INSERT INTO osin_jti (jti, expire_at) VALUES ($1, $2);
+
+UPDATE osin_jti SET expire_at = $1 WHERE jti = $2 AND expire_at > $3 AND expire_at < $4;
+
+DELETE FROM osin_jti WHERE expire_at > $1 AND expire_at < $2;
+
+DELETE FROM osin_redeemed WHERE expire_at > $1 AND expire_at < $2;
+
+INSERT INTO osin_device (device_code, user_code, client_id, expires_in, poll_interval, scope, status, created_at, last_polled_at, user_data) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10);
+
+SELECT d.device_code, d.user_code, d.client_id, d.expires_in, d.poll_interval, d.scope, d.status, d.created_at, d.last_polled_at, d.user_data, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_device d JOIN osin_client c ON c.id = d.client_id WHERE d.device_code = $1;
+
+SELECT d.device_code, d.user_code, d.client_id, d.expires_in, d.poll_interval, d.scope, d.status, d.created_at, d.last_polled_at, d.user_data, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_device d JOIN osin_client c ON c.id = d.client_id WHERE d.user_code = $1;
+
+UPDATE osin_device SET user_code = $1, client_id = $2, expires_in = $3, poll_interval = $4, scope = $5, status = $6, created_at = $7, last_polled_at = $8, user_data = $9 WHERE device_code = $10;
+
+SELECT d.device_code, d.user_code, d.client_id, d.expires_in, d.poll_interval, d.scope, d.status, d.created_at, d.last_polled_at, d.user_data, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_device d JOIN osin_client c ON c.id = d.client_id WHERE d.device_code = $1;
+
+DELETE FROM osin_device WHERE device_code = $1;
+
+INSERT INTO osin_pushed (request_uri, client_id, params, expires_in, created_at, user_data) VALUES ($1, $2, $3, $4, $5, $6);
+
+SELECT p.request_uri, p.client_id, p.params, p.expires_in, p.created_at, p.user_data, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_pushed p JOIN osin_client c ON c.id = p.client_id WHERE p.request_uri = $1;
+
+SELECT p.request_uri, p.client_id, p.params, p.expires_in, p.created_at, p.user_data, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_pushed p JOIN osin_client c ON c.id = p.client_id WHERE p.request_uri = $1;
+
+DELETE FROM osin_pushed WHERE request_uri = $1;
+
+INSERT INTO osin_client (id, secret, redirect_uri, redirect_uris, user_data, json_web_keys, tls_client_auth, allowed_access_types, allowed_authorize_types, allowed_scopes, token_endpoint_auth_method, access_expiration, authorization_expiration) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) ON CONFLICT (id) DO UPDATE SET secret = EXCLUDED.secret, redirect_uri = EXCLUDED.redirect_uri, redirect_uris = EXCLUDED.redirect_uris, user_data = EXCLUDED.user_data, json_web_keys = EXCLUDED.json_web_keys, tls_client_auth = EXCLUDED.tls_client_auth, allowed_access_types = EXCLUDED.allowed_access_types, allowed_authorize_types = EXCLUDED.allowed_authorize_types, allowed_scopes = EXCLUDED.allowed_scopes, token_endpoint_auth_method = EXCLUDED.token_endpoint_auth_method, access_expiration = EXCLUDED.access_expiration, authorization_expiration = EXCLUDED.authorization_expiration;
+
+INSERT INTO osin_registration (client_id, metadata, software_statement, registration_access_token, created_at, user_data) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (client_id) DO UPDATE SET metadata = EXCLUDED.metadata, software_statement = EXCLUDED.software_statement, registration_access_token = EXCLUDED.registration_access_token, created_at = EXCLUDED.created_at, user_data = EXCLUDED.user_data;
+
+SELECT r.client_id, r.metadata, r.software_statement, r.registration_access_token, r.created_at, r.user_data, c.id, c.secret, c.redirect_uri, c.redirect_uris, c.user_data, c.json_web_keys, c.tls_client_auth, c.allowed_access_types, c.allowed_authorize_types, c.allowed_scopes, c.token_endpoint_auth_method, c.access_expiration, c.authorization_expiration FROM osin_registration r JOIN osin_client c ON c.id = r.client_id WHERE r.client_id = $1;
+
+DELETE FROM osin_registration WHERE client_id = $1;
+
+DELETE FROM osin_client WHERE id = $1;
+
+DELETE FROM osin_client WHERE id = $1;
@@ -0,0 +1,147 @@
+package osin
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// Token type identifiers (https://tools.ietf.org/html/rfc8693#section-3)
+const (
+	TOKEN_TYPE_ACCESS_TOKEN  = "urn:ietf:params:oauth:token-type:access_token"
+	TOKEN_TYPE_REFRESH_TOKEN = "urn:ietf:params:oauth:token-type:refresh_token"
+	TOKEN_TYPE_ID_TOKEN      = "urn:ietf:params:oauth:token-type:id_token"
+	TOKEN_TYPE_SAML1         = "urn:ietf:params:oauth:token-type:saml1"
+	TOKEN_TYPE_SAML2         = "urn:ietf:params:oauth:token-type:saml2"
+	TOKEN_TYPE_JWT           = "urn:ietf:params:oauth:token-type:jwt"
+)
+
+// handleTokenExchangeRequest parses a token exchange request
+// (https://tools.ietf.org/html/rfc8693#section-2.1).
+// Subject and actor tokens of the access_token and refresh_token types must have been issued
+// by this server, and are loaded into SubjectAccessData and ActorAccessData.
+// Tokens of other types must be validated by the application.
+func (s *Server) handleTokenExchangeRequest(w *Response, r *http.Request) *AccessRequest {
+	// get client authentication
+	auth := s.getClientAuth(w, r, s.Config.AllowClientSecretInParams)
+	if auth == nil {
+		return nil
+	}
+
+	// generate access token
+	ret := &AccessRequest{
+		Type:               TOKEN_EXCHANGE,
+		Scope:              r.FormValue("scope"),
+		SubjectToken:       r.FormValue("subject_token"),
+		SubjectTokenType:   r.FormValue("subject_token_type"),
+		ActorToken:         r.FormValue("actor_token"),
+		ActorTokenType:     r.FormValue("actor_token_type"),
+		Resource:           r.Form["resource"],
+		Audience:           r.Form["audience"],
+		RequestedTokenType: r.FormValue("requested_token_type"),
+		IssuedTokenType:    TOKEN_TYPE_ACCESS_TOKEN,
+		GenerateRefresh:    false,
+		Expiration:         s.Config.AccessExpiration,
+		HttpRequest:        r,
+	}
+
+	// "subject_token" and "subject_token_type" are required
+	if ret.SubjectToken == "" || ret.SubjectTokenType == "" {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "token_exchange_request=%s", "subject_token and subject_token_type required")
+		return nil
+	}
+
+	// "actor_token_type" is required with "actor_token", and must not be sent without it
+	if (ret.ActorToken == "") != (ret.ActorTokenType == "") {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, nil, "token_exchange_request=%s", "actor_token and actor_token_type must be sent together")
+		return nil
+	}
+
+	// "resource" must be an absolute uri without fragment
+	for _, resource := range ret.Resource {
+		if u, err := url.Parse(resource); err != nil || !u.IsAbs() || u.Fragment != "" {
+			s.setErrorAndLog(w, E_INVALID_TARGET, err, "token_exchange_request=%s, resource=%s", "invalid resource", resource)
+			return nil
+		}
+	}
+
+	// must have a valid client, services usually have no redirect uri
+	if ret.Client = s.authenticateClient(auth, w.Storage, w); ret.Client == nil {
+		return nil
+	}
+
+	var err error
+	if ret.SubjectAccessData, err = s.loadExchangedToken(w.Storage, ret.SubjectToken, ret.SubjectTokenType); err != nil {
+		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "token_exchange_request=%s", "invalid subject_token")
+		return nil
+	}
+	if ret.ActorToken != "" {
+		if ret.ActorAccessData, err = s.loadExchangedToken(w.Storage, ret.ActorToken, ret.ActorTokenType); err != nil {
+			s.setErrorAndLog(w, E_INVALID_REQUEST, err, "token_exchange_request=%s", "invalid actor_token")
+			return nil
+		}
+	}
+
+	// record the delegation chain, the current actor first
+	if ret.SubjectAccessData != nil {
+		ret.Subject = tokenSubject(ret.SubjectAccessData)
+		ret.Actors = append(ret.Actors, ret.SubjectAccessData.Actors...)
+	}
+	if ret.ActorAccessData != nil {
+		ret.Actors = append([]string{tokenSubject(ret.ActorAccessData)}, ret.Actors...)
+	}
+
+	// set redirect uri
+	ret.RedirectUri = s.firstRedirectUri(ret.Client)
+
+	return ret
+}
+
+// loadExchangedToken loads a subject or actor token issued by this server.
+// Returns nil AccessData for token types which are not known to the server.
+func (s *Server) loadExchangedToken(storage Storage, token string, tokenType string) (*AccessData, error) {
+	var ret *AccessData
+	var err error
+	switch tokenType {
+	case TOKEN_TYPE_ACCESS_TOKEN:
+		ret, err = storage.LoadAccess(token)
+	case TOKEN_TYPE_REFRESH_TOKEN:
+		ret, err = storage.LoadRefresh(token)
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	if ret == nil || ret.Client == nil {
+		return nil, errors.New("Token not found")
+	}
+	if tokenType == TOKEN_TYPE_ACCESS_TOKEN && ret.IsExpiredAt(s.Now()) {
+		return nil, errors.New("Token is expired")
+	}
+	return ret, nil
+}
+
+// tokenSubject returns the subject of a token issued by this server, or the id of
+// its client for tokens issued without one, such as client credentials tokens
+func tokenSubject(data *AccessData) string {
+	if data.Subject != "" {
+		return data.Subject
+	}
+	return data.Client.GetId()
+}
+
+// actClaim builds the nested `act` claim for a list of actors, the current actor first
+// (https://tools.ietf.org/html/rfc8693#section-4.1)
+func actClaim(actors []string) map[string]interface{} {
+	var ret map[string]interface{}
+	for i := len(actors) - 1; i >= 0; i-- {
+		act := map[string]interface{}{"sub": actors[i]}
+		if ret != nil {
+			act["act"] = ret
+		}
+		ret = act
+	}
+	return ret
+}
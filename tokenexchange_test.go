@@ -0,0 +1,234 @@
+package osin
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTokenExchangeRequest(t *testing.T, form url.Values) *http.Request {
+	req, err := http.NewRequest("POST", "http://localhost:14000/token", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.SetBasicAuth("1234", "aabbccdd")
+	req.Form = form
+	req.Form.Set("grant_type", string(TOKEN_EXCHANGE))
+	req.PostForm = make(url.Values)
+	return req
+}
+
+func TestAccessTokenExchange(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{TOKEN_EXCHANGE}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.Storage.SaveAccess(&AccessData{
+		Client:      &DefaultClient{Id: "frontend"},
+		AccessToken: "user-token",
+		Scope:       "read write",
+		ExpiresIn:   3600,
+		CreatedAt:   time.Now(),
+		Subject:     "user-1",
+		Actors:      []string{"gateway"},
+	})
+	server.Storage.SaveAccess(&AccessData{
+		Client:      &DefaultClient{Id: "1234"},
+		AccessToken: "service-token",
+		ExpiresIn:   3600,
+		CreatedAt:   time.Now(),
+		Subject:     "service-1",
+	})
+	resp := server.NewResponse()
+
+	req := newTokenExchangeRequest(t, url.Values{
+		"subject_token":      {"user-token"},
+		"subject_token_type": {TOKEN_TYPE_ACCESS_TOKEN},
+		"actor_token":        {"service-token"},
+		"actor_token_type":   {TOKEN_TYPE_ACCESS_TOKEN},
+		"audience":           {"downstream-api"},
+		"resource":           {"https://api.example.com/orders"},
+		"scope":              {"read"},
+	})
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		if ar.SubjectAccessData == nil || ar.SubjectAccessData.AccessToken != "user-token" {
+			t.Fatalf("Subject token was not loaded: %v", ar.SubjectAccessData)
+		}
+		if !reflect.DeepEqual(ar.Resource, []string{"https://api.example.com/orders"}) {
+			t.Fatalf("Unexpected resource: %v", ar.Resource)
+		}
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+	if d := resp.Output["access_token"]; d != "1" {
+		t.Fatalf("Unexpected access token: %s", d)
+	}
+	if d := resp.Output["issued_token_type"]; d != TOKEN_TYPE_ACCESS_TOKEN {
+		t.Fatalf("Unexpected issued token type: %s", d)
+	}
+	if d := resp.Output["token_type"]; d != "Bearer" {
+		t.Fatalf("Unexpected token type: %s", d)
+	}
+	if _, ok := resp.Output["refresh_token"]; ok {
+		t.Fatalf("Should not issue a refresh token")
+	}
+
+	ret, err := server.Storage.LoadAccess("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Subject != "user-1" {
+		t.Errorf("Unexpected subject: %s", ret.Subject)
+	}
+	if !reflect.DeepEqual(ret.Actors, []string{"service-1", "gateway"}) {
+		t.Errorf("Unexpected actors: %v", ret.Actors)
+	}
+	if !reflect.DeepEqual(ret.Audience, []string{"downstream-api"}) {
+		t.Errorf("Unexpected audience: %v", ret.Audience)
+	}
+
+	expectedAct := map[string]interface{}{
+		"sub": "service-1",
+		"act": map[string]interface{}{"sub": "gateway"},
+	}
+	if act := actClaim(ret.Actors); !reflect.DeepEqual(act, expectedAct) {
+		t.Errorf("Unexpected act claim: %v", act)
+	}
+}
+
+func TestAccessTokenExchangeClientSubject(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{TOKEN_EXCHANGE}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	server.Storage.SaveAccess(&AccessData{
+		Client:      &DefaultClient{Id: "backend"},
+		AccessToken: "backend-token",
+		ExpiresIn:   3600,
+		CreatedAt:   time.Now(),
+	})
+	resp := server.NewResponse()
+
+	// neither token has a subject
+	req := newTokenExchangeRequest(t, url.Values{
+		"subject_token":      {"9999"},
+		"subject_token_type": {TOKEN_TYPE_ACCESS_TOKEN},
+		"actor_token":        {"backend-token"},
+		"actor_token_type":   {TOKEN_TYPE_ACCESS_TOKEN},
+	})
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+	ret, err := server.Storage.LoadAccess("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Subject != "1234" {
+		t.Errorf("Unexpected subject: %s", ret.Subject)
+	}
+	if !reflect.DeepEqual(ret.Actors, []string{"backend"}) {
+		t.Errorf("Unexpected actors: %v", ret.Actors)
+	}
+}
+
+func TestAccessTokenExchangeInvalid(t *testing.T) {
+	testcases := map[string]struct {
+		Form          url.Values
+		ExpectedError string
+	}{
+		"missing subject token type": {
+			Form:          url.Values{"subject_token": {"9999"}},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"actor token without type": {
+			Form: url.Values{
+				"subject_token":      {"9999"},
+				"subject_token_type": {TOKEN_TYPE_ACCESS_TOKEN},
+				"actor_token":        {"9999"},
+			},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"unknown subject token": {
+			Form: url.Values{
+				"subject_token":      {"unknown"},
+				"subject_token_type": {TOKEN_TYPE_ACCESS_TOKEN},
+			},
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"relative resource": {
+			Form: url.Values{
+				"subject_token":      {"9999"},
+				"subject_token_type": {TOKEN_TYPE_ACCESS_TOKEN},
+				"resource":           {"/orders"},
+			},
+			ExpectedError: E_INVALID_TARGET,
+		},
+	}
+
+	for k, test := range testcases {
+		sconfig := NewServerConfig()
+		sconfig.AllowedAccessTypes = AllowedAccessType{TOKEN_EXCHANGE}
+		server := NewServer(sconfig, NewTestingStorage())
+		resp := server.NewResponse()
+		req := newTokenExchangeRequest(t, test.Form)
+
+		if ar := server.HandleAccessRequest(resp, req); ar != nil {
+			t.Errorf("%s: should not return a request", k)
+			continue
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %v, got %v", k, test.ExpectedError, resp.ErrorId)
+		}
+	}
+}
+
+func TestAccessTokenExchangeExternalSubject(t *testing.T) {
+	sconfig := NewServerConfig()
+	sconfig.AllowedAccessTypes = AllowedAccessType{TOKEN_EXCHANGE}
+	server := NewServer(sconfig, NewTestingStorage())
+	server.AccessTokenGen = &TestingAccessTokenGen{}
+	resp := server.NewResponse()
+
+	req := newTokenExchangeRequest(t, url.Values{
+		"subject_token":        {"eyJ..."},
+		"subject_token_type":   {TOKEN_TYPE_JWT},
+		"requested_token_type": {TOKEN_TYPE_JWT},
+	})
+
+	if ar := server.HandleAccessRequest(resp, req); ar != nil {
+		if ar.SubjectAccessData != nil {
+			t.Fatalf("External subject token should not be loaded")
+		}
+		// the application validates tokens of other types
+		ar.Subject = "external-user"
+		ar.IssuedTokenType = ar.RequestedTokenType
+		ar.Authorized = true
+		server.FinishAccessRequest(resp, req, ar)
+	}
+
+	if resp.IsError {
+		t.Fatalf("Should not be an error: %v, %v", resp.ErrorId, resp.InternalError)
+	}
+	if d := resp.Output["issued_token_type"]; d != TOKEN_TYPE_JWT {
+		t.Fatalf("Unexpected issued token type: %s", d)
+	}
+	if d := resp.Output["token_type"]; d != "N_A" {
+		t.Fatalf("Unexpected token type: %s", d)
+	}
+	if ret, _ := server.Storage.LoadAccess("1"); ret == nil || ret.Subject != "external-user" {
+		t.Fatalf("Unexpected access data: %v", ret)
+	}
+}
@@ -1,7 +1,9 @@
 package osin
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"math/big"
 
 	"github.com/pborman/uuid"
 )
@@ -31,3 +33,32 @@ func (a *AccessTokenGenDefault) GenerateAccessToken(data *AccessData, generatere
 	}
 	return
 }
+
+// DeviceCodeGenDefault is the default device code generator
+type DeviceCodeGenDefault struct {
+}
+
+// userCodeCharset excludes vowels and look-alike characters, as suggested by
+// https://tools.ietf.org/html/rfc8628#section-6.1
+const userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+// GenerateDeviceCode generates a base64-encoded UUID device code and
+// a user code in the form XXXX-XXXX
+func (a *DeviceCodeGenDefault) GenerateDeviceCode(data *DeviceData) (devicecode string, usercode string, err error) {
+	token := uuid.NewRandom()
+	devicecode = base64.RawURLEncoding.EncodeToString([]byte(token))
+
+	code := make([]byte, 0, 9)
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeCharset))))
+		if err != nil {
+			return "", "", err
+		}
+		code = append(code, userCodeCharset[n.Int64()])
+	}
+	usercode = string(code)
+	return
+}
@@ -6,6 +6,8 @@ import (
 	"net"
 	"net/url"
 	"strings"
+
+	"golang.org/x/net/publicsuffix"
 )
 
 // error returned when validation don't match
@@ -83,7 +85,16 @@ func ValidateUriList(baseUriList string, redirectUri string, separator string) (
 		slist = append(slist, baseUriList)
 	}
 
-	for _, sitem := range slist {
+	realRedirectUri, err = ValidateUris(slist, redirectUri)
+	if _, iok := err.(UriValidationError); iok {
+		return "", newUriValidationError("urls don't validate", baseUriList, redirectUri)
+	}
+	return realRedirectUri, err
+}
+
+// ValidateUris validates that redirectUri is contained in one of baseUris
+func ValidateUris(baseUris []string, redirectUri string) (realRedirectUri string, err error) {
+	for _, sitem := range baseUris {
 		realRedirectUri, err = ValidateUri(sitem, redirectUri)
 		// validated, return no error
 		if err == nil {
@@ -96,7 +107,7 @@ func ValidateUriList(baseUriList string, redirectUri string, separator string) (
 		}
 	}
 
-	return "", newUriValidationError("urls don't validate", baseUriList, redirectUri)
+	return "", newUriValidationError("urls don't validate", strings.Join(baseUris, " "), redirectUri)
 }
 
 // ValidateUri validates that redirectUri is contained in baseUri
@@ -137,3 +148,146 @@ func FirstUri(baseUriList string, separator string) string {
 
 	return ""
 }
+
+// RedirectURIValidator validates the redirect_uri of authorization and token requests
+// against the URIs registered by the client
+type RedirectURIValidator interface {
+	// ValidateRedirectURI returns the redirect URI to use if redirectUri matches one of
+	// the registered baseUris, or a UriValidationError if none matches
+	ValidateRedirectURI(baseUris []string, redirectUri string) (string, error)
+}
+
+// ClientRedirectURIValidator is an optional interface clients can implement to select
+// their redirect URI validation policy, e.g. NativeRedirectURIValidator for native apps
+type ClientRedirectURIValidator interface {
+	// GetRedirectURIValidator returns the policy of the client, nil for Server.RedirectURIValidator
+	GetRedirectURIValidator() RedirectURIValidator
+}
+
+// PrefixRedirectURIValidator allows redirect URIs whose path is a subpath of a registered URI,
+// on any port for loopback registered URIs. This is the default policy, see ValidateUri.
+type PrefixRedirectURIValidator struct{}
+
+func (PrefixRedirectURIValidator) ValidateRedirectURI(baseUris []string, redirectUri string) (string, error) {
+	return ValidateUris(baseUris, redirectUri)
+}
+
+// ExactRedirectURIValidator only allows redirect URIs identical to a registered URI,
+// as required by OAuth 2.1 (https://tools.ietf.org/html/draft-ietf-oauth-security-topics#section-4.1.3)
+type ExactRedirectURIValidator struct{}
+
+func (ExactRedirectURIValidator) ValidateRedirectURI(baseUris []string, redirectUri string) (string, error) {
+	if _, err := parseRedirectUri(redirectUri); err != nil {
+		return "", err
+	}
+	for _, base := range baseUris {
+		if base == redirectUri {
+			return redirectUri, nil
+		}
+	}
+	return "", newUriValidationError("urls don't match", strings.Join(baseUris, " "), redirectUri)
+}
+
+// NativeRedirectURIValidator validates the redirect URIs of native apps (https://tools.ietf.org/html/rfc8252#section-7):
+// private-use URI schemes in reverse domain name notation and claimed https URIs must match
+// a registered URI exactly, and loopback IP URIs (127.0.0.1 or [::1]) on any port.
+type NativeRedirectURIValidator struct{}
+
+func (NativeRedirectURIValidator) ValidateRedirectURI(baseUris []string, redirectUri string) (string, error) {
+	redirect, err := parseRedirectUri(redirectUri)
+	if err != nil {
+		return "", err
+	}
+	loopback := redirect.Scheme == "http" && isLoopbackIP(redirect.Hostname())
+	if !loopback && redirect.Scheme != "https" && !strings.Contains(redirect.Scheme, ".") {
+		return "", newUriValidationError("not a native app redirect uri", strings.Join(baseUris, " "), redirectUri)
+	}
+
+	for _, base := range baseUris {
+		if base == redirectUri {
+			return redirectUri, nil
+		}
+		if !loopback {
+			continue
+		}
+		// https://tools.ietf.org/html/rfc8252#section-7.3
+		b, err := url.Parse(base)
+		if err == nil && b.Scheme == "http" && b.User == nil && redirect.User == nil &&
+			b.Hostname() == redirect.Hostname() && b.Path == redirect.Path && b.RawQuery == redirect.RawQuery {
+			return redirectUri, nil
+		}
+	}
+	return "", newUriValidationError("urls don't match", strings.Join(baseUris, " "), redirectUri)
+}
+
+// WildcardRedirectURIValidator allows registered URIs with a wildcard subdomain, such as
+// https://*.example.com/callback, matching a single DNS label. The scheme, port, path and
+// query must match exactly. The wildcard must be below a registrable domain: wildcards directly
+// under a public suffix, such as https://*.co.uk/callback, never match.
+type WildcardRedirectURIValidator struct{}
+
+func (WildcardRedirectURIValidator) ValidateRedirectURI(baseUris []string, redirectUri string) (string, error) {
+	redirect, err := parseRedirectUri(redirectUri)
+	if err != nil {
+		return "", err
+	}
+	host := strings.ToLower(redirect.Hostname())
+
+	for _, base := range baseUris {
+		if base == redirectUri {
+			return redirectUri, nil
+		}
+		b, err := url.Parse(base)
+		if err != nil || !strings.HasPrefix(b.Host, "*.") {
+			continue
+		}
+		if b.Scheme != redirect.Scheme || b.Port() != redirect.Port() || b.User != nil || redirect.User != nil ||
+			b.Path != redirect.Path || b.RawQuery != redirect.RawQuery {
+			continue
+		}
+		suffix := strings.ToLower(b.Hostname()[1:])
+		if _, err := publicsuffix.EffectiveTLDPlusOne(suffix[1:]); err != nil || !strings.HasSuffix(host, suffix) {
+			continue
+		}
+		if label := strings.TrimSuffix(host, suffix); label != "" && !strings.Contains(label, ".") {
+			return redirectUri, nil
+		}
+	}
+	return "", newUriValidationError("urls don't match", strings.Join(baseUris, " "), redirectUri)
+}
+
+// parseRedirectUri parses an absolute redirect URI without fragment
+func parseRedirectUri(redirectUri string) (*url.URL, error) {
+	if redirectUri == "" {
+		return nil, errors.New("urls cannot be blank.")
+	}
+	u, err := url.Parse(redirectUri)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() {
+		return nil, newUriValidationError("url must be absolute.", "", redirectUri)
+	}
+	if u.Fragment != "" {
+		return nil, newUriValidationError("url must not include fragment.", "", redirectUri)
+	}
+	return u, nil
+}
+
+// isLoopbackIP returns true for loopback IP literals, not for host names such as localhost
+func isLoopbackIP(host string) bool {
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validateRedirectUri validates the redirect URI of a request with the policy of the client
+func (s *Server) validateRedirectUri(client Client, redirectUri string) (string, error) {
+	validator := s.RedirectURIValidator
+	if c, ok := client.(ClientRedirectURIValidator); ok && c.GetRedirectURIValidator() != nil {
+		validator = c.GetRedirectURIValidator()
+	}
+	if validator == nil {
+		validator = PrefixRedirectURIValidator{}
+	}
+	return validator.ValidateRedirectURI(s.clientRedirectUris(client), redirectUri)
+}
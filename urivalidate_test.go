@@ -230,3 +230,80 @@ func TestURIListValidate(t *testing.T) {
 		t.Error("V4 should have failed")
 	}
 }
+
+func TestURIsValidate(t *testing.T) {
+	// V1: URIs may contain any separator
+	if _, err := ValidateUris([]string{"http://xxx:14000/appauth", "http://localhost:14000/app;auth"}, "http://localhost:14000/app;auth"); err != nil {
+		t.Errorf("V1: %s", err)
+	}
+
+	// V2
+	if _, err := ValidateUris([]string{"http://xxx:14000/appauth", "http://localhost:14000/appauth"}, "http://localhost:14000/app"); err == nil {
+		t.Error("V2 should have failed")
+	}
+
+	// V3
+	if _, err := ValidateUris(nil, "http://localhost:14000/appauth"); err == nil {
+		t.Error("V3 should have failed")
+	}
+}
+
+func TestRedirectURIValidators(t *testing.T) {
+	testcases := map[string]struct {
+		Validator   RedirectURIValidator
+		Base        string
+		Redirect    string
+		ExpectValid bool
+	}{
+		"prefix subpath":          {PrefixRedirectURIValidator{}, "https://app.example.com/cb", "https://app.example.com/cb/sub", true},
+		"exact":                   {ExactRedirectURIValidator{}, "https://app.example.com/cb", "https://app.example.com/cb", true},
+		"exact subpath":           {ExactRedirectURIValidator{}, "https://app.example.com/cb", "https://app.example.com/cb/sub", false},
+		"exact query":             {ExactRedirectURIValidator{}, "https://app.example.com/cb", "https://app.example.com/cb?a=1", false},
+		"exact loopback port":     {ExactRedirectURIValidator{}, "http://127.0.0.1/cb", "http://127.0.0.1:5000/cb", false},
+		"exact fragment":          {ExactRedirectURIValidator{}, "https://app.example.com/cb#a", "https://app.example.com/cb#a", false},
+		"native ipv4 loopback":    {NativeRedirectURIValidator{}, "http://127.0.0.1/cb", "http://127.0.0.1:5000/cb", true},
+		"native ipv6 loopback":    {NativeRedirectURIValidator{}, "http://[::1]/cb", "http://[::1]:5000/cb", true},
+		"native loopback path":    {NativeRedirectURIValidator{}, "http://127.0.0.1/cb", "http://127.0.0.1:5000/other", false},
+		"native localhost":        {NativeRedirectURIValidator{}, "http://localhost/cb", "http://localhost:5000/cb", false},
+		"native private scheme":   {NativeRedirectURIValidator{}, "com.example.app:/oauth", "com.example.app:/oauth", true},
+		"native other scheme":     {NativeRedirectURIValidator{}, "myapp:/oauth", "myapp:/oauth", false},
+		"native claimed https":    {NativeRedirectURIValidator{}, "https://app.example.com/cb", "https://app.example.com/cb", true},
+		"native http":             {NativeRedirectURIValidator{}, "http://app.example.com/cb", "http://app.example.com/cb", false},
+		"wildcard subdomain":      {WildcardRedirectURIValidator{}, "https://*.example.com/cb", "https://pr-1.example.com/cb", true},
+		"wildcard nested":         {WildcardRedirectURIValidator{}, "https://*.example.com/cb", "https://a.pr-1.example.com/cb", false},
+		"wildcard apex":           {WildcardRedirectURIValidator{}, "https://*.example.com/cb", "https://example.com/cb", false},
+		"wildcard other domain":   {WildcardRedirectURIValidator{}, "https://*.example.com/cb", "https://pr-1.evilexample.com/cb", false},
+		"wildcard top-level":      {WildcardRedirectURIValidator{}, "https://*.com/cb", "https://example.com/cb", false},
+		"wildcard public suffix":  {WildcardRedirectURIValidator{}, "https://*.co.uk/cb", "https://example.co.uk/cb", false},
+		"wildcard private suffix": {WildcardRedirectURIValidator{}, "https://*.github.io/cb", "https://example.github.io/cb", false},
+		"wildcard registrable":    {WildcardRedirectURIValidator{}, "https://*.example.co.uk/cb", "https://pr-1.example.co.uk/cb", true},
+		"wildcard path":           {WildcardRedirectURIValidator{}, "https://*.example.com/cb", "https://pr-1.example.com/cb/sub", false},
+		"wildcard scheme":         {WildcardRedirectURIValidator{}, "https://*.example.com/cb", "http://pr-1.example.com/cb", false},
+		"wildcard exact fallback": {WildcardRedirectURIValidator{}, "https://app.example.com/cb", "https://app.example.com/cb", true},
+	}
+
+	for k, test := range testcases {
+		u, err := test.Validator.ValidateRedirectURI([]string{test.Base}, test.Redirect)
+		if test.ExpectValid {
+			if err != nil || u != test.Redirect {
+				t.Errorf("%s: expected %q to be valid, got %q: %v", k, test.Redirect, u, err)
+			}
+		} else if err == nil {
+			t.Errorf("%s: expected %q to be invalid", k, test.Redirect)
+		}
+	}
+}
+
+func TestClientRedirectURIValidator(t *testing.T) {
+	server := NewServer(NewServerConfig(), NewTestingStorage())
+	server.RedirectURIValidator = ExactRedirectURIValidator{}
+	web := &DefaultClient{Id: "web", RedirectUri: "http://127.0.0.1/cb"}
+	native := &DefaultClient{Id: "cli", RedirectUri: "http://127.0.0.1/cb", RedirectURIValidator: NativeRedirectURIValidator{}}
+
+	if _, err := server.validateRedirectUri(web, "http://127.0.0.1:5000/cb"); err == nil {
+		t.Error("Web clients should require an exact match")
+	}
+	if _, err := server.validateRedirectUri(native, "http://127.0.0.1:5000/cb"); err != nil {
+		t.Errorf("Native clients should use any loopback port: %v", err)
+	}
+}
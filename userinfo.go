@@ -0,0 +1,248 @@
+package osin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// scopeClaims are the claims requested by the OpenID Connect scopes
+// (https://openid.net/specs/openid-connect-core-1_0.html#ScopeClaims)
+var scopeClaims = map[string][]string{
+	"profile": {
+		"name", "family_name", "given_name", "middle_name", "nickname", "preferred_username",
+		"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "updated_at",
+	},
+	"email":   {"email", "email_verified"},
+	"address": {"address"},
+	"phone":   {"phone_number", "phone_number_verified"},
+}
+
+// UserInfoClaimsProvider supplies the end-user claims served by HandleUserInfoRequest
+type UserInfoClaimsProvider interface {
+	// GetUserInfoClaims returns the claims about the end-user who authorized the access token,
+	// which must include `sub`. Claims not granted to the client are filtered out by the library.
+	GetUserInfoClaims(data *AccessData) (map[string]interface{}, error)
+}
+
+// UserInfoClient is an optional interface clients can implement to receive signed
+// UserInfo responses (userinfo_signed_response_alg client metadata, OpenID Connect)
+type UserInfoClient interface {
+	// UserInfoSignedResponseAlg returns the JWS alg of the responses, empty for JSON responses
+	UserInfoSignedResponseAlg() string
+}
+
+// UserInfoRequest is a request for the claims about the end-user who authorized an access token
+type UserInfoRequest struct {
+	AccessData *AccessData
+
+	// Names of the claims to return: sub, the claims of the granted scopes and the userinfo
+	// claims requested by the claims parameter of the authorization. Change to return others.
+	Claims []string
+
+	// Set to respond with a JWT signed by Server.IDTokenSigner instead of JSON
+	Signed bool
+
+	// HttpRequest *http.Request for special use
+	HttpRequest *http.Request
+}
+
+// claimsRequest is the claims authorization request parameter
+// (https://openid.net/specs/openid-connect-core-1_0.html#ClaimsParameter)
+type claimsRequest struct {
+	UserInfo map[string]json.RawMessage `json:"userinfo"`
+	IDToken  map[string]json.RawMessage `json:"id_token"`
+}
+
+// HandleUserInfoRequest is the http.HandlerFunc for the OpenID Connect UserInfo endpoint
+// (https://openid.net/specs/openid-connect-core-1_0.html#UserInfo).
+// The access token must have been granted the openid scope.
+func (s *Server) HandleUserInfoRequest(w *Response, r *http.Request) *UserInfoRequest {
+	bindContext(w, r)
+	r.ParseForm()
+	token, err := accessTokenAuth(r)
+	if err != nil {
+		s.setBearerErrorAndLog(w, E_INVALID_REQUEST, err, "handle_userinfo_request=%s", "invalid access token authentication")
+		return nil
+	}
+	if token == "" {
+		s.setBearerErrorAndLog(w, E_INVALID_REQUEST, nil, "handle_userinfo_request=%s", "access token required")
+		return nil
+	}
+
+	ret := &UserInfoRequest{
+		HttpRequest: r,
+	}
+
+	// load access data
+	ret.AccessData, err = w.Storage.LoadAccess(token)
+	if isNotFound(err) || (err == nil && ret.AccessData == nil) {
+		s.setBearerErrorAndLog(w, E_INVALID_TOKEN, nil, "handle_userinfo_request=%s", "access token not found")
+		return nil
+	}
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "handle_userinfo_request=%s", "failed to load access data")
+		return nil
+	}
+	if ret.AccessData.Client == nil {
+		s.setBearerErrorAndLog(w, E_INVALID_TOKEN, nil, "handle_userinfo_request=%s", "access data client is nil")
+		return nil
+	}
+	if ret.AccessData.IsExpiredAt(s.Now()) {
+		s.setBearerErrorAndLog(w, E_INVALID_TOKEN, nil, "handle_userinfo_request=%s", "access data is expired")
+		return nil
+	}
+	if !CheckCertificateBinding(r, ret.AccessData) {
+		s.setBearerErrorAndLog(w, E_INVALID_TOKEN, nil, "handle_userinfo_request=%s", "client certificate mismatch")
+		return nil
+	}
+	if err = s.checkDPoPBinding(r, ret.AccessData, w.Storage); err != nil {
+		s.setDPoPErrorAndLog(w, err, "handle_userinfo_request=%s", "invalid DPoP proof")
+		return nil
+	}
+	if !hasScope(ret.AccessData.Scope, OPENID_SCOPE) {
+		s.setBearerErrorAndLog(w, E_INSUFFICIENT_SCOPE, nil, "handle_userinfo_request=%s", "openid scope required")
+		return nil
+	}
+
+	// claims granted to the client
+	ret.Claims = []string{"sub"}
+	for _, scope := range strings.Fields(ret.AccessData.Scope) {
+		ret.Claims = append(ret.Claims, scopeClaims[scope]...)
+	}
+	if ad := accessAuthorizeData(ret.AccessData); ad != nil && ad.Claims != "" {
+		claims, err := parseClaimsRequest(ad.Claims)
+		if err != nil {
+			s.setErrorAndLog(w, E_SERVER_ERROR, err, "handle_userinfo_request=%s", "invalid claims request")
+			return nil
+		}
+		for name := range claims.UserInfo {
+			ret.Claims = append(ret.Claims, name)
+		}
+	}
+
+	if c, ok := ret.AccessData.Client.(UserInfoClient); ok {
+		if alg := c.UserInfoSignedResponseAlg(); alg != "" {
+			if s.IDTokenSigner == nil || s.IDTokenSigner.SigningAlgorithm() != alg {
+				s.setErrorAndLog(w, E_SERVER_ERROR, fmt.Errorf("can't sign UserInfo responses with alg %q", alg), "handle_userinfo_request=%s", "unsupported userinfo_signed_response_alg")
+				return nil
+			}
+			ret.Signed = true
+		}
+	}
+
+	return ret
+}
+
+// FinishUserInfoRequest outputs the claims of the end-user from Server.UserInfoClaims
+// (https://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse)
+func (s *Server) FinishUserInfoRequest(w *Response, r *http.Request, ur *UserInfoRequest) {
+	bindContext(w, r)
+
+	// don't process if is already an error
+	if w.IsError {
+		return
+	}
+
+	if s.UserInfoClaims == nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("no UserInfoClaims provider"), "finish_userinfo_request=%s", "userinfo not supported")
+		return
+	}
+	claims, err := s.UserInfoClaims.GetUserInfoClaims(ur.AccessData)
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_userinfo_request=%s", "error getting claims")
+		return
+	}
+	if sub, _ := claims["sub"].(string); sub == "" {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("UserInfo sub claim required"), "finish_userinfo_request=%s", "error getting claims")
+		return
+	}
+
+	output := make(ResponseData)
+	for _, name := range ur.Claims {
+		if v, ok := claims[name]; ok {
+			output[name] = v
+		}
+	}
+
+	if !ur.Signed {
+		for k, v := range output {
+			w.Output[k] = v
+		}
+		return
+	}
+
+	// https://openid.net/specs/openid-connect-core-1_0.html#UserInfoResponse
+	if s.IDTokenSigner == nil || s.Config.Issuer == "" {
+		s.setErrorAndLog(w, E_SERVER_ERROR, errors.New("IDTokenSigner and issuer required to sign UserInfo responses"), "finish_userinfo_request=%s", "error signing response")
+		return
+	}
+	output["iss"] = s.Config.Issuer
+	output["aud"] = ur.AccessData.Client.GetId()
+	jwt, err := s.IDTokenSigner.SignIDToken(output)
+	if err != nil {
+		s.setErrorAndLog(w, E_SERVER_ERROR, err, "finish_userinfo_request=%s", "error signing response")
+		return
+	}
+	w.Headers.Set("Content-Type", "application/jwt")
+	w.RawOutput = []byte(jwt)
+}
+
+// setBearerErrorAndLog sets the error of a request authenticated by an access token,
+// which is also returned in the WWW-Authenticate header (https://tools.ietf.org/html/rfc6750#section-3)
+func (s Server) setBearerErrorAndLog(w *Response, responseError string, internalError error, debugFormat string, debugArgs ...interface{}) {
+	responseError = storageErrorId(responseError, internalError)
+	w.Headers.Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q", responseError))
+	s.setErrorAndLog(w, responseError, internalError, debugFormat, debugArgs...)
+}
+
+// accessTokenAuth returns the access token sent in the Authorization header (Bearer
+// or DPoP scheme), the form-encoded body or the query of the request
+// (https://tools.ietf.org/html/rfc6750#section-2). Clients must use only one method.
+func accessTokenAuth(r *http.Request) (string, error) {
+	var tokens []string
+	if header := r.Header.Get("Authorization"); header != "" {
+		if dpop := CheckDPoPAuth(r); dpop != nil {
+			tokens = append(tokens, dpop.Code)
+		} else if parts := strings.SplitN(header, " ", 2); len(parts) == 2 && strings.EqualFold(parts[0], "bearer") && parts[1] != "" {
+			tokens = append(tokens, parts[1])
+		} else {
+			return "", errors.New("unsupported authorization scheme")
+		}
+	}
+	tokens = append(tokens, r.PostForm["access_token"]...)
+	if r.URL != nil {
+		tokens = append(tokens, r.URL.Query()["access_token"]...)
+	}
+
+	if len(tokens) > 1 {
+		return "", errors.New("access token sent more than once")
+	}
+	if len(tokens) == 0 {
+		return "", nil
+	}
+	return tokens[0], nil
+}
+
+// accessAuthorizeData returns the authorization the access token was issued from,
+// following refreshed tokens to the original one
+func accessAuthorizeData(data *AccessData) *AuthorizeData {
+	for data != nil {
+		if data.AuthorizeData != nil {
+			return data.AuthorizeData
+		}
+		data = data.AccessData
+	}
+	return nil
+}
+
+// parseClaimsRequest parses the claims authorization request parameter
+func parseClaimsRequest(claims string) (*claimsRequest, error) {
+	ret := &claimsRequest{}
+	if err := json.Unmarshal([]byte(claims), ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -0,0 +1,182 @@
+package osin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testingUserInfoClaims struct{}
+
+func (testingUserInfoClaims) GetUserInfoClaims(data *AccessData) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"sub":          "user",
+		"name":         "Jane Doe",
+		"email":        "jane.doe@example.com",
+		"phone_number": "+1 555 0100",
+		"groups":       []string{"admin"},
+	}, nil
+}
+
+type testingUserInfoClient struct {
+	DefaultClient
+	Alg string
+}
+
+func (c *testingUserInfoClient) UserInfoSignedResponseAlg() string {
+	return c.Alg
+}
+
+func newTestingUserInfoStorage() *TestingStorage {
+	storage := NewTestingStorage()
+	storage.SetClient("signed", &testingUserInfoClient{DefaultClient{Id: "signed", RedirectUri: "http://localhost:14000/appauth"}, "ES256"})
+	save := func(token, scope, claims string, client Client) {
+		storage.SaveAccess(&AccessData{
+			Client: client,
+			AuthorizeData: &AuthorizeData{
+				Client: client,
+				Scope:  scope,
+				Claims: claims,
+			},
+			AccessToken: token,
+			ExpiresIn:   3600,
+			CreatedAt:   time.Now(),
+			Scope:       scope,
+		})
+	}
+	save("profile", "openid profile", "", storage.clients["1234"])
+	save("claims", "openid email", `{"userinfo":{"groups":{"essential":true}}}`, storage.clients["1234"])
+	save("oauth", "read", "", storage.clients["1234"])
+	save("signed", "openid", "", storage.clients["signed"])
+	return storage
+}
+
+func TestUserInfo(t *testing.T) {
+	testcases := map[string]struct {
+		Method        string
+		Header        string
+		Form          url.Values
+		Query         string
+		ExpectedError string
+		ExpectedOut   ResponseData
+	}{
+		"header": {
+			Header:      "Bearer profile",
+			ExpectedOut: ResponseData{"sub": "user", "name": "Jane Doe"},
+		},
+		"form body": {
+			Method:      "POST",
+			Form:        url.Values{"access_token": {"profile"}},
+			ExpectedOut: ResponseData{"sub": "user", "name": "Jane Doe"},
+		},
+		"query": {
+			Query:       "access_token=profile",
+			ExpectedOut: ResponseData{"sub": "user", "name": "Jane Doe"},
+		},
+		"claims request": {
+			Header:      "Bearer claims",
+			ExpectedOut: ResponseData{"sub": "user", "email": "jane.doe@example.com", "groups": []string{"admin"}},
+		},
+		"missing token": {
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"multiple methods": {
+			Header:        "Bearer profile",
+			Query:         "access_token=profile",
+			ExpectedError: E_INVALID_REQUEST,
+		},
+		"unknown token": {
+			Header:        "Bearer unknown",
+			ExpectedError: E_INVALID_TOKEN,
+		},
+		"without openid scope": {
+			Header:        "Bearer oauth",
+			ExpectedError: E_INSUFFICIENT_SCOPE,
+		},
+	}
+
+	for k, test := range testcases {
+		server := NewServer(NewServerConfig(), newTestingUserInfoStorage())
+		server.UserInfoClaims = testingUserInfoClaims{}
+		resp := server.NewResponse()
+
+		method := test.Method
+		if method == "" {
+			method = "GET"
+		}
+		req, err := http.NewRequest(method, "http://localhost:14000/userinfo?"+test.Query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.Header != "" {
+			req.Header.Set("Authorization", test.Header)
+		}
+		req.Form = req.URL.Query()
+		req.PostForm = make(url.Values)
+		for k, v := range test.Form {
+			req.Form[k] = v
+			req.PostForm[k] = v
+		}
+
+		if ur := server.HandleUserInfoRequest(resp, req); ur != nil {
+			server.FinishUserInfoRequest(resp, req, ur)
+		}
+		if resp.ErrorId != test.ExpectedError {
+			t.Errorf("%s: expected error %q, got %q: %v", k, test.ExpectedError, resp.ErrorId, resp.InternalError)
+			continue
+		}
+		if test.ExpectedError != "" {
+			if h := resp.Headers.Get("WWW-Authenticate"); h != `Bearer error="`+test.ExpectedError+`"` {
+				t.Errorf("%s: unexpected WWW-Authenticate header %q", k, h)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(resp.Output, test.ExpectedOut) {
+			t.Errorf("%s: expected %v, got %v", k, test.ExpectedOut, resp.Output)
+		}
+	}
+}
+
+func TestUserInfoSigned(t *testing.T) {
+	key := newTestingKey(t, "k1")
+	sconfig := NewServerConfig()
+	sconfig.Issuer = "https://auth.example.com"
+	server := NewServer(sconfig, newTestingUserInfoStorage())
+	server.UserInfoClaims = testingUserInfoClaims{}
+	server.IDTokenSigner = &JWTIDTokenSigner{Key: key}
+	resp := server.NewResponse()
+
+	req, err := http.NewRequest("GET", "http://localhost:14000/userinfo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer signed")
+
+	if ur := server.HandleUserInfoRequest(resp, req); ur != nil {
+		server.FinishUserInfoRequest(resp, req, ur)
+	}
+	if resp.IsError {
+		t.Fatalf("Error in response: %s: %v", resp.ErrorId, resp.InternalError)
+	}
+
+	w := httptest.NewRecorder()
+	if err = OutputJSON(resp, w, req); err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/jwt" {
+		t.Fatalf("Unexpected Content-Type %q", ct)
+	}
+	claims, err := parseJWT(w.Body.String(), publicKeySet(key).Keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "user" || claims.Issuer != "https://auth.example.com" || !claims.HasAudience("signed") {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+	if _, ok := claims.Claims["name"]; ok {
+		t.Errorf("Claims of scopes not granted should be filtered: %v", claims.Claims)
+	}
+}
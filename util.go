@@ -2,11 +2,14 @@ package osin
 
 import (
 	"crypto/subtle"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"net/http"
 	"net/url"
 	"strings"
+
+	"gopkg.in/square/go-jose.v1"
 )
 
 // Parse basic authentication header
@@ -15,6 +18,45 @@ type BasicAuth struct {
 	Password string
 }
 
+// clientAuth is the authentication of a client at the token endpoint: its id and secret,
+// or the assertion or certificate it authenticates with instead of a secret
+type clientAuth struct {
+	*BasicAuth
+
+	// JWT client assertion the client authenticates with instead of the password
+	assertion string
+
+	// TLS client certificate the client authenticates with instead of the password,
+	// and whether the TLS server verified its chain
+	certificate         *x509.Certificate
+	certificateVerified bool
+
+	// Whether the secret was sent in the request body instead of the Authorization header
+	inParams bool
+}
+
+// authMethod returns the token endpoint authentication method (rfc7591) of the client authentication
+func (a *clientAuth) authMethod() string {
+	switch {
+	case a.assertion != "":
+		if jws, err := jose.ParseSigned(a.assertion); err == nil && len(jws.Signatures) == 1 && strings.HasPrefix(jws.Signatures[0].Header.Algorithm, "HS") {
+			return "client_secret_jwt"
+		}
+		return "private_key_jwt"
+	case a.certificate != nil:
+		if a.certificateVerified {
+			return "tls_client_auth"
+		}
+		return "self_signed_tls_client_auth"
+	case a.Password == "":
+		return "none"
+	case a.inParams:
+		return "client_secret_post"
+	default:
+		return "client_secret_basic"
+	}
+}
+
 // Parse bearer authentication header
 type BearerAuth struct {
 	Code string
@@ -90,17 +132,34 @@ func CheckBearerAuth(r *http.Request) *BearerAuth {
 	return &BearerAuth{Code: token}
 }
 
+// Return "DPoP" token from the authorization header of requests using DPoP-bound tokens (rfc9449)
+func CheckDPoPAuth(r *http.Request) *BearerAuth {
+	s := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(s) != 2 || !strings.EqualFold(s[0], DPOP_TOKEN_TYPE) || s[1] == "" {
+		return nil
+	}
+	return &BearerAuth{Code: s[1]}
+}
+
 // getClientAuth checks client basic authentication in params if allowed,
 // otherwise gets it from the header.
 // Sets an error on the response if no auth is present or a server error occurs.
-func (s Server) getClientAuth(w *Response, r *http.Request, allowQueryParams bool) *BasicAuth {
+func (s Server) getClientAuth(w *Response, r *http.Request, allowQueryParams bool) *clientAuth {
+
+	// private_key_jwt and client_secret_jwt
+	if _, hasAssertion := r.Form["client_assertion_type"]; hasAssertion {
+		return s.getClientAssertionAuth(w, r)
+	}
 
 	if allowQueryParams {
 		// Allow for auth without password
 		if _, hasSecret := r.Form["client_secret"]; hasSecret {
-			auth := &BasicAuth{
-				Username: r.FormValue("client_id"),
-				Password: r.FormValue("client_secret"),
+			auth := &clientAuth{
+				BasicAuth: &BasicAuth{
+					Username: r.FormValue("client_id"),
+					Password: r.FormValue("client_secret"),
+				},
+				inParams: true,
 			}
 			if auth.Username != "" {
 				return auth
@@ -108,6 +167,17 @@ func (s Server) getClientAuth(w *Response, r *http.Request, allowQueryParams boo
 		}
 	}
 
+	// tls_client_auth and self_signed_tls_client_auth
+	if cert := peerCertificate(r); cert != nil && s.Config.AllowTLSClientCertificates && r.Header.Get("Authorization") == "" {
+		if _, hasSecret := r.Form["client_secret"]; !hasSecret && r.FormValue("client_id") != "" {
+			return &clientAuth{
+				BasicAuth:           &BasicAuth{Username: r.FormValue("client_id")},
+				certificate:         cert,
+				certificateVerified: len(r.TLS.VerifiedChains) > 0,
+			}
+		}
+	}
+
 	auth, err := CheckBasicAuth(r)
 	if err != nil {
 		s.setErrorAndLog(w, E_INVALID_REQUEST, err, "get_client_auth=%s", "check auth error")
@@ -117,5 +187,5 @@ func (s Server) getClientAuth(w *Response, r *http.Request, allowQueryParams boo
 		s.setErrorAndLog(w, E_INVALID_REQUEST, errors.New("Client authentication not sent"), "get_client_auth=%s", "client authentication not sent")
 		return nil
 	}
-	return auth
+	return &clientAuth{BasicAuth: auth}
 }